@@ -18,7 +18,8 @@ func init() {
 	addBoxCmd.Flags().StringVarP(&cliFlags.boxName, "name", "n", "", "Name of the box")
 	addBoxCmd.Flags().DurationVarP(&cliFlags.minDuration, "min", "", 0, "Minimum duration")
 	addBoxCmd.Flags().DurationVarP(&cliFlags.maxDuration, "max", "", 0, "Maximum duration")
-	requiredFlags := []string{"name", "min", "max"}
+	addBoxCmd.Flags().StringSliceVarP(&cliFlags.targets, "target", "", nil, "Explicit target for a period instead of scaling the weekly min and max, e.g. month=20h-30h or year=100h")
+	requiredFlags := []string{"name"}
 	for _, flag := range requiredFlags {
 		err := addBoxCmd.MarkFlagRequired(flag)
 		if err != nil {
@@ -26,11 +27,20 @@ func init() {
 		}
 
 	}
+	// the weekly times can be left out when the box has explicit targets
+	addBoxCmd.MarkFlagsRequiredTogether("min", "max")
+	addBoxCmd.MarkFlagsOneRequired("min", "target")
 
 	// Add span command flags
 	addSpanCmd.Flags().StringVarP(&cliFlags.boxName, "box", "b", "", "Name of the box")
-	addSpanCmd.Flags().StringVarP(&cliFlags.startTime, "start", "s", "", "Start time")
-	addSpanCmd.Flags().StringVarP(&cliFlags.endTime, "end", "e", "", "End time")
+	addSpanCmd.Flags().StringVarP(&cliFlags.startTime, "start", "s", "", "Start time, e.g. \"yesterday 14:30\", \"mon 9am\" or \"2h ago\"")
+	addSpanCmd.Flags().StringVarP(&cliFlags.endTime, "end", "e", "", "End time, e.g. \"yesterday 16:00\" or \"now\"")
+	addSpanCmd.Flags().StringVarP(&cliFlags.notes, "notes", "", "", "Free-text notes")
+	addSpanCmd.Flags().StringSliceVarP(&cliFlags.tags, "tag", "", nil, "Tags, repeat or comma separate for several")
+	err := addSpanCmd.RegisterFlagCompletionFunc("box", completeBoxes)
+	if err != nil {
+		log.Fatal(err)
+	}
 	requiredFlags = []string{"box", "start", "end"}
 	for _, flag := range requiredFlags {
 		err := addSpanCmd.MarkFlagRequired(flag)
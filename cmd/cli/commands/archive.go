@@ -0,0 +1,62 @@
+package commands
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"log"
+	"time"
+)
+
+var archiveCmd = &cobra.Command{
+	Use:   "archive",
+	Short: "Archive a box",
+}
+
+var unarchiveCmd = &cobra.Command{
+	Use:   "unarchive",
+	Short: "Make an archived box active again",
+}
+
+var archiveBoxCmd = &cobra.Command{
+	Use:   "box <name>",
+	Short: "Archive a box and its sub-boxes, keeping their spans for past reports",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		box := tb.Boxes[resolveBox(args[0])]
+		if box.Archived() {
+			log.Fatalf("box %s is already archived", box.Name)
+		}
+		err := tb.ArchiveBox(box.Name, time.Now())
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Archived box %s\n", box.Name)
+	},
+}
+
+var unarchiveBoxCmd = &cobra.Command{
+	Use:   "box <name>",
+	Short: "Make an archived box, its sub-boxes and parents active again",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		box := tb.Boxes[resolveBox(args[0])]
+		if !box.Archived() {
+			log.Fatalf("box %s isn't archived", box.Name)
+		}
+		err := tb.UnarchiveBox(box.Name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Unarchived box %s\n", box.Name)
+	},
+}
+
+// completeBoxes completes the names of the boxes that aren't archived
+func completeBoxes(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return tb.ActiveNames(), cobra.ShellCompDirectiveNoFileComp
+}
+
+func init() {
+	archiveCmd.AddCommand(archiveBoxCmd)
+	unarchiveCmd.AddCommand(unarchiveBoxCmd)
+}
@@ -0,0 +1,128 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aldernero/timebox/pkg/db"
+	"github.com/charmbracelet/huh"
+	"github.com/spf13/cobra"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// snapshotKeep is how many of the snapshots taken before destructive
+// commands are kept
+const snapshotKeep = 10
+
+var backupCmd = &cobra.Command{
+	Use:   "backup",
+	Short: "Back up the database",
+	Long: `Back up the database to a new file named by the current time. The copy is
+consistent even while the TUI is writing to the database.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		dest := cliFlags.dest
+		if dest == "" {
+			dest = backupDir()
+		}
+		before, err := store.Backups(dest)
+		if err != nil {
+			log.Fatal(err)
+		}
+		name, err := store.Backup(cmd.Context(), dest, cliFlags.keep)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Backed up to %s\n", name)
+		for _, old := range before {
+			if _, err := os.Stat(old); errors.Is(err, os.ErrNotExist) {
+				fmt.Printf("Removed old backup %s\n", old)
+			}
+		}
+	},
+}
+
+var restoreCmd = &cobra.Command{
+	Use:   "restore <file>",
+	Short: "Replace the database with a backup",
+	Long: `Replace the database with a backup, after checking the backup's integrity.
+The current database is saved as a snapshot first. Close the TUI before
+restoring.`,
+	Args: cobra.ExactArgs(1),
+	// the database is replaced rather than opened
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {},
+	Run: func(cmd *cobra.Command, args []string) {
+		backup := args[0]
+		version, err := db.CheckBackup(cmd.Context(), backup)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !cliFlags.force {
+			var confirmed bool
+			err := huh.NewConfirm().
+				Title(fmt.Sprintf("Replace %s with %s?", dbFile, backup)).
+				Affirmative("Yes").
+				Negative("No").
+				Value(&confirmed).
+				Run()
+			if err != nil {
+				log.Fatal(err)
+			}
+			if !confirmed {
+				fmt.Println("Cancelling restore")
+				return
+			}
+		}
+		if _, err := os.Stat(dbFile); err == nil {
+			current, err := db.Open(cmd.Context(), dbFile)
+			if err != nil {
+				log.Fatal(err)
+			}
+			name, err := current.Backup(cmd.Context(), snapshotDir(), snapshotKeep)
+			if closeErr := current.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				log.Fatalf("can't save the current database: %v", err)
+			}
+			fmt.Printf("Saved the current database to %s\n", name)
+		}
+		err = db.Restore(cmd.Context(), dbFile, backup)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Restored %s from %s (schema version %d)\n", dbFile, backup, version)
+		if version < db.LatestSchemaVersion() {
+			fmt.Println("It's migrated to the current schema the next time it's opened")
+		}
+	},
+}
+
+// backupDir is where backups go by default, next to the database
+func backupDir() string {
+	return filepath.Join(filepath.Dir(dbFile), "backups")
+}
+
+// snapshotDir keeps the automatic snapshots apart from the backups, so
+// pruning either one doesn't remove the other
+func snapshotDir() string {
+	return filepath.Join(backupDir(), "snapshots")
+}
+
+// snapshot backs up the database before a command that deletes or replaces
+// data
+func snapshot(cmd *cobra.Command) {
+	name, err := store.Backup(cmd.Context(), snapshotDir(), snapshotKeep)
+	if err != nil {
+		log.Fatalf("can't take a snapshot, nothing was changed: %v", err)
+	}
+	fmt.Printf("Saved a snapshot to %s\n", name)
+}
+
+func init() {
+	backupCmd.Flags().StringVarP(&cliFlags.dest, "dest", "d", "", "Directory of the backups (default: backups next to the database)")
+	backupCmd.Flags().IntVarP(&cliFlags.keep, "keep", "k", 0, "Only keep this many of the newest backups (default: all)")
+
+	restoreCmd.Flags().BoolVarP(&cliFlags.force, "force", "", false, "Restore without asking")
+}
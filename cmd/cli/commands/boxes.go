@@ -8,6 +8,8 @@ import (
 	"github.com/charmbracelet/lipgloss/table"
 	"github.com/spf13/cobra"
 	"log"
+	"strings"
+	"time"
 )
 
 var headerStyle = lipgloss.NewStyle().
@@ -23,20 +25,49 @@ var oddRowStyle = lipgloss.NewStyle().
 	Foreground(lipgloss.Color("237")).
 	Background(lipgloss.Color("238"))
 
+// resolveBox normalizes a box path given on the command line and checks
+// that the box exists
+func resolveBox(name string) string {
+	path, err := util.NormalizeBoxPath(name)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if _, ok := tb.Boxes[path]; !ok {
+		log.Fatalf("box \"%s\" does not exist", path)
+	}
+	return path
+}
+
 var listBoxesCmd = &cobra.Command{
 	Use:   "boxes",
 	Short: "List boxes",
 	Run: func(cmd *cobra.Command, args []string) {
 		var rows [][]string
-		for _, name := range tb.Names {
+		for _, name := range tb.TreeNames() {
 			box := tb.Boxes[name]
+			if box.Archived() && !cliFlags.archived {
+				continue
+			}
 			minTime := util.DurationParser(box.MinTime)
 			maxTime := util.DurationParser(box.MaxTime)
-			rows = append(rows, []string{name, minTime, maxTime})
+			label := strings.Repeat("  ", box.Depth()) + box.Leaf()
+			row := []string{label, minTime, maxTime, formatTargets(box)}
+			if cliFlags.archived {
+				var archived string
+				if box.Archived() {
+					archived = tb.Calendar.In(box.ArchiveTime).Format(time.DateOnly)
+				}
+				row = append(row, archived)
+			}
+			rows = append(rows, row)
+		}
+		headers := []string{"Box", "Min", "Max", "Targets"}
+		if cliFlags.archived {
+			headers = append(headers, "Archived")
 		}
 		t := table.New().
 			Border(lipgloss.NormalBorder()).
-			Headers("Box", "Min", "Max").
+			Headers(headers...).
 			StyleFunc(func(row, col int) lipgloss.Style {
 				return lipgloss.NewStyle().Margin(0, 1)
 			}).
@@ -52,10 +83,16 @@ var addBoxCmd = &cobra.Command{
 		if cliFlags.boxName == "" {
 			log.Fatal("box name is required")
 		}
+		boxName, err := util.NormalizeBoxPath(cliFlags.boxName)
+		if err != nil {
+			log.Fatal(err)
+		}
+		cliFlags.boxName = boxName
 		if ok := tb.Boxes[cliFlags.boxName]; ok.Name != "" {
 			log.Fatalf("box \"%s\" already exists", cliFlags.boxName)
 		}
-		if cliFlags.minDuration >= cliFlags.maxDuration {
+		// a box with explicit targets doesn't need weekly times
+		if cliFlags.minDuration > cliFlags.maxDuration || (cliFlags.minDuration == cliFlags.maxDuration && len(cliFlags.targets) == 0) {
 			log.Fatal("min duration must be less than max duration")
 		}
 		box := util.Box{
@@ -63,7 +100,8 @@ var addBoxCmd = &cobra.Command{
 			MinTime: cliFlags.minDuration,
 			MaxTime: cliFlags.maxDuration,
 		}
-		err := tb.AddBox(box)
+		setTargets(&box, cliFlags.targets)
+		err = tb.AddBox(box)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -73,18 +111,23 @@ var addBoxCmd = &cobra.Command{
 var deleteBoxCmd = &cobra.Command{
 	Use:   "box",
 	Short: "Delete a box",
+	Long:  "Delete a box and its spans. A box with sub-boxes can only be deleted together with its sub-boxes, using --recursive.",
 	Args:  cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		box := args[0]
-		_, ok := tb.Boxes[box]
-		if !ok {
-			log.Fatalf("box \"%s\" does not exist", box)
+		box := resolveBox(args[0])
+		hasChildren := tb.HasChildren(box)
+		if hasChildren && !cliFlags.recursive {
+			log.Fatalf("box \"%s\" has sub-boxes, use --recursive to delete them too", box)
+		}
+		title := "Are you sure you want to delete the box?"
+		if hasChildren {
+			title = "Are you sure you want to delete the box and all of its sub-boxes?"
 		}
 		var confirmed bool
 		form := huh.NewForm(
 			huh.NewGroup(
 				huh.NewConfirm().
-					Title("Are you sure you want to delete the box?").
+					Title(title).
 					Affirmative("Yes").
 					Negative("No").
 					Value(&confirmed),
@@ -100,7 +143,13 @@ var deleteBoxCmd = &cobra.Command{
 				return
 			}
 		}
-		err := tb.DeleteBoxAndSpans(box)
+		snapshot(cmd)
+		var err error
+		if hasChildren {
+			err = tb.DeleteBoxTree(box)
+		} else {
+			err = tb.DeleteBoxAndSpans(box)
+		}
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -112,16 +161,24 @@ var updateBoxCmd = &cobra.Command{
 	Short: "Update a box",
 	Args:  cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		boxName := args[0]
-		box, ok := tb.Boxes[boxName]
-		if !ok {
-			log.Fatalf("box \"%s\" does not exist", boxName)
-		}
+		box := tb.Boxes[resolveBox(args[0])]
 		if cliFlags.maxDuration > 0 && (cliFlags.minDuration >= cliFlags.maxDuration) {
 			log.Fatal("min duration must be less than max duration")
 		}
-		if cliFlags.minDuration == 0 && cliFlags.maxDuration == 0 {
-			fmt.Println("No changes made")
+		if cliFlags.rename != "" {
+			err := tb.RenameBox(box.Name, cliFlags.rename)
+			if err != nil {
+				log.Fatal(err)
+			}
+			tb = tb.Reload()
+			newName, _ := util.NormalizeBoxPath(cliFlags.rename)
+			fmt.Printf("Renamed box %s to %s\n", box.Name, newName)
+			box = tb.Boxes[newName]
+		}
+		if cliFlags.minDuration == 0 && cliFlags.maxDuration == 0 && len(cliFlags.targets) == 0 {
+			if cliFlags.rename == "" {
+				fmt.Println("No changes made")
+			}
 			return
 		}
 		if cliFlags.minDuration != 0 {
@@ -130,9 +187,36 @@ var updateBoxCmd = &cobra.Command{
 		if cliFlags.maxDuration != 0 {
 			box.MaxTime = cliFlags.maxDuration
 		}
+		if box.MinTime > box.MaxTime {
+			log.Fatalf("min duration %s is greater than max duration %s", util.DurationParser(box.MinTime), util.DurationParser(box.MaxTime))
+		}
+		setTargets(&box, cliFlags.targets)
 		err := tb.UpdateBox(box)
 		if err != nil {
 			log.Fatal(err)
 		}
 	},
 }
+
+// setTargets applies the --target flags to a box
+func setTargets(box *util.Box, targets []string) {
+	for _, s := range targets {
+		p, target, err := util.ParseTarget(s)
+		if err != nil {
+			log.Fatal(err)
+		}
+		box.SetTarget(p, target)
+	}
+}
+
+// formatTargets lists the explicit targets of a box, like "month 20h0m0s-30h0m0s"
+func formatTargets(box util.Box) string {
+	var targets []string
+	for _, p := range []util.Period{util.Month, util.Quarter, util.Year} {
+		if t, ok := box.Targets[p]; ok {
+			period := util.TimePeriod{Period: p}
+			targets = append(targets, strings.ToLower(period.String())+" "+t.String())
+		}
+	}
+	return strings.Join(targets, ", ")
+}
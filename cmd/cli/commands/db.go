@@ -0,0 +1,64 @@
+package commands
+
+import (
+	"fmt"
+	"github.com/aldernero/timebox/pkg/db"
+	"github.com/spf13/cobra"
+	"log"
+)
+
+var dbCmd = &cobra.Command{
+	Use:   "db",
+	Short: "Manage the database",
+	// the database is opened by the subcommands, so pending migrations can
+	// be shown before they're applied
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {},
+}
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Apply pending schema migrations",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		tbdb, err := db.Open(cmd.Context(), dbFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer func(tbdb *db.TBDB) {
+			_ = tbdb.Close()
+		}(tbdb)
+		version, err := tbdb.SchemaVersion(cmd.Context())
+		if err != nil {
+			log.Fatal(err)
+		}
+		pending, err := tbdb.PendingMigrations(cmd.Context())
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(pending) == 0 {
+			fmt.Printf("Database is up to date (schema version %d)\n", version)
+			return
+		}
+		if cliFlags.dryRun {
+			fmt.Printf("Schema version %d, %d pending migration(s):\n", version, len(pending))
+			for _, m := range pending {
+				fmt.Printf("  %3d  %s\n", m.Version, m.Description)
+			}
+			return
+		}
+		applied, err := tbdb.Migrate(cmd.Context())
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, m := range applied {
+			fmt.Printf("Applied %3d  %s\n", m.Version, m.Description)
+		}
+		fmt.Printf("Database is at schema version %d\n", db.LatestSchemaVersion())
+	},
+}
+
+func init() {
+	dbCmd.AddCommand(migrateCmd)
+
+	migrateCmd.Flags().BoolVarP(&cliFlags.dryRun, "dry-run", "n", false, "Only show the pending migrations")
+}
@@ -13,6 +13,7 @@ func init() {
 
 	// Delete box command flags
 	deleteBoxCmd.Flags().BoolVarP(&cliFlags.force, "force", "f", false, "Force delete")
+	deleteBoxCmd.Flags().BoolVarP(&cliFlags.recursive, "recursive", "r", false, "Also delete all sub-boxes")
 
 	// Delete span command flags
 	deleteSpanCmd.Flags().BoolVarP(&cliFlags.force, "force", "f", false, "Force delete")
@@ -0,0 +1,124 @@
+package commands
+
+import (
+	"fmt"
+	"github.com/aldernero/timebox/pkg/util"
+	"github.com/charmbracelet/huh"
+	"github.com/spf13/cobra"
+	"log"
+	"time"
+)
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Find and repair broken spans",
+	Long: `Find spans whose box doesn't exist, overlapping spans, spans of zero or
+negative length and spans ending in the future.
+
+With --fix every problem gets the repair that keeps the most time: missing
+boxes are recreated, overlapping spans are trimmed, negative spans have
+their start and end swapped, future spans end now and the rest are
+deleted. With --interactive the repair is chosen for each problem.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		now := time.Now()
+		problems, err := tb.Diagnose(now)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(problems) == 0 {
+			fmt.Println("No problems found")
+			return
+		}
+		if !cliFlags.fix && !cliFlags.interactive {
+			for _, p := range problems {
+				fmt.Println(p)
+			}
+			fmt.Printf("Found %d problems, run with --fix or --interactive to repair them\n", len(problems))
+			return
+		}
+		// a repair can change the other problems, so diagnose again after
+		// each one and skip the problems that were already handled
+		handled := make(map[string]bool)
+		var fixed int
+		for {
+			p, ok := nextProblem(problems, handled)
+			if !ok {
+				break
+			}
+			handled[p.Key()] = true
+			repair, box, ok := chooseRepair(p, now)
+			if ok {
+				if err := tb.Fix(p, repair, box, now); err != nil {
+					fmt.Printf("Can't fix %s: %v\n", p, err)
+				} else {
+					fmt.Printf("Fixed %s: %s\n", p, repair)
+					fixed++
+				}
+			}
+			tb = tb.Reload()
+			problems, err = tb.Diagnose(now)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		fmt.Printf("Fixed %d problems, %d left\n", fixed, len(problems))
+	},
+}
+
+func nextProblem(problems []util.Problem, handled map[string]bool) (util.Problem, bool) {
+	for _, p := range problems {
+		if !handled[p.Key()] {
+			return p, true
+		}
+	}
+	return util.Problem{}, false
+}
+
+// chooseRepair returns the default repair of a problem, or asks for one with
+// --interactive. It returns false if the problem is skipped.
+func chooseRepair(p util.Problem, now time.Time) (util.Repair, string, bool) {
+	repairs := p.Repairs(now)
+	if !cliFlags.interactive {
+		return repairs[0], "", true
+	}
+	choice := 0
+	options := []huh.Option[int]{}
+	for i, r := range repairs {
+		options = append(options, huh.NewOption(r.String(), i))
+	}
+	options = append(options, huh.NewOption("skip", -1))
+	err := huh.NewSelect[int]().
+		Title(p.String()).
+		Options(options...).
+		Value(&choice).
+		Run()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if choice < 0 {
+		return 0, "", false
+	}
+	var box string
+	if repairs[choice] == util.RepairReassign {
+		names := tb.ActiveNames()
+		if len(names) == 0 {
+			fmt.Println("There are no boxes to move the span to")
+			return 0, "", false
+		}
+		err := huh.NewSelect[string]().
+			Title("Move the span to").
+			Options(huh.NewOptions(names...)...).
+			Value(&box).
+			Run()
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	return repairs[choice], box, true
+}
+
+func init() {
+	doctorCmd.Flags().BoolVarP(&cliFlags.fix, "fix", "", false, "Apply the default repair to every problem")
+	doctorCmd.Flags().BoolVarP(&cliFlags.interactive, "interactive", "i", false, "Choose the repair for each problem")
+}
@@ -0,0 +1,186 @@
+package commands
+
+import (
+	"fmt"
+	"github.com/aldernero/timebox/pkg/ical"
+	"github.com/aldernero/timebox/pkg/util"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export boxes and spans as csv or json",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		format := exchangeFormat(cliFlags.output)
+		var span util.Span
+		if cliFlags.startTime != "" {
+			from, err := util.ParseDurationOrTime(cliFlags.startTime)
+			if err != nil {
+				log.Fatal(err)
+			}
+			span.Start = from
+		}
+		span.End = time.Now()
+		if cliFlags.endTime != "" {
+			to, err := util.ParseDurationOrTime(cliFlags.endTime)
+			if err != nil {
+				log.Fatal(err)
+			}
+			span.End = to
+		}
+		var boxName string
+		if cliFlags.boxName != "" {
+			boxName = resolveBox(cliFlags.boxName)
+		}
+		var w io.Writer = os.Stdout
+		if cliFlags.output != "" {
+			f, err := os.Create(cliFlags.output)
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer f.Close()
+			w = f
+		}
+		e := tb.Export(span, boxName)
+		var err error
+		if format == ical.Format {
+			spans := make([]util.Span, len(e.Spans))
+			for i, es := range e.Spans {
+				spans[i] = es.Span()
+			}
+			err = ical.Write(w, spans)
+		} else {
+			err = e.Write(w, format)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		switch {
+		case cliFlags.output == "":
+		case format == ical.Format:
+			fmt.Printf("Exported %d spans to %s\n", len(e.Spans), cliFlags.output)
+		default:
+			fmt.Printf("Exported %d boxes and %d spans to %s\n", len(e.Boxes), len(e.Spans), cliFlags.output)
+		}
+	},
+}
+
+var importCmd = &cobra.Command{
+	Use:   "import <file>",
+	Short: "Import boxes and spans from a csv or json export",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		policy, err := util.ParseConflictPolicy(cliFlags.onConflict)
+		if err != nil {
+			log.Fatal(err)
+		}
+		f, err := os.Open(args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		var e util.Export
+		var readErrs []util.ImportError
+		if format := exchangeFormat(args[0]); format == ical.Format {
+			e, readErrs = readCalendar(f)
+		} else {
+			e, err = util.ReadExport(f, format)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		if policy == util.ConflictReplace {
+			snapshot(cmd)
+		}
+		res := tb.Import(e, policy)
+		res.Errors = append(readErrs, res.Errors...)
+		for _, ie := range res.Errors {
+			fmt.Println(ie.Error())
+		}
+		fmt.Printf("Boxes: %d added, %d updated, %d skipped\n", res.BoxesAdded, res.BoxesUpdated, res.BoxesSkipped)
+		fmt.Printf("Spans: %d added, %d replaced, %d skipped\n", res.SpansAdded, res.SpansReplaced, res.SpansSkipped)
+		if res.Aborted {
+			log.Fatal("import stopped, nothing was imported")
+		}
+		if len(res.Errors) > 0 {
+			log.Fatalf("%d row(s) could not be imported", len(res.Errors))
+		}
+	},
+}
+
+// readCalendar maps the events of an iCalendar file to spans, using the
+// --map flags and the ICSMap setting of the config file
+func readCalendar(r io.Reader) (util.Export, []util.ImportError) {
+	events, err := ical.Parse(r)
+	if err != nil {
+		log.Fatal(err)
+	}
+	mapping, err := ical.ParseMapping(cliFlags.mappings)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for summary, box := range viper.GetStringMapString("ICSMap") {
+		if _, ok := mapping[summary]; !ok {
+			mapping[summary] = box
+		}
+	}
+	opts := ical.Options{Mapping: mapping, IncludeAllDay: cliFlags.allDay}
+	if cliFlags.startTime != "" {
+		opts.From, err = util.ParseDurationOrTime(cliFlags.startTime)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if cliFlags.endTime != "" {
+		opts.To, err = util.ParseDurationOrTime(cliFlags.endTime)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	e, errs := ical.ToExport(events, opts)
+	// the spans refer to boxes by the mapped name
+	for i, s := range e.Spans {
+		if name, err := util.NormalizeBoxPath(s.Box); err == nil {
+			e.Spans[i].Box = name
+		}
+	}
+	return e, errs
+}
+
+// exchangeFormat returns the --format flag, or guesses the format from the
+// file extension
+func exchangeFormat(file string) string {
+	if cliFlags.format != "" {
+		return cliFlags.format
+	}
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".json":
+		return util.FormatJSON
+	case ".ics":
+		return ical.Format
+	}
+	return util.FormatCSV
+}
+
+func init() {
+	exportCmd.Flags().StringVarP(&cliFlags.format, "format", "", "", "Output format, csv, json or ics (default: from the output file, else csv)")
+	exportCmd.Flags().StringVarP(&cliFlags.output, "output", "o", "", "Output file (default: stdout)")
+	exportCmd.Flags().StringVarP(&cliFlags.boxName, "box", "b", "", "Only this box and its sub-boxes")
+	exportCmd.Flags().StringVarP(&cliFlags.startTime, "from", "f", "", "Earliest start time, e.g. \"last mon\" or \"2023-03-01\"")
+	exportCmd.Flags().StringVarP(&cliFlags.endTime, "to", "t", "", "Latest end time (default: now)")
+
+	importCmd.Flags().StringVarP(&cliFlags.format, "format", "", "", "Input format, csv, json or ics (default: from the file extension)")
+	importCmd.Flags().StringSliceVarP(&cliFlags.mappings, "map", "m", nil, "Map an event summary to a box for ics, e.g. Standup=Work/Meetings, * for all others")
+	importCmd.Flags().BoolVarP(&cliFlags.allDay, "all-day", "", false, "Import all-day events from ics as spans tagged all-day instead of skipping them")
+	importCmd.Flags().StringVarP(&cliFlags.startTime, "from", "f", "", "Only events ending after this time (ics)")
+	importCmd.Flags().StringVarP(&cliFlags.endTime, "to", "t", "", "Only events starting before this time, recurring events are expanded up to it (ics, default: now)")
+	importCmd.Flags().StringVarP(&cliFlags.onConflict, "on-conflict", "", "skip", "What to do with existing boxes and overlapping spans: skip, fail or replace")
+}
@@ -0,0 +1,142 @@
+package commands
+
+import (
+	"fmt"
+	"github.com/aldernero/timebox/pkg/util"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/lipgloss/table"
+	"github.com/spf13/cobra"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "Show how boxes and spans were changed",
+	Long: `Show how boxes and spans were changed, with the versions each change
+left behind. Every change is recorded, including undo and redo, along
+with where it was made: cli, tui, import or api.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		var since time.Time
+		empty := "no changes"
+		if cliFlags.since != "" {
+			t, err := util.ParseDurationOrTime(cliFlags.since)
+			if err != nil {
+				log.Fatal(err)
+			}
+			since = t
+			empty += " since " + tb.Calendar.In(since).Format(time.DateTime)
+		}
+		versions, err := tb.History(since)
+		if err != nil {
+			log.Fatal(err)
+		}
+		printHistory(versions, empty)
+	},
+}
+
+var historySpanCmd = &cobra.Command{
+	Use:   "span <id>",
+	Short: "Show the versions of a span",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		id, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil {
+			log.Fatalf("invalid span id %s", args[0])
+		}
+		versions, err := tb.SpanHistory(id)
+		if err != nil {
+			log.Fatal(err)
+		}
+		printHistory(versions, fmt.Sprintf("no history for span %d", id))
+	},
+}
+
+var historyBoxCmd = &cobra.Command{
+	Use:   "box <name>",
+	Short: "Show the versions of a box, which can be deleted",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name, err := util.NormalizeBoxPath(args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+		versions, err := tb.BoxHistory(name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		printHistory(versions, fmt.Sprintf("no history for box %s", name))
+	},
+}
+
+func printHistory(versions []util.Version, empty string) {
+	if len(versions) == 0 {
+		fmt.Println(empty)
+		return
+	}
+	var rows [][]string
+	for _, v := range versions {
+		item := "box " + v.BoxName
+		if v.BoxName == "" {
+			item = fmt.Sprintf("span %d", v.SpanID)
+		}
+		rows = append(rows, []string{
+			tb.Calendar.In(v.Time).Format(time.DateTime),
+			v.Source,
+			v.Operation,
+			item,
+			versionString(v),
+			v.Description,
+		})
+	}
+	t := table.New().
+		Border(lipgloss.NormalBorder()).
+		Headers("Time", "Source", "Operation", "Item", "Version", "Description").
+		StyleFunc(func(row, col int) lipgloss.Style {
+			return lipgloss.NewStyle().Margin(0, 1)
+		}).
+		Rows(rows...)
+	fmt.Println(t.Render())
+}
+
+// versionString shows a version of a box or a span on one line
+func versionString(v util.Version) string {
+	switch {
+	case v.Span != nil:
+		s := *v.Span
+		parts := []string{
+			s.Box,
+			tb.Calendar.In(s.Start).Format(time.DateTime),
+			"to",
+			tb.Calendar.In(s.End).Format(time.DateTime),
+		}
+		if tags := s.TagString(); tags != "" {
+			parts = append(parts, tags)
+		}
+		if s.Notes != "" {
+			parts = append(parts, strconv.Quote(s.Notes))
+		}
+		return strings.Join(parts, " ")
+	case v.Box != nil:
+		b := *v.Box
+		result := fmt.Sprintf("min %s, max %s", util.DurationParser(b.MinTime), util.DurationParser(b.MaxTime))
+		if targets := formatTargets(b); targets != "" {
+			result += ", " + targets
+		}
+		if b.Archived() {
+			result += ", archived " + tb.Calendar.In(b.ArchiveTime).Format(time.DateOnly)
+		}
+		return result
+	}
+	return "deleted"
+}
+
+func init() {
+	historyCmd.AddCommand(historySpanCmd)
+	historyCmd.AddCommand(historyBoxCmd)
+
+	historyCmd.Flags().StringVarP(&cliFlags.since, "since", "s", "", "Only changes at or after this time, e.g. \"yesterday\" or \"168h ago\" (default: all)")
+}
@@ -11,7 +11,13 @@ func init() {
 	listCmd.AddCommand(listBoxesCmd)
 	listCmd.AddCommand(listSpansCmd)
 
+	listBoxesCmd.Flags().BoolVarP(&cliFlags.archived, "archived", "", false, "Also list archived boxes")
+
 	listSpansCmd.Flags().StringVarP(&cliFlags.boxName, "box", "b", "", "Name of the box")
-	listSpansCmd.Flags().StringVarP(&cliFlags.startTime, "from", "f", "", "Earliest start time")
+	listSpansCmd.Flags().StringVarP(&cliFlags.startTime, "from", "f", "", "Earliest start time, e.g. \"last mon\" or \"2023-03-01\"")
 	listSpansCmd.Flags().StringVarP(&cliFlags.endTime, "to", "t", "", "Latest end time (default: now)")
+	listSpansCmd.Flags().VarP(&cliFlags.period, "period", "p", "Only spans in this period, week, month, quarter or year")
+	listSpansCmd.Flags().Lookup("period").DefValue = ""
+	listSpansCmd.Flags().IntVarP(&cliFlags.offset, "offset", "o", 0, "Periods relative to the current one, e.g. -1 for the last one")
+	listSpansCmd.Flags().StringSliceVarP(&cliFlags.tags, "tag", "", nil, "Only spans having all of these tags")
 }
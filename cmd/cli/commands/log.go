@@ -0,0 +1,51 @@
+package commands
+
+import (
+	"fmt"
+	"github.com/aldernero/timebox/pkg/util"
+	"github.com/spf13/cobra"
+	"log"
+	"strings"
+	"time"
+)
+
+var logCmd = &cobra.Command{
+	Use:   "log <entry>",
+	Short: "Add a span from a one-line entry",
+	Long: `Add a span from a one-line entry with a duration or a range of times of
+day, a box, an optional time expression and #tags, for example
+
+  timebox log "45m piano yesterday evening #scales"
+  timebox log Work 9:00-12:30
+
+A duration ends now unless a time of day is given, and boxes can be
+abbreviated as long as only one active box matches.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		span, err := util.ParseLogEntry(strings.Join(args, " "), tb.ActiveNames(), util.NewTimeParser(time.Now))
+		if err != nil {
+			log.Fatal(err)
+		}
+		span.Notes = cliFlags.notes
+		start := tb.Calendar.In(span.Start).Format(time.DateTime)
+		end := tb.Calendar.In(span.End).Format(time.DateTime)
+		summary := fmt.Sprintf("%s: %s to %s (%s)", span.Box, start, end, util.DurationParser(span.Duration()))
+		if len(span.Tags) > 0 {
+			summary += " " + span.TagString()
+		}
+		if cliFlags.dryRun {
+			fmt.Println("Would log", summary)
+			return
+		}
+		err = tb.AddSpan(span, span.Box)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("Logged", summary)
+	},
+}
+
+func init() {
+	logCmd.Flags().StringVarP(&cliFlags.notes, "notes", "", "", "Free-text notes")
+	logCmd.Flags().BoolVarP(&cliFlags.dryRun, "dry-run", "n", false, "Only show the span that would be added")
+}
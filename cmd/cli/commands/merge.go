@@ -0,0 +1,154 @@
+package commands
+
+import (
+	"fmt"
+	"github.com/aldernero/timebox/pkg/util"
+	"github.com/charmbracelet/huh"
+	"github.com/spf13/cobra"
+	"log"
+	"strconv"
+	"time"
+)
+
+var mergeCmd = &cobra.Command{
+	Use:   "merge",
+	Short: "Merge back-to-back spans of the same box",
+	Long: `Merge the spans of a box that follow each other with at most --gap
+between them into one span, which covers the gaps. A span of another box in
+between keeps spans apart. Notes are joined and tags combined.
+
+The merges are shown before anything changes, and all of them are made at
+once.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		filterSpan := util.Span{End: time.Now()}
+		if cliFlags.startTime != "" {
+			from, err := util.ParseDurationOrTime(cliFlags.startTime)
+			if err != nil {
+				log.Fatal(err)
+			}
+			filterSpan.Start = from
+		}
+		if cliFlags.endTime != "" {
+			to, err := util.ParseDurationOrTime(cliFlags.endTime)
+			if err != nil {
+				log.Fatal(err)
+			}
+			filterSpan.End = to
+		}
+		var boxName string
+		if cliFlags.boxName != "" {
+			boxName = resolveBox(cliFlags.boxName)
+		}
+		spans := tb.GetSpansForTimespan(filterSpan)
+		var edits []util.SpanEdit
+		for _, run := range spans.Runs(cliFlags.gap) {
+			if boxName != "" && !util.IsBoxInTree(run[0].Box, boxName) {
+				continue
+			}
+			edit, err := util.MergeSpans(run)
+			if err != nil {
+				log.Fatal(err)
+			}
+			edits = append(edits, edit)
+		}
+		if len(edits) == 0 {
+			fmt.Println("No spans to merge")
+			return
+		}
+		applyEdits(edits, "Merge")
+	},
+}
+
+var mergeSpansCmd = &cobra.Command{
+	Use:   "spans <id>...",
+	Short: "Merge spans of the same box into one",
+	Args:  cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		var spans []util.Span
+		for _, arg := range args {
+			id, err := strconv.Atoi(arg)
+			if err != nil {
+				log.Fatal(err)
+			}
+			span, ok := tb.Spans[int64(id)]
+			if !ok {
+				log.Fatalf("span with ID %d does not exist", id)
+			}
+			spans = append(spans, span)
+		}
+		edit, err := util.MergeSpans(spans)
+		if err != nil {
+			log.Fatal(err)
+		}
+		applyEdits([]util.SpanEdit{edit}, "Merge")
+	},
+}
+
+// applyEdits shows the spans edits remove and add, and makes the edits
+// after a confirmation, unless --force is given or --dry-run only shows them
+func applyEdits(edits []util.SpanEdit, verb string) {
+	var removed, added int
+	for _, e := range edits {
+		for _, s := range e.Old {
+			fmt.Println("-", spanLine(s))
+		}
+		for _, s := range e.New {
+			fmt.Println("+", spanLine(s))
+		}
+		removed += len(e.Old)
+		added += len(e.New)
+	}
+	err := tb.CheckEdits(edits)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if cliFlags.dryRun {
+		return
+	}
+	if !cliFlags.force {
+		var confirmed bool
+		err := huh.NewConfirm().
+			Title(fmt.Sprintf("%s %d spans into %d?", verb, removed, added)).
+			Affirmative("Yes").
+			Negative("No").
+			Value(&confirmed).
+			Run()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !confirmed {
+			fmt.Println("Nothing changed")
+			return
+		}
+	}
+	err = tb.ApplyEdits(edits)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Replaced %d spans with %d\n", removed, added)
+}
+
+// spanLine shows a span for a preview, new spans don't have an ID yet
+func spanLine(s util.Span) string {
+	id := "new"
+	if s.ID != 0 {
+		id = strconv.FormatInt(s.ID, 10)
+	}
+	start := tb.Calendar.In(s.Start).Format(time.DateTime)
+	end := tb.Calendar.In(s.End).Format(time.DateTime)
+	return fmt.Sprintf("%4s %s: %s to %s (%s)", id, s.Box, start, end, util.DurationParser(s.Duration()))
+}
+
+func init() {
+	mergeCmd.AddCommand(mergeSpansCmd)
+
+	mergeCmd.Flags().StringVarP(&cliFlags.boxName, "box", "b", "", "Only merge spans of this box and its sub-boxes")
+	mergeCmd.Flags().DurationVarP(&cliFlags.gap, "gap", "g", 0, "Longest gap between spans to merge, e.g. 2m")
+	mergeCmd.Flags().StringVarP(&cliFlags.startTime, "from", "f", "", "Earliest start time, e.g. \"last mon\" or \"2023-03-01\"")
+	mergeCmd.Flags().StringVarP(&cliFlags.endTime, "to", "t", "", "Latest end time (default: now)")
+	for _, c := range []*cobra.Command{mergeCmd, mergeSpansCmd} {
+		c.Flags().BoolVarP(&cliFlags.force, "force", "", false, "Merge without asking")
+		c.Flags().BoolVarP(&cliFlags.dryRun, "dry-run", "n", false, "Only show the merges")
+	}
+}
@@ -0,0 +1,127 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/aldernero/timebox/pkg/util"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/lipgloss/table"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+var reportCmd = &cobra.Command{
+	Use:   "report",
+	Short: "Show the time used per box in a period against its min and max time",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if !cmd.Flags().Changed("period") {
+			if err := cliFlags.period.Set(viper.GetString("TimePeriod")); err != nil {
+				log.Fatal(err)
+			}
+		}
+		p := cliFlags.period.Period
+		report := tb.Report(p, util.PeriodSpan(p, tb.Calendar, cliFlags.offset), cliFlags.prorate).WithoutArchived()
+		switch cliFlags.format {
+		case "", "table":
+			printReportTable(report)
+		case "json":
+			printReportJSON(report)
+		case "markdown", "md":
+			printReportMarkdown(report)
+		default:
+			log.Fatalf("unknown format %s, expected table, json or markdown", cliFlags.format)
+		}
+	},
+}
+
+func reportTitle(r util.Report) string {
+	label := util.PeriodLabel(r.Period, r.Span.Start, tb.Calendar)
+	title := fmt.Sprintf("%s: %s to %s", label, tb.Calendar.In(r.Span.Start).Format(time.DateTime), tb.Calendar.In(r.Span.End).Format(time.DateTime))
+	if r.Prorated {
+		title += ", targets pro-rated to date"
+	}
+	return title
+}
+
+func printReportTable(r util.Report) {
+	var rows [][]string
+	for _, u := range r.Boxes {
+		label := strings.Repeat("  ", u.Box.Depth()) + u.Box.Leaf()
+		rows = append(rows, []string{label, util.DurationParser(u.Min), util.DurationParser(u.Max), util.DurationParser(u.Used), u.Status.String()})
+	}
+	t := table.New().
+		Border(lipgloss.NormalBorder()).
+		Headers("Box", "Min", "Max", "Used", "Status").
+		StyleFunc(func(row, col int) lipgloss.Style {
+			return lipgloss.NewStyle().Margin(0, 1)
+		}).
+		Rows(rows...)
+	fmt.Println(reportTitle(r))
+	fmt.Println(t.Render())
+}
+
+func printReportMarkdown(r util.Report) {
+	fmt.Printf("## %s\n\n", reportTitle(r))
+	fmt.Println("| Box | Min | Max | Used | Status |")
+	fmt.Println("| --- | ---: | ---: | ---: | --- |")
+	for _, u := range r.Boxes {
+		name := strings.ReplaceAll(u.Box.Name, "|", `\|`)
+		fmt.Printf("| %s | %s | %s | %s | %s |\n", name, util.DurationParser(u.Min), util.DurationParser(u.Max), util.DurationParser(u.Used), u.Status)
+	}
+}
+
+type reportBoxJSON struct {
+	Box    string      `json:"box"`
+	Min    string      `json:"min"`
+	Max    string      `json:"max"`
+	Used   string      `json:"used"`
+	Status util.Status `json:"status"`
+}
+
+type reportJSON struct {
+	Period   string          `json:"period"`
+	Start    time.Time       `json:"start"`
+	End      time.Time       `json:"end"`
+	Prorated bool            `json:"prorated"`
+	Boxes    []reportBoxJSON `json:"boxes"`
+}
+
+func printReportJSON(r util.Report) {
+	period := util.TimePeriod{Period: r.Period}
+	out := reportJSON{
+		Period:   strings.ToLower(period.String()),
+		Start:    r.Span.Start,
+		End:      r.Span.End,
+		Prorated: r.Prorated,
+		Boxes:    []reportBoxJSON{},
+	}
+	for _, u := range r.Boxes {
+		out.Boxes = append(out.Boxes, reportBoxJSON{
+			Box:    u.Box.Name,
+			Min:    u.Min.String(),
+			Max:    u.Max.String(),
+			Used:   u.Used.String(),
+			Status: u.Status,
+		})
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(out); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func init() {
+	reportCmd.Flags().VarP(&cliFlags.period, "period", "p", "Period, week, month, quarter or year (default: TimePeriod from the config)")
+	// the default comes from the config, not from the zero value
+	reportCmd.Flags().Lookup("period").DefValue = ""
+	reportCmd.Flags().IntVarP(&cliFlags.offset, "offset", "o", 0, "Periods relative to the current one, e.g. -1 for the last one")
+	// export and import share the flag, so the default is empty rather than table
+	reportCmd.Flags().StringVarP(&cliFlags.format, "format", "", "", "Output format, table, json or markdown (default: table)")
+	reportCmd.Flags().BoolVarP(&cliFlags.prorate, "prorate", "", false, "Scale the targets of the current period to the part that has passed")
+}
@@ -1,10 +1,14 @@
 package commands
 
 import (
+	"context"
 	"fmt"
+	"github.com/aldernero/timebox/pkg/db"
 	"github.com/aldernero/timebox/pkg/util"
 	"github.com/spf13/cobra"
+	"log"
 	"os"
+	"os/signal"
 	"path"
 	"time"
 
@@ -15,6 +19,7 @@ import (
 var (
 	cfgFile string
 	dbFile  string
+	store   *db.TBDB
 	tb      util.TimeBox
 )
 
@@ -26,19 +31,68 @@ type CliFlags struct {
 	endTime     string
 	period      util.TimePeriod
 	force       bool
+	recursive   bool
+	dryRun      bool
+	discard     bool
+	notes       string
+	tags        []string
+	format      string
+	output      string
+	onConflict  string
+	mappings    []string
+	allDay      bool
+	offset      int
+	prorate     bool
+	targets     []string
+	rename      string
+	archived    bool
+	fix         bool
+	interactive bool
+	gap         time.Duration
+	splitAt     []string
+	every       time.Duration
+	since       string
+	dest        string
+	keep        int
 }
 
 var cliFlags CliFlags
 
 var rootCmd = &cobra.Command{
-	Use:              "",
-	Short:            "",
-	Long:             "",
-	PersistentPreRun: nil,
+	Use:   "",
+	Short: "",
+	Long:  "",
+	// commands that don't work on boxes and spans override this to avoid
+	// opening and migrating the database
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		cal := configCalendar()
+		if cal.Location != nil {
+			// times on the command line are entered and shown in TimeZone
+			time.Local = cal.Location
+		}
+		var err error
+		store, err = db.Open(cmd.Context(), dbFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		tb = util.TimeBoxFromDB(cmd.Context(), store)
+		tb.Calendar = cal
+		tb.Source = db.SourceCLI
+	},
+	PersistentPostRun: func(cmd *cobra.Command, args []string) {
+		if store != nil {
+			if err := store.Close(); err != nil {
+				log.Fatal(err)
+			}
+		}
+	},
 }
 
 func Execute() {
-	if err := rootCmd.Execute(); err != nil {
+	// interrupting a command cancels its queries
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := rootCmd.ExecuteContext(ctx); err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
@@ -56,6 +110,24 @@ func init() {
 	rootCmd.AddCommand(addCmd)
 	rootCmd.AddCommand(deleteCmd)
 	rootCmd.AddCommand(updateCmd)
+	rootCmd.AddCommand(startCmd)
+	rootCmd.AddCommand(stopCmd)
+	rootCmd.AddCommand(statusCmd)
+	rootCmd.AddCommand(dbCmd)
+	rootCmd.AddCommand(exportCmd)
+	rootCmd.AddCommand(importCmd)
+	rootCmd.AddCommand(reportCmd)
+	rootCmd.AddCommand(logCmd)
+	rootCmd.AddCommand(archiveCmd)
+	rootCmd.AddCommand(unarchiveCmd)
+	rootCmd.AddCommand(doctorCmd)
+	rootCmd.AddCommand(mergeCmd)
+	rootCmd.AddCommand(splitCmd)
+	rootCmd.AddCommand(undoCmd)
+	rootCmd.AddCommand(redoCmd)
+	rootCmd.AddCommand(historyCmd)
+	rootCmd.AddCommand(backupCmd)
+	rootCmd.AddCommand(restoreCmd)
 }
 
 func initConfig() {
@@ -79,6 +151,10 @@ func initConfig() {
 		if _, err := os.Stat(cfgPath); os.IsNotExist(err) {
 			viper.SetDefault("HoursInWeek", 168)
 			viper.SetDefault("TimePeriod", "week")
+			viper.SetDefault("WeekStartDay", "sunday")
+			viper.SetDefault("FiscalYearStart", "january")
+			// an IANA zone like Europe/Berlin, empty for the zone of the system
+			viper.SetDefault("TimeZone", "")
 			if err := viper.SafeWriteConfigAs(cfgPath); err != nil {
 				fmt.Println("Can't write config:", err)
 				os.Exit(1)
@@ -93,5 +169,15 @@ func initConfig() {
 	if dbFile == "" {
 		dbFile = path.Join(".", "timebox.db")
 	}
-	tb = util.TimeBoxFromDB(dbFile)
+}
+
+// configCalendar reads the WeekStartDay, FiscalYearStart and TimeZone
+// settings, which older config files don't have. Without a TimeZone the zone
+// of the system is used.
+func configCalendar() util.Calendar {
+	cal, err := util.ParseCalendar(viper.GetString("WeekStartDay"), viper.GetString("FiscalYearStart"), viper.GetString("TimeZone"))
+	if err != nil {
+		log.Fatalf("invalid calendar settings in config: %v", err)
+	}
+	return cal
 }
@@ -17,51 +17,59 @@ var listSpansCmd = &cobra.Command{
 	Short: "List spans",
 	Run: func(cmd *cobra.Command, args []string) {
 		var filterSpan util.Span
-		if cliFlags.startTime == "" {
-			filterSpan.Start = time.Time{}
-		} else {
-			from, err := util.ParseDurationOrTime(cliFlags.startTime)
-			if err != nil {
-				log.Fatal(err)
+		if cmd.Flags().Changed("period") || cmd.Flags().Changed("offset") {
+			if cliFlags.startTime != "" || cliFlags.endTime != "" {
+				log.Fatal("--period and --offset can't be combined with --from and --to")
 			}
-			filterSpan.Start = from
-		}
-		if cliFlags.endTime == "" {
-			filterSpan.End = time.Now()
+			filterSpan = util.PeriodSpan(cliFlags.period.Period, tb.Calendar, cliFlags.offset)
 		} else {
-			to, err := util.ParseDurationOrTime(cliFlags.endTime)
-			if err != nil {
-				log.Fatal(err)
+			filterSpan.End = time.Now()
+			if cliFlags.startTime != "" {
+				from, err := util.ParseDurationOrTime(cliFlags.startTime)
+				if err != nil {
+					log.Fatal(err)
+				}
+				filterSpan.Start = from
+			}
+			if cliFlags.endTime != "" {
+				to, err := util.ParseDurationOrTime(cliFlags.endTime)
+				if err != nil {
+					log.Fatal(err)
+				}
+				filterSpan.End = to
 			}
-			filterSpan.End = to
 		}
 		var spanset util.SpanSet
+		tags := util.ParseTags(cliFlags.tags)
 		if cliFlags.boxName != "" {
-			// check if box exists
-			if _, ok := tb.Boxes[cliFlags.boxName]; !ok {
-				log.Fatalf("box \"%s\" does not exist", cliFlags.boxName)
-			}
-			fullset := tb.GetSpansForTimespan(filterSpan)
+			// the box and all of its sub-boxes
+			boxName := resolveBox(cliFlags.boxName)
+			fullset := tb.GetSpansForTimespan(filterSpan, tags...)
 			spanset = util.NewSpanSet()
 			for _, s := range fullset.Spans {
-				if s.Box == cliFlags.boxName {
+				if util.IsBoxInTree(s.Box, boxName) {
 					spanset.Add(s)
 				}
 			}
 		} else {
-			spanset = tb.GetSpansForTimespan(filterSpan)
+			spanset = tb.GetSpansForTimespan(filterSpan, tags...)
 		}
 		var rows [][]string
 		for _, s := range spanset.Spans {
 			id := fmt.Sprintf("%d", s.ID)
-			start := s.Start.Format("2006-01-02 15:04:05")
-			end := s.End.Format("2006-01-02 15:04:05")
+			start := tb.Calendar.In(s.Start).Format("2006-01-02 15:04:05")
+			end := tb.Calendar.In(s.End).Format("2006-01-02 15:04:05")
 			dur := s.End.Sub(s.Start).String()
-			rows = append(rows, []string{id, s.Box, start, end, dur})
+			// the zone is only shown for spans recorded elsewhere
+			var zone string
+			if name := util.ZoneName(s.Start.Location()); name != util.ZoneName(tb.Calendar.In(s.Start).Location()) {
+				zone = name
+			}
+			rows = append(rows, []string{id, s.Box, start, end, dur, zone, s.TagString(), s.Notes})
 		}
 		t := table.New().
 			Border(lipgloss.NormalBorder()).
-			Headers("ID", "Box", "Start", "End", "Duration").
+			Headers("ID", "Box", "Start", "End", "Duration", "Recorded In", "Tags", "Notes").
 			StyleFunc(func(row, col int) lipgloss.Style {
 				return lipgloss.NewStyle().Margin(0, 1)
 			}).
@@ -85,6 +93,7 @@ var addSpanCmd = &cobra.Command{
 		if cliFlags.boxName == "" {
 			log.Fatal("box name is required")
 		}
+		cliFlags.boxName = resolveBox(cliFlags.boxName)
 		if start.IsZero() || end.IsZero() {
 			log.Fatal("start and end times are required")
 		}
@@ -95,6 +104,8 @@ var addSpanCmd = &cobra.Command{
 			Start: start,
 			End:   end,
 			Box:   cliFlags.boxName,
+			Notes: cliFlags.notes,
+			Tags:  util.ParseTags(cliFlags.tags),
 		}
 		err = tb.AddSpan(span, cliFlags.boxName)
 		if err != nil {
@@ -168,7 +179,13 @@ var updateSpanCmd = &cobra.Command{
 			span.End = end
 		}
 		if cliFlags.boxName != "" {
-			span.Box = cliFlags.boxName
+			span.Box = resolveBox(cliFlags.boxName)
+		}
+		if cmd.Flags().Changed("notes") {
+			span.Notes = cliFlags.notes
+		}
+		if cmd.Flags().Changed("tag") {
+			span.Tags = util.ParseTags(cliFlags.tags)
 		}
 		fmt.Println("New span", span.String())
 		err = tb.UpdateSpan(span)
@@ -0,0 +1,80 @@
+package commands
+
+import (
+	"github.com/aldernero/timebox/pkg/util"
+	"github.com/spf13/cobra"
+	"log"
+	"strconv"
+	"time"
+)
+
+var splitCmd = &cobra.Command{
+	Use:   "split",
+	Short: "Split a span into several",
+}
+
+var splitSpanCmd = &cobra.Command{
+	Use:   "span <id>",
+	Short: "Split a span at times within it or into pieces of a length",
+	Long: `Split a span at times within it, like --at 12:00, or into pieces of a
+length, like --every 1h. Times of day are on the day the span starts, or
+on the day it ends if that puts them within the span. The pieces keep the
+box, notes and tags of the span.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		id, err := strconv.Atoi(args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+		span, ok := tb.Spans[int64(id)]
+		if !ok {
+			log.Fatalf("span with ID %d does not exist", id)
+		}
+		if (len(cliFlags.splitAt) > 0) == (cliFlags.every > 0) {
+			log.Fatal("give either --at or --every")
+		}
+		var edit util.SpanEdit
+		if cliFlags.every > 0 {
+			edit, err = util.SplitSpanEvery(span, cliFlags.every)
+		} else {
+			var times []time.Time
+			for _, expr := range cliFlags.splitAt {
+				times = append(times, splitTime(span, expr))
+			}
+			edit, err = util.SplitSpanAt(span, times...)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		applyEdits([]util.SpanEdit{edit}, "Split")
+	},
+}
+
+// splitTime parses a time expression relative to the start of a span, or
+// to its end if that is within the span and the start isn't
+func splitTime(span util.Span, expr string) time.Time {
+	var first time.Time
+	for i, ref := range []time.Time{span.Start, span.End} {
+		ref = tb.Calendar.In(ref)
+		at, err := util.NewTimeParser(func() time.Time { return ref }).Parse(expr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if at.After(span.Start) && at.Before(span.End) {
+			return at
+		}
+		if i == 0 {
+			first = at
+		}
+	}
+	return first
+}
+
+func init() {
+	splitCmd.AddCommand(splitSpanCmd)
+
+	splitSpanCmd.Flags().StringSliceVarP(&cliFlags.splitAt, "at", "a", nil, "Times to split at, e.g. 12:00, repeat or comma separate for several")
+	splitSpanCmd.Flags().DurationVarP(&cliFlags.every, "every", "e", 0, "Length of the pieces, e.g. 1h")
+	splitSpanCmd.Flags().BoolVarP(&cliFlags.force, "force", "", false, "Split without asking")
+	splitSpanCmd.Flags().BoolVarP(&cliFlags.dryRun, "dry-run", "n", false, "Only show the pieces")
+}
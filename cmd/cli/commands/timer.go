@@ -0,0 +1,97 @@
+package commands
+
+import (
+	"fmt"
+	"github.com/aldernero/timebox/pkg/util"
+	"github.com/spf13/cobra"
+	"log"
+	"time"
+)
+
+var startCmd = &cobra.Command{
+	Use:   "start <box>",
+	Short: "Start a timer for a box",
+	Args:  cobra.ExactArgs(1),
+	// only the first argument is a box
+	ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) > 0 {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		return completeBoxes(cmd, args, toComplete)
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		boxName := resolveBox(args[0])
+		start := time.Now()
+		if cliFlags.startTime != "" {
+			t, err := util.ParseDurationOrTime(cliFlags.startTime)
+			if err != nil {
+				log.Fatal(err)
+			}
+			start = t
+		}
+		err := tb.StartTimer(boxName, start)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Started timer for %s at %s\n", boxName, tb.Calendar.In(start).Format(time.DateTime))
+	},
+}
+
+var stopCmd = &cobra.Command{
+	Use:   "stop",
+	Short: "Stop the running timer and save it as a span",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if cliFlags.discard {
+			_, running, err := tb.RunningTimer()
+			if err != nil {
+				log.Fatal(err)
+			}
+			if !running {
+				log.Fatal("no timer is running")
+			}
+			err = tb.CancelTimer()
+			if err != nil {
+				log.Fatal(err)
+			}
+			fmt.Println("Discarded running timer")
+			return
+		}
+		end := time.Now()
+		if cliFlags.endTime != "" {
+			t, err := util.ParseDurationOrTime(cliFlags.endTime)
+			if err != nil {
+				log.Fatal(err)
+			}
+			end = t
+		}
+		span, err := tb.StopTimer(end)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Stopped timer for %s after %s\n", span.Box, util.DurationParser(span.Duration()))
+	},
+}
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the running timer",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		timer, running, err := tb.RunningTimer()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !running {
+			fmt.Println("No timer running")
+			return
+		}
+		fmt.Printf("%s: running for %s (since %s)\n", timer.Box, util.DurationParser(timer.Elapsed()), tb.Calendar.In(timer.Start).Format(time.DateTime))
+	},
+}
+
+func init() {
+	startCmd.Flags().StringVarP(&cliFlags.startTime, "at", "a", "", "Start time, e.g. \"9am\" or \"15m\" for 15 minutes ago (default: now)")
+	stopCmd.Flags().StringVarP(&cliFlags.endTime, "at", "a", "", "End time, e.g. \"noon\" or \"now -10m\" (default: now)")
+	stopCmd.Flags().BoolVarP(&cliFlags.discard, "discard", "", false, "Discard the running timer without saving a span")
+}
@@ -0,0 +1,36 @@
+package commands
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"log"
+)
+
+var undoCmd = &cobra.Command{
+	Use:   "undo",
+	Short: "Undo the last change of boxes or spans",
+	Long: `Undo the last change of boxes or spans. Changes are undone one at a
+time, newest first. Undoing a stopped timer removes its span, the timer
+doesn't run again.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		desc, err := tb.Undo()
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Undid %s\n", desc)
+	},
+}
+
+var redoCmd = &cobra.Command{
+	Use:   "redo",
+	Short: "Redo the last undone change, until something else is changed",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		desc, err := tb.Redo()
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Redid %s\n", desc)
+	},
+}
@@ -16,11 +16,15 @@ func init() {
 	// Update box command flags
 	updateBoxCmd.Flags().DurationVarP(&cliFlags.minDuration, "min", "", 0, "Minimum duration")
 	updateBoxCmd.Flags().DurationVarP(&cliFlags.maxDuration, "max", "", 0, "Maximum duration")
-	updateBoxCmd.MarkFlagsOneRequired("min", "max")
+	updateBoxCmd.Flags().StringSliceVarP(&cliFlags.targets, "target", "", nil, "Explicit target for a period, e.g. month=20h-30h, an empty value like month= goes back to scaling")
+	updateBoxCmd.Flags().StringVarP(&cliFlags.rename, "rename", "", "", "New name of the box, its sub-boxes and spans move along")
+	updateBoxCmd.MarkFlagsOneRequired("min", "max", "target", "rename")
 
 	// Update span command flags
 	updateSpanCmd.Flags().StringVarP(&cliFlags.boxName, "box", "b", "", "Name of the box")
-	updateSpanCmd.Flags().StringVarP(&cliFlags.startTime, "start", "s", "", "Start time")
-	updateSpanCmd.Flags().StringVarP(&cliFlags.endTime, "end", "e", "", "End time")
-	updateSpanCmd.MarkFlagsOneRequired("box", "start", "end")
+	updateSpanCmd.Flags().StringVarP(&cliFlags.startTime, "start", "s", "", "Start time, e.g. \"yesterday 14:30\", \"mon 9am\" or \"2h ago\"")
+	updateSpanCmd.Flags().StringVarP(&cliFlags.endTime, "end", "e", "", "End time, e.g. \"yesterday 16:00\" or \"now\"")
+	updateSpanCmd.Flags().StringVarP(&cliFlags.notes, "notes", "", "", "Free-text notes")
+	updateSpanCmd.Flags().StringSliceVarP(&cliFlags.tags, "tag", "", nil, "Tags, replaces the existing tags")
+	updateSpanCmd.MarkFlagsOneRequired("box", "start", "end", "notes", "tag")
 }
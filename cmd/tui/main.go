@@ -1,13 +1,59 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"github.com/aldernero/timebox/pkg/db"
 	"github.com/aldernero/timebox/pkg/tui"
 	"github.com/aldernero/timebox/pkg/util"
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/spf13/viper"
+	"os"
+	"time"
 )
 
 var dbName = "timebox.db"
 
 func main() {
-	timebox := util.TimeBoxFromDB(dbName)
+	cal := readCalendar()
+	if cal.Location != nil {
+		time.Local = cal.Location
+	}
+	ctx := context.Background()
+	store, err := db.Open(ctx, dbName)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer func(store *db.TBDB) {
+		_ = store.Close()
+	}(store)
+	timebox := util.TimeBoxFromDB(ctx, store)
+	timebox.Calendar = cal
+	timebox.Source = db.SourceTUI
 	tui.StartTea(timebox)
 }
+
+// readCalendar reads the WeekStartDay, FiscalYearStart and TimeZone settings
+// from the config file written by the cli, if there is one
+func readCalendar() util.Calendar {
+	viper.SetEnvPrefix("timebox")
+	viper.AutomaticEnv()
+	if home, err := homedir.Dir(); err == nil {
+		viper.AddConfigPath(home)
+	}
+	viper.SetConfigType("yaml")
+	viper.SetConfigName(".timebox")
+	if err := viper.ReadInConfig(); err != nil {
+		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
+			fmt.Println("Can't read config:", err)
+			os.Exit(1)
+		}
+	}
+	cal, err := util.ParseCalendar(viper.GetString("WeekStartDay"), viper.GetString("FiscalYearStart"), viper.GetString("TimeZone"))
+	if err != nil {
+		fmt.Println("Invalid calendar settings in config:", err)
+		os.Exit(1)
+	}
+	return cal
+}
@@ -0,0 +1,192 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// backupTimeFormat names backups by the time they're taken, so they sort
+// oldest first
+const backupTimeFormat = "20060102-150405"
+
+// backupPrefix returns the start of the names of the backups of a database
+// file, e.g. "timebox-" for timebox.db
+func backupPrefix(name string) string {
+	base := filepath.Base(strings.SplitN(name, "?", 2)[0])
+	return strings.TrimSuffix(base, filepath.Ext(base)) + "-"
+}
+
+// isBackup tells whether file is named like a backup Backup takes of the
+// database with the given prefix, <prefix>20060102-150405-NNN.db. Backups
+// of timebox-work.db start with "timebox-" too, but don't have a time after
+// it.
+func isBackup(file, prefix string) bool {
+	stamp, ok := strings.CutPrefix(file, prefix)
+	if !ok {
+		return false
+	}
+	stamp, ok = strings.CutSuffix(stamp, ".db")
+	if !ok || len(stamp) != len(backupTimeFormat)+4 {
+		return false
+	}
+	if _, err := time.Parse(backupTimeFormat, stamp[:len(backupTimeFormat)]); err != nil {
+		return false
+	}
+	millis := stamp[len(backupTimeFormat):]
+	if millis[0] != '-' {
+		return false
+	}
+	for _, c := range millis[1:] {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Backup writes a copy of the database to a new file in dir, creating dir
+// if needed, and returns the name of the file. The copy is consistent even
+// while the database is written to. Only the newest keep backups of the
+// database in dir are kept, all of them if keep is 0.
+func (d *TBDB) Backup(ctx context.Context, dir string, keep int) (string, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	name := filepath.Join(dir, fmt.Sprintf("%s%s-%03d.db", backupPrefix(d.name), now.Format(backupTimeFormat), now.Nanosecond()/int(time.Millisecond)))
+	_, err = d.db.ExecContext(ctx, "VACUUM INTO ?", name)
+	if err != nil {
+		return "", fmt.Errorf("can't back up to %s: %w", name, err)
+	}
+	if keep <= 0 {
+		return name, nil
+	}
+	backups, err := d.Backups(dir)
+	if err != nil {
+		return name, err
+	}
+	for len(backups) > keep {
+		err = os.Remove(backups[0])
+		if err != nil {
+			return name, err
+		}
+		backups = backups[1:]
+	}
+	return name, nil
+}
+
+// Backups returns the backups of the database in dir, oldest first
+func (d *TBDB) Backups(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	prefix := backupPrefix(d.name)
+	var result []string
+	for _, e := range entries {
+		if !e.IsDir() && isBackup(e.Name(), prefix) {
+			result = append(result, filepath.Join(dir, e.Name()))
+		}
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// CheckBackup checks that a file is an intact timebox database this build
+// can open, and returns its schema version
+func CheckBackup(ctx context.Context, name string) (int, error) {
+	if _, err := os.Stat(name); err != nil {
+		return 0, err
+	}
+	conn, err := sql.Open(defaultDriver, "file:"+name+"?mode=ro")
+	if err != nil {
+		return 0, err
+	}
+	defer func(conn *sql.DB) {
+		_ = conn.Close()
+	}(conn)
+	var result string
+	err = conn.QueryRowContext(ctx, "PRAGMA integrity_check").Scan(&result)
+	if err != nil {
+		return 0, fmt.Errorf("can't check %s: %w", name, err)
+	}
+	if result != "ok" {
+		return 0, fmt.Errorf("%s is damaged: %s", name, result)
+	}
+	var tables int
+	err = conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name IN ('boxes', 'spans')").Scan(&tables)
+	if err != nil {
+		return 0, err
+	}
+	if tables != 2 {
+		return 0, fmt.Errorf("%s isn't a timebox database", name)
+	}
+	version, err := schemaVersion(ctx, conn)
+	if err != nil {
+		return 0, err
+	}
+	if version > LatestSchemaVersion() {
+		return version, fmt.Errorf("%s has schema version %d, this build of timebox only knows up to %d", name, version, LatestSchemaVersion())
+	}
+	return version, nil
+}
+
+// Restore replaces the database file name with a copy of backup after
+// checking it with CheckBackup. The database must not be open. Older
+// backups are migrated the next time the database is opened.
+func Restore(ctx context.Context, name, backup string) error {
+	_, err := CheckBackup(ctx, backup)
+	if err != nil {
+		return err
+	}
+	// move everything in the write-ahead log into the file, so nothing of
+	// the current database is left over next to the restored one
+	if _, err := os.Stat(name); err == nil {
+		current, err := Open(ctx, name)
+		if err != nil {
+			return err
+		}
+		_, err = current.db.ExecContext(ctx, "PRAGMA wal_checkpoint(TRUNCATE)")
+		if closeErr := current.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	tmp := name + ".restore"
+	err = os.Remove(tmp)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	conn, err := sql.Open(defaultDriver, "file:"+backup+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	_, err = conn.ExecContext(ctx, "VACUUM INTO ?", tmp)
+	if closeErr := conn.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("can't copy %s: %w", backup, err)
+	}
+	for _, suffix := range []string{"-wal", "-shm"} {
+		err = os.Remove(name + suffix)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return os.Rename(tmp, name)
+}
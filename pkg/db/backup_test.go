@@ -0,0 +1,111 @@
+package db
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDB_Backup(t *testing.T) {
+	tbdb := setup(t)
+	require.NoError(t, tbdb.AddBox(ctx, "box-1", 1, 2))
+	dir := filepath.Join(t.TempDir(), "backups")
+	first, err := tbdb.Backup(ctx, dir, 0)
+	require.NoError(t, err)
+	assert.Equal(t, backupPrefix(dbName), filepath.Base(first)[:len(backupPrefix(dbName))])
+	version, err := CheckBackup(ctx, first)
+	require.NoError(t, err)
+	assert.Equal(t, LatestSchemaVersion(), version)
+
+	// a file of another database in the same directory isn't a backup
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "other-20230301-090000-000.db"), nil, 0o644))
+	second, err := tbdb.Backup(ctx, dir, 0)
+	require.NoError(t, err)
+	third, err := tbdb.Backup(ctx, dir, 2)
+	require.NoError(t, err)
+	backups, err := tbdb.Backups(dir)
+	require.NoError(t, err)
+	assert.Equal(t, []string{second, third}, backups)
+	assert.NoFileExists(t, first)
+}
+
+func TestCheckBackup(t *testing.T) {
+	dir := t.TempDir()
+	_, err := CheckBackup(ctx, filepath.Join(dir, "missing.db"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	assert.NoFileExists(t, filepath.Join(dir, "missing.db"))
+
+	garbage := filepath.Join(dir, "garbage.db")
+	require.NoError(t, os.WriteFile(garbage, []byte("not a database, but long enough to have a header of sorts"), 0o644))
+	_, err = CheckBackup(ctx, garbage)
+	assert.Error(t, err)
+
+	other := filepath.Join(dir, "other.db")
+	conn := openDB(t, other)
+	_, err = conn.db.ExecContext(ctx, "CREATE TABLE notes (text TEXT)")
+	require.NoError(t, err)
+	_, err = CheckBackup(ctx, other)
+	assert.EqualError(t, err, other+" isn't a timebox database")
+}
+
+func TestRestore(t *testing.T) {
+	name := filepath.Join(t.TempDir(), dbName)
+	tbdb, err := Open(ctx, name)
+	require.NoError(t, err)
+	require.NoError(t, tbdb.CreateDB(ctx))
+	require.NoError(t, tbdb.AddBox(ctx, "box-1", 1, 2))
+	backup, err := tbdb.Backup(ctx, t.TempDir(), 0)
+	require.NoError(t, err)
+	require.NoError(t, tbdb.AddBox(ctx, "box-2", 3, 4))
+	require.NoError(t, tbdb.Close())
+
+	require.NoError(t, Restore(ctx, name, backup))
+	tbdb = openDB(t, name)
+	boxes, err := tbdb.GetAllBoxes(ctx)
+	require.NoError(t, err)
+	require.Len(t, boxes, 1)
+	assert.Equal(t, "box-1", boxes[0].Name)
+	assert.NoFileExists(t, name+".restore")
+
+	// restoring a new file works too
+	fresh := filepath.Join(t.TempDir(), dbName)
+	require.NoError(t, Restore(ctx, fresh, backup))
+	version, err := CheckBackup(ctx, fresh)
+	require.NoError(t, err)
+	assert.Equal(t, LatestSchemaVersion(), version)
+}
+
+func TestDB_BackupsOfTwoDatabases(t *testing.T) {
+	dir := t.TempDir()
+	backupDir := filepath.Join(dir, "backups")
+	var dbs []*TBDB
+	for _, name := range []string{"timebox.db", "timebox-work.db"} {
+		tbdb := openDB(t, filepath.Join(dir, name))
+		require.NoError(t, tbdb.CreateDB(ctx))
+		require.NoError(t, tbdb.AddBox(ctx, "box-1", 1, 2))
+		dbs = append(dbs, tbdb)
+	}
+	work, err := dbs[1].Backup(ctx, backupDir, 1)
+	require.NoError(t, err)
+	var kept string
+	for i := 0; i < 2; i++ {
+		kept, err = dbs[0].Backup(ctx, backupDir, 1)
+		require.NoError(t, err)
+	}
+
+	// pruning the backups of timebox.db leaves those of timebox-work.db
+	backups, err := dbs[0].Backups(backupDir)
+	require.NoError(t, err)
+	assert.Equal(t, []string{kept}, backups)
+	backups, err = dbs[1].Backups(backupDir)
+	require.NoError(t, err)
+	assert.Equal(t, []string{work}, backups)
+
+	assert.True(t, isBackup("timebox-20260301-090000-123.db", "timebox-"))
+	assert.False(t, isBackup("timebox-work-20260301-090000-123.db", "timebox-"))
+	assert.False(t, isBackup("timebox-20260301-090000.db", "timebox-"))
+	assert.False(t, isBackup("timebox-20261301-090000-123.db", "timebox-"))
+	assert.False(t, isBackup("timebox-20260301-090000-12x.db", "timebox-"))
+}
@@ -0,0 +1,90 @@
+package db
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// benchDB returns a database with n spans seeded over ten boxes, and the
+// start of the first span
+func benchDB(b *testing.B, n int) (*TBDB, int64) {
+	tbdb := openDB(b, filepath.Join(b.TempDir(), dbName))
+	if err := tbdb.CreateDB(ctx); err != nil {
+		b.Fatal(err)
+	}
+	var boxes []string
+	for i := 0; i < 10; i++ {
+		boxes = append(boxes, benchBox(i))
+	}
+	first, err := tbdb.Seed(ctx, boxes, n)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return tbdb, first
+}
+
+func benchBox(i int) string {
+	return fmt.Sprintf("box-%d", i%10)
+}
+
+func BenchmarkTBDB(b *testing.B) {
+	week := int64(7 * 24 * time.Hour / time.Second)
+	benchStep, benchLength := int64(SeedStep/time.Second), int64(SeedLength/time.Second)
+	for _, n := range SeedSizes {
+		tbdb, first := benchDB(b, n)
+		// in the middle of the history, between two spans
+		middle := first + int64(n/2)*benchStep + benchLength
+		b.Run(fmt.Sprintf("DoesSpanOverlap/spans=%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				overlaps, err := tbdb.DoesSpanOverlap(ctx, middle, middle+benchLength)
+				if err != nil || overlaps {
+					b.Fatal(overlaps, err)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("GetSpansForTimeRange/spans=%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				spans, err := tbdb.GetSpansForTimeRange(ctx, middle, middle+week)
+				if err != nil || len(spans) == 0 {
+					b.Fatal(len(spans), err)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("GetSpansForTimeRangeTagged/spans=%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				spans, err := tbdb.GetSpansForTimeRange(ctx, middle, middle+week, "focus")
+				if err != nil || len(spans) == 0 {
+					b.Fatal(len(spans), err)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("UpdateSpanRow/spans=%d", n), func(b *testing.B) {
+			spans, err := tbdb.GetSpansForTimeRange(ctx, middle, middle+benchStep)
+			if err != nil || len(spans) != 1 {
+				b.Fatal(len(spans), err)
+			}
+			span := spans[0]
+			for i := 0; i < b.N; i++ {
+				span.End = span.Start + benchLength - int64(i%2)
+				err := tbdb.UpdateSpanRow(ctx, span)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("AddAndDeleteSpan/spans=%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				id, err := tbdb.AddSpanRow(ctx, SpanRow{Start: middle, End: middle + benchLength, Box: benchBox(0), Tags: []string{"focus"}})
+				if err != nil {
+					b.Fatal(err)
+				}
+				err = tbdb.DeleteSpanByID(ctx, id)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -1,21 +1,33 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	//_ "github.com/mattn/go-sqlite3"
 	"log"
 	_ "modernc.org/sqlite"
-	"os"
+	"strings"
 	"time"
 )
 
 const defaultDriver = "sqlite"
 
-// TBDB is the base struct for the database
+// BoxPathSeparator separates the levels of a box path, e.g. Work/ClientA
+const BoxPathSeparator = "/"
+
+// busyTimeout is how long a write waits for another process holding the
+// write lock, e.g. the TUI while the CLI stops a timer
+const busyTimeout = 5 * time.Second
+
+// TBDB is a timebox database in a SQLite file. It keeps its connections open
+// from Open until Close.
 type TBDB struct {
-	name   string
-	driver string
+	name string
+	db   *sql.DB
+	// tx is set for the TBDB that InTx passes on, all its calls run in the
+	// transaction
+	tx *sql.Tx
 }
 
 type SpanRow struct {
@@ -23,6 +35,11 @@ type SpanRow struct {
 	Start int64
 	End   int64
 	Box   string
+	Notes string
+	Tags  []string
+	// TZ is the IANA name of the time zone the span was recorded in, empty
+	// for spans recorded before zones were stored
+	TZ string
 }
 
 type BoxRow struct {
@@ -30,408 +47,744 @@ type BoxRow struct {
 	CreateTime int64
 	MinTime    int64
 	MaxTime    int64
+	// ArchiveTime is when the box was archived, 0 for active boxes
+	ArchiveTime int64
+}
+
+// TimerRow is the open-ended span of a running timer, there is at most one
+type TimerRow struct {
+	Start int64
+	Box   string
+	TZ    string
 }
 
-func NewDBWithName(name string) TBDB {
-	return TBDB{
-		name:   name,
-		driver: defaultDriver,
+// Open opens the database file, creating it if needed. Init or Migrate bring
+// its schema up to date.
+func Open(ctx context.Context, name string) (*TBDB, error) {
+	db, err := sql.Open(defaultDriver, dsn(name))
+	if err != nil {
+		return nil, err
+	}
+	err = db.PingContext(ctx)
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("can't open database %s: %w", name, err)
 	}
+	return &TBDB{name: name, db: db}, nil
 }
 
-func (d TBDB) Init() {
-	if _, err := os.Stat(d.name); os.IsNotExist(err) {
-		err := d.CreateDB()
-		if err != nil {
-			log.Fatal(err)
-		}
+// Close closes the connections to the database
+func (d *TBDB) Close() error {
+	return d.db.Close()
+}
+
+// dsn enables the foreign keys from spans, the timer and targets to boxes,
+// which SQLite only enforces when asked to on every connection. WAL lets the
+// TUI and the CLI read while the other one writes, and transactions take the
+// write lock when they begin, so the checks in a transaction still hold when
+// it writes.
+func dsn(name string) string {
+	sep := "?"
+	if strings.Contains(name, "?") {
+		sep = "&"
+	}
+	return name + sep + strings.Join([]string{
+		fmt.Sprintf("_pragma=busy_timeout(%d)", busyTimeout.Milliseconds()),
+		"_pragma=journal_mode(WAL)",
+		"_pragma=foreign_keys(1)",
+		"_txlock=immediate",
+	}, "&")
+}
+
+// q returns what the queries of d run on
+func (d *TBDB) q() querier {
+	if d.tx != nil {
+		return d.tx
 	}
+	return d.db
 }
 
-// Create functions
+// InTx runs f with a TBDB whose calls all run in one transaction, which is
+// committed if f succeeds
+func (d *TBDB) InTx(ctx context.Context, f func(s Store) error) error {
+	return d.inTx(ctx, func(tx *sql.Tx) error {
+		return f(&TBDB{name: d.name, db: d.db, tx: tx})
+	})
+}
 
-func (d TBDB) CreateDB() error {
-	db, err := sql.Open(d.driver, d.name)
+// inTx runs f in a transaction, which is committed if f succeeds. Within
+// InTx, f runs in its transaction.
+func (d *TBDB) inTx(ctx context.Context, f func(tx *sql.Tx) error) error {
+	if d.tx != nil {
+		return f(d.tx)
+	}
+	tx, err := d.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
-	defer func(db *sql.DB) {
-		err := db.Close()
-		if err != nil {
-
-		}
-	}(db)
-	// spans table, stores spans of time spent on a given box
-	sqlStmt := `
-	CREATE TABLE spans (id INTEGER PRIMARY KEY AUTOINCREMENT, start INTEGER NOT NULL, end INTEGER NOT NULL, box TEXT NOT NULL);
-	`
-	_, err = db.Exec(sqlStmt)
+	defer func(tx *sql.Tx) {
+		_ = tx.Rollback()
+	}(tx)
+	err = f(tx)
 	if err != nil {
 		return err
 	}
-	// boxes table, stores active boxes and their configurations
-	sqlStmt = `
-	CREATE TABLE boxes (name TEXT NOT NULL PRIMARY KEY, createTime INTEGER NOT NULL, minTime INTEGER NOT NULL, maxTime INTEGER NOT NULL);
-	`
-	_, err = db.Exec(sqlStmt)
-	return err
+	return tx.Commit()
 }
 
-func (d TBDB) AddSpan(start, end int64, box string) error {
-	if start > end {
-		return fmt.Errorf("start time is after end time")
-	}
-	now := time.Now().Unix()
-	if start > now || end > now {
-		return fmt.Errorf("time span is in the future")
-	}
-	db, err := sql.Open(d.driver, d.name)
+// Init brings the database schema up to date, creating the database if needed
+func (d *TBDB) Init(ctx context.Context) {
+	_, err := d.Migrate(ctx)
 	if err != nil {
-		return err
+		log.Fatal(err)
 	}
-	defer func(db *sql.DB) {
-		err := db.Close()
-		if err != nil {
+}
 
-		}
-	}(db)
-	exists, err := d.DoesBoxExist(box)
+// Create functions
+
+func (d *TBDB) CreateDB(ctx context.Context) error {
+	_, err := d.Migrate(ctx)
+	return err
+}
+
+func (d *TBDB) AddSpan(ctx context.Context, start, end int64, box string) error {
+	_, err := d.AddSpanRow(ctx, SpanRow{Start: start, End: end, Box: box})
+	return err
+}
+
+// AddSpanRow validates and inserts a span along with its notes and tags,
+// returning the ID of the new span
+func (d *TBDB) AddSpanRow(ctx context.Context, span SpanRow) (int64, error) {
+	err := ValidateSpanTimes(span.Start, span.End)
 	if err != nil {
-		return err
+		return 0, err
 	}
-	if !exists {
-		return fmt.Errorf("box %s doesn't exist", box)
+	var id int64
+	err = d.inTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		id, err = insertSpan(ctx, tx, span)
+		return err
+	})
+	return id, err
+}
+
+// insertSpan inserts a span with its tags after checking its box exists and
+// it doesn't overlap other spans
+func insertSpan(ctx context.Context, tx *sql.Tx, span SpanRow) (int64, error) {
+	err := checkBoxExists(ctx, tx, span.Box)
+	if err != nil {
+		return 0, err
 	}
-	overlaps, err := d.DoesSpanOverlap(start, end)
+	overlaps, err := spanOverlaps(ctx, tx, span.Start, span.End, 0)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	if overlaps {
-		return fmt.Errorf("time overlaps existing span")
+		return 0, fmt.Errorf("time overlaps existing span")
 	}
-	tx, err := db.Begin()
+	return writeNewSpan(ctx, tx, span)
+}
+
+// writeNewSpan inserts a span with its tags without any checks
+func writeNewSpan(ctx context.Context, tx *sql.Tx, span SpanRow) (int64, error) {
+	res, err := tx.ExecContext(ctx, "INSERT INTO spans(start, end, box, notes, tz) values(?, ?, ?, ?, ?)", span.Start, span.End, span.Box, span.Notes, span.TZ)
 	if err != nil {
-		return err
+		return 0, err
 	}
-	stmt, err := tx.Prepare("INSERT INTO spans(start, end, box) values(?, ?, ?)")
+	id, err := res.LastInsertId()
 	if err != nil {
-		return err
+		return 0, err
 	}
-	defer func(stmt *sql.Stmt) {
-		err := stmt.Close()
-		if err != nil {
-
-		}
-	}(stmt)
-	_, err = stmt.Exec(start, end, box)
-	if err != nil {
-		return err
-	}
-	err = tx.Commit()
-	return err
+	return id, setSpanTags(ctx, tx, id, span.Tags)
 }
 
-func (d TBDB) AddBox(name string, minTime, maxTime int64) error {
+func (d *TBDB) AddBox(ctx context.Context, name string, minTime, maxTime int64) error {
 	if minTime > maxTime {
 		return fmt.Errorf("minTime is greater than maxTime")
 	}
-	db, err := sql.Open(d.driver, d.name)
-	if err != nil {
-		return err
-	}
-	defer func(db *sql.DB) {
-		err := db.Close()
-		if err != nil {
-
+	return d.inTx(ctx, func(tx *sql.Tx) error {
+		if i := strings.LastIndex(name, BoxPathSeparator); i >= 0 {
+			parent := name[:i]
+			exists, err := boxExists(ctx, tx, parent)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				return fmt.Errorf("parent box %s doesn't exist", parent)
+			}
 		}
-	}(db)
-	tx, err := db.Begin()
-	if err != nil {
-		return err
-	}
-	stmt, err := tx.Prepare("INSERT INTO boxes(name, createTime, minTime, maxTime) values(?, ?, ?, ?)")
-	if err != nil {
+		_, err := tx.ExecContext(ctx, "INSERT INTO boxes(name, createTime, minTime, maxTime) values(?, ?, ?, ?)", name, time.Now().Unix(), minTime, maxTime)
 		return err
-	}
-	defer func(stmt *sql.Stmt) {
-		err := stmt.Close()
-		if err != nil {
+	})
+}
 
-		}
-	}(stmt)
-	now := time.Now().Unix()
-	_, err = stmt.Exec(name, now, minTime, maxTime)
-	if err != nil {
-		return err
-	}
-	err = tx.Commit()
-	return err
+func (d *TBDB) DoesSpanOverlap(ctx context.Context, start, end int64) (bool, error) {
+	return spanOverlaps(ctx, d.q(), start, end, 0)
 }
 
-func (d TBDB) DoesSpanOverlap(start, end int64) (bool, error) {
-	var result bool
+// spanOverlaps reports whether a time range overlaps a span other than the
+// span with the ID except, 0 checks all spans
+func spanOverlaps(ctx context.Context, q querier, start, end, except int64) (bool, error) {
+	// a span ending after start began less than the longest span before it,
+	// see span_lengths
 	var count int
-	db, err := sql.Open(d.driver, d.name)
-	if err != nil {
-		return result, err
-	}
-	defer func(db *sql.DB) {
-		err := db.Close()
-		if err != nil {
-
-		}
-	}(db)
-	row := db.QueryRow("SELECT COUNT(*) FROM spans WHERE NOT ? <= start AND NOT ? >= end;", end, start)
-	err = row.Scan(&count)
-	if err != nil {
-		return result, err
-	}
-	result = count > 0
-	return result, nil
+	err := q.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM spans WHERE start > ? - (SELECT longest FROM span_lengths) AND start < ? AND end > ? AND id != ?",
+		start, end, start, except,
+	).Scan(&count)
+	return count > 0, err
 }
 
-func (d TBDB) DoesBoxExist(name string) (bool, error) {
-	var result bool
-	db, err := sql.Open(d.driver, d.name)
-	if err != nil {
-		return result, err
-	}
-	defer func(db *sql.DB) {
-		err := db.Close()
-		if err != nil {
+func (d *TBDB) DoesBoxExist(ctx context.Context, name string) (bool, error) {
+	return boxExists(ctx, d.q(), name)
+}
 
-		}
-	}(db)
-	row := db.QueryRow("SELECT COUNT(*) FROM boxes WHERE name = ?", name)
+func boxExists(ctx context.Context, q querier, name string) (bool, error) {
 	var count int
-	err = row.Scan(&count)
-	if err != nil {
-		return result, err
-	}
-	result = count > 0
-	return result, nil
+	err := q.QueryRowContext(ctx, "SELECT COUNT(*) FROM boxes WHERE name = ?", name).Scan(&count)
+	return count > 0, err
 }
 
 // Read functions
 
-func (d TBDB) GetBox(name string) (BoxRow, error) {
+func (d *TBDB) GetBox(ctx context.Context, name string) (BoxRow, error) {
 	var result BoxRow
-	db, err := sql.Open(d.driver, d.name)
-	if err != nil {
-		return result, err
-	}
-	defer func(db *sql.DB) {
-		err := db.Close()
-		if err != nil {
-
-		}
-	}(db)
-	row := db.QueryRow("SELECT * FROM boxes WHERE name = ?", name)
-	var createTime, minTime, maxTime int64
-	err = row.Scan(&name, &createTime, &minTime, &maxTime)
+	row := d.q().QueryRowContext(ctx, "SELECT name, createTime, minTime, maxTime, archiveTime FROM boxes WHERE name = ?", name)
+	err := row.Scan(&result.Name, &result.CreateTime, &result.MinTime, &result.MaxTime, &result.ArchiveTime)
 	if err != nil {
-		return result, err
+		return BoxRow{}, err
 	}
-	return BoxRow{name, createTime, minTime, maxTime}, nil
+	return result, nil
 }
 
-func (d TBDB) GetAllBoxes() ([]BoxRow, error) {
+func (d *TBDB) GetAllBoxes(ctx context.Context) ([]BoxRow, error) {
 	var result []BoxRow
-	db, err := sql.Open(d.driver, d.name)
+	rows, err := d.q().QueryContext(ctx, "SELECT name, createTime, minTime, maxTime, archiveTime FROM boxes ORDER BY createTime DESC")
 	if err != nil {
 		return result, err
 	}
-	defer func(db *sql.DB) {
-		err := db.Close()
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+	for rows.Next() {
+		var br BoxRow
+		err := rows.Scan(&br.Name, &br.CreateTime, &br.MinTime, &br.MaxTime, &br.ArchiveTime)
 		if err != nil {
-
+			return result, err
 		}
-	}(db)
-	rows, err := db.Query("SELECT * FROM boxes ORDER BY createTime DESC")
+		result = append(result, br)
+	}
+	return result, rows.Err()
+}
+
+// GetChildBoxes returns the names of the direct sub-boxes of a box
+func (d *TBDB) GetChildBoxes(ctx context.Context, name string) ([]string, error) {
+	return childBoxes(ctx, d.q(), name)
+}
+
+func childBoxes(ctx context.Context, q querier, name string) ([]string, error) {
+	var result []string
+	prefix := name + BoxPathSeparator
+	rows, err := q.QueryContext(ctx,
+		"SELECT name FROM boxes WHERE substr(name, 1, length(?)) = ? AND instr(substr(name, length(?) + 1), ?) = 0 ORDER BY createTime DESC",
+		prefix, prefix, prefix, BoxPathSeparator,
+	)
 	if err != nil {
 		return result, err
 	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
 	for rows.Next() {
-		var name string
-		var createTime, minTime, maxTime int64
-		err := rows.Scan(&name, &createTime, &minTime, &maxTime)
+		var child string
+		err := rows.Scan(&child)
 		if err != nil {
 			return result, err
 		}
-		result = append(result, BoxRow{name, createTime, minTime, maxTime})
+		result = append(result, child)
 	}
-	return result, nil
+	return result, rows.Err()
 }
 
-func (d TBDB) GetSpansForBox(boxName string) ([]SpanRow, error) {
-	var result []SpanRow
-	db, err := sql.Open(d.driver, d.name)
+// GetSpansForBox returns the spans of a box, sql.ErrNoRows if there is no
+// such box
+func (d *TBDB) GetSpansForBox(ctx context.Context, boxName string) ([]SpanRow, error) {
+	exists, err := boxExists(ctx, d.q(), boxName)
 	if err != nil {
-		return result, err
+		return nil, err
 	}
-	defer func(db *sql.DB) {
-		err := db.Close()
-		if err != nil {
-
-		}
-	}(db)
-	box, err := d.GetBox(boxName)
+	if !exists {
+		return nil, sql.ErrNoRows
+	}
+	rows, err := d.q().QueryContext(ctx, "SELECT id, start, end, box, notes, tz FROM spans WHERE box = ? ORDER BY start", boxName)
 	if err != nil {
-		return result, err
+		return nil, err
 	}
-	rows, err := db.Query("SELECT * FROM spans WHERE box = ? ORDER BY start", box.Name)
+	result, err := scanSpanRows(rows)
 	if err != nil {
 		return result, err
 	}
-	for rows.Next() {
-		var id int64
-		var name string
-		var start, end int64
-		err := rows.Scan(&id, &start, &end, &name)
-		if err != nil {
-			return result, err
-		}
-		result = append(result, SpanRow{id, start, end, name})
-	}
-	return result, nil
+	err = fillSpanTags(ctx, d.q(), result)
+	return result, err
 }
 
-func (d TBDB) GetSpansForTimeRange(start, end int64) ([]SpanRow, error) {
-	var result []SpanRow
-	db, err := sql.Open(d.driver, d.name)
+// GetSpansForTimeRange returns the spans contained in [start, end]. If tags
+// are given, only spans having all of them are returned.
+func (d *TBDB) GetSpansForTimeRange(ctx context.Context, start, end int64, tags ...string) ([]SpanRow, error) {
+	// a span ending by end started at least the shortest span before it
+	query := "SELECT id, start, end, box, notes, tz FROM spans WHERE start >= ? AND start <= ? - (SELECT shortest FROM span_lengths) AND end <= ?"
+	args := []any{start, end, end}
+	if len(tags) > 0 {
+		filter, filterArgs := hasAllTagsFilter(tags)
+		query += " AND " + filter
+		args = append(args, filterArgs...)
+	}
+	rows, err := d.q().QueryContext(ctx, query+" ORDER BY start", args...)
 	if err != nil {
-		return result, err
+		return nil, err
 	}
-	defer func(db *sql.DB) {
-		err := db.Close()
-		if err != nil {
-
-		}
-	}(db)
-	rows, err := db.Query("SELECT * FROM spans WHERE start >= ? AND end <= ? ORDER BY start", start, end)
+	result, err := scanSpanRows(rows)
 	if err != nil {
 		return result, err
 	}
-	for rows.Next() {
-		id := -1
-		var name string
-		var start, end int64
-		err := rows.Scan(&id, &start, &end, &name)
-		if err != nil {
-			return result, err
-		}
-		result = append(result, SpanRow{int64(id), start, end, name})
-	}
-	return result, nil
+	err = fillSpanTags(ctx, d.q(), result)
+	return result, err
 }
 
 // Update functions
 
-func (d TBDB) UpdateBox(name string, minTime, maxTime int64) error {
-	db, err := sql.Open(d.driver, d.name)
-	if err != nil {
-		return err
+func (d *TBDB) UpdateBox(ctx context.Context, name string, minTime, maxTime int64) error {
+	if minTime > maxTime {
+		return fmt.Errorf("minTime is greater than maxTime")
 	}
-	defer func(db *sql.DB) {
-		err := db.Close()
+	_, err := d.q().ExecContext(ctx, "UPDATE boxes SET minTime = ?, maxTime = ? WHERE name = ?", minTime, maxTime, name)
+	return err
+}
+
+// ArchiveBox archives a box and its sub-boxes at archiveTime, they keep
+// their spans and targets
+func (d *TBDB) ArchiveBox(ctx context.Context, name string, archiveTime int64) error {
+	return d.inTx(ctx, func(tx *sql.Tx) error {
+		err := checkBoxExists(ctx, tx, name)
 		if err != nil {
+			return err
+		}
+		if archiveTime <= 0 {
+			return fmt.Errorf("invalid archive time %d", archiveTime)
+		}
+		// sub-boxes archived before keep their time
+		prefix := name + BoxPathSeparator
+		_, err = tx.ExecContext(ctx,
+			"UPDATE boxes SET archiveTime = ? WHERE archiveTime = 0 AND (name = ? OR substr(name, 1, length(?)) = ?)",
+			archiveTime, name, prefix, prefix,
+		)
+		return err
+	})
+}
 
+// UnarchiveBox makes a box active again along with its sub-boxes and the
+// boxes it is part of
+func (d *TBDB) UnarchiveBox(ctx context.Context, name string) error {
+	return d.inTx(ctx, func(tx *sql.Tx) error {
+		err := checkBoxExists(ctx, tx, name)
+		if err != nil {
+			return err
 		}
-	}(db)
-	stmt, err := db.Prepare("UPDATE boxes SET minTime = ?, maxTime = ? WHERE name = ?")
-	if err != nil {
+		prefix := name + BoxPathSeparator
+		_, err = tx.ExecContext(ctx,
+			"UPDATE boxes SET archiveTime = 0 WHERE name = ? OR substr(name, 1, length(?)) = ? OR substr(?, 1, length(name) + 1) = name || ?",
+			name, prefix, prefix, name, BoxPathSeparator,
+		)
 		return err
-	}
-	_, err = stmt.Exec(minTime, maxTime, name)
-	return err
+	})
 }
 
 // Delete functions
 
-func (d TBDB) DeleteBox(name string) error {
-	db, err := sql.Open(d.driver, d.name)
-	if err != nil {
-		return err
-	}
-	defer func(db *sql.DB) {
-		err := db.Close()
+// DeleteBox deletes a box that has no spans. Boxes with sub-boxes can only
+// be deleted as a whole with DeleteBoxTree.
+func (d *TBDB) DeleteBox(ctx context.Context, name string) error {
+	return d.inTx(ctx, func(tx *sql.Tx) error {
+		err := checkNoChildren(ctx, tx, name)
+		if err != nil {
+			return err
+		}
+		var count int
+		err = tx.QueryRowContext(ctx, "SELECT (SELECT COUNT(*) FROM spans WHERE box = ?) + (SELECT COUNT(*) FROM timer WHERE box = ?)", name, name).Scan(&count)
 		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return fmt.Errorf("box %s has spans", name)
+		}
+		// the targets are deleted along with the box
+		_, err = tx.ExecContext(ctx, "DELETE FROM boxes WHERE name = ?", name)
+		return err
+	})
+}
 
+// DeleteBoxAndSpans deletes a box without sub-boxes along with its spans
+func (d *TBDB) DeleteBoxAndSpans(ctx context.Context, name string) error {
+	return d.inTx(ctx, func(tx *sql.Tx) error {
+		err := checkNoChildren(ctx, tx, name)
+		if err != nil {
+			return err
 		}
-	}(db)
-	stmt, err := db.Prepare("DELETE FROM boxes WHERE name = ?")
-	_, err = stmt.Exec(name)
-	return err
+		stmts := []string{
+			"DELETE FROM span_tags WHERE span_id IN (SELECT id FROM spans WHERE box = ?)",
+			"DELETE FROM spans WHERE box = ?",
+			"DELETE FROM timer WHERE box = ?",
+			"DELETE FROM boxes WHERE name = ?",
+		}
+		for _, stmt := range stmts {
+			_, err = tx.ExecContext(ctx, stmt, name)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 }
 
-func (d TBDB) DeleteBoxAndSpans(name string) error {
-	db, err := sql.Open(d.driver, d.name)
-	if err != nil {
-		return err
+// RenameBox renames a box and its sub-boxes, which can move it to another
+// parent. Spans, the timer and targets follow through their foreign keys.
+func (d *TBDB) RenameBox(ctx context.Context, oldName, newName string) error {
+	if oldName == newName {
+		return nil
 	}
-	defer func(db *sql.DB) {
-		err := db.Close()
+	if strings.HasPrefix(newName, oldName+BoxPathSeparator) {
+		return fmt.Errorf("can't move box %s into itself", oldName)
+	}
+	return d.inTx(ctx, func(tx *sql.Tx) error {
+		err := checkBoxExists(ctx, tx, oldName)
+		if err != nil {
+			return err
+		}
+		if i := strings.LastIndex(newName, BoxPathSeparator); i >= 0 {
+			parent := newName[:i]
+			exists, err := boxExists(ctx, tx, parent)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				return fmt.Errorf("parent box %s doesn't exist", parent)
+			}
+		}
+		oldPrefix, newPrefix := oldName+BoxPathSeparator, newName+BoxPathSeparator
+		var taken sql.NullString
+		err = tx.QueryRowContext(ctx,
+			"SELECT MIN(name) FROM boxes WHERE name = ? OR (substr(name, 1, length(?)) = ? AND ? || substr(name, length(?) + 1) IN (SELECT name FROM boxes))",
+			newName, oldPrefix, oldPrefix, newPrefix, oldPrefix,
+		).Scan(&taken)
 		if err != nil {
+			return err
+		}
+		if taken.Valid {
+			conflict := taken.String
+			if conflict != newName {
+				conflict = newPrefix + conflict[len(oldPrefix):]
+			}
+			return fmt.Errorf("box %s already exists", conflict)
+		}
+		_, err = tx.ExecContext(ctx,
+			"UPDATE boxes SET name = CASE WHEN name = ? THEN ? ELSE ? || substr(name, length(?) + 1) END WHERE name = ? OR substr(name, 1, length(?)) = ?",
+			oldName, newName, newPrefix, oldPrefix, oldName, oldPrefix, oldPrefix,
+		)
+		return err
+	})
+}
 
+// DeleteBoxTree deletes a box, all of its sub-boxes and all of their spans
+func (d *TBDB) DeleteBoxTree(ctx context.Context, name string) error {
+	return d.inTx(ctx, func(tx *sql.Tx) error {
+		prefix := name + BoxPathSeparator
+		inTree := "(box = ? OR substr(box, 1, length(?)) = ?)"
+		stmts := []string{
+			"DELETE FROM span_tags WHERE span_id IN (SELECT id FROM spans WHERE " + inTree + ")",
+			"DELETE FROM spans WHERE " + inTree,
+			"DELETE FROM timer WHERE " + inTree,
+			"DELETE FROM box_targets WHERE " + inTree,
+			"DELETE FROM boxes WHERE (name = ? OR substr(name, 1, length(?)) = ?)",
 		}
-	}(db)
-	stmt, err := db.Prepare("DELETE FROM boxes WHERE name = ?")
-	_, err = stmt.Exec(name)
+		for _, stmt := range stmts {
+			_, err := tx.ExecContext(ctx, stmt, name, prefix, prefix)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func checkBoxExists(ctx context.Context, q querier, name string) error {
+	exists, err := boxExists(ctx, q, name)
 	if err != nil {
 		return err
 	}
-	stmt, err = db.Prepare("DELETE FROM spans WHERE box = ?")
-	_, err = stmt.Exec(name)
-	return err
+	if !exists {
+		return fmt.Errorf("box %s doesn't exist", name)
+	}
+	return nil
 }
 
-func (d TBDB) DeleteSpan(start, end int64, box string) error {
-	db, err := sql.Open(d.driver, d.name)
+func checkNoChildren(ctx context.Context, q querier, name string) error {
+	children, err := childBoxes(ctx, q, name)
 	if err != nil {
 		return err
 	}
-	defer func(db *sql.DB) {
-		err := db.Close()
+	if len(children) > 0 {
+		return fmt.Errorf("box %s has sub-boxes", name)
+	}
+	return nil
+}
+
+func (d *TBDB) DeleteSpan(ctx context.Context, start, end int64, box string) error {
+	return d.inTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, "DELETE FROM span_tags WHERE span_id IN (SELECT id FROM spans WHERE start = ? AND end = ? AND box = ?)", start, end, box)
 		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "DELETE FROM spans WHERE start = ? AND end = ? AND box = ?", start, end, box)
+		return err
+	})
+}
 
+// DeleteSpanByID deletes a span with its tags, the span must exist
+func (d *TBDB) DeleteSpanByID(ctx context.Context, id int64) error {
+	return d.inTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, "DELETE FROM span_tags WHERE span_id = ?", id)
+		if err != nil {
+			return err
 		}
-	}(db)
-	stmt, err := db.Prepare("DELETE FROM spans WHERE start = ? AND end = ? AND box = ? ")
-	_, err = stmt.Exec(start, end, box)
-	return err
+		res, err := tx.ExecContext(ctx, "DELETE FROM spans WHERE id = ?", id)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return fmt.Errorf("span %d doesn't exist", id)
+		}
+		return nil
+	})
 }
 
-func (d TBDB) DeleteSpanByID(id int64) error {
-	db, err := sql.Open(d.driver, d.name)
+// UpdateSpan changes a span's times and box, the span must not overlap
+// other spans
+func (d *TBDB) UpdateSpan(ctx context.Context, id, start, end int64, box string) error {
+	err := ValidateSpanTimes(start, end)
 	if err != nil {
 		return err
 	}
-	defer func(db *sql.DB) {
-		err := db.Close()
+	return d.inTx(ctx, func(tx *sql.Tx) error {
+		err := checkBoxExists(ctx, tx, box)
 		if err != nil {
-
+			return err
 		}
-	}(db)
-	stmt, err := db.Prepare("DELETE FROM spans WHERE id = ? ")
-	_, err = stmt.Exec(id)
-	return err
+		overlaps, err := spanOverlaps(ctx, tx, start, end, id)
+		if err != nil {
+			return err
+		}
+		if overlaps {
+			return fmt.Errorf("time overlaps existing span")
+		}
+		_, err = tx.ExecContext(ctx, "UPDATE spans SET start = ?, end = ?, box = ? WHERE id = ?", start, end, box, id)
+		return err
+	})
 }
 
-func (d TBDB) UpdateSpan(id, start, end int64, box string) error {
-	db, err := sql.Open(d.driver, d.name)
-	if err != nil {
+// UpdateSpanRow updates a span's times, box and notes, and replaces its tags
+func (d *TBDB) UpdateSpanRow(ctx context.Context, span SpanRow) error {
+	return d.inTx(ctx, func(tx *sql.Tx) error {
+		_, err := updateSpanRow(ctx, tx, span)
 		return err
+	})
+}
+
+// updateSpanRow updates a span after checking its times, its box and that
+// it doesn't overlap other spans, and returns whether the span exists
+func updateSpanRow(ctx context.Context, tx *sql.Tx, span SpanRow) (bool, error) {
+	err := ValidateSpanTimes(span.Start, span.End)
+	if err != nil {
+		return false, err
 	}
-	defer func(db *sql.DB) {
-		err := db.Close()
-		if err != nil {
+	err = checkBoxExists(ctx, tx, span.Box)
+	if err != nil {
+		return false, err
+	}
+	overlaps, err := spanOverlaps(ctx, tx, span.Start, span.End, span.ID)
+	if err != nil {
+		return false, err
+	}
+	if overlaps {
+		return false, fmt.Errorf("time overlaps existing span")
+	}
+	return writeSpanRow(ctx, tx, span)
+}
 
+// writeSpanRow updates a span and its tags without any checks, and returns
+// whether the span exists
+func writeSpanRow(ctx context.Context, tx *sql.Tx, span SpanRow) (bool, error) {
+	res, err := tx.ExecContext(ctx, "UPDATE spans SET start = ?, end = ?, box = ?, notes = ?, tz = ? WHERE id = ?", span.Start, span.End, span.Box, span.Notes, span.TZ, span.ID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return false, err
+	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM span_tags WHERE span_id = ?", span.ID)
+	if err != nil {
+		return false, err
+	}
+	return true, setSpanTags(ctx, tx, span.ID, span.Tags)
+}
+
+// ApplySpanChanges deletes, updates and adds spans in one transaction. The
+// updated and added spans must not overlap the other spans once all changes
+// are made, so spans can trade time with each other.
+func (d *TBDB) ApplySpanChanges(ctx context.Context, changes SpanChanges) ([]int64, error) {
+	err := changes.validate()
+	if err != nil {
+		return nil, err
+	}
+	var ids []int64
+	err = d.inTx(ctx, func(tx *sql.Tx) error {
+		for _, id := range changes.Delete {
+			_, err := tx.ExecContext(ctx, "DELETE FROM span_tags WHERE span_id = ?", id)
+			if err != nil {
+				return err
+			}
+			_, err = tx.ExecContext(ctx, "DELETE FROM spans WHERE id = ?", id)
+			if err != nil {
+				return err
+			}
+		}
+		var changed []SpanRow
+		for _, span := range changes.Update {
+			err := checkBoxExists(ctx, tx, span.Box)
+			if err != nil {
+				return err
+			}
+			found, err := writeSpanRow(ctx, tx, span)
+			if err != nil {
+				return err
+			}
+			if !found {
+				return fmt.Errorf("span %d doesn't exist", span.ID)
+			}
+			changed = append(changed, span)
 		}
-	}(db)
-	stmt, err := db.Prepare("UPDATE spans SET start = ?, end = ?, box = ? WHERE id = ?")
+		for _, span := range changes.Add {
+			err := checkBoxExists(ctx, tx, span.Box)
+			if err != nil {
+				return err
+			}
+			span.ID, err = writeNewSpan(ctx, tx, span)
+			if err != nil {
+				return err
+			}
+			ids = append(ids, span.ID)
+			changed = append(changed, span)
+		}
+		for _, span := range changed {
+			overlaps, err := spanOverlaps(ctx, tx, span.Start, span.End, span.ID)
+			if err != nil {
+				return err
+			}
+			if overlaps {
+				return fmt.Errorf("time overlaps existing span")
+			}
+		}
+		return nil
+	})
 	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// Timer functions
+
+func (d *TBDB) StartTimer(ctx context.Context, timer TimerRow) error {
+	if timer.Start > time.Now().Unix() {
+		return fmt.Errorf("start time is in the future")
+	}
+	return d.inTx(ctx, func(tx *sql.Tx) error {
+		err := checkBoxExists(ctx, tx, timer.Box)
+		if err != nil {
+			return err
+		}
+		_, running, err := getTimer(ctx, tx)
+		if err != nil {
+			return err
+		}
+		if running {
+			return fmt.Errorf("a timer is already running")
+		}
+		overlaps, err := spanOverlaps(ctx, tx, timer.Start, timer.Start, 0)
+		if err != nil {
+			return err
+		}
+		if overlaps {
+			return fmt.Errorf("start time overlaps existing span")
+		}
+		_, err = tx.ExecContext(ctx, "INSERT INTO timer(id, start, box, tz) values(1, ?, ?, ?)", timer.Start, timer.Box, timer.TZ)
 		return err
+	})
+}
+
+func (d *TBDB) GetTimer(ctx context.Context) (TimerRow, bool, error) {
+	return getTimer(ctx, d.q())
+}
+
+func getTimer(ctx context.Context, q querier) (TimerRow, bool, error) {
+	var result TimerRow
+	row := q.QueryRowContext(ctx, "SELECT start, box, tz FROM timer WHERE id = 1")
+	err := row.Scan(&result.Start, &result.Box, &result.TZ)
+	if err == sql.ErrNoRows {
+		return result, false, nil
 	}
-	_, err = stmt.Exec(start, end, box, id)
+	if err != nil {
+		return result, false, err
+	}
+	return result, true, nil
+}
+
+// StopTimer turns the running timer into a span ending at end. The span goes
+// through the same validation as AddSpan, the timer is kept if it fails.
+func (d *TBDB) StopTimer(ctx context.Context, end int64) (SpanRow, error) {
+	var result SpanRow
+	err := d.inTx(ctx, func(tx *sql.Tx) error {
+		timer, running, err := getTimer(ctx, tx)
+		if err != nil {
+			return err
+		}
+		if !running {
+			return fmt.Errorf("no timer is running")
+		}
+		span := SpanRow{Start: timer.Start, End: end, Box: timer.Box, TZ: timer.TZ}
+		err = ValidateSpanTimes(span.Start, span.End)
+		if err != nil {
+			return err
+		}
+		span.ID, err = insertSpan(ctx, tx, span)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "DELETE FROM timer")
+		if err != nil {
+			return err
+		}
+		result = span
+		return nil
+	})
+	return result, err
+}
+
+func (d *TBDB) DeleteTimer(ctx context.Context) error {
+	_, err := d.q().ExecContext(ctx, "DELETE FROM timer")
 	return err
 }
@@ -1,11 +1,13 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"log"
+	"math"
 	"os"
 	"path/filepath"
 	"testing"
@@ -14,27 +16,30 @@ import (
 
 const dbName = "test.db"
 
-func setup(t *testing.T) TBDB {
+var ctx = context.Background()
+
+func setup(t *testing.T) *TBDB {
 	tempDir, err := os.MkdirTemp(os.TempDir(), "timebox")
 	require.NoError(t, err)
 	testdb := filepath.Join(tempDir, filepath.FromSlash(dbName))
 	err = os.Remove(testdb)
 	require.NoFileExists(t, testdb)
-	tbdb := NewDBWithName(testdb)
-	require.NoError(t, tbdb.CreateDB())
+	tbdb := openDB(t, testdb)
+	require.NoError(t, tbdb.CreateDB(ctx))
 	return tbdb
 }
 
-func boxWithCreateTime(t *testing.T, tbdb TBDB, name string, minTime, maxTime, ts int64) {
-	db, err := sql.Open(tbdb.driver, tbdb.name)
+func openDB(t testing.TB, name string) *TBDB {
+	tbdb, err := Open(ctx, name)
 	require.NoError(t, err)
-	defer func(db *sql.DB) {
-		err := db.Close()
-		if err != nil {
-			log.Fatalf("error closing db: %v", err)
-		}
-	}(db)
-	tx, err := db.Begin()
+	t.Cleanup(func() {
+		require.NoError(t, tbdb.Close())
+	})
+	return tbdb
+}
+
+func boxWithCreateTime(t *testing.T, tbdb *TBDB, name string, minTime, maxTime, ts int64) {
+	tx, err := tbdb.db.Begin()
 	require.NoError(t, err)
 	stmt, err := tx.Prepare("INSERT INTO boxes(name, createTime, minTime, maxTime) values(?, ?, ?, ?)")
 	require.NoError(t, err)
@@ -52,10 +57,10 @@ func boxWithCreateTime(t *testing.T, tbdb TBDB, name string, minTime, maxTime, t
 
 func TestTBDB_CreateDB(t *testing.T) {
 	tbdb := setup(t)
-	exists, err := tbdb.DoesBoxExist("box name")
+	exists, err := tbdb.DoesBoxExist(ctx, "box name")
 	require.NoError(t, err)
 	assert.False(t, exists)
-	overlaps, err := tbdb.DoesSpanOverlap(0, 1)
+	overlaps, err := tbdb.DoesSpanOverlap(ctx, 0, 1)
 	require.NoError(t, err)
 	assert.False(t, overlaps)
 	//require.NoError(t, os.Remove(testdb))
@@ -63,33 +68,33 @@ func TestTBDB_CreateDB(t *testing.T) {
 
 func TestTBDB_AddBox(t *testing.T) {
 	tbdb := setup(t)
-	require.NoError(t, tbdb.AddBox("box-1", 1, 2))
-	require.NoError(t, tbdb.AddBox("box-2", 1, 2))
-	err := tbdb.AddBox("box-1", 3, 4)
+	require.NoError(t, tbdb.AddBox(ctx, "box-1", 1, 2))
+	require.NoError(t, tbdb.AddBox(ctx, "box-2", 1, 2))
+	err := tbdb.AddBox(ctx, "box-1", 3, 4)
 	require.Error(t, err)
 	assert.ErrorContains(t, err, "constraint failed")
-	err = tbdb.AddBox("box-3", 5, 2)
+	err = tbdb.AddBox(ctx, "box-3", 5, 2)
 	require.Error(t, err)
 	assert.EqualError(t, err, "minTime is greater than maxTime")
 }
 
 func TestTBDB_AddSpan(t *testing.T) {
 	tbdb := setup(t)
-	require.NoError(t, tbdb.AddBox("box-1", 1, 2))
-	require.NoError(t, tbdb.AddBox("box-2", 1, 2))
-	require.NoError(t, tbdb.AddSpan(1, 10, "box-1"))
-	require.NoError(t, tbdb.AddSpan(12, 14, "box-2"))
-	err := tbdb.AddSpan(7, 11, "box-1")
+	require.NoError(t, tbdb.AddBox(ctx, "box-1", 1, 2))
+	require.NoError(t, tbdb.AddBox(ctx, "box-2", 1, 2))
+	require.NoError(t, tbdb.AddSpan(ctx, 1, 10, "box-1"))
+	require.NoError(t, tbdb.AddSpan(ctx, 12, 14, "box-2"))
+	err := tbdb.AddSpan(ctx, 7, 11, "box-1")
 	require.Error(t, err)
 	assert.EqualError(t, err, "time overlaps existing span")
-	err = tbdb.AddSpan(7, 11, "box-3")
+	err = tbdb.AddSpan(ctx, 7, 11, "box-3")
 	require.Error(t, err)
 	assert.EqualError(t, err, "box box-3 doesn't exist")
-	require.NoError(t, tbdb.AddSpan(15, 20, "box-1"))
-	err = tbdb.AddSpan(2, 1, "box-2")
+	require.NoError(t, tbdb.AddSpan(ctx, 15, 20, "box-1"))
+	err = tbdb.AddSpan(ctx, 2, 1, "box-2")
 	require.Error(t, err)
 	assert.EqualError(t, err, "start time is after end time")
-	err = tbdb.AddSpan(time.Now().Unix()+86400, time.Now().Unix()+100000, "box-1")
+	err = tbdb.AddSpan(ctx, time.Now().Unix()+86400, time.Now().Unix()+100000, "box-1")
 	assert.EqualError(t, err, "time span is in the future")
 }
 
@@ -111,9 +116,9 @@ func TestTBDB_GetBox(t *testing.T) {
 	for name, tc := range tests {
 		t.Run(name, func(t *testing.T) {
 			if tc.create {
-				require.NoError(t, tbdb.AddBox(tc.name, tc.minTime, tc.maxTime))
+				require.NoError(t, tbdb.AddBox(ctx, tc.name, tc.minTime, tc.maxTime))
 			}
-			br, err := tbdb.GetBox(tc.name)
+			br, err := tbdb.GetBox(ctx, tc.name)
 			if tc.expError {
 				assert.EqualError(t, err, tc.errStr)
 			} else {
@@ -149,9 +154,9 @@ func TestTBDB_GetAllBoxes(t *testing.T) {
 		t.Run(name, func(t *testing.T) {
 			tbdb := setup(t)
 			for _, box := range tc.boxes {
-				require.NoError(t, tbdb.AddBox(box.Name, box.MinTime, box.MaxTime))
+				require.NoError(t, tbdb.AddBox(ctx, box.Name, box.MinTime, box.MaxTime))
 			}
-			boxes, err := tbdb.GetAllBoxes()
+			boxes, err := tbdb.GetAllBoxes(ctx)
 			if tc.expError {
 				assert.EqualError(t, err, tc.errStr)
 			} else {
@@ -175,14 +180,14 @@ func TestTBDB_GetSpansForBox(t *testing.T) {
 	start := time.Now().Add(-1 * time.Hour)
 	boxWithCreateTime(t, tbdb, box, 1, 2, ts)
 	input := []SpanRow{
-		{1, start.Unix(), start.Add(5 * time.Minute).Unix(), box},
-		{2, start.Add(6 * time.Minute).Unix(), start.Add(7 * time.Minute).Unix(), box},
-		{3, start.Add(9 * time.Minute).Unix(), start.Add(12 * time.Minute).Unix(), box},
+		{ID: 1, Start: start.Unix(), End: start.Add(5 * time.Minute).Unix(), Box: box},
+		{ID: 2, Start: start.Add(6 * time.Minute).Unix(), End: start.Add(7 * time.Minute).Unix(), Box: box},
+		{ID: 3, Start: start.Add(9 * time.Minute).Unix(), End: start.Add(12 * time.Minute).Unix(), Box: box},
 	}
 	for _, i := range input {
-		require.NoError(t, tbdb.AddSpan(i.Start, i.End, i.Box))
+		require.NoError(t, tbdb.AddSpan(ctx, i.Start, i.End, i.Box))
 	}
-	spans, err := tbdb.GetSpansForBox("box-1")
+	spans, err := tbdb.GetSpansForBox(ctx, "box-1")
 	require.NoError(t, err)
 	assert.Equal(t, len(input), len(spans))
 	for i := range input {
@@ -195,9 +200,9 @@ func TestTBDB_GetSpansForBox(t *testing.T) {
 func TestTBDB_UpdateBox(t *testing.T) {
 	tbdb := setup(t)
 	box := "box-1"
-	require.NoError(t, tbdb.AddBox(box, 1, 2))
-	require.NoError(t, tbdb.UpdateBox(box, 1, 3))
-	br, err := tbdb.GetBox(box)
+	require.NoError(t, tbdb.AddBox(ctx, box, 1, 2))
+	require.NoError(t, tbdb.UpdateBox(ctx, box, 1, 3))
+	br, err := tbdb.GetBox(ctx, box)
 	require.NoError(t, err)
 	assert.Equal(t, int64(3), br.MaxTime)
 }
@@ -205,12 +210,12 @@ func TestTBDB_UpdateBox(t *testing.T) {
 func TestTBDB_DeleteBox(t *testing.T) {
 	tbdb := setup(t)
 	box := "box-1"
-	require.NoError(t, tbdb.AddBox(box, 1, 2))
-	exists, err := tbdb.DoesBoxExist(box)
+	require.NoError(t, tbdb.AddBox(ctx, box, 1, 2))
+	exists, err := tbdb.DoesBoxExist(ctx, box)
 	require.NoError(t, err)
 	assert.True(t, exists)
-	require.NoError(t, tbdb.DeleteBox(box))
-	exists, err = tbdb.DoesBoxExist(box)
+	require.NoError(t, tbdb.DeleteBox(ctx, box))
+	exists, err = tbdb.DoesBoxExist(ctx, box)
 	require.NoError(t, err)
 	assert.False(t, exists)
 }
@@ -218,17 +223,17 @@ func TestTBDB_DeleteBox(t *testing.T) {
 func TestTBDB_DeleteBoxAndSpans(t *testing.T) {
 	tbdb := setup(t)
 	box := "box-1"
-	require.NoError(t, tbdb.AddBox(box, 1, 2))
-	require.NoError(t, tbdb.AddSpan(1, 2, box))
-	require.NoError(t, tbdb.AddSpan(5, 7, box))
-	require.NoError(t, tbdb.AddBox("box-2", 1, 2))
-	require.NoError(t, tbdb.AddSpan(8, 10, "box-2"))
-	err := tbdb.DeleteBoxAndSpans(box)
+	require.NoError(t, tbdb.AddBox(ctx, box, 1, 2))
+	require.NoError(t, tbdb.AddSpan(ctx, 1, 2, box))
+	require.NoError(t, tbdb.AddSpan(ctx, 5, 7, box))
+	require.NoError(t, tbdb.AddBox(ctx, "box-2", 1, 2))
+	require.NoError(t, tbdb.AddSpan(ctx, 8, 10, "box-2"))
+	err := tbdb.DeleteBoxAndSpans(ctx, box)
 	require.NoError(t, err)
-	exists, err := tbdb.DoesBoxExist(box)
+	exists, err := tbdb.DoesBoxExist(ctx, box)
 	require.NoError(t, err)
 	assert.False(t, exists)
-	_, err = tbdb.GetSpansForBox(box)
+	_, err = tbdb.GetSpansForBox(ctx, box)
 	assert.EqualError(t, err, "sql: no rows in result set")
 }
 
@@ -236,24 +241,275 @@ func TestTBDB_DoesSpanOverlap(t *testing.T) {
 	tbdb := setup(t)
 	box1 := "box-1"
 	box2 := "box-2"
-	require.NoError(t, tbdb.AddBox(box1, 1, 2))
-	require.NoError(t, tbdb.AddSpan(1, 2, box1))
-	require.NoError(t, tbdb.AddSpan(5, 7, box1))
-	require.NoError(t, tbdb.AddBox(box2, 1, 2))
-	require.NoError(t, tbdb.AddSpan(8, 10, box2))
+	require.NoError(t, tbdb.AddBox(ctx, box1, 1, 2))
+	require.NoError(t, tbdb.AddSpan(ctx, 1, 2, box1))
+	require.NoError(t, tbdb.AddSpan(ctx, 5, 7, box1))
+	require.NoError(t, tbdb.AddBox(ctx, box2, 1, 2))
+	require.NoError(t, tbdb.AddSpan(ctx, 8, 10, box2))
 	// overlaps first span
 	span := SpanRow{Start: 1, End: 2, Box: box1}
-	overlaps, err := tbdb.DoesSpanOverlap(span.Start, span.End)
+	overlaps, err := tbdb.DoesSpanOverlap(ctx, span.Start, span.End)
 	require.NoError(t, err)
 	require.True(t, overlaps)
 	// doesn't overlap first span
 	span = SpanRow{Start: 2, End: 4, Box: box2}
-	overlaps, err = tbdb.DoesSpanOverlap(span.Start, span.End)
+	overlaps, err = tbdb.DoesSpanOverlap(ctx, span.Start, span.End)
 	require.NoError(t, err)
 	require.False(t, overlaps)
 	// overlaps last span
 	span = SpanRow{Start: 9, End: 12, Box: box1}
-	overlaps, err = tbdb.DoesSpanOverlap(span.Start, span.End)
+	overlaps, err = tbdb.DoesSpanOverlap(ctx, span.Start, span.End)
 	require.NoError(t, err)
 	require.True(t, overlaps)
 }
+
+func TestTBDB_LongSpans(t *testing.T) {
+	tbdb := setup(t)
+	require.NoError(t, tbdb.AddBox(ctx, "box-1", 1, 2))
+	require.NoError(t, tbdb.AddSpan(ctx, 100, 1000, "box-1"))
+	require.NoError(t, tbdb.AddSpan(ctx, 1000, 1010, "box-1"))
+	require.NoError(t, tbdb.AddSpan(ctx, 1020, 1020, "box-1"))
+	// found through the length of the longest span
+	for _, tc := range []struct {
+		start, end int64
+		want       bool
+	}{
+		{500, 501, true},
+		{999, 1001, true},
+		{1005, 1006, true},
+		{1010, 1020, false},
+		{0, 100, false},
+	} {
+		overlaps, err := tbdb.DoesSpanOverlap(ctx, tc.start, tc.end)
+		require.NoError(t, err)
+		assert.Equal(t, tc.want, overlaps, "%d-%d", tc.start, tc.end)
+	}
+	spans, err := tbdb.GetSpansForTimeRange(ctx, 0, 1010)
+	require.NoError(t, err)
+	assert.Len(t, spans, 2)
+	spans, err = tbdb.GetSpansForTimeRange(ctx, 1000, 1020)
+	require.NoError(t, err)
+	assert.Len(t, spans, 2)
+	spans, err = tbdb.GetSpansForTimeRange(ctx, math.MinInt64, math.MaxInt64)
+	require.NoError(t, err)
+	assert.Len(t, spans, 3)
+
+	// the bounds follow updates and deletes
+	require.NoError(t, tbdb.DeleteSpan(ctx, 100, 1000, "box-1"))
+	assertSpanLengths(t, tbdb, 0, 10)
+	require.NoError(t, tbdb.UpdateSpan(ctx, 3, 1020, 5000, "box-1"))
+	assertSpanLengths(t, tbdb, 10, 3980)
+	overlaps, err := tbdb.DoesSpanOverlap(ctx, 4000, 4001)
+	require.NoError(t, err)
+	assert.True(t, overlaps)
+	spans, err = tbdb.GetSpansForTimeRange(ctx, 1000, 1010)
+	require.NoError(t, err)
+	assert.Len(t, spans, 1)
+	// a span fixed after being much too long shrinks them again
+	require.NoError(t, tbdb.UpdateSpan(ctx, 3, 1020, 1025, "box-1"))
+	assertSpanLengths(t, tbdb, 5, 10)
+
+	// rebuilding the spans table drops the triggers, migrating adds them
+	_, err = tbdb.db.ExecContext(ctx, "DROP TRIGGER span_lengths_delete")
+	require.NoError(t, err)
+	require.NoError(t, tbdb.inTx(ctx, func(tx *sql.Tx) error {
+		return keepSpanLengths(ctx, tx)
+	}))
+	require.NoError(t, tbdb.DeleteSpanByID(ctx, 3))
+	assertSpanLengths(t, tbdb, 10, 10)
+}
+
+func assertSpanLengths(t *testing.T, tbdb *TBDB, shortest, longest int64) {
+	var s, l int64
+	require.NoError(t, tbdb.db.QueryRowContext(ctx, "SELECT shortest, longest FROM span_lengths").Scan(&s, &l))
+	assert.Equal(t, []int64{shortest, longest}, []int64{s, l})
+}
+
+func TestTBDB_Timer(t *testing.T) {
+	tbdb := setup(t)
+	box := "box-1"
+	now := time.Now().Unix()
+	require.NoError(t, tbdb.AddBox(ctx, box, 1, 2))
+	require.NoError(t, tbdb.AddSpan(ctx, now-7200, now-3600, box))
+	_, running, err := tbdb.GetTimer(ctx)
+	require.NoError(t, err)
+	assert.False(t, running)
+	_, err = tbdb.StopTimer(ctx, now)
+	assert.EqualError(t, err, "no timer is running")
+	err = tbdb.StartTimer(ctx, TimerRow{Start: now - 60, Box: "box-2"})
+	assert.EqualError(t, err, "box box-2 doesn't exist")
+	err = tbdb.StartTimer(ctx, TimerRow{Start: now + 3600, Box: box})
+	assert.EqualError(t, err, "start time is in the future")
+	err = tbdb.StartTimer(ctx, TimerRow{Start: now - 5000, Box: box})
+	assert.EqualError(t, err, "start time overlaps existing span")
+	require.NoError(t, tbdb.StartTimer(ctx, TimerRow{Start: now - 1800, Box: box}))
+	err = tbdb.StartTimer(ctx, TimerRow{Start: now - 60, Box: box})
+	assert.EqualError(t, err, "a timer is already running")
+	timer, running, err := tbdb.GetTimer(ctx)
+	require.NoError(t, err)
+	assert.True(t, running)
+	assert.Equal(t, TimerRow{Start: now - 1800, Box: box}, timer)
+	// stopping before the start fails validation and keeps the timer
+	_, err = tbdb.StopTimer(ctx, now-3600)
+	assert.EqualError(t, err, "start time is after end time")
+	_, running, err = tbdb.GetTimer(ctx)
+	require.NoError(t, err)
+	assert.True(t, running)
+	span, err := tbdb.StopTimer(ctx, now)
+	require.NoError(t, err)
+	assert.Equal(t, now-1800, span.Start)
+	assert.Equal(t, now, span.End)
+	_, running, err = tbdb.GetTimer(ctx)
+	require.NoError(t, err)
+	assert.False(t, running)
+	spans, err := tbdb.GetSpansForBox(ctx, box)
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(spans))
+}
+
+func TestTBDB_SpanNotesAndTags(t *testing.T) {
+	tbdb := setup(t)
+	box := "box-1"
+	require.NoError(t, tbdb.AddBox(ctx, box, 1, 2))
+	id1, err := tbdb.AddSpanRow(ctx, SpanRow{Start: 1, End: 2, Box: box, Notes: "scales", Tags: []string{"practice", "piano"}})
+	require.NoError(t, err)
+	id2, err := tbdb.AddSpanRow(ctx, SpanRow{Start: 3, End: 4, Box: box, Tags: []string{"practice"}})
+	require.NoError(t, err)
+	_, err = tbdb.AddSpanRow(ctx, SpanRow{Start: 5, End: 6, Box: box})
+	require.NoError(t, err)
+	spans, err := tbdb.GetSpansForBox(ctx, box)
+	require.NoError(t, err)
+	require.Equal(t, 3, len(spans))
+	assert.Equal(t, id1, spans[0].ID)
+	assert.Equal(t, "scales", spans[0].Notes)
+	assert.Equal(t, []string{"piano", "practice"}, spans[0].Tags)
+	assert.Equal(t, []string{"practice"}, spans[1].Tags)
+	assert.Empty(t, spans[2].Tags)
+
+	tests := map[string]struct {
+		tags []string
+		want int
+	}{
+		"no filter":   {tags: nil, want: 3},
+		"one tag":     {tags: []string{"practice"}, want: 2},
+		"all tags":    {tags: []string{"practice", "piano"}, want: 1},
+		"unknown tag": {tags: []string{"work"}, want: 0},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			spans, err := tbdb.GetSpansForTimeRange(ctx, 0, 10, tc.tags...)
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, len(spans))
+		})
+	}
+
+	require.NoError(t, tbdb.UpdateSpanRow(ctx, SpanRow{ID: id2, Start: 3, End: 4, Box: box, Notes: "arpeggios", Tags: []string{"piano"}}))
+	spans, err = tbdb.GetSpansForTimeRange(ctx, 0, 10, "piano")
+	require.NoError(t, err)
+	require.Equal(t, 2, len(spans))
+	assert.Equal(t, "arpeggios", spans[1].Notes)
+	assert.Equal(t, []string{"piano"}, spans[1].Tags)
+
+	require.NoError(t, tbdb.DeleteSpanByID(ctx, id1))
+	spans, err = tbdb.GetSpansForTimeRange(ctx, 0, 10, "practice")
+	require.NoError(t, err)
+	assert.Empty(t, spans)
+}
+
+func TestTBDB_CreateDBAddsNotesColumn(t *testing.T) {
+	tempDir, err := os.MkdirTemp(os.TempDir(), "timebox")
+	require.NoError(t, err)
+	testdb := filepath.Join(tempDir, filepath.FromSlash(dbName))
+	db, err := sql.Open(defaultDriver, testdb)
+	require.NoError(t, err)
+	_, err = db.Exec("CREATE TABLE spans (id INTEGER PRIMARY KEY AUTOINCREMENT, start INTEGER NOT NULL, end INTEGER NOT NULL, box TEXT NOT NULL)")
+	require.NoError(t, err)
+	_, err = db.Exec("INSERT INTO spans(start, end, box) values(1, 2, 'box-1')")
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+	tbdb := openDB(t, testdb)
+	require.NoError(t, tbdb.CreateDB(ctx))
+	require.NoError(t, tbdb.CreateDB(ctx))
+	spans, err := tbdb.GetSpansForTimeRange(ctx, 0, 10)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(spans))
+	assert.Equal(t, "", spans[0].Notes)
+}
+
+func TestTBDB_BoxHierarchy(t *testing.T) {
+	tbdb := setup(t)
+	err := tbdb.AddBox(ctx, "Work/ClientA", 1, 2)
+	assert.EqualError(t, err, "parent box Work doesn't exist")
+	require.NoError(t, tbdb.AddBox(ctx, "Work", 1, 2))
+	require.NoError(t, tbdb.AddBox(ctx, "Work/ClientA", 1, 2))
+	require.NoError(t, tbdb.AddBox(ctx, "Work/ClientA/Meetings", 1, 2))
+	require.NoError(t, tbdb.AddBox(ctx, "Work/ClientB", 1, 2))
+	require.NoError(t, tbdb.AddBox(ctx, "Workshop", 1, 2))
+	children, err := tbdb.GetChildBoxes(ctx, "Work")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"Work/ClientA", "Work/ClientB"}, children)
+	children, err = tbdb.GetChildBoxes(ctx, "Work/ClientB")
+	require.NoError(t, err)
+	assert.Empty(t, children)
+
+	require.NoError(t, tbdb.AddSpan(ctx, 1, 2, "Work"))
+	require.NoError(t, tbdb.AddSpan(ctx, 3, 4, "Work/ClientA/Meetings"))
+	require.NoError(t, tbdb.AddSpan(ctx, 5, 6, "Workshop"))
+	assert.EqualError(t, tbdb.DeleteBox(ctx, "Work/ClientA"), "box Work/ClientA has sub-boxes")
+	assert.EqualError(t, tbdb.DeleteBoxAndSpans(ctx, "Work"), "box Work has sub-boxes")
+
+	require.NoError(t, tbdb.DeleteBoxTree(ctx, "Work"))
+	boxes, err := tbdb.GetAllBoxes(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(boxes))
+	assert.Equal(t, "Workshop", boxes[0].Name)
+	spans, err := tbdb.GetSpansForTimeRange(ctx, 0, 10)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(spans))
+	assert.Equal(t, "Workshop", spans[0].Box)
+}
+
+func TestTBDB_Open(t *testing.T) {
+	tbdb := setup(t)
+	var mode string
+	require.NoError(t, tbdb.db.QueryRow("PRAGMA journal_mode").Scan(&mode))
+	assert.Equal(t, "wal", mode)
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err := tbdb.GetAllBoxes(canceled)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Error(t, tbdb.AddBox(canceled, "box-1", 1, 2))
+	exists, err := tbdb.DoesBoxExist(ctx, "box-1")
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestTBDB_ConcurrentAddSpan(t *testing.T) {
+	tbdb := setup(t)
+	require.NoError(t, tbdb.AddBox(ctx, "box-1", 1, 2))
+	// a second process writing to the same file
+	other := openDB(t, tbdb.name)
+	const n = 8
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		store := tbdb
+		if i%2 == 1 {
+			store = other
+		}
+		go func(store *TBDB, i int64) {
+			// every span overlaps all the others
+			errs <- store.AddSpan(ctx, 10+i, 100, "box-1")
+		}(store, int64(i))
+	}
+	var added int
+	for i := 0; i < n; i++ {
+		err := <-errs
+		if err == nil {
+			added++
+		} else {
+			assert.EqualError(t, err, "time overlaps existing span")
+		}
+	}
+	assert.Equal(t, 1, added)
+}
@@ -0,0 +1,200 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Sources of changes, recorded in the history
+const (
+	SourceCLI    = "cli"
+	SourceTUI    = "tui"
+	SourceImport = "import"
+	SourceAPI    = "api"
+)
+
+// Operations that made versions
+const (
+	VersionAdded   = "add"
+	VersionUpdated = "update"
+	VersionDeleted = "delete"
+)
+
+// VersionRow is a box or a span as a change left it. BoxName is set for
+// versions of boxes and SpanID for versions of spans. Box or Span holds the
+// version and is nil for deleted ones.
+type VersionRow struct {
+	ID          int64
+	Time        int64
+	Source      string
+	Operation   string
+	Description string
+	BoxName     string
+	SpanID      int64
+	Box         *BoxImage
+	Span        *SpanRow
+}
+
+// versions returns the boxes and spans named by keys that differ between
+// before and after, as after has them
+func versions(at int64, source, description string, keys ImageKeys, before, after Image) []VersionRow {
+	keys = keys.normalized()
+	before.normalize()
+	after.normalize()
+	base := VersionRow{Time: at, Source: source, Description: description}
+	var result []VersionRow
+	oldBoxes, newBoxes := make(map[string]BoxImage), make(map[string]BoxImage)
+	for _, b := range before.Boxes {
+		oldBoxes[b.Name] = b
+	}
+	for _, b := range after.Boxes {
+		newBoxes[b.Name] = b
+	}
+	for _, name := range keys.Boxes {
+		old, hadOld := oldBoxes[name]
+		b, hasNew := newBoxes[name]
+		op, ok := versionOperation(hadOld, hasNew, old, b)
+		if !ok {
+			continue
+		}
+		v := base
+		v.Operation, v.BoxName = op, name
+		if hasNew {
+			v.Box = &b
+		}
+		result = append(result, v)
+	}
+	oldSpans, newSpans := make(map[int64]SpanRow), make(map[int64]SpanRow)
+	for _, s := range before.Spans {
+		oldSpans[s.ID] = s
+	}
+	for _, s := range after.Spans {
+		newSpans[s.ID] = s
+	}
+	for _, id := range keys.Spans {
+		old, hadOld := oldSpans[id]
+		s, hasNew := newSpans[id]
+		op, ok := versionOperation(hadOld, hasNew, old, s)
+		if !ok {
+			continue
+		}
+		v := base
+		v.Operation, v.SpanID = op, id
+		if hasNew {
+			v.Span = &s
+		}
+		result = append(result, v)
+	}
+	return result
+}
+
+// versionOperation returns the operation that turned old into v, false if
+// nothing changed
+func versionOperation(hadOld, hasNew bool, old, v any) (string, bool) {
+	switch {
+	case !hadOld && hasNew:
+		return VersionAdded, true
+	case hadOld && !hasNew:
+		return VersionDeleted, true
+	case !hadOld && !hasNew:
+		return "", false
+	}
+	a, errA := json.Marshal(old)
+	b, errB := json.Marshal(v)
+	if errA == nil && errB == nil && string(a) == string(b) {
+		return "", false
+	}
+	return VersionUpdated, true
+}
+
+func addVersions(ctx context.Context, tx *sql.Tx, vs []VersionRow) error {
+	for _, v := range vs {
+		var version any
+		var err error
+		var raw []byte
+		switch {
+		case v.Box != nil:
+			raw, err = json.Marshal(v.Box)
+		case v.Span != nil:
+			raw, err = json.Marshal(v.Span)
+		}
+		if err != nil {
+			return err
+		}
+		if raw != nil {
+			version = string(raw)
+		}
+		var boxName, spanID any
+		if v.BoxName != "" {
+			boxName = v.BoxName
+		} else {
+			spanID = v.SpanID
+		}
+		_, err = tx.ExecContext(ctx,
+			"INSERT INTO history(time, source, operation, description, boxName, spanId, version) values(?, ?, ?, ?, ?, ?, ?)",
+			v.Time, v.Source, v.Operation, v.Description, boxName, spanID, version)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetHistory returns the versions made at or after since, oldest first
+func (d *TBDB) GetHistory(ctx context.Context, since int64) ([]VersionRow, error) {
+	return d.queryHistory(ctx, "WHERE time >= ?", since)
+}
+
+// GetSpanHistory returns the versions of a span, oldest first
+func (d *TBDB) GetSpanHistory(ctx context.Context, id int64) ([]VersionRow, error) {
+	return d.queryHistory(ctx, "WHERE spanId = ?", id)
+}
+
+// GetBoxHistory returns the versions of a box, oldest first
+func (d *TBDB) GetBoxHistory(ctx context.Context, name string) ([]VersionRow, error) {
+	return d.queryHistory(ctx, "WHERE boxName = ?", name)
+}
+
+func (d *TBDB) queryHistory(ctx context.Context, where string, args ...any) ([]VersionRow, error) {
+	rows, err := d.q().QueryContext(ctx,
+		"SELECT id, time, source, operation, description, boxName, spanId, version FROM history "+where+" ORDER BY id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+	var result []VersionRow
+	for rows.Next() {
+		var v VersionRow
+		var boxName, version sql.NullString
+		var spanID sql.NullInt64
+		err = rows.Scan(&v.ID, &v.Time, &v.Source, &v.Operation, &v.Description, &boxName, &spanID, &version)
+		if err != nil {
+			return nil, err
+		}
+		v.BoxName, v.SpanID = boxName.String, spanID.Int64
+		if version.Valid {
+			if boxName.Valid {
+				v.Box = new(BoxImage)
+				err = json.Unmarshal([]byte(version.String), v.Box)
+			} else {
+				v.Span = new(SpanRow)
+				err = json.Unmarshal([]byte(version.String), v.Span)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("version %d: %w", v.ID, err)
+			}
+		}
+		result = append(result, v)
+	}
+	return result, rows.Err()
+}
+
+// undoVersions returns the versions an undo or a redo of op makes
+func undoVersions(op OperationRow, source, verb string, from, to Image) []VersionRow {
+	return versions(time.Now().Unix(), source, verb+" "+op.Description, op.Keys, from, to)
+}
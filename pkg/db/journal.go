@@ -0,0 +1,447 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+var (
+	ErrNothingToUndo = errors.New("nothing to undo")
+	ErrNothingToRedo = errors.New("nothing to redo")
+)
+
+// ImageKeys name the boxes and spans an operation changes, and whether it
+// changes the timer
+type ImageKeys struct {
+	Boxes []string `json:"boxes,omitempty"`
+	Spans []int64  `json:"spans,omitempty"`
+	Timer bool     `json:"timer,omitempty"`
+}
+
+// Image is the state of the boxes, spans and timer named by some ImageKeys,
+// the ones missing from it don't exist
+type Image struct {
+	Boxes []BoxImage `json:"boxes,omitempty"`
+	Spans []SpanRow  `json:"spans,omitempty"`
+	Timer *TimerRow  `json:"timer,omitempty"`
+}
+
+// BoxImage is a box with its targets
+type BoxImage struct {
+	BoxRow
+	Targets []TargetRow `json:"targets,omitempty"`
+}
+
+// OperationRow is a journaled change of the boxes and spans in Keys, from
+// Before to After, made through Source, e.g. SourceCLI. Undone operations
+// can be redone until the next change.
+type OperationRow struct {
+	ID          int64
+	Time        int64
+	Description string
+	Source      string
+	Keys        ImageKeys
+	Before      Image
+	After       Image
+	Undone      bool
+}
+
+// normalized returns the keys sorted and without duplicates
+func (k ImageKeys) normalized() ImageKeys {
+	result := ImageKeys{Timer: k.Timer}
+	seen := make(map[string]bool)
+	for _, name := range k.Boxes {
+		if !seen[name] {
+			seen[name] = true
+			result.Boxes = append(result.Boxes, name)
+		}
+	}
+	sort.Strings(result.Boxes)
+	seenID := make(map[int64]bool)
+	for _, id := range k.Spans {
+		if !seenID[id] {
+			seenID[id] = true
+			result.Spans = append(result.Spans, id)
+		}
+	}
+	sort.Slice(result.Spans, func(i, j int) bool {
+		return result.Spans[i] < result.Spans[j]
+	})
+	return result
+}
+
+// normalize sorts an image so equal states have equal images
+func (img *Image) normalize() {
+	sort.Slice(img.Boxes, func(i, j int) bool {
+		return img.Boxes[i].Name < img.Boxes[j].Name
+	})
+	for i := range img.Boxes {
+		targets := img.Boxes[i].Targets
+		sort.Slice(targets, func(a, b int) bool {
+			return targets[a].Period < targets[b].Period
+		})
+		if len(targets) == 0 {
+			img.Boxes[i].Targets = nil
+		}
+	}
+	sort.Slice(img.Spans, func(i, j int) bool {
+		return img.Spans[i].ID < img.Spans[j].ID
+	})
+	for i := range img.Spans {
+		if len(img.Spans[i].Tags) == 0 {
+			img.Spans[i].Tags = nil
+		}
+	}
+	if len(img.Boxes) == 0 {
+		img.Boxes = nil
+	}
+	if len(img.Spans) == 0 {
+		img.Spans = nil
+	}
+}
+
+func sameImage(a, b Image) bool {
+	a.normalize()
+	b.normalize()
+	ja, errA := json.Marshal(a)
+	jb, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(ja, jb)
+}
+
+// boxDepth orders boxes so parents come before their children
+func boxDepth(name string) int {
+	return strings.Count(name, BoxPathSeparator)
+}
+
+func changedSince(op OperationRow, verb string) error {
+	return fmt.Errorf("can't %s \"%s\", its boxes or spans changed since", verb, op.Description)
+}
+
+func imageOverlaps(s SpanRow) error {
+	return fmt.Errorf("span %d would overlap a span added since", s.ID)
+}
+
+func (d *TBDB) GetImage(ctx context.Context, keys ImageKeys) (Image, error) {
+	return getImage(ctx, d.q(), keys)
+}
+
+func getImage(ctx context.Context, q querier, keys ImageKeys) (Image, error) {
+	var img Image
+	keys = keys.normalized()
+	for _, name := range keys.Boxes {
+		var bi BoxImage
+		row := q.QueryRowContext(ctx, "SELECT name, createTime, minTime, maxTime, archiveTime FROM boxes WHERE name = ?", name)
+		err := row.Scan(&bi.Name, &bi.CreateTime, &bi.MinTime, &bi.MaxTime, &bi.ArchiveTime)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return img, err
+		}
+		bi.Targets, err = boxTargets(ctx, q, name)
+		if err != nil {
+			return img, err
+		}
+		img.Boxes = append(img.Boxes, bi)
+	}
+	for _, id := range keys.Spans {
+		var sr SpanRow
+		row := q.QueryRowContext(ctx, "SELECT id, start, end, box, notes, tz FROM spans WHERE id = ?", id)
+		err := row.Scan(&sr.ID, &sr.Start, &sr.End, &sr.Box, &sr.Notes, &sr.TZ)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return img, err
+		}
+		img.Spans = append(img.Spans, sr)
+	}
+	err := fillSpanTags(ctx, q, img.Spans)
+	if err != nil {
+		return img, err
+	}
+	if keys.Timer {
+		timer, running, err := getTimer(ctx, q)
+		if err != nil {
+			return img, err
+		}
+		if running {
+			img.Timer = &timer
+		}
+	}
+	img.normalize()
+	return img, nil
+}
+
+func boxTargets(ctx context.Context, q querier, box string) ([]TargetRow, error) {
+	rows, err := q.QueryContext(ctx, "SELECT box, period, minTime, maxTime FROM box_targets WHERE box = ? ORDER BY period", box)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+	var result []TargetRow
+	for rows.Next() {
+		var tr TargetRow
+		err = rows.Scan(&tr.Box, &tr.Period, &tr.MinTime, &tr.MaxTime)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, tr)
+	}
+	return result, rows.Err()
+}
+
+// restoreImage makes the boxes, spans and timer named by keys look like img.
+// The spans of img must not overlap the spans that aren't named by keys.
+func restoreImage(ctx context.Context, tx *sql.Tx, keys ImageKeys, img Image) error {
+	keys = keys.normalized()
+	boxes := append([]BoxImage(nil), img.Boxes...)
+	sort.SliceStable(boxes, func(i, j int) bool {
+		return boxDepth(boxes[i].Name) < boxDepth(boxes[j].Name)
+	})
+	present := make(map[string]bool)
+	for _, b := range boxes {
+		present[b.Name] = true
+		_, err := tx.ExecContext(ctx,
+			"INSERT INTO boxes(name, createTime, minTime, maxTime, archiveTime) values(?, ?, ?, ?, ?) "+
+				"ON CONFLICT(name) DO UPDATE SET createTime = excluded.createTime, minTime = excluded.minTime, maxTime = excluded.maxTime, archiveTime = excluded.archiveTime",
+			b.Name, b.CreateTime, b.MinTime, b.MaxTime, b.ArchiveTime)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "DELETE FROM box_targets WHERE box = ?", b.Name)
+		if err != nil {
+			return err
+		}
+		for _, t := range b.Targets {
+			_, err = tx.ExecContext(ctx, "INSERT INTO box_targets(box, period, minTime, maxTime) values(?, ?, ?, ?)", t.Box, t.Period, t.MinTime, t.MaxTime)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	presentID := make(map[int64]bool)
+	for _, s := range img.Spans {
+		presentID[s.ID] = true
+		_, err := tx.ExecContext(ctx,
+			"INSERT INTO spans(id, start, end, box, notes, tz) values(?, ?, ?, ?, ?, ?) "+
+				"ON CONFLICT(id) DO UPDATE SET start = excluded.start, end = excluded.end, box = excluded.box, notes = excluded.notes, tz = excluded.tz",
+			s.ID, s.Start, s.End, s.Box, s.Notes, s.TZ)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "DELETE FROM span_tags WHERE span_id = ?", s.ID)
+		if err != nil {
+			return err
+		}
+		err = setSpanTags(ctx, tx, s.ID, s.Tags)
+		if err != nil {
+			return err
+		}
+	}
+	for _, id := range keys.Spans {
+		if presentID[id] {
+			continue
+		}
+		_, err := tx.ExecContext(ctx, "DELETE FROM span_tags WHERE span_id = ?", id)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "DELETE FROM spans WHERE id = ?", id)
+		if err != nil {
+			return err
+		}
+	}
+	for _, s := range img.Spans {
+		overlaps, err := spanOverlaps(ctx, tx, s.Start, s.End, s.ID)
+		if err != nil {
+			return err
+		}
+		if overlaps {
+			return imageOverlaps(s)
+		}
+	}
+	if keys.Timer {
+		_, err := tx.ExecContext(ctx, "DELETE FROM timer")
+		if err != nil {
+			return err
+		}
+		if t := img.Timer; t != nil {
+			_, err = tx.ExecContext(ctx, "INSERT INTO timer(id, start, box, tz) values(1, ?, ?, ?)", t.Start, t.Box, t.TZ)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	// children before their parents
+	for i := len(keys.Boxes) - 1; i >= 0; i-- {
+		if name := keys.Boxes[i]; !present[name] {
+			_, err := tx.ExecContext(ctx, "DELETE FROM boxes WHERE name = ?", name)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// AddOperation journals a change and adds the versions it makes to the
+// history, the undone operations can't be redone after it
+func (d *TBDB) AddOperation(ctx context.Context, op OperationRow) (int64, error) {
+	keys, err := json.Marshal(op.Keys.normalized())
+	if err != nil {
+		return 0, err
+	}
+	op.Before.normalize()
+	before, err := json.Marshal(op.Before)
+	if err != nil {
+		return 0, err
+	}
+	op.After.normalize()
+	after, err := json.Marshal(op.After)
+	if err != nil {
+		return 0, err
+	}
+	var id int64
+	err = d.inTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, "DELETE FROM operations WHERE undone = 1")
+		if err != nil {
+			return err
+		}
+		res, err := tx.ExecContext(ctx,
+			"INSERT INTO operations(time, description, source, imageKeys, beforeImage, afterImage) values(?, ?, ?, ?, ?, ?)",
+			op.Time, op.Description, op.Source, string(keys), string(before), string(after))
+		if err != nil {
+			return err
+		}
+		id, err = res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		return addVersions(ctx, tx, versions(op.Time, op.Source, op.Description, op.Keys, op.Before, op.After))
+	})
+	return id, err
+}
+
+// GetOperations returns the journal, oldest first
+func (d *TBDB) GetOperations(ctx context.Context) ([]OperationRow, error) {
+	rows, err := d.q().QueryContext(ctx, "SELECT "+operationColumns+" FROM operations ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+	var result []OperationRow
+	for rows.Next() {
+		op, err := scanOperation(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, op)
+	}
+	return result, rows.Err()
+}
+
+// UndoOperation restores the state before the last operation that isn't
+// undone, as long as its boxes and spans haven't changed since. source is
+// recorded in the history.
+func (d *TBDB) UndoOperation(ctx context.Context, source string) (OperationRow, error) {
+	var op OperationRow
+	err := d.inTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		op, err = scanOperation(tx.QueryRowContext(ctx, "SELECT "+operationColumns+" FROM operations WHERE undone = 0 ORDER BY id DESC LIMIT 1"))
+		if err == sql.ErrNoRows {
+			return ErrNothingToUndo
+		}
+		if err != nil {
+			return err
+		}
+		current, err := getImage(ctx, tx, op.Keys)
+		if err != nil {
+			return err
+		}
+		if !sameImage(current, op.After) {
+			return changedSince(op, "undo")
+		}
+		err = restoreImage(ctx, tx, op.Keys, op.Before)
+		if err != nil {
+			return fmt.Errorf("can't undo \"%s\": %w", op.Description, err)
+		}
+		err = addVersions(ctx, tx, undoVersions(op, source, "undo", op.After, op.Before))
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "UPDATE operations SET undone = 1 WHERE id = ?", op.ID)
+		return err
+	})
+	op.Undone = true
+	return op, err
+}
+
+// RedoOperation makes the first undone operation again
+func (d *TBDB) RedoOperation(ctx context.Context, source string) (OperationRow, error) {
+	var op OperationRow
+	err := d.inTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		op, err = scanOperation(tx.QueryRowContext(ctx, "SELECT "+operationColumns+" FROM operations WHERE undone = 1 ORDER BY id LIMIT 1"))
+		if err == sql.ErrNoRows {
+			return ErrNothingToRedo
+		}
+		if err != nil {
+			return err
+		}
+		current, err := getImage(ctx, tx, op.Keys)
+		if err != nil {
+			return err
+		}
+		if !sameImage(current, op.Before) {
+			return changedSince(op, "redo")
+		}
+		err = restoreImage(ctx, tx, op.Keys, op.After)
+		if err != nil {
+			return fmt.Errorf("can't redo \"%s\": %w", op.Description, err)
+		}
+		err = addVersions(ctx, tx, undoVersions(op, source, "redo", op.Before, op.After))
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "UPDATE operations SET undone = 0 WHERE id = ?", op.ID)
+		return err
+	})
+	op.Undone = false
+	return op, err
+}
+
+const operationColumns = "id, time, description, source, imageKeys, beforeImage, afterImage, undone"
+
+// scanner is implemented by both *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanOperation(row scanner) (OperationRow, error) {
+	var op OperationRow
+	var keys, before, after string
+	err := row.Scan(&op.ID, &op.Time, &op.Description, &op.Source, &keys, &before, &after, &op.Undone)
+	if err != nil {
+		return op, err
+	}
+	for _, f := range []struct {
+		raw string
+		v   any
+	}{{keys, &op.Keys}, {before, &op.Before}, {after, &op.After}} {
+		if err := json.Unmarshal([]byte(f.raw), f.v); err != nil {
+			return op, fmt.Errorf("operation %d: %w", op.ID, err)
+		}
+	}
+	return op, nil
+}
@@ -0,0 +1,868 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStore is a Store that keeps boxes, spans and the timer in memory,
+// nothing is persisted
+type MemoryStore struct {
+	mu sync.Mutex
+	memoryState
+}
+
+// memoryState is everything a MemoryStore keeps
+type memoryState struct {
+	boxes   map[string]memoryBox
+	targets map[string][]TargetRow
+	spans   map[int64]SpanRow
+	timer   *TimerRow
+	lastID  int64
+	seq     int
+	// operations is the journal, oldest first
+	operations []OperationRow
+	lastOpID   int64
+	// history is append-only, oldest first
+	history       []VersionRow
+	lastVersionID int64
+}
+
+// memoryBox remembers the insertion order to break createTime ties
+type memoryBox struct {
+	BoxRow
+	seq int
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{memoryState: memoryState{
+		boxes:   make(map[string]memoryBox),
+		targets: make(map[string][]TargetRow),
+		spans:   make(map[int64]SpanRow),
+	}}
+}
+
+func (m *MemoryStore) Init(ctx context.Context) {}
+
+// InTx runs f with a copy of the store and keeps what f changed if it
+// succeeds. Other calls wait for f.
+func (m *MemoryStore) InTx(ctx context.Context, f func(s Store) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c := &MemoryStore{memoryState: m.clone()}
+	err := f(c)
+	if err != nil {
+		return err
+	}
+	m.memoryState = c.memoryState
+	return nil
+}
+
+// clone copies the state, the rows in it are replaced rather than changed
+// so they can be shared
+func (s memoryState) clone() memoryState {
+	c := s
+	c.boxes = make(map[string]memoryBox, len(s.boxes))
+	for k, v := range s.boxes {
+		c.boxes[k] = v
+	}
+	c.targets = make(map[string][]TargetRow, len(s.targets))
+	for k, v := range s.targets {
+		c.targets[k] = v
+	}
+	c.spans = make(map[int64]SpanRow, len(s.spans))
+	for k, v := range s.spans {
+		c.spans[k] = v
+	}
+	if s.timer != nil {
+		timer := *s.timer
+		c.timer = &timer
+	}
+	c.operations = append([]OperationRow(nil), s.operations...)
+	c.history = append([]VersionRow(nil), s.history...)
+	return c
+}
+
+// Box functions
+
+func (m *MemoryStore) AddBox(ctx context.Context, name string, minTime, maxTime int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if minTime > maxTime {
+		return fmt.Errorf("minTime is greater than maxTime")
+	}
+	if i := strings.LastIndex(name, BoxPathSeparator); i >= 0 {
+		parent := name[:i]
+		if _, ok := m.boxes[parent]; !ok {
+			return fmt.Errorf("parent box %s doesn't exist", parent)
+		}
+	}
+	if _, ok := m.boxes[name]; ok {
+		return fmt.Errorf("constraint failed: box %s already exists", name)
+	}
+	m.seq++
+	m.boxes[name] = memoryBox{
+		BoxRow: BoxRow{Name: name, CreateTime: time.Now().Unix(), MinTime: minTime, MaxTime: maxTime},
+		seq:    m.seq,
+	}
+	return nil
+}
+
+func (m *MemoryStore) DoesBoxExist(ctx context.Context, name string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.boxes[name]
+	return ok, nil
+}
+
+func (m *MemoryStore) GetBox(ctx context.Context, name string) (BoxRow, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	box, ok := m.boxes[name]
+	if !ok {
+		return BoxRow{}, sql.ErrNoRows
+	}
+	return box.BoxRow, nil
+}
+
+func (m *MemoryStore) GetAllBoxes(ctx context.Context) ([]BoxRow, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.sortedBoxes(func(string) bool { return true }), nil
+}
+
+func (m *MemoryStore) GetChildBoxes(ctx context.Context, name string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	prefix := name + BoxPathSeparator
+	children := m.sortedBoxes(func(n string) bool {
+		return strings.HasPrefix(n, prefix) && !strings.Contains(n[len(prefix):], BoxPathSeparator)
+	})
+	var result []string
+	for _, child := range children {
+		result = append(result, child.Name)
+	}
+	return result, nil
+}
+
+func (m *MemoryStore) UpdateBox(ctx context.Context, name string, minTime, maxTime int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if minTime > maxTime {
+		return fmt.Errorf("minTime is greater than maxTime")
+	}
+	if box, ok := m.boxes[name]; ok {
+		box.MinTime = minTime
+		box.MaxTime = maxTime
+		m.boxes[name] = box
+	}
+	return nil
+}
+
+func (m *MemoryStore) DeleteBox(ctx context.Context, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	err := m.checkNoChildren(name)
+	if err != nil {
+		return err
+	}
+	for _, sr := range m.spans {
+		if sr.Box == name {
+			return fmt.Errorf("box %s has spans", name)
+		}
+	}
+	if m.timer != nil && m.timer.Box == name {
+		return fmt.Errorf("box %s has spans", name)
+	}
+	delete(m.boxes, name)
+	delete(m.targets, name)
+	return nil
+}
+
+func (m *MemoryStore) DeleteBoxAndSpans(ctx context.Context, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	err := m.checkNoChildren(name)
+	if err != nil {
+		return err
+	}
+	delete(m.boxes, name)
+	delete(m.targets, name)
+	m.deleteSpansWhere(func(sr SpanRow) bool { return sr.Box == name })
+	if m.timer != nil && m.timer.Box == name {
+		m.timer = nil
+	}
+	return nil
+}
+
+func (m *MemoryStore) RenameBox(ctx context.Context, oldName, newName string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if oldName == newName {
+		return nil
+	}
+	oldPrefix, newPrefix := oldName+BoxPathSeparator, newName+BoxPathSeparator
+	if strings.HasPrefix(newName, oldPrefix) {
+		return fmt.Errorf("can't move box %s into itself", oldName)
+	}
+	if _, ok := m.boxes[oldName]; !ok {
+		return fmt.Errorf("box %s doesn't exist", oldName)
+	}
+	if i := strings.LastIndex(newName, BoxPathSeparator); i >= 0 {
+		if _, ok := m.boxes[newName[:i]]; !ok {
+			return fmt.Errorf("parent box %s doesn't exist", newName[:i])
+		}
+	}
+	renamed := func(n string) (string, bool) {
+		switch {
+		case n == oldName:
+			return newName, true
+		case strings.HasPrefix(n, oldPrefix):
+			return newPrefix + n[len(oldPrefix):], true
+		}
+		return n, false
+	}
+	var conflicts []string
+	for n := range m.boxes {
+		if r, ok := renamed(n); ok {
+			if _, taken := m.boxes[r]; taken {
+				conflicts = append(conflicts, r)
+			}
+		}
+	}
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		return fmt.Errorf("box %s already exists", conflicts[0])
+	}
+	boxes := make(map[string]memoryBox, len(m.boxes))
+	for n, box := range m.boxes {
+		box.Name, _ = renamed(n)
+		boxes[box.Name] = box
+	}
+	m.boxes = boxes
+	targets := make(map[string][]TargetRow, len(m.targets))
+	for n, rows := range m.targets {
+		r, _ := renamed(n)
+		for i := range rows {
+			rows[i].Box = r
+		}
+		targets[r] = rows
+	}
+	m.targets = targets
+	for id, sr := range m.spans {
+		sr.Box, _ = renamed(sr.Box)
+		m.spans[id] = sr
+	}
+	if m.timer != nil {
+		m.timer.Box, _ = renamed(m.timer.Box)
+	}
+	return nil
+}
+
+func (m *MemoryStore) DeleteBoxTree(ctx context.Context, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	prefix := name + BoxPathSeparator
+	inTree := func(n string) bool {
+		return n == name || strings.HasPrefix(n, prefix)
+	}
+	for n := range m.boxes {
+		if inTree(n) {
+			delete(m.boxes, n)
+			delete(m.targets, n)
+		}
+	}
+	m.deleteSpansWhere(func(sr SpanRow) bool { return inTree(sr.Box) })
+	if m.timer != nil && inTree(m.timer.Box) {
+		m.timer = nil
+	}
+	return nil
+}
+
+func (m *MemoryStore) ArchiveBox(ctx context.Context, name string, archiveTime int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.boxes[name]; !ok {
+		return fmt.Errorf("box %s doesn't exist", name)
+	}
+	if archiveTime <= 0 {
+		return fmt.Errorf("invalid archive time %d", archiveTime)
+	}
+	prefix := name + BoxPathSeparator
+	for n, box := range m.boxes {
+		if (n == name || strings.HasPrefix(n, prefix)) && box.ArchiveTime == 0 {
+			box.ArchiveTime = archiveTime
+			m.boxes[n] = box
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) UnarchiveBox(ctx context.Context, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.boxes[name]; !ok {
+		return fmt.Errorf("box %s doesn't exist", name)
+	}
+	prefix := name + BoxPathSeparator
+	for n, box := range m.boxes {
+		if n == name || strings.HasPrefix(n, prefix) || strings.HasPrefix(name, n+BoxPathSeparator) {
+			box.ArchiveTime = 0
+			m.boxes[n] = box
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) GetAllTargets(ctx context.Context) ([]TargetRow, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var result []TargetRow
+	for _, targets := range m.targets {
+		result = append(result, targets...)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Box != result[j].Box {
+			return result[i].Box < result[j].Box
+		}
+		return result[i].Period < result[j].Period
+	})
+	return result, nil
+}
+
+func (m *MemoryStore) SetBoxTargets(ctx context.Context, box string, targets []TargetRow) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	err := validateTargets(box, targets)
+	if err != nil {
+		return err
+	}
+	if _, ok := m.boxes[box]; !ok {
+		return fmt.Errorf("box %s doesn't exist", box)
+	}
+	if len(targets) == 0 {
+		delete(m.targets, box)
+		return nil
+	}
+	m.targets[box] = append([]TargetRow(nil), targets...)
+	return nil
+}
+
+// Span functions
+
+func (m *MemoryStore) AddSpan(ctx context.Context, start, end int64, box string) error {
+	_, err := m.AddSpanRow(ctx, SpanRow{Start: start, End: end, Box: box})
+	return err
+}
+
+func (m *MemoryStore) AddSpanRow(ctx context.Context, span SpanRow) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.addSpanRow(span)
+}
+
+func (m *MemoryStore) DoesSpanOverlap(ctx context.Context, start, end int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.overlaps(start, end, 0), nil
+}
+
+func (m *MemoryStore) GetSpansForBox(ctx context.Context, boxName string) ([]SpanRow, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.boxes[boxName]; !ok {
+		return nil, sql.ErrNoRows
+	}
+	return m.sortedSpans(func(sr SpanRow) bool { return sr.Box == boxName }), nil
+}
+
+func (m *MemoryStore) GetSpansForTimeRange(ctx context.Context, start, end int64, tags ...string) ([]SpanRow, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.sortedSpans(func(sr SpanRow) bool {
+		if sr.Start < start || sr.End > end {
+			return false
+		}
+		for _, tag := range tags {
+			if !containsString(sr.Tags, tag) {
+				return false
+			}
+		}
+		return true
+	}), nil
+}
+
+func (m *MemoryStore) UpdateSpan(ctx context.Context, id, start, end int64, box string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sr, ok := m.spans[id]
+	if !ok {
+		sr = SpanRow{ID: id}
+	}
+	sr.Start, sr.End, sr.Box = start, end, box
+	_, err := m.updateSpanRow(sr)
+	return err
+}
+
+func (m *MemoryStore) UpdateSpanRow(ctx context.Context, span SpanRow) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, err := m.updateSpanRow(span)
+	return err
+}
+
+func (m *MemoryStore) ApplySpanChanges(ctx context.Context, changes SpanChanges) ([]int64, error) {
+	err := changes.validate()
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// work on a copy of the spans so nothing changes on an error
+	saved, lastID := m.spans, m.lastID
+	m.spans = make(map[int64]SpanRow, len(saved))
+	for id, sr := range saved {
+		m.spans[id] = sr
+	}
+	ids, err := m.applySpanChanges(changes)
+	if err != nil {
+		m.spans, m.lastID = saved, lastID
+		return nil, err
+	}
+	return ids, nil
+}
+
+func (m *MemoryStore) applySpanChanges(changes SpanChanges) ([]int64, error) {
+	for _, id := range changes.Delete {
+		delete(m.spans, id)
+	}
+	var changed []SpanRow
+	for _, span := range changes.Update {
+		if _, ok := m.boxes[span.Box]; !ok {
+			return nil, fmt.Errorf("box %s doesn't exist", span.Box)
+		}
+		if _, ok := m.spans[span.ID]; !ok {
+			return nil, fmt.Errorf("span %d doesn't exist", span.ID)
+		}
+		span.Tags = normalizeTags(span.Tags)
+		m.spans[span.ID] = span
+		changed = append(changed, span)
+	}
+	var ids []int64
+	for _, span := range changes.Add {
+		if _, ok := m.boxes[span.Box]; !ok {
+			return nil, fmt.Errorf("box %s doesn't exist", span.Box)
+		}
+		m.lastID++
+		span.ID = m.lastID
+		span.Tags = normalizeTags(span.Tags)
+		m.spans[span.ID] = span
+		ids = append(ids, span.ID)
+		changed = append(changed, span)
+	}
+	for _, span := range changed {
+		if m.overlaps(span.Start, span.End, span.ID) {
+			return nil, fmt.Errorf("time overlaps existing span")
+		}
+	}
+	return ids, nil
+}
+
+func (m *MemoryStore) DeleteSpan(ctx context.Context, start, end int64, box string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.deleteSpansWhere(func(sr SpanRow) bool {
+		return sr.Start == start && sr.End == end && sr.Box == box
+	})
+	return nil
+}
+
+func (m *MemoryStore) DeleteSpanByID(ctx context.Context, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.spans[id]; !ok {
+		return fmt.Errorf("span %d doesn't exist", id)
+	}
+	delete(m.spans, id)
+	return nil
+}
+
+// Timer functions
+
+func (m *MemoryStore) StartTimer(ctx context.Context, timer TimerRow) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if timer.Start > time.Now().Unix() {
+		return fmt.Errorf("start time is in the future")
+	}
+	if _, ok := m.boxes[timer.Box]; !ok {
+		return fmt.Errorf("box %s doesn't exist", timer.Box)
+	}
+	if m.timer != nil {
+		return fmt.Errorf("a timer is already running")
+	}
+	if m.overlaps(timer.Start, timer.Start, 0) {
+		return fmt.Errorf("start time overlaps existing span")
+	}
+	m.timer = &timer
+	return nil
+}
+
+func (m *MemoryStore) GetTimer(ctx context.Context) (TimerRow, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.timer == nil {
+		return TimerRow{}, false, nil
+	}
+	return *m.timer, true, nil
+}
+
+func (m *MemoryStore) StopTimer(ctx context.Context, end int64) (SpanRow, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.timer == nil {
+		return SpanRow{}, fmt.Errorf("no timer is running")
+	}
+	span := SpanRow{Start: m.timer.Start, End: end, Box: m.timer.Box, TZ: m.timer.TZ}
+	id, err := m.addSpanRow(span)
+	if err != nil {
+		return SpanRow{}, err
+	}
+	m.timer = nil
+	span.ID = id
+	return span, nil
+}
+
+func (m *MemoryStore) DeleteTimer(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.timer = nil
+	return nil
+}
+
+// helpers, the caller holds the lock
+
+func (m *MemoryStore) addSpanRow(span SpanRow) (int64, error) {
+	err := ValidateSpanTimes(span.Start, span.End)
+	if err != nil {
+		return 0, err
+	}
+	if _, ok := m.boxes[span.Box]; !ok {
+		return 0, fmt.Errorf("box %s doesn't exist", span.Box)
+	}
+	if m.overlaps(span.Start, span.End, 0) {
+		return 0, fmt.Errorf("time overlaps existing span")
+	}
+	m.lastID++
+	span.ID = m.lastID
+	span.Tags = normalizeTags(span.Tags)
+	m.spans[span.ID] = span
+	return span.ID, nil
+}
+
+// updateSpanRow updates a span after the same checks as addSpanRow, and
+// returns whether the span exists
+func (m *MemoryStore) updateSpanRow(span SpanRow) (bool, error) {
+	err := ValidateSpanTimes(span.Start, span.End)
+	if err != nil {
+		return false, err
+	}
+	if _, ok := m.boxes[span.Box]; !ok {
+		return false, fmt.Errorf("box %s doesn't exist", span.Box)
+	}
+	if m.overlaps(span.Start, span.End, span.ID) {
+		return false, fmt.Errorf("time overlaps existing span")
+	}
+	if _, ok := m.spans[span.ID]; !ok {
+		return false, nil
+	}
+	span.Tags = normalizeTags(span.Tags)
+	m.spans[span.ID] = span
+	return true, nil
+}
+
+// overlaps reports whether a time range overlaps a span other than the span
+// with the ID except, 0 checks all spans
+func (m *MemoryStore) overlaps(start, end, except int64) bool {
+	for _, sr := range m.spans {
+		if sr.ID != except && sr.Start < end && sr.End > start {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *MemoryStore) checkNoChildren(name string) error {
+	prefix := name + BoxPathSeparator
+	for n := range m.boxes {
+		if strings.HasPrefix(n, prefix) {
+			return fmt.Errorf("box %s has sub-boxes", name)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) deleteSpansWhere(match func(SpanRow) bool) {
+	for id, sr := range m.spans {
+		if match(sr) {
+			delete(m.spans, id)
+		}
+	}
+}
+
+// sortedBoxes returns the matching boxes, newest first
+func (m *MemoryStore) sortedBoxes(match func(name string) bool) []BoxRow {
+	var boxes []memoryBox
+	for name, box := range m.boxes {
+		if match(name) {
+			boxes = append(boxes, box)
+		}
+	}
+	sort.Slice(boxes, func(i, j int) bool {
+		if boxes[i].CreateTime != boxes[j].CreateTime {
+			return boxes[i].CreateTime > boxes[j].CreateTime
+		}
+		return boxes[i].seq > boxes[j].seq
+	})
+	var result []BoxRow
+	for _, box := range boxes {
+		result = append(result, box.BoxRow)
+	}
+	return result
+}
+
+// sortedSpans returns copies of the matching spans ordered by start time
+func (m *MemoryStore) sortedSpans(match func(SpanRow) bool) []SpanRow {
+	var result []SpanRow
+	for _, sr := range m.spans {
+		if match(sr) {
+			sr.Tags = append([]string(nil), sr.Tags...)
+			result = append(result, sr)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Start != result[j].Start {
+			return result[i].Start < result[j].Start
+		}
+		return result[i].ID < result[j].ID
+	})
+	return result
+}
+
+// Journal functions
+
+func (m *MemoryStore) GetImage(ctx context.Context, keys ImageKeys) (Image, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.image(keys), nil
+}
+
+func (m *MemoryStore) image(keys ImageKeys) Image {
+	var img Image
+	keys = keys.normalized()
+	for _, name := range keys.Boxes {
+		if box, ok := m.boxes[name]; ok {
+			targets := append([]TargetRow(nil), m.targets[name]...)
+			img.Boxes = append(img.Boxes, BoxImage{BoxRow: box.BoxRow, Targets: targets})
+		}
+	}
+	for _, id := range keys.Spans {
+		if sr, ok := m.spans[id]; ok {
+			sr.Tags = append([]string(nil), sr.Tags...)
+			img.Spans = append(img.Spans, sr)
+		}
+	}
+	if keys.Timer && m.timer != nil {
+		timer := *m.timer
+		img.Timer = &timer
+	}
+	img.normalize()
+	return img
+}
+
+func (m *MemoryStore) restoreImage(keys ImageKeys, img Image) error {
+	// the spans named by keys are replaced by those of img
+	named := make(map[int64]bool)
+	for _, id := range keys.Spans {
+		named[id] = true
+	}
+	for _, s := range img.Spans {
+		for _, sr := range m.spans {
+			if !named[sr.ID] && sr.Start < s.End && sr.End > s.Start {
+				return imageOverlaps(s)
+			}
+		}
+	}
+	present := make(map[string]bool)
+	for _, b := range img.Boxes {
+		present[b.Name] = true
+		box, ok := m.boxes[b.Name]
+		if !ok {
+			m.seq++
+			box.seq = m.seq
+		}
+		box.BoxRow = b.BoxRow
+		m.boxes[b.Name] = box
+		m.targets[b.Name] = append([]TargetRow(nil), b.Targets...)
+	}
+	presentID := make(map[int64]bool)
+	for _, s := range img.Spans {
+		presentID[s.ID] = true
+		s.Tags = normalizeTags(s.Tags)
+		m.spans[s.ID] = s
+		if s.ID > m.lastID {
+			m.lastID = s.ID
+		}
+	}
+	for _, id := range keys.Spans {
+		if !presentID[id] {
+			delete(m.spans, id)
+		}
+	}
+	if keys.Timer {
+		m.timer = nil
+		if img.Timer != nil {
+			timer := *img.Timer
+			m.timer = &timer
+		}
+	}
+	for _, name := range keys.Boxes {
+		if !present[name] {
+			delete(m.boxes, name)
+			delete(m.targets, name)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) AddOperation(ctx context.Context, op OperationRow) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var kept []OperationRow
+	for _, o := range m.operations {
+		if !o.Undone {
+			kept = append(kept, o)
+		}
+	}
+	m.lastOpID++
+	op.ID = m.lastOpID
+	op.Keys = op.Keys.normalized()
+	op.Before.normalize()
+	op.After.normalize()
+	op.Undone = false
+	m.operations = append(kept, op)
+	m.addVersions(versions(op.Time, op.Source, op.Description, op.Keys, op.Before, op.After))
+	return op.ID, nil
+}
+
+func (m *MemoryStore) GetOperations(ctx context.Context) ([]OperationRow, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]OperationRow(nil), m.operations...), nil
+}
+
+func (m *MemoryStore) UndoOperation(ctx context.Context, source string) (OperationRow, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := len(m.operations) - 1; i >= 0; i-- {
+		op := m.operations[i]
+		if op.Undone {
+			continue
+		}
+		if !sameImage(m.image(op.Keys), op.After) {
+			return op, changedSince(op, "undo")
+		}
+		if err := m.restoreImage(op.Keys, op.Before); err != nil {
+			return op, fmt.Errorf("can't undo \"%s\": %w", op.Description, err)
+		}
+		m.addVersions(undoVersions(op, source, "undo", op.After, op.Before))
+		m.operations[i].Undone = true
+		return m.operations[i], nil
+	}
+	return OperationRow{}, ErrNothingToUndo
+}
+
+func (m *MemoryStore) RedoOperation(ctx context.Context, source string) (OperationRow, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, op := range m.operations {
+		if !op.Undone {
+			continue
+		}
+		if !sameImage(m.image(op.Keys), op.Before) {
+			return op, changedSince(op, "redo")
+		}
+		if err := m.restoreImage(op.Keys, op.After); err != nil {
+			return op, fmt.Errorf("can't redo \"%s\": %w", op.Description, err)
+		}
+		m.addVersions(undoVersions(op, source, "redo", op.Before, op.After))
+		m.operations[i].Undone = false
+		return m.operations[i], nil
+	}
+	return OperationRow{}, ErrNothingToRedo
+}
+
+func (m *MemoryStore) addVersions(vs []VersionRow) {
+	for _, v := range vs {
+		m.lastVersionID++
+		v.ID = m.lastVersionID
+		m.history = append(m.history, v)
+	}
+}
+
+func (m *MemoryStore) GetHistory(ctx context.Context, since int64) ([]VersionRow, error) {
+	return m.findVersions(func(v VersionRow) bool {
+		return v.Time >= since
+	}), nil
+}
+
+func (m *MemoryStore) GetSpanHistory(ctx context.Context, id int64) ([]VersionRow, error) {
+	return m.findVersions(func(v VersionRow) bool {
+		return v.BoxName == "" && v.SpanID == id
+	}), nil
+}
+
+func (m *MemoryStore) GetBoxHistory(ctx context.Context, name string) ([]VersionRow, error) {
+	return m.findVersions(func(v VersionRow) bool {
+		return v.BoxName == name
+	}), nil
+}
+
+func (m *MemoryStore) findVersions(match func(VersionRow) bool) []VersionRow {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var result []VersionRow
+	for _, v := range m.history {
+		if match(v) {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// normalizeTags dedupes and sorts tags, matching the order tags are read
+// back from SQLite
+func normalizeTags(tags []string) []string {
+	var result []string
+	for _, tag := range tags {
+		if !containsString(result, tag) {
+			result = append(result, tag)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,404 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Migration is one ordered step of the schema history. Migrations must be
+// safe to run against databases created before schema versioning existed,
+// whose tables may already be partly there.
+type Migration struct {
+	Version     int
+	Description string
+	up          func(ctx context.Context, tx *sql.Tx) error
+}
+
+// migrations lists every schema change in order, new ones are appended with
+// the next version number and existing ones are never edited
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "create spans and boxes tables",
+		up: execStatements(
+			// spans table, stores spans of time spent on a given box
+			"CREATE TABLE IF NOT EXISTS spans (id INTEGER PRIMARY KEY AUTOINCREMENT, start INTEGER NOT NULL, end INTEGER NOT NULL, box TEXT NOT NULL)",
+			// boxes table, stores active boxes and their configurations
+			"CREATE TABLE IF NOT EXISTS boxes (name TEXT NOT NULL PRIMARY KEY, createTime INTEGER NOT NULL, minTime INTEGER NOT NULL, maxTime INTEGER NOT NULL)",
+		),
+	},
+	{
+		Version:     2,
+		Description: "create timer table",
+		up: execStatements(
+			// timer table, stores the start of the running timer, if any
+			"CREATE TABLE IF NOT EXISTS timer (id INTEGER PRIMARY KEY CHECK (id = 1), start INTEGER NOT NULL, box TEXT NOT NULL)",
+		),
+	},
+	{
+		Version:     3,
+		Description: "add notes to spans",
+		up: func(ctx context.Context, tx *sql.Tx) error {
+			return addColumnIfMissing(ctx, tx, "spans", "notes", "TEXT NOT NULL DEFAULT ''")
+		},
+	},
+	{
+		Version:     4,
+		Description: "create tags and span_tags tables",
+		up: execStatements(
+			// many-to-many mapping of tags to spans
+			"CREATE TABLE IF NOT EXISTS tags (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT NOT NULL UNIQUE)",
+			"CREATE TABLE IF NOT EXISTS span_tags (span_id INTEGER NOT NULL, tag_id INTEGER NOT NULL, PRIMARY KEY (span_id, tag_id))",
+		),
+	},
+	{
+		Version:     5,
+		Description: "create box_targets table",
+		up: execStatements(
+			// explicit min and max times of a box for a period like month,
+			// periods without a row are scaled from the weekly times in boxes
+			"CREATE TABLE IF NOT EXISTS box_targets (box TEXT NOT NULL, period TEXT NOT NULL, minTime INTEGER NOT NULL, maxTime INTEGER NOT NULL, PRIMARY KEY (box, period))",
+		),
+	},
+	{
+		Version:     6,
+		Description: "add time zones to spans and the timer",
+		up: func(ctx context.Context, tx *sql.Tx) error {
+			err := addColumnIfMissing(ctx, tx, "spans", "tz", "TEXT NOT NULL DEFAULT ''")
+			if err != nil {
+				return err
+			}
+			return addColumnIfMissing(ctx, tx, "timer", "tz", "TEXT NOT NULL DEFAULT ''")
+		},
+	},
+	{
+		Version:     7,
+		Description: "reference boxes from spans, the timer and targets",
+		up:          addBoxForeignKeys,
+	},
+	{
+		Version:     8,
+		Description: "add archive time to boxes",
+		up: func(ctx context.Context, tx *sql.Tx) error {
+			return addColumnIfMissing(ctx, tx, "boxes", "archiveTime", "INTEGER NOT NULL DEFAULT 0")
+		},
+	},
+	{
+		Version:     9,
+		Description: "index spans by time, box and length, and tags by span",
+		up: execStatements(
+			"CREATE INDEX IF NOT EXISTS spans_start ON spans(start)",
+			"CREATE INDEX IF NOT EXISTS spans_box_start ON spans(box, start)",
+			// bounds the start of the spans overlapping a time, see
+			// spanLengthBounds
+			"CREATE INDEX IF NOT EXISTS spans_length ON spans(end - start)",
+			"CREATE INDEX IF NOT EXISTS span_tags_tag ON span_tags(tag_id, span_id)",
+		),
+	},
+	{
+		Version:     10,
+		Description: "create operations journal",
+		up: execStatements(
+			// changes of boxes and spans with images of them before and
+			// after as JSON, for undo and redo
+			"CREATE TABLE IF NOT EXISTS operations (id INTEGER PRIMARY KEY AUTOINCREMENT, time INTEGER NOT NULL, description TEXT NOT NULL, imageKeys TEXT NOT NULL, beforeImage TEXT NOT NULL, afterImage TEXT NOT NULL, undone INTEGER NOT NULL DEFAULT 0)",
+		),
+	},
+	{
+		Version:     11,
+		Description: "create history of box and span versions",
+		up: func(ctx context.Context, tx *sql.Tx) error {
+			err := addColumnIfMissing(ctx, tx, "operations", "source", "TEXT NOT NULL DEFAULT ''")
+			if err != nil {
+				return err
+			}
+			return execStatements(
+				// every version of a box or a span a change made, version is
+				// NULL for deleted ones. Rows are only ever added.
+				"CREATE TABLE IF NOT EXISTS history (id INTEGER PRIMARY KEY AUTOINCREMENT, time INTEGER NOT NULL, source TEXT NOT NULL, operation TEXT NOT NULL, description TEXT NOT NULL, boxName TEXT, spanId INTEGER, version TEXT)",
+				"CREATE INDEX IF NOT EXISTS history_time ON history(time)",
+				"CREATE INDEX IF NOT EXISTS history_box ON history(boxName)",
+				"CREATE INDEX IF NOT EXISTS history_span ON history(spanId)",
+				"CREATE TRIGGER IF NOT EXISTS history_no_update BEFORE UPDATE ON history BEGIN SELECT RAISE(ABORT, 'history is append-only'); END",
+				"CREATE TRIGGER IF NOT EXISTS history_no_delete BEFORE DELETE ON history BEGIN SELECT RAISE(ABORT, 'history is append-only'); END",
+			)(ctx, tx)
+		},
+	},
+	{
+		Version:     12,
+		Description: "keep bounds of the span lengths",
+		up: execStatements(
+			// bounds of the lengths of all spans ever stored, so that overlap
+			// and range queries can limit the starts they scan without
+			// measuring the spans first. The bounds only ever widen, which
+			// keeps them valid after deletes. Rebuilding the spans table
+			// drops the triggers, they have to be created again.
+			"CREATE TABLE IF NOT EXISTS span_lengths (id INTEGER PRIMARY KEY CHECK (id = 1), shortest INTEGER NOT NULL, longest INTEGER NOT NULL)",
+			"INSERT OR IGNORE INTO span_lengths(id, shortest, longest) SELECT 1, COALESCE(MIN(end - start), 9223372036854775807), COALESCE(MAX(end - start), 0) FROM spans",
+			"CREATE TRIGGER IF NOT EXISTS span_lengths_insert AFTER INSERT ON spans BEGIN "+
+				"UPDATE span_lengths SET shortest = MIN(shortest, NEW.end - NEW.start), longest = MAX(longest, NEW.end - NEW.start); END",
+			"CREATE TRIGGER IF NOT EXISTS span_lengths_update AFTER UPDATE OF start, end ON spans BEGIN "+
+				"UPDATE span_lengths SET shortest = MIN(shortest, NEW.end - NEW.start), longest = MAX(longest, NEW.end - NEW.start); END",
+			"DROP INDEX IF EXISTS spans_length",
+		),
+	},
+	{
+		Version:     13,
+		Description: "keep the span length bounds exact",
+		up: func(ctx context.Context, tx *sql.Tx) error {
+			// the bounds of version 12 never shrink, so one long span left
+			// every later overlap check scanning its length. With the
+			// lengths indexed again, deletes and updates recompute them.
+			err := execStatements(
+				"CREATE INDEX IF NOT EXISTS spans_length ON spans(end - start)",
+				"DROP TRIGGER IF EXISTS span_lengths_update",
+			)(ctx, tx)
+			if err != nil {
+				return err
+			}
+			return keepSpanLengths(ctx, tx)
+		},
+	},
+}
+
+// recomputeSpanLengths sets span_lengths to the bounds of the spans, found
+// with the spans_length index
+const recomputeSpanLengths = "UPDATE span_lengths SET " +
+	"shortest = COALESCE((SELECT MIN(end - start) FROM spans), 9223372036854775807), " +
+	"longest = COALESCE((SELECT MAX(end - start) FROM spans), 0)"
+
+// keepSpanLengths creates the triggers that keep span_lengths up to date and
+// recomputes the bounds, if the table exists. Migrate runs it after applying
+// migrations, since rebuilding the spans table drops its triggers.
+func keepSpanLengths(ctx context.Context, tx *sql.Tx) error {
+	var exists int
+	err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'span_lengths'").Scan(&exists)
+	if err != nil || exists == 0 {
+		return err
+	}
+	return execStatements(
+		"CREATE TRIGGER IF NOT EXISTS span_lengths_insert AFTER INSERT ON spans BEGIN "+
+			"UPDATE span_lengths SET shortest = MIN(shortest, NEW.end - NEW.start), longest = MAX(longest, NEW.end - NEW.start); END",
+		"CREATE TRIGGER IF NOT EXISTS span_lengths_update AFTER UPDATE OF start, end ON spans BEGIN "+recomputeSpanLengths+"; END",
+		"CREATE TRIGGER IF NOT EXISTS span_lengths_delete AFTER DELETE ON spans BEGIN "+recomputeSpanLengths+"; END",
+		recomputeSpanLengths,
+	)(ctx, tx)
+}
+
+// LatestSchemaVersion is the schema version this build of timebox expects
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// SchemaVersion returns the version recorded in the database, 0 for
+// databases that have never been migrated
+func (d *TBDB) SchemaVersion(ctx context.Context) (int, error) {
+	return schemaVersion(ctx, d.q())
+}
+
+// PendingMigrations returns the migrations that Migrate would apply
+func (d *TBDB) PendingMigrations(ctx context.Context) ([]Migration, error) {
+	version, err := d.SchemaVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return pendingMigrations(version)
+}
+
+// Migrate applies all pending migrations in a single transaction, either all
+// of them are applied or none. It returns the applied migrations.
+func (d *TBDB) Migrate(ctx context.Context) ([]Migration, error) {
+	var applied []Migration
+	err := d.inTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS schema_version (version INTEGER NOT NULL PRIMARY KEY, description TEXT NOT NULL, appliedTime INTEGER NOT NULL)")
+		if err != nil {
+			return err
+		}
+		version, err := schemaVersion(ctx, tx)
+		if err != nil {
+			return err
+		}
+		pending, err := pendingMigrations(version)
+		if err != nil {
+			return err
+		}
+		now := time.Now().Unix()
+		for _, m := range pending {
+			err = m.up(ctx, tx)
+			if err != nil {
+				return fmt.Errorf("migration %d (%s): %w", m.Version, m.Description, err)
+			}
+			_, err = tx.ExecContext(ctx, "INSERT INTO schema_version(version, description, appliedTime) values(?, ?, ?)", m.Version, m.Description, now)
+			if err != nil {
+				return err
+			}
+		}
+		if len(pending) > 0 {
+			err = keepSpanLengths(ctx, tx)
+			if err != nil {
+				return err
+			}
+		}
+		applied = pending
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return applied, nil
+}
+
+func pendingMigrations(version int) ([]Migration, error) {
+	if latest := LatestSchemaVersion(); version > latest {
+		return nil, fmt.Errorf("database schema version %d is newer than the supported version %d", version, latest)
+	}
+	var result []Migration
+	for _, m := range migrations {
+		if m.Version > version {
+			result = append(result, m)
+		}
+	}
+	return result, nil
+}
+
+// querier is implemented by both *sql.DB and *sql.Tx
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func schemaVersion(ctx context.Context, q querier) (int, error) {
+	var exists int
+	row := q.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_version'")
+	err := row.Scan(&exists)
+	if err != nil || exists == 0 {
+		return 0, err
+	}
+	var version sql.NullInt64
+	row = q.QueryRowContext(ctx, "SELECT MAX(version) FROM schema_version")
+	err = row.Scan(&version)
+	return int(version.Int64), err
+}
+
+// boxForeignKeyTables are the tables referencing boxes by name, with the
+// definition they are rebuilt with, SQLite can't add a foreign key to an
+// existing table
+var boxForeignKeyTables = []struct{ name, columns, definition string }{
+	{
+		name:       "spans",
+		columns:    "id, start, end, box, notes, tz",
+		definition: "id INTEGER PRIMARY KEY AUTOINCREMENT, start INTEGER NOT NULL, end INTEGER NOT NULL, box TEXT NOT NULL REFERENCES boxes(name) ON UPDATE CASCADE, notes TEXT NOT NULL DEFAULT '', tz TEXT NOT NULL DEFAULT ''",
+	},
+	{
+		name:       "timer",
+		columns:    "id, start, box, tz",
+		definition: "id INTEGER PRIMARY KEY CHECK (id = 1), start INTEGER NOT NULL, box TEXT NOT NULL REFERENCES boxes(name) ON UPDATE CASCADE, tz TEXT NOT NULL DEFAULT ''",
+	},
+	{
+		name:       "box_targets",
+		columns:    "box, period, minTime, maxTime",
+		definition: "box TEXT NOT NULL REFERENCES boxes(name) ON UPDATE CASCADE ON DELETE CASCADE, period TEXT NOT NULL, minTime INTEGER NOT NULL, maxTime INTEGER NOT NULL, PRIMARY KEY (box, period)",
+	},
+}
+
+// addBoxForeignKeys rebuilds the tables referencing boxes with foreign keys,
+// so renaming a box carries its spans along. Spans and a timer of deleted
+// boxes, which older versions left behind, get their box back without
+// targets, targets of deleted boxes are dropped.
+func addBoxForeignKeys(ctx context.Context, tx *sql.Tx) error {
+	rows, err := tx.QueryContext(ctx, "SELECT box FROM spans UNION SELECT box FROM timer EXCEPT SELECT name FROM boxes")
+	if err != nil {
+		return err
+	}
+	var missing []string
+	for rows.Next() {
+		var box string
+		if err := rows.Scan(&box); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		missing = append(missing, box)
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	for _, box := range missing {
+		// and the parents of sub-boxes
+		parts := strings.Split(box, BoxPathSeparator)
+		for i := range parts {
+			_, err = tx.ExecContext(ctx, "INSERT OR IGNORE INTO boxes(name, createTime, minTime, maxTime) values(?, ?, 0, 0)", strings.Join(parts[:i+1], BoxPathSeparator), now)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM box_targets WHERE box NOT IN (SELECT name FROM boxes)")
+	if err != nil {
+		return err
+	}
+	var seq sql.NullInt64
+	err = tx.QueryRowContext(ctx, "SELECT seq FROM sqlite_sequence WHERE name = 'spans'").Scan(&seq)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	for _, t := range boxForeignKeyTables {
+		err = execStatements(
+			fmt.Sprintf("CREATE TABLE %s_new (%s)", t.name, t.definition),
+			fmt.Sprintf("INSERT INTO %s_new (%s) SELECT %s FROM %s", t.name, t.columns, t.columns, t.name),
+			fmt.Sprintf("DROP TABLE %s", t.name),
+			fmt.Sprintf("ALTER TABLE %s_new RENAME TO %s", t.name, t.name),
+		)(ctx, tx)
+		if err != nil {
+			return err
+		}
+	}
+	// keep the IDs of deleted spans from being reused
+	_, err = tx.ExecContext(ctx, "UPDATE sqlite_sequence SET seq = max(seq, ?) WHERE name = 'spans'", seq.Int64)
+	return err
+}
+
+func execStatements(stmts ...string) func(ctx context.Context, tx *sql.Tx) error {
+	return func(ctx context.Context, tx *sql.Tx) error {
+		for _, stmt := range stmts {
+			_, err := tx.ExecContext(ctx, stmt)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// addColumnIfMissing adds a column to an existing table, doing nothing if the
+// column is already there
+func addColumnIfMissing(ctx context.Context, q querier, table, column, definition string) error {
+	rows, err := q.QueryContext(ctx, fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	found := false
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var dflt sql.NullString
+		err := rows.Scan(&cid, &name, &colType, &notNull, &dflt, &pk)
+		if err != nil {
+			_ = rows.Close()
+			return err
+		}
+		if name == column {
+			found = true
+		}
+	}
+	err = rows.Close()
+	if err != nil || found {
+		return err
+	}
+	_, err = q.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
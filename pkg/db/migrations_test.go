@@ -0,0 +1,142 @@
+package db
+
+import (
+	"database/sql"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func emptyDB(t *testing.T) *TBDB {
+	tempDir, err := os.MkdirTemp(os.TempDir(), "timebox")
+	require.NoError(t, err)
+	return openDB(t, filepath.Join(tempDir, filepath.FromSlash(dbName)))
+}
+
+func TestTBDB_Migrate(t *testing.T) {
+	tbdb := emptyDB(t)
+	version, err := tbdb.SchemaVersion(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, version)
+	pending, err := tbdb.PendingMigrations(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, len(migrations), len(pending))
+
+	applied, err := tbdb.Migrate(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, migrationVersions(pending), migrationVersions(applied))
+	version, err = tbdb.SchemaVersion(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, LatestSchemaVersion(), version)
+	pending, err = tbdb.PendingMigrations(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+
+	// running again is a no-op
+	applied, err = tbdb.Migrate(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, applied)
+}
+
+func TestTBDB_MigrateUnversionedDB(t *testing.T) {
+	// a database as created before schema versioning existed
+	tbdb := emptyDB(t)
+	db, err := sql.Open(defaultDriver, tbdb.name)
+	require.NoError(t, err)
+	_, err = db.Exec(`
+	CREATE TABLE spans (id INTEGER PRIMARY KEY AUTOINCREMENT, start INTEGER NOT NULL, end INTEGER NOT NULL, box TEXT NOT NULL);
+	CREATE TABLE boxes (name TEXT NOT NULL PRIMARY KEY, createTime INTEGER NOT NULL, minTime INTEGER NOT NULL, maxTime INTEGER NOT NULL);
+	INSERT INTO boxes(name, createTime, minTime, maxTime) values('box-1', 1, 2, 3);
+	INSERT INTO spans(start, end, box) values(1, 2, 'box-1');
+	`)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	applied, err := tbdb.Migrate(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, len(migrations), len(applied))
+	spans, err := tbdb.GetSpansForBox(ctx, "box-1")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(spans))
+	assert.Equal(t, "", spans[0].Notes)
+	_, err = tbdb.AddSpanRow(ctx, SpanRow{Start: 3, End: 4, Box: "box-1", Notes: "notes", Tags: []string{"tag"}})
+	require.NoError(t, err)
+}
+
+func TestTBDB_MigrateOrphanSpans(t *testing.T) {
+	// spans of a box deleted by an older version, which didn't enforce the
+	// foreign keys
+	tbdb := emptyDB(t)
+	saved := migrations
+	migrations = saved[:6]
+	_, err := tbdb.Migrate(ctx)
+	migrations = saved
+	require.NoError(t, err)
+	db, err := sql.Open(defaultDriver, tbdb.name)
+	require.NoError(t, err)
+	_, err = db.Exec(`
+	INSERT INTO spans(id, start, end, box) values(7, 1, 2, 'Work/A');
+	INSERT INTO box_targets(box, period, minTime, maxTime) values('Gone', 'month', 1, 2);
+	`)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	applied, err := tbdb.Migrate(ctx)
+	require.NoError(t, err)
+	require.NotEmpty(t, applied)
+	assert.Equal(t, 7, applied[0].Version)
+	for _, box := range []string{"Work", "Work/A"} {
+		exists, err := tbdb.DoesBoxExist(ctx, box)
+		require.NoError(t, err)
+		assert.True(t, exists, box)
+	}
+	targets, err := tbdb.GetAllTargets(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, targets)
+	// span IDs aren't reused
+	id, err := tbdb.AddSpanRow(ctx, SpanRow{Start: 3, End: 4, Box: "Work"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(8), id)
+	assert.Error(t, tbdb.AddSpan(ctx, 5, 6, "Gone"))
+}
+
+func TestTBDB_MigrateRollsBack(t *testing.T) {
+	tbdb := emptyDB(t)
+	saved := migrations
+	defer func() { migrations = saved }()
+	migrations = append(append([]Migration{}, saved...), Migration{
+		Version:     LatestSchemaVersion() + 1,
+		Description: "broken",
+		up:          execStatements("CREATE TABLE broken (id INTEGER PRIMARY KEY)", "NOT SQL"),
+	})
+	_, err := tbdb.Migrate(ctx)
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "broken")
+	version, err := tbdb.SchemaVersion(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, version)
+	exists, err := tbdb.DoesBoxExist(ctx, "box-1")
+	assert.Error(t, err)
+	assert.False(t, exists)
+}
+
+func TestTBDB_MigrateNewerSchema(t *testing.T) {
+	tbdb := setup(t)
+	db, err := sql.Open(defaultDriver, tbdb.name)
+	require.NoError(t, err)
+	_, err = db.Exec("INSERT INTO schema_version(version, description, appliedTime) values(?, 'future', 0)", LatestSchemaVersion()+1)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+	_, err = tbdb.Migrate(ctx)
+	assert.ErrorContains(t, err, "newer than the supported version")
+}
+
+func migrationVersions(ms []Migration) []int {
+	var result []int
+	for _, m := range ms {
+		result = append(result, m.Version)
+	}
+	return result
+}
@@ -0,0 +1,55 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// SeedSizes are the numbers of spans benchmarks seed, the time per
+// operation should barely grow from one to the next
+var SeedSizes = []int{10_000, 100_000}
+
+// The spans Seed writes are SeedLength long and start SeedStep apart
+const (
+	SeedStep   = 2 * time.Hour
+	SeedLength = 30 * time.Minute
+)
+
+// Seed writes n spans to the database without any checks, like years of
+// history for benchmarks. The boxes are created in the given order, parents
+// before their sub-boxes, and take the spans in turn. Every third span is
+// tagged focus and the last one ends before now. Seed returns the start of
+// the first span.
+func (d *TBDB) Seed(ctx context.Context, boxes []string, n int) (int64, error) {
+	step, length := int64(SeedStep/time.Second), int64(SeedLength/time.Second)
+	first := time.Now().Unix() - int64(n+1)*step
+	err := d.inTx(ctx, func(tx *sql.Tx) error {
+		for _, box := range boxes {
+			_, err := tx.ExecContext(ctx, "INSERT INTO boxes(name, createTime, minTime, maxTime) values(?, ?, 3600, 7200)", box, first)
+			if err != nil {
+				return err
+			}
+		}
+		for i := 0; i < n; i++ {
+			start := first + int64(i)*step
+			res, err := tx.ExecContext(ctx, "INSERT INTO spans(start, end, box) values(?, ?, ?)", start, start+length, boxes[i%len(boxes)])
+			if err != nil {
+				return err
+			}
+			if i%3 != 0 {
+				continue
+			}
+			id, err := res.LastInsertId()
+			if err != nil {
+				return err
+			}
+			err = setSpanTags(ctx, tx, id, []string{"focus"})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return first, err
+}
@@ -0,0 +1,97 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Store is the storage behind a TimeBox. TBDB stores everything in a SQLite
+// file, MemoryStore keeps it in memory for embedding and tests. Both apply
+// the same validation rules. The context of each call cancels its queries.
+type Store interface {
+	Init(ctx context.Context)
+	// InTx runs f with a Store whose calls are applied together if f
+	// succeeds and not at all otherwise
+	InTx(ctx context.Context, f func(s Store) error) error
+
+	AddBox(ctx context.Context, name string, minTime, maxTime int64) error
+	DoesBoxExist(ctx context.Context, name string) (bool, error)
+	GetBox(ctx context.Context, name string) (BoxRow, error)
+	GetAllBoxes(ctx context.Context) ([]BoxRow, error)
+	GetChildBoxes(ctx context.Context, name string) ([]string, error)
+	UpdateBox(ctx context.Context, name string, minTime, maxTime int64) error
+	RenameBox(ctx context.Context, oldName, newName string) error
+	ArchiveBox(ctx context.Context, name string, archiveTime int64) error
+	UnarchiveBox(ctx context.Context, name string) error
+	DeleteBox(ctx context.Context, name string) error
+	DeleteBoxAndSpans(ctx context.Context, name string) error
+	DeleteBoxTree(ctx context.Context, name string) error
+
+	GetAllTargets(ctx context.Context) ([]TargetRow, error)
+	SetBoxTargets(ctx context.Context, box string, targets []TargetRow) error
+
+	AddSpan(ctx context.Context, start, end int64, box string) error
+	AddSpanRow(ctx context.Context, span SpanRow) (int64, error)
+	DoesSpanOverlap(ctx context.Context, start, end int64) (bool, error)
+	GetSpansForBox(ctx context.Context, boxName string) ([]SpanRow, error)
+	GetSpansForTimeRange(ctx context.Context, start, end int64, tags ...string) ([]SpanRow, error)
+	UpdateSpan(ctx context.Context, id, start, end int64, box string) error
+	UpdateSpanRow(ctx context.Context, span SpanRow) error
+	DeleteSpan(ctx context.Context, start, end int64, box string) error
+	DeleteSpanByID(ctx context.Context, id int64) error
+	ApplySpanChanges(ctx context.Context, changes SpanChanges) ([]int64, error)
+
+	StartTimer(ctx context.Context, timer TimerRow) error
+	GetTimer(ctx context.Context) (TimerRow, bool, error)
+	StopTimer(ctx context.Context, end int64) (SpanRow, error)
+	DeleteTimer(ctx context.Context) error
+
+	GetImage(ctx context.Context, keys ImageKeys) (Image, error)
+	AddOperation(ctx context.Context, op OperationRow) (int64, error)
+	GetOperations(ctx context.Context) ([]OperationRow, error)
+	UndoOperation(ctx context.Context, source string) (OperationRow, error)
+	RedoOperation(ctx context.Context, source string) (OperationRow, error)
+	GetHistory(ctx context.Context, since int64) ([]VersionRow, error)
+	GetSpanHistory(ctx context.Context, id int64) ([]VersionRow, error)
+	GetBoxHistory(ctx context.Context, name string) ([]VersionRow, error)
+}
+
+// SpanChanges are applied together by ApplySpanChanges: the spans with the
+// IDs in Delete are deleted first, then the spans in Update are updated and
+// those in Add are added. Overlaps are checked once everything is applied.
+type SpanChanges struct {
+	Delete []int64
+	Update []SpanRow
+	Add    []SpanRow
+}
+
+// validate checks the times of the updated and added spans
+func (c SpanChanges) validate() error {
+	for _, spans := range [][]SpanRow{c.Update, c.Add} {
+		for _, span := range spans {
+			if err := ValidateSpanTimes(span.Start, span.End); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+var (
+	_ Store = (*TBDB)(nil)
+	_ Store = (*MemoryStore)(nil)
+)
+
+// ValidateSpanTimes checks the rules every new span has to follow regardless
+// of the other spans
+func ValidateSpanTimes(start, end int64) error {
+	if start > end {
+		return fmt.Errorf("start time is after end time")
+	}
+	now := time.Now().Unix()
+	if start > now || end > now {
+		return fmt.Errorf("time span is in the future")
+	}
+	return nil
+}
@@ -0,0 +1,588 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// stores returns one empty instance of every Store implementation, they are
+// all expected to behave the same
+func stores(t *testing.T) map[string]Store {
+	return map[string]Store{
+		"sqlite": setup(t),
+		"memory": NewMemoryStore(),
+	}
+}
+
+func TestStore_Boxes(t *testing.T) {
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, store.AddBox(ctx, "box-1", 1, 2))
+			require.NoError(t, store.AddBox(ctx, "box-2", 3, 4))
+			assert.Error(t, store.AddBox(ctx, "box-1", 1, 2))
+			assert.EqualError(t, store.AddBox(ctx, "box-3", 5, 2), "minTime is greater than maxTime")
+			exists, err := store.DoesBoxExist(ctx, "box-2")
+			require.NoError(t, err)
+			assert.True(t, exists)
+			_, err = store.GetBox(ctx, "box-3")
+			assert.ErrorIs(t, err, sql.ErrNoRows)
+			require.NoError(t, store.UpdateBox(ctx, "box-1", 1, 5))
+			box, err := store.GetBox(ctx, "box-1")
+			require.NoError(t, err)
+			assert.Equal(t, int64(5), box.MaxTime)
+			assert.EqualError(t, store.UpdateBox(ctx, "box-1", 6, 5), "minTime is greater than maxTime")
+			box, err = store.GetBox(ctx, "box-1")
+			require.NoError(t, err)
+			assert.Equal(t, int64(1), box.MinTime)
+			boxes, err := store.GetAllBoxes(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, 2, len(boxes))
+			require.NoError(t, store.DeleteBox(ctx, "box-2"))
+			exists, err = store.DoesBoxExist(ctx, "box-2")
+			require.NoError(t, err)
+			assert.False(t, exists)
+		})
+	}
+}
+
+func TestStore_Spans(t *testing.T) {
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, store.AddBox(ctx, "box-1", 1, 2))
+			require.NoError(t, store.AddBox(ctx, "box-2", 1, 2))
+			id, err := store.AddSpanRow(ctx, SpanRow{Start: 10, End: 20, Box: "box-1", Notes: "n", Tags: []string{"b", "a"}})
+			require.NoError(t, err)
+			require.NoError(t, store.AddSpan(ctx, 30, 40, "box-2"))
+			assert.EqualError(t, store.AddSpan(ctx, 15, 25, "box-2"), "time overlaps existing span")
+			assert.EqualError(t, store.AddSpan(ctx, 50, 60, "box-3"), "box box-3 doesn't exist")
+			assert.EqualError(t, store.AddSpan(ctx, 60, 50, "box-1"), "start time is after end time")
+			now := time.Now().Unix()
+			assert.EqualError(t, store.AddSpan(ctx, now+10, now+20, "box-1"), "time span is in the future")
+			overlaps, err := store.DoesSpanOverlap(ctx, 20, 30)
+			require.NoError(t, err)
+			assert.False(t, overlaps)
+
+			spans, err := store.GetSpansForBox(ctx, "box-1")
+			require.NoError(t, err)
+			require.Equal(t, 1, len(spans))
+			assert.Equal(t, SpanRow{ID: id, Start: 10, End: 20, Box: "box-1", Notes: "n", Tags: []string{"a", "b"}}, spans[0])
+			spans, err = store.GetSpansForTimeRange(ctx, 0, 100)
+			require.NoError(t, err)
+			assert.Equal(t, 2, len(spans))
+			spans, err = store.GetSpansForTimeRange(ctx, 0, 100, "a")
+			require.NoError(t, err)
+			assert.Equal(t, 1, len(spans))
+			spans, err = store.GetSpansForTimeRange(ctx, 0, 35)
+			require.NoError(t, err)
+			assert.Equal(t, 1, len(spans))
+
+			require.NoError(t, store.UpdateSpanRow(ctx, SpanRow{ID: id, Start: 11, End: 21, Box: "box-2", Tags: []string{"c"}}))
+			spans, err = store.GetSpansForBox(ctx, "box-2")
+			require.NoError(t, err)
+			require.Equal(t, 2, len(spans))
+			assert.Equal(t, int64(11), spans[0].Start)
+			assert.Equal(t, []string{"c"}, spans[0].Tags)
+			assert.Equal(t, "", spans[0].Notes)
+
+			// updates follow the rules of new spans, a span doesn't overlap itself
+			assert.EqualError(t, store.UpdateSpanRow(ctx, SpanRow{ID: id, Start: 15, End: 35, Box: "box-2"}), "time overlaps existing span")
+			assert.EqualError(t, store.UpdateSpan(ctx, id, 25, 31, "box-2"), "time overlaps existing span")
+			assert.EqualError(t, store.UpdateSpanRow(ctx, SpanRow{ID: id, Start: 21, End: 11, Box: "box-2"}), "start time is after end time")
+			assert.EqualError(t, store.UpdateSpan(ctx, id, now-10, now+10, "box-2"), "time span is in the future")
+			require.NoError(t, store.UpdateSpan(ctx, id, 12, 22, "box-2"))
+			spans, err = store.GetSpansForBox(ctx, "box-2")
+			require.NoError(t, err)
+			assert.Equal(t, int64(12), spans[0].Start)
+			assert.Equal(t, []string{"c"}, spans[0].Tags)
+
+			require.NoError(t, store.DeleteSpan(ctx, 30, 40, "box-2"))
+			require.NoError(t, store.DeleteSpanByID(ctx, id))
+			assert.EqualError(t, store.DeleteSpanByID(ctx, id), fmt.Sprintf("span %d doesn't exist", id))
+			spans, err = store.GetSpansForTimeRange(ctx, 0, 100)
+			require.NoError(t, err)
+			assert.Empty(t, spans)
+		})
+	}
+}
+
+func TestStore_ApplySpanChanges(t *testing.T) {
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, store.AddBox(ctx, "box-1", 1, 2))
+			require.NoError(t, store.AddBox(ctx, "box-2", 1, 2))
+			var ids []int64
+			for _, start := range []int64{10, 20, 30, 50} {
+				id, err := store.AddSpanRow(ctx, SpanRow{Start: start, End: start + 10, Box: "box-1", Tags: []string{"a"}})
+				require.NoError(t, err)
+				ids = append(ids, id)
+			}
+			spansOf := func(box string) []SpanRow {
+				spans, err := store.GetSpansForBox(ctx, box)
+				require.NoError(t, err)
+				return spans
+			}
+
+			// nothing changes when a change fails
+			_, err := store.ApplySpanChanges(ctx, SpanChanges{
+				Delete: ids[1:2],
+				Update: []SpanRow{{ID: ids[0], Start: 10, End: 35, Box: "box-1"}},
+			})
+			assert.EqualError(t, err, "time overlaps existing span")
+			_, err = store.ApplySpanChanges(ctx, SpanChanges{
+				Delete: ids[1:3],
+				Update: []SpanRow{{ID: ids[0], Start: 10, End: 40, Box: "box-1"}},
+				Add:    []SpanRow{{Start: 55, End: 58, Box: "box-2"}},
+			})
+			assert.EqualError(t, err, "time overlaps existing span")
+			_, err = store.ApplySpanChanges(ctx, SpanChanges{Update: []SpanRow{{ID: 99, Start: 70, End: 80, Box: "box-1"}}})
+			assert.EqualError(t, err, "span 99 doesn't exist")
+			_, err = store.ApplySpanChanges(ctx, SpanChanges{
+				Update: []SpanRow{{ID: ids[0], Start: 10, End: 25, Box: "box-1"}, {ID: ids[1], Start: 24, End: 30, Box: "box-1"}},
+			})
+			assert.EqualError(t, err, "time overlaps existing span")
+			assert.Len(t, spansOf("box-1"), 4)
+
+			// adjacent spans can move the time between them in one change
+			_, err = store.ApplySpanChanges(ctx, SpanChanges{
+				Update: []SpanRow{{ID: ids[0], Start: 10, End: 25, Box: "box-1", Tags: []string{"a"}}, {ID: ids[1], Start: 25, End: 30, Box: "box-1", Tags: []string{"a"}}},
+			})
+			require.NoError(t, err)
+			assert.Equal(t, int64(25), spansOf("box-1")[1].Start)
+			_, err = store.ApplySpanChanges(ctx, SpanChanges{
+				Update: []SpanRow{{ID: ids[0], Start: 10, End: 20, Box: "box-1", Tags: []string{"a"}}, {ID: ids[1], Start: 20, End: 30, Box: "box-1", Tags: []string{"a"}}},
+			})
+			require.NoError(t, err)
+
+			// merge the first three spans, split the last one
+			added, err := store.ApplySpanChanges(ctx, SpanChanges{
+				Delete: ids[1:3],
+				Update: []SpanRow{
+					{ID: ids[0], Start: 10, End: 40, Box: "box-1", Tags: []string{"a", "b"}},
+					{ID: ids[3], Start: 50, End: 55, Box: "box-1"},
+				},
+				Add: []SpanRow{{Start: 55, End: 60, Box: "box-2", Notes: "n"}},
+			})
+			require.NoError(t, err)
+			require.Len(t, added, 1)
+			assert.Equal(t, []SpanRow{
+				{ID: ids[0], Start: 10, End: 40, Box: "box-1", Tags: []string{"a", "b"}},
+				{ID: ids[3], Start: 50, End: 55, Box: "box-1"},
+			}, spansOf("box-1"))
+			assert.Equal(t, []SpanRow{{ID: added[0], Start: 55, End: 60, Box: "box-2", Notes: "n"}}, spansOf("box-2"))
+		})
+	}
+}
+
+func TestStore_DeleteBoxes(t *testing.T) {
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			for _, box := range []string{"Work", "Work/A", "Work/A/B", "Piano"} {
+				require.NoError(t, store.AddBox(ctx, box, 1, 2))
+			}
+			assert.EqualError(t, store.AddBox(ctx, "Home/A", 1, 2), "parent box Home doesn't exist")
+			children, err := store.GetChildBoxes(ctx, "Work")
+			require.NoError(t, err)
+			assert.Equal(t, []string{"Work/A"}, children)
+			require.NoError(t, store.AddSpan(ctx, 1, 2, "Work/A/B"))
+			require.NoError(t, store.AddSpan(ctx, 3, 4, "Piano"))
+			assert.EqualError(t, store.DeleteBoxAndSpans(ctx, "Work/A"), "box Work/A has sub-boxes")
+			require.NoError(t, store.DeleteBoxTree(ctx, "Work"))
+			boxes, err := store.GetAllBoxes(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, 1, len(boxes))
+			require.NoError(t, store.DeleteBoxAndSpans(ctx, "Piano"))
+			spans, err := store.GetSpansForTimeRange(ctx, 0, 100)
+			require.NoError(t, err)
+			assert.Empty(t, spans)
+		})
+	}
+}
+
+func TestStore_RenameBox(t *testing.T) {
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			for _, box := range []string{"Work", "Work/A", "Work/A/B", "Home", "Home/B"} {
+				require.NoError(t, store.AddBox(ctx, box, 1, 2))
+			}
+			require.NoError(t, store.AddSpan(ctx, 1, 2, "Work/A"))
+			require.NoError(t, store.AddSpan(ctx, 3, 4, "Work/A/B"))
+			require.NoError(t, store.SetBoxTargets(ctx, "Work/A", []TargetRow{{Box: "Work/A", Period: "month", MinTime: 1, MaxTime: 2}}))
+			require.NoError(t, store.StartTimer(ctx, TimerRow{Start: 5, Box: "Work/A/B"}))
+
+			assert.EqualError(t, store.RenameBox(ctx, "Gone", "New"), "box Gone doesn't exist")
+			assert.EqualError(t, store.RenameBox(ctx, "Work/A", "Work/A/C"), "can't move box Work/A into itself")
+			assert.EqualError(t, store.RenameBox(ctx, "Work/A", "Play/A"), "parent box Play doesn't exist")
+			assert.EqualError(t, store.RenameBox(ctx, "Work/A", "Home"), "box Home already exists")
+			assert.EqualError(t, store.RenameBox(ctx, "Work/A/B", "Home/B"), "box Home/B already exists")
+			require.NoError(t, store.RenameBox(ctx, "Work/A/B", "Work/A/C"))
+			assert.EqualError(t, store.DeleteBox(ctx, "Work/A/C"), "box Work/A/C has spans")
+
+			require.NoError(t, store.RenameBox(ctx, "Work", "Job"))
+			boxes, err := store.GetAllBoxes(ctx)
+			require.NoError(t, err)
+			var names []string
+			for _, b := range boxes {
+				names = append(names, b.Name)
+			}
+			assert.ElementsMatch(t, []string{"Job", "Job/A", "Job/A/C", "Home", "Home/B"}, names)
+			spans, err := store.GetSpansForTimeRange(ctx, 0, 10)
+			require.NoError(t, err)
+			require.Equal(t, 2, len(spans))
+			assert.Equal(t, "Job/A", spans[0].Box)
+			assert.Equal(t, "Job/A/C", spans[1].Box)
+			targets, err := store.GetAllTargets(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, []TargetRow{{Box: "Job/A", Period: "month", MinTime: 1, MaxTime: 2}}, targets)
+			timer, running, err := store.GetTimer(ctx)
+			require.NoError(t, err)
+			require.True(t, running)
+			assert.Equal(t, "Job/A/C", timer.Box)
+			assert.EqualError(t, store.UpdateSpan(ctx, spans[0].ID, 1, 2, "Work/A"), "box Work/A doesn't exist")
+		})
+	}
+}
+
+func TestStore_ArchiveBox(t *testing.T) {
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			for _, box := range []string{"Work", "Work/A", "Work/A/B", "Home"} {
+				require.NoError(t, store.AddBox(ctx, box, 1, 2))
+			}
+			require.NoError(t, store.AddSpan(ctx, 1, 2, "Work/A"))
+			archiveTimes := func() map[string]int64 {
+				boxes, err := store.GetAllBoxes(ctx)
+				require.NoError(t, err)
+				result := make(map[string]int64)
+				for _, b := range boxes {
+					result[b.Name] = b.ArchiveTime
+				}
+				return result
+			}
+			assert.EqualError(t, store.ArchiveBox(ctx, "Gone", 10), "box Gone doesn't exist")
+			require.NoError(t, store.ArchiveBox(ctx, "Work/A/B", 5))
+			require.NoError(t, store.ArchiveBox(ctx, "Work", 10))
+			assert.Equal(t, map[string]int64{"Work": 10, "Work/A": 10, "Work/A/B": 5, "Home": 0}, archiveTimes())
+			box, err := store.GetBox(ctx, "Work/A")
+			require.NoError(t, err)
+			assert.Equal(t, int64(10), box.ArchiveTime)
+			// the spans are kept
+			spans, err := store.GetSpansForBox(ctx, "Work/A")
+			require.NoError(t, err)
+			assert.Equal(t, 1, len(spans))
+
+			require.NoError(t, store.UnarchiveBox(ctx, "Work/A"))
+			assert.Equal(t, map[string]int64{"Work": 0, "Work/A": 0, "Work/A/B": 0, "Home": 0}, archiveTimes())
+		})
+	}
+}
+
+func TestStore_Targets(t *testing.T) {
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, store.AddBox(ctx, "Work", 1, 2))
+			require.NoError(t, store.AddBox(ctx, "Work/A", 1, 2))
+			assert.EqualError(t, store.SetBoxTargets(ctx, "Home", nil), "box Home doesn't exist")
+			assert.EqualError(t, store.SetBoxTargets(ctx, "Work", []TargetRow{{Box: "Work", Period: "day", MinTime: 1, MaxTime: 2}}), "unknown target period day")
+			assert.EqualError(t, store.SetBoxTargets(ctx, "Work", []TargetRow{{Box: "Work", Period: "month", MinTime: 3, MaxTime: 2}}), "minTime is greater than maxTime")
+			require.NoError(t, store.SetBoxTargets(ctx, "Work", []TargetRow{
+				{Box: "Work", Period: "year", MinTime: 10, MaxTime: 20},
+				{Box: "Work", Period: "month", MinTime: 1, MaxTime: 2},
+			}))
+			require.NoError(t, store.SetBoxTargets(ctx, "Work/A", []TargetRow{{Box: "Work/A", Period: "quarter", MinTime: 5, MaxTime: 6}}))
+			targets, err := store.GetAllTargets(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, []TargetRow{
+				{Box: "Work", Period: "month", MinTime: 1, MaxTime: 2},
+				{Box: "Work", Period: "year", MinTime: 10, MaxTime: 20},
+				{Box: "Work/A", Period: "quarter", MinTime: 5, MaxTime: 6},
+			}, targets)
+
+			// setting replaces all targets of the box
+			require.NoError(t, store.SetBoxTargets(ctx, "Work", []TargetRow{{Box: "Work", Period: "month", MinTime: 3, MaxTime: 4}}))
+			require.NoError(t, store.DeleteBoxAndSpans(ctx, "Work/A"))
+			targets, err = store.GetAllTargets(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, []TargetRow{{Box: "Work", Period: "month", MinTime: 3, MaxTime: 4}}, targets)
+			require.NoError(t, store.DeleteBoxTree(ctx, "Work"))
+			targets, err = store.GetAllTargets(ctx)
+			require.NoError(t, err)
+			assert.Empty(t, targets)
+		})
+	}
+}
+
+func TestStore_Timer(t *testing.T) {
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			now := time.Now().Unix()
+			require.NoError(t, store.AddBox(ctx, "box-1", 1, 2))
+			assert.EqualError(t, store.StartTimer(ctx, TimerRow{Start: now, Box: "box-2"}), "box box-2 doesn't exist")
+			require.NoError(t, store.StartTimer(ctx, TimerRow{Start: now - 60, Box: "box-1", TZ: "Europe/Berlin"}))
+			assert.EqualError(t, store.StartTimer(ctx, TimerRow{Start: now, Box: "box-1"}), "a timer is already running")
+			timer, running, err := store.GetTimer(ctx)
+			require.NoError(t, err)
+			assert.True(t, running)
+			assert.Equal(t, "box-1", timer.Box)
+			span, err := store.StopTimer(ctx, now)
+			require.NoError(t, err)
+			assert.NotZero(t, span.ID)
+			assert.Equal(t, now-60, span.Start)
+			assert.Equal(t, "Europe/Berlin", span.TZ)
+			spans, err := store.GetSpansForBox(ctx, "box-1")
+			require.NoError(t, err)
+			require.Equal(t, 1, len(spans))
+			assert.Equal(t, "Europe/Berlin", spans[0].TZ)
+			_, running, err = store.GetTimer(ctx)
+			require.NoError(t, err)
+			assert.False(t, running)
+			require.NoError(t, store.StartTimer(ctx, TimerRow{Start: now, Box: "box-1"}))
+			require.NoError(t, store.DeleteTimer(ctx))
+			_, err = store.StopTimer(ctx, now)
+			assert.EqualError(t, err, "no timer is running")
+		})
+	}
+}
+
+func TestStore_Journal(t *testing.T) {
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			_, err := store.UndoOperation(ctx, SourceAPI)
+			assert.ErrorIs(t, err, ErrNothingToUndo)
+			require.NoError(t, store.AddBox(ctx, "box-1", 1, 2))
+			require.NoError(t, store.SetBoxTargets(ctx, "box-1", []TargetRow{{Box: "box-1", Period: "month", MinTime: 3, MaxTime: 4}}))
+			id, err := store.AddSpanRow(ctx, SpanRow{Start: 10, End: 20, Box: "box-1", Notes: "n", Tags: []string{"a"}, TZ: "UTC"})
+			require.NoError(t, err)
+
+			// delete the box and its span as one operation
+			keys := ImageKeys{Boxes: []string{"box-1"}, Spans: []int64{id}}
+			before, err := store.GetImage(ctx, keys)
+			require.NoError(t, err)
+			require.Len(t, before.Boxes, 1)
+			require.Len(t, before.Spans, 1)
+			assert.Equal(t, []string{"a"}, before.Spans[0].Tags)
+			require.NoError(t, store.DeleteBoxAndSpans(ctx, "box-1"))
+			after, err := store.GetImage(ctx, keys)
+			require.NoError(t, err)
+			assert.Equal(t, Image{}, after)
+			_, err = store.AddOperation(ctx, OperationRow{Time: 1, Description: "delete box-1", Keys: keys, Before: before, After: after})
+			require.NoError(t, err)
+
+			op, err := store.UndoOperation(ctx, SourceAPI)
+			require.NoError(t, err)
+			assert.Equal(t, "delete box-1", op.Description)
+			restored, err := store.GetImage(ctx, keys)
+			require.NoError(t, err)
+			assert.Equal(t, before, restored)
+			_, err = store.UndoOperation(ctx, SourceAPI)
+			assert.ErrorIs(t, err, ErrNothingToUndo)
+
+			_, err = store.RedoOperation(ctx, SourceAPI)
+			require.NoError(t, err)
+			exists, err := store.DoesBoxExist(ctx, "box-1")
+			require.NoError(t, err)
+			assert.False(t, exists)
+			_, err = store.RedoOperation(ctx, SourceAPI)
+			assert.ErrorIs(t, err, ErrNothingToRedo)
+
+			// a change outside the journal blocks undo
+			require.NoError(t, store.AddBox(ctx, "box-1", 5, 6))
+			_, err = store.UndoOperation(ctx, SourceAPI)
+			assert.EqualError(t, err, "can't undo \"delete box-1\", its boxes or spans changed since")
+			require.NoError(t, store.DeleteBox(ctx, "box-1"))
+			_, err = store.UndoOperation(ctx, SourceAPI)
+			require.NoError(t, err)
+
+			// a new operation drops the undone ones
+			_, err = store.AddOperation(ctx, OperationRow{Description: "nothing"})
+			require.NoError(t, err)
+			ops, err := store.GetOperations(ctx)
+			require.NoError(t, err)
+			require.Len(t, ops, 1)
+			assert.Equal(t, "nothing", ops[0].Description)
+			_, err = store.RedoOperation(ctx, SourceAPI)
+			assert.ErrorIs(t, err, ErrNothingToRedo)
+		})
+	}
+}
+
+func TestStore_InTx(t *testing.T) {
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, store.AddBox(ctx, "box-1", 1, 2))
+			errFail := errors.New("fail")
+			err := store.InTx(ctx, func(s Store) error {
+				require.NoError(t, s.AddBox(ctx, "box-2", 1, 2))
+				_, err := s.AddSpanRow(ctx, SpanRow{Start: 10, End: 20, Box: "box-2"})
+				require.NoError(t, err)
+				// the transaction sees its own changes
+				exists, err := s.DoesBoxExist(ctx, "box-2")
+				require.NoError(t, err)
+				assert.True(t, exists)
+				return errFail
+			})
+			assert.ErrorIs(t, err, errFail)
+			exists, err := store.DoesBoxExist(ctx, "box-2")
+			require.NoError(t, err)
+			assert.False(t, exists)
+			spans, err := store.GetSpansForTimeRange(ctx, 0, 100)
+			require.NoError(t, err)
+			assert.Empty(t, spans)
+
+			require.NoError(t, store.InTx(ctx, func(s Store) error {
+				require.NoError(t, s.AddBox(ctx, "box-2", 1, 2))
+				_, err := s.AddOperation(ctx, OperationRow{Description: "add box-2", Keys: ImageKeys{Boxes: []string{"box-2"}}})
+				return err
+			}))
+			exists, err = store.DoesBoxExist(ctx, "box-2")
+			require.NoError(t, err)
+			assert.True(t, exists)
+			ops, err := store.GetOperations(ctx)
+			require.NoError(t, err)
+			assert.Len(t, ops, 1)
+		})
+	}
+}
+
+func TestStore_JournalTimer(t *testing.T) {
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, store.AddBox(ctx, "box-1", 1, 2))
+			require.NoError(t, store.StartTimer(ctx, TimerRow{Start: 10, Box: "box-1", TZ: "UTC"}))
+			keys := ImageKeys{Timer: true}
+			before, err := store.GetImage(ctx, keys)
+			require.NoError(t, err)
+			assert.Equal(t, &TimerRow{Start: 10, Box: "box-1", TZ: "UTC"}, before.Timer)
+			sr, err := store.StopTimer(ctx, 20)
+			require.NoError(t, err)
+			keys.Spans = []int64{sr.ID}
+			after, err := store.GetImage(ctx, keys)
+			require.NoError(t, err)
+			assert.Nil(t, after.Timer)
+			_, err = store.AddOperation(ctx, OperationRow{Description: "stop timer", Keys: keys, Before: before, After: after})
+			require.NoError(t, err)
+
+			_, err = store.UndoOperation(ctx, SourceAPI)
+			require.NoError(t, err)
+			timer, running, err := store.GetTimer(ctx)
+			require.NoError(t, err)
+			assert.True(t, running)
+			assert.Equal(t, TimerRow{Start: 10, Box: "box-1", TZ: "UTC"}, timer)
+			spans, err := store.GetSpansForBox(ctx, "box-1")
+			require.NoError(t, err)
+			assert.Empty(t, spans)
+
+			_, err = store.RedoOperation(ctx, SourceAPI)
+			require.NoError(t, err)
+			_, running, err = store.GetTimer(ctx)
+			require.NoError(t, err)
+			assert.False(t, running)
+		})
+	}
+}
+
+func TestStore_UndoOverlap(t *testing.T) {
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, store.AddBox(ctx, "box-1", 1, 2))
+			id, err := store.AddSpanRow(ctx, SpanRow{Start: 10, End: 20, Box: "box-1"})
+			require.NoError(t, err)
+			keys := ImageKeys{Spans: []int64{id}}
+			before, err := store.GetImage(ctx, keys)
+			require.NoError(t, err)
+			require.NoError(t, store.DeleteSpanByID(ctx, id))
+			_, err = store.AddOperation(ctx, OperationRow{Description: "delete span", Keys: keys, Before: before})
+			require.NoError(t, err)
+
+			// a span added outside the journal takes the time of the deleted one
+			require.NoError(t, store.AddSpan(ctx, 15, 25, "box-1"))
+			_, err = store.UndoOperation(ctx, SourceAPI)
+			assert.EqualError(t, err, fmt.Sprintf("can't undo \"delete span\": span %d would overlap a span added since", id))
+			spans, err := store.GetSpansForBox(ctx, "box-1")
+			require.NoError(t, err)
+			require.Len(t, spans, 1)
+			assert.Equal(t, int64(15), spans[0].Start)
+			ops, err := store.GetOperations(ctx)
+			require.NoError(t, err)
+			assert.False(t, ops[0].Undone)
+		})
+	}
+}
+
+func TestStore_History(t *testing.T) {
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, store.AddBox(ctx, "box-1", 1, 2))
+			id, err := store.AddSpanRow(ctx, SpanRow{Start: 10, End: 20, Box: "box-1"})
+			require.NoError(t, err)
+			other, err := store.AddSpanRow(ctx, SpanRow{Start: 30, End: 40, Box: "box-1"})
+			require.NoError(t, err)
+
+			// only the span that changed gets a version
+			keys := ImageKeys{Spans: []int64{id, other}}
+			before, err := store.GetImage(ctx, keys)
+			require.NoError(t, err)
+			require.NoError(t, store.UpdateSpanRow(ctx, SpanRow{ID: id, Start: 10, End: 25, Box: "box-1", Notes: "late"}))
+			after, err := store.GetImage(ctx, keys)
+			require.NoError(t, err)
+			_, err = store.AddOperation(ctx, OperationRow{Time: 100, Description: "update span", Source: SourceCLI, Keys: keys, Before: before, After: after})
+			require.NoError(t, err)
+			versions, err := store.GetSpanHistory(ctx, id)
+			require.NoError(t, err)
+			require.Len(t, versions, 1)
+			v := versions[0]
+			assert.Equal(t, VersionUpdated, v.Operation)
+			assert.Equal(t, SourceCLI, v.Source)
+			assert.Equal(t, int64(100), v.Time)
+			require.NotNil(t, v.Span)
+			assert.Equal(t, "late", v.Span.Notes)
+			versions, err = store.GetSpanHistory(ctx, other)
+			require.NoError(t, err)
+			assert.Empty(t, versions)
+
+			// undoing adds a version instead of removing one
+			_, err = store.UndoOperation(ctx, SourceTUI)
+			require.NoError(t, err)
+			versions, err = store.GetSpanHistory(ctx, id)
+			require.NoError(t, err)
+			require.Len(t, versions, 2)
+			assert.Equal(t, "undo update span", versions[1].Description)
+			assert.Equal(t, SourceTUI, versions[1].Source)
+			assert.Equal(t, "", versions[1].Span.Notes)
+
+			boxKeys := ImageKeys{Boxes: []string{"box-1"}, Spans: []int64{id, other}}
+			before, err = store.GetImage(ctx, boxKeys)
+			require.NoError(t, err)
+			require.NoError(t, store.DeleteBoxAndSpans(ctx, "box-1"))
+			_, err = store.AddOperation(ctx, OperationRow{Time: 200, Description: "delete box-1", Keys: boxKeys, Before: before})
+			require.NoError(t, err)
+			versions, err = store.GetBoxHistory(ctx, "box-1")
+			require.NoError(t, err)
+			require.Len(t, versions, 1)
+			assert.Equal(t, VersionDeleted, versions[0].Operation)
+			assert.Nil(t, versions[0].Box)
+			// the undo is recorded at the current time
+			versions, err = store.GetHistory(ctx, 200)
+			require.NoError(t, err)
+			assert.Len(t, versions, 4)
+			versions, err = store.GetHistory(ctx, 201)
+			require.NoError(t, err)
+			assert.Len(t, versions, 1)
+		})
+	}
+}
+
+func TestDB_HistoryIsAppendOnly(t *testing.T) {
+	tbdb := setup(t)
+	require.NoError(t, tbdb.AddBox(ctx, "box-1", 1, 2))
+	_, err := tbdb.AddOperation(ctx, OperationRow{Description: "add box-1", Keys: ImageKeys{Boxes: []string{"box-1"}}, After: Image{Boxes: []BoxImage{{BoxRow: BoxRow{Name: "box-1"}}}}})
+	require.NoError(t, err)
+	_, err = tbdb.db.ExecContext(ctx, "DELETE FROM history")
+	assert.ErrorContains(t, err, "history is append-only")
+	_, err = tbdb.db.ExecContext(ctx, "UPDATE history SET source = 'cli'")
+	assert.ErrorContains(t, err, "history is append-only")
+}
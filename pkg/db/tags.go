@@ -0,0 +1,104 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// tagQueryBatch limits the number of span IDs bound in a single tag query
+const tagQueryBatch = 500
+
+func scanSpanRows(rows *sql.Rows) ([]SpanRow, error) {
+	var result []SpanRow
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+	for rows.Next() {
+		var sr SpanRow
+		err := rows.Scan(&sr.ID, &sr.Start, &sr.End, &sr.Box, &sr.Notes, &sr.TZ)
+		if err != nil {
+			return result, err
+		}
+		result = append(result, sr)
+	}
+	return result, rows.Err()
+}
+
+// setSpanTags links the span to the given tags, creating tags as needed
+func setSpanTags(ctx context.Context, tx *sql.Tx, spanID int64, tags []string) error {
+	for _, tag := range tags {
+		_, err := tx.ExecContext(ctx, "INSERT OR IGNORE INTO tags(name) values(?)", tag)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "INSERT OR IGNORE INTO span_tags(span_id, tag_id) SELECT ?, id FROM tags WHERE name = ?", spanID, tag)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fillSpanTags loads the tags of each span in place
+func fillSpanTags(ctx context.Context, q querier, spans []SpanRow) error {
+	index := make(map[int64]int, len(spans))
+	for i, sr := range spans {
+		index[sr.ID] = i
+	}
+	for lo := 0; lo < len(spans); lo += tagQueryBatch {
+		hi := lo + tagQueryBatch
+		if hi > len(spans) {
+			hi = len(spans)
+		}
+		args := make([]any, 0, hi-lo)
+		for _, sr := range spans[lo:hi] {
+			args = append(args, sr.ID)
+		}
+		query := fmt.Sprintf(
+			"SELECT st.span_id, t.name FROM span_tags st JOIN tags t ON t.id = st.tag_id WHERE st.span_id IN (%s) ORDER BY t.name",
+			placeholders(len(args)),
+		)
+		rows, err := q.QueryContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var id int64
+			var name string
+			err := rows.Scan(&id, &name)
+			if err != nil {
+				_ = rows.Close()
+				return err
+			}
+			i := index[id]
+			spans[i].Tags = append(spans[i].Tags, name)
+		}
+		err = rows.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hasAllTagsFilter returns a WHERE clause matching spans tagged with every
+// one of the given tags. It is checked for each span the rest of the query
+// selects, so a time range keeps it from reading the tags of all spans.
+func hasAllTagsFilter(tags []string) (string, []any) {
+	args := make([]any, 0, len(tags)+1)
+	for _, tag := range tags {
+		args = append(args, tag)
+	}
+	args = append(args, len(tags))
+	filter := fmt.Sprintf(
+		"(SELECT COUNT(DISTINCT t.name) FROM span_tags st JOIN tags t ON t.id = st.tag_id WHERE st.span_id = spans.id AND t.name IN (%s)) = ?",
+		placeholders(len(tags)),
+	)
+	return filter, args
+}
+
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
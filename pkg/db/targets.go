@@ -0,0 +1,84 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// TargetPeriods are the periods a box can have explicit targets for
+var TargetPeriods = []string{"week", "month", "quarter", "year"}
+
+// TargetRow holds the min and max time of a box for one period. Periods
+// without a row are scaled from the weekly times of the box.
+type TargetRow struct {
+	Box     string
+	Period  string
+	MinTime int64
+	MaxTime int64
+}
+
+func validateTargets(box string, targets []TargetRow) error {
+	seen := make(map[string]bool)
+	for _, t := range targets {
+		if t.Box != box {
+			return fmt.Errorf("target for box %s given for box %s", t.Box, box)
+		}
+		if !containsString(TargetPeriods, t.Period) {
+			return fmt.Errorf("unknown target period %s", t.Period)
+		}
+		if seen[t.Period] {
+			return fmt.Errorf("duplicate target for period %s", t.Period)
+		}
+		seen[t.Period] = true
+		if t.MinTime > t.MaxTime {
+			return fmt.Errorf("minTime is greater than maxTime")
+		}
+	}
+	return nil
+}
+
+func (d *TBDB) GetAllTargets(ctx context.Context) ([]TargetRow, error) {
+	var result []TargetRow
+	rows, err := d.q().QueryContext(ctx, "SELECT box, period, minTime, maxTime FROM box_targets ORDER BY box, period")
+	if err != nil {
+		return result, err
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+	for rows.Next() {
+		var tr TargetRow
+		err = rows.Scan(&tr.Box, &tr.Period, &tr.MinTime, &tr.MaxTime)
+		if err != nil {
+			return result, err
+		}
+		result = append(result, tr)
+	}
+	return result, rows.Err()
+}
+
+// SetBoxTargets replaces all targets of a box
+func (d *TBDB) SetBoxTargets(ctx context.Context, box string, targets []TargetRow) error {
+	err := validateTargets(box, targets)
+	if err != nil {
+		return err
+	}
+	return d.inTx(ctx, func(tx *sql.Tx) error {
+		err := checkBoxExists(ctx, tx, box)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "DELETE FROM box_targets WHERE box = ?", box)
+		if err != nil {
+			return err
+		}
+		for _, t := range targets {
+			_, err = tx.ExecContext(ctx, "INSERT INTO box_targets(box, period, minTime, maxTime) values(?, ?, ?, ?)", t.Box, t.Period, t.MinTime, t.MaxTime)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,121 @@
+// Package ical reads and writes the subset of iCalendar (RFC 5545) needed to
+// exchange spans with calendar applications
+package ical
+
+import (
+	"fmt"
+	"github.com/aldernero/timebox/pkg/util"
+	"io"
+	"strings"
+	"time"
+)
+
+// Format is the name of the format for the export and import commands
+const Format = "ics"
+
+const (
+	prodID         = "-//aldernero//timebox//EN"
+	dateLayout     = "20060102"
+	dateTimeLayout = "20060102T150405"
+	// tagsProperty carries the tags of a span, calendar apps ignore it
+	tagsProperty  = "X-TIMEBOX-TAGS"
+	maxLineOctets = 75
+)
+
+// Write emits a calendar with one VEVENT per span. The box is used as the
+// summary and the category, the notes as the description.
+func Write(w io.Writer, spans []util.Span) error {
+	cw := &contentWriter{w: w}
+	stamp := time.Now().UTC().Format(dateTimeLayout) + "Z"
+	cw.line("BEGIN:VCALENDAR")
+	cw.line("VERSION:2.0")
+	cw.line("PRODID:" + prodID)
+	for _, s := range spans {
+		cw.line("BEGIN:VEVENT")
+		cw.line(fmt.Sprintf("UID:span-%d-%d@timebox", s.ID, s.Start.Unix()))
+		cw.line("DTSTAMP:" + stamp)
+		cw.line("DTSTART:" + s.Start.UTC().Format(dateTimeLayout) + "Z")
+		cw.line("DTEND:" + s.End.UTC().Format(dateTimeLayout) + "Z")
+		cw.line("SUMMARY:" + escapeText(s.Box))
+		cw.line("CATEGORIES:" + escapeText(s.Box))
+		if s.Notes != "" {
+			cw.line("DESCRIPTION:" + escapeText(s.Notes))
+		}
+		if len(s.Tags) > 0 {
+			tags := make([]string, len(s.Tags))
+			for i, t := range s.Tags {
+				tags[i] = escapeText(t)
+			}
+			cw.line(tagsProperty + ":" + strings.Join(tags, ","))
+		}
+		cw.line("END:VEVENT")
+	}
+	cw.line("END:VCALENDAR")
+	return cw.err
+}
+
+type contentWriter struct {
+	w   io.Writer
+	err error
+}
+
+// line writes a content line, folded after 75 octets without splitting
+// UTF-8 sequences
+func (cw *contentWriter) line(s string) {
+	if cw.err != nil {
+		return
+	}
+	var b strings.Builder
+	width := 0
+	for _, r := range s {
+		n := len(string(r))
+		if width+n > maxLineOctets {
+			b.WriteString("\r\n ")
+			width = 1
+		}
+		b.WriteRune(r)
+		width += n
+	}
+	b.WriteString("\r\n")
+	_, cw.err = io.WriteString(cw.w, b.String())
+}
+
+func escapeText(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+	return r.Replace(s)
+}
+
+func unescapeText(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+			switch s[i] {
+			case 'n', 'N':
+				b.WriteByte('\n')
+			default:
+				b.WriteByte(s[i])
+			}
+			continue
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// splitText splits a list valued TEXT property like CATEGORIES on the commas
+// that aren't escaped
+func splitText(s string) []string {
+	var parts []string
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case ',':
+			parts = append(parts, unescapeText(s[start:i]))
+			start = i + 1
+		}
+	}
+	return append(parts, unescapeText(s[start:]))
+}
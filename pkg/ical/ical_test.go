@@ -0,0 +1,205 @@
+package ical
+
+import (
+	"bytes"
+	"github.com/aldernero/timebox/pkg/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func calendar(events ...string) string {
+	return "BEGIN:VCALENDAR\r\nVERSION:2.0\r\n" + strings.Join(events, "") + "END:VCALENDAR\r\n"
+}
+
+func parseCalendar(t *testing.T, s string) []Event {
+	events, err := Parse(strings.NewReader(s))
+	require.NoError(t, err)
+	return events
+}
+
+func starts(e util.Export) []string {
+	var result []string
+	for _, s := range e.Spans {
+		result = append(result, s.Start.Format(time.RFC3339))
+	}
+	return result
+}
+
+func TestWrite_RoundTrip(t *testing.T) {
+	start := time.Date(2023, time.March, 1, 9, 0, 0, 0, time.UTC)
+	notes := "a long note, with a comma; a semicolon and a newline\n" + strings.Repeat("ü", 60)
+	spans := []util.Span{
+		{ID: 1, Start: start, End: start.Add(time.Hour), Box: "Work/ClientA", Notes: notes, Tags: []string{"a", "b"}},
+		{ID: 2, Start: start.Add(2 * time.Hour), End: start.Add(3 * time.Hour), Box: "Piano"},
+	}
+	var buf bytes.Buffer
+	require.NoError(t, Write(&buf, spans))
+	for _, line := range strings.Split(buf.String(), "\r\n") {
+		assert.LessOrEqual(t, len(line), 75)
+	}
+	assert.Contains(t, buf.String(), "CATEGORIES:Work/ClientA\r\n")
+
+	events := parseCalendar(t, buf.String())
+	require.Equal(t, 2, len(events))
+	assert.Equal(t, "Work/ClientA", events[0].Summary)
+	assert.Equal(t, notes, events[0].Description)
+	assert.True(t, events[0].Start.Equal(start))
+
+	e, errs := ToExport(events, Options{})
+	assert.Empty(t, errs)
+	require.Equal(t, 2, len(e.Spans))
+	assert.Equal(t, "Work/ClientA", e.Spans[0].Box)
+	assert.Equal(t, []string{"a", "b"}, e.Spans[0].Tags)
+	assert.Equal(t, "Piano", e.Spans[1].Box)
+}
+
+func TestParse_TimeZones(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	require.NoError(t, err)
+	events := parseCalendar(t, calendar(
+		"BEGIN:VEVENT\r\nSUMMARY:Standup\r\nDTSTART;TZID=Europe/Berlin:20230301T090000\r\nDURATION:PT15M\r\nEND:VEVENT\r\n",
+		"BEGIN:VEVENT\r\nSUMMARY:Stand\r\n up\r\nDTSTART;TZID=\"America/New_York\":20230301T090000\r\nDTEND;TZID=America/New_York:20230301T100000\r\nEND:VEVENT\r\n",
+		"BEGIN:VEVENT\r\nSUMMARY:Standup\r\nDTSTART;TZID=Mars/Olympus:20230301T090000\r\nDTEND:20230301T100000Z\r\nEND:VEVENT\r\n",
+	))
+	require.Equal(t, 3, len(events))
+	require.NoError(t, events[0].Err)
+	assert.True(t, events[0].Start.Equal(time.Date(2023, time.March, 1, 8, 0, 0, 0, time.UTC)))
+	assert.Equal(t, berlin, events[0].Start.Location())
+	assert.Equal(t, 15*time.Minute, events[0].End.Sub(events[0].Start))
+	require.NoError(t, events[1].Err)
+	assert.Equal(t, "Standup", events[1].Summary)
+	assert.True(t, events[1].Start.Equal(time.Date(2023, time.March, 1, 14, 0, 0, 0, time.UTC)))
+	assert.EqualError(t, events[2].Err, "unknown time zone Mars/Olympus")
+
+	e, errs := ToExport(events, Options{Mapping: map[string]string{"standup": "Work"}})
+	require.Equal(t, 1, len(errs))
+	assert.Equal(t, 14, errs[0].Row)
+	require.Equal(t, 2, len(e.Spans))
+	assert.Equal(t, "Work", e.Spans[1].Box)
+}
+
+func TestToExport_AllDay(t *testing.T) {
+	events := parseCalendar(t, calendar(
+		"BEGIN:VEVENT\r\nSUMMARY:Holiday\r\nDTSTART;VALUE=DATE:20230301\r\nDTEND;VALUE=DATE:20230303\r\nEND:VEVENT\r\n",
+		"BEGIN:VEVENT\r\nSUMMARY:Focus\r\nDTSTART:20230301T090000Z\r\nDTEND:20230301T100000Z\r\nBEGIN:VALARM\r\nSUMMARY:Reminder\r\nEND:VALARM\r\nEND:VEVENT\r\n",
+	))
+	require.Equal(t, 2, len(events))
+	assert.True(t, events[0].AllDay)
+	assert.Equal(t, "Focus", events[1].Summary)
+
+	e, errs := ToExport(events, Options{})
+	assert.Empty(t, errs)
+	require.Equal(t, 1, len(e.Spans))
+	assert.Equal(t, "Focus", e.Spans[0].Box)
+
+	e, _ = ToExport(events, Options{IncludeAllDay: true, Mapping: map[string]string{"*": "Misc"}})
+	require.Equal(t, 2, len(e.Spans))
+	assert.Equal(t, "Misc", e.Spans[0].Box)
+	assert.Equal(t, []string{AllDayTag}, e.Spans[0].Tags)
+	assert.Equal(t, time.Date(2023, time.March, 3, 0, 0, 0, 0, time.Local), e.Spans[0].End)
+}
+
+func TestToExport_Recurring(t *testing.T) {
+	from := time.Date(2023, time.January, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2023, time.April, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		rule     string
+		extra    string
+		expected []string
+	}{
+		{
+			name:     "weekly by day with count",
+			rule:     "FREQ=WEEKLY;BYDAY=MO,WE;COUNT=4",
+			expected: []string{"2023-03-01T09:00:00Z", "2023-03-06T09:00:00Z", "2023-03-08T09:00:00Z", "2023-03-13T09:00:00Z"},
+		},
+		{
+			name:     "daily with interval and until",
+			rule:     "FREQ=DAILY;INTERVAL=10;UNTIL=20230321T090000Z",
+			expected: []string{"2023-03-01T09:00:00Z", "2023-03-11T09:00:00Z", "2023-03-21T09:00:00Z"},
+		},
+		{
+			name:     "monthly last friday, cut off by the range",
+			rule:     "FREQ=MONTHLY;BYDAY=-1FR",
+			expected: []string{"2023-03-31T09:00:00Z"},
+		},
+		{
+			name:     "exdate",
+			rule:     "FREQ=DAILY;COUNT=3",
+			extra:    "EXDATE:20230302T090000Z\r\n",
+			expected: []string{"2023-03-01T09:00:00Z", "2023-03-03T09:00:00Z"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events := parseCalendar(t, calendar("BEGIN:VEVENT\r\nUID:x\r\nSUMMARY:Gym\r\nDTSTART:20230301T090000Z\r\nDTEND:20230301T100000Z\r\nRRULE:"+tt.rule+"\r\n"+tt.extra+"END:VEVENT\r\n"))
+			require.NoError(t, events[0].Err)
+			e, errs := ToExport(events, Options{From: from, To: to})
+			assert.Empty(t, errs)
+			assert.Equal(t, tt.expected, starts(e))
+		})
+	}
+}
+
+func TestToExport_RecurringOverride(t *testing.T) {
+	events := parseCalendar(t, calendar(
+		"BEGIN:VEVENT\r\nUID:x\r\nSUMMARY:Gym\r\nDTSTART:20230301T090000Z\r\nDTEND:20230301T100000Z\r\nRRULE:FREQ=DAILY;COUNT=3\r\nEND:VEVENT\r\n",
+		"BEGIN:VEVENT\r\nUID:x\r\nRECURRENCE-ID:20230302T090000Z\r\nSUMMARY:Gym\r\nDTSTART:20230302T180000Z\r\nDTEND:20230302T190000Z\r\nEND:VEVENT\r\n",
+	))
+	e, errs := ToExport(events, Options{To: time.Date(2023, time.April, 1, 0, 0, 0, 0, time.UTC)})
+	assert.Empty(t, errs)
+	assert.ElementsMatch(t, []string{"2023-03-01T09:00:00Z", "2023-03-03T09:00:00Z", "2023-03-02T18:00:00Z"}, starts(e))
+}
+
+func TestRRule_DST(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	require.NoError(t, err)
+	events := parseCalendar(t, calendar("BEGIN:VEVENT\r\nSUMMARY:Gym\r\nDTSTART;TZID=Europe/Berlin:20230324T090000\r\nDTEND;TZID=Europe/Berlin:20230324T100000\r\nRRULE:FREQ=WEEKLY;UNTIL=20230331\r\nEND:VEVENT\r\n"))
+	e, errs := ToExport(events, Options{To: time.Date(2023, time.May, 1, 0, 0, 0, 0, time.UTC)})
+	assert.Empty(t, errs)
+	require.Equal(t, 2, len(e.Spans))
+	// the wall clock time stays the same across the switch to summer time
+	assert.Equal(t, "09:00", e.Spans[1].Start.In(berlin).Format("15:04"))
+	assert.Equal(t, time.Hour, e.Spans[1].End.Sub(e.Spans[1].Start))
+}
+
+func TestParseRRule_Errors(t *testing.T) {
+	for _, rule := range []string{"", "FREQ=HOURLY", "FREQ=DAILY;BYSETPOS=1", "FREQ=WEEKLY;BYDAY=2MO", "FREQ=DAILY;COUNT=2;UNTIL=20230101", "FREQ=DAILY;INTERVAL=0"} {
+		_, err := parseRRule(rule)
+		assert.Error(t, err, rule)
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	_, err := Parse(strings.NewReader("BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nEND:VCALENDAR\r\n"))
+	assert.Error(t, err)
+	_, err = Parse(strings.NewReader("BEGIN:VCALENDAR\r\nnonsense\r\nEND:VCALENDAR\r\n"))
+	assert.EqualError(t, err, "line 2: invalid content line \"nonsense\"")
+	_, err = ParseMapping([]string{"Standup"})
+	assert.Error(t, err)
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		in   string
+		days int
+		d    time.Duration
+	}{
+		{"P1W", 7, 0},
+		{"P1DT2H30M", 1, 2*time.Hour + 30*time.Minute},
+		{"-PT15M", 0, -15 * time.Minute},
+		{"PT90S", 0, 90 * time.Second},
+	}
+	for _, tt := range tests {
+		days, d, err := parseDuration(tt.in)
+		require.NoError(t, err, tt.in)
+		assert.Equal(t, tt.days, days, tt.in)
+		assert.Equal(t, tt.d, d, tt.in)
+	}
+	_, _, err := parseDuration("P1H")
+	assert.Error(t, err)
+}
@@ -0,0 +1,316 @@
+package ical
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Event is a VEVENT. Events that can't be turned into spans, e.g. because of
+// an unknown time zone, are returned with Err set so they can be reported
+// one by one.
+type Event struct {
+	UID         string
+	Summary     string
+	Description string
+	Categories  []string
+	Tags        []string
+	Start       time.Time
+	End         time.Time
+	AllDay      bool
+	Line        int // line of BEGIN:VEVENT
+	Err         error
+
+	rrule        *rrule
+	exdates      []exdate
+	recurrenceID time.Time
+}
+
+type exdate struct {
+	t    time.Time
+	date bool
+}
+
+// Recurring reports whether the event has a recurrence rule
+func (e Event) Recurring() bool {
+	return e.rrule != nil
+}
+
+type property struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+// Parse reads the VEVENTs of a calendar. Only malformed content lines and
+// unbalanced components are reported as an error.
+func Parse(r io.Reader) ([]Event, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+	var events []Event
+	var stack []string
+	var ev *Event
+	var hasEnd, hasDuration bool
+	var duration string
+	for _, l := range lines {
+		if strings.TrimSpace(l.text) == "" {
+			continue
+		}
+		p, err := parseProperty(l.text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", l.number, err)
+		}
+		switch p.name {
+		case "BEGIN":
+			stack = append(stack, strings.ToUpper(p.value))
+			if len(stack) >= 2 && stack[len(stack)-1] == "VEVENT" && stack[len(stack)-2] == "VCALENDAR" {
+				ev = &Event{Line: l.number}
+				hasEnd, hasDuration, duration = false, false, ""
+			}
+			continue
+		case "END":
+			if len(stack) == 0 || stack[len(stack)-1] != strings.ToUpper(p.value) {
+				return nil, fmt.Errorf("line %d: unexpected END:%s", l.number, p.value)
+			}
+			stack = stack[:len(stack)-1]
+			if ev != nil && strings.ToUpper(p.value) == "VEVENT" {
+				finishEvent(ev, hasEnd, hasDuration, duration)
+				events = append(events, *ev)
+				ev = nil
+			}
+			continue
+		}
+		// properties of nested components like VALARM are ignored
+		if ev == nil || stack[len(stack)-1] != "VEVENT" || ev.Err != nil {
+			continue
+		}
+		switch p.name {
+		case "UID":
+			ev.UID = p.value
+		case "SUMMARY":
+			ev.Summary = unescapeText(p.value)
+		case "DESCRIPTION":
+			ev.Description = unescapeText(p.value)
+		case "CATEGORIES":
+			ev.Categories = append(ev.Categories, splitText(p.value)...)
+		case tagsProperty:
+			ev.Tags = append(ev.Tags, splitText(p.value)...)
+		case "DTSTART":
+			ev.Start, ev.AllDay, ev.Err = parseDateTime(p)
+		case "DTEND":
+			hasEnd = true
+			ev.End, _, ev.Err = parseDateTime(p)
+		case "DURATION":
+			hasDuration = true
+			duration = p.value
+		case "RRULE":
+			ev.rrule, ev.Err = parseRRule(p.value)
+		case "EXDATE":
+			for _, v := range strings.Split(p.value, ",") {
+				ex := p
+				ex.value = v
+				t, date, err := parseDateTime(ex)
+				if err != nil {
+					ev.Err = err
+					break
+				}
+				ev.exdates = append(ev.exdates, exdate{t: t, date: date})
+			}
+		case "RECURRENCE-ID":
+			ev.recurrenceID, _, ev.Err = parseDateTime(p)
+		case "RDATE":
+			ev.Err = errors.New("RDATE is not supported")
+		}
+	}
+	if len(stack) > 0 {
+		return nil, fmt.Errorf("missing END:%s", stack[len(stack)-1])
+	}
+	return events, nil
+}
+
+func finishEvent(ev *Event, hasEnd, hasDuration bool, duration string) {
+	if ev.Err != nil {
+		return
+	}
+	switch {
+	case ev.Start.IsZero():
+		ev.Err = errors.New("event has no DTSTART")
+	case hasDuration:
+		days, d, err := parseDuration(duration)
+		if err != nil {
+			ev.Err = err
+			return
+		}
+		ev.End = ev.Start.AddDate(0, 0, days).Add(d)
+	case !hasEnd && ev.AllDay:
+		ev.End = ev.Start.AddDate(0, 0, 1)
+	case !hasEnd:
+		ev.Err = errors.New("event has no DTEND or DURATION")
+	}
+	if ev.Err == nil && ev.End.Before(ev.Start) {
+		ev.Err = errors.New("event ends before it starts")
+	}
+	if ev.Err == nil && ev.rrule != nil {
+		// UNTIL without a zone is in the zone of DTSTART
+		ev.Err = ev.rrule.resolveUntil(ev.Start.Location())
+	}
+}
+
+type contentLine struct {
+	number int
+	text   string
+}
+
+// unfold joins continuation lines, which start with a space or a tab
+func unfold(r io.Reader) ([]contentLine, error) {
+	var lines []contentLine
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	n := 0
+	for sc.Scan() {
+		n++
+		text := strings.TrimRight(sc.Text(), "\r")
+		if (strings.HasPrefix(text, " ") || strings.HasPrefix(text, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1].text += text[1:]
+			continue
+		}
+		lines = append(lines, contentLine{number: n, text: text})
+	}
+	return lines, sc.Err()
+}
+
+// parseProperty splits a content line like
+// DTSTART;TZID="Europe/Berlin":20230301T090000 into its parts
+func parseProperty(s string) (property, error) {
+	p := property{params: make(map[string]string)}
+	i := strings.IndexAny(s, ";:")
+	if i <= 0 {
+		return p, fmt.Errorf("invalid content line \"%s\"", s)
+	}
+	p.name = strings.ToUpper(s[:i])
+	rest := s[i:]
+	for strings.HasPrefix(rest, ";") {
+		rest = rest[1:]
+		eq := strings.IndexByte(rest, '=')
+		if eq <= 0 {
+			return p, fmt.Errorf("invalid parameter in %s", p.name)
+		}
+		key := strings.ToUpper(rest[:eq])
+		rest = rest[eq+1:]
+		var val string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.IndexByte(rest[1:], '"')
+			if end < 0 {
+				return p, fmt.Errorf("unterminated quote in %s", p.name)
+			}
+			val = rest[1 : end+1]
+			rest = rest[end+2:]
+		} else {
+			j := strings.IndexAny(rest, ";:")
+			if j < 0 {
+				return p, fmt.Errorf("missing value in %s", p.name)
+			}
+			val = rest[:j]
+			rest = rest[j:]
+		}
+		p.params[key] = val
+	}
+	if !strings.HasPrefix(rest, ":") {
+		return p, fmt.Errorf("missing value in %s", p.name)
+	}
+	p.value = rest[1:]
+	return p, nil
+}
+
+// parseDateTime parses a DATE or DATE-TIME value in UTC, in the zone given
+// by TZID, or as floating time in the local zone. Dates are midnight local
+// time.
+func parseDateTime(p property) (time.Time, bool, error) {
+	v := strings.TrimSpace(p.value)
+	if p.params["VALUE"] == "DATE" || len(v) == len(dateLayout) {
+		t, err := time.ParseInLocation(dateLayout, v, time.Local)
+		if err != nil {
+			return t, true, fmt.Errorf("invalid date in %s: %s", p.name, v)
+		}
+		return t, true, nil
+	}
+	loc := time.Local
+	if strings.HasSuffix(v, "Z") {
+		v = strings.TrimSuffix(v, "Z")
+		loc = time.UTC
+	} else if tzid, ok := p.params["TZID"]; ok {
+		var err error
+		loc, err = time.LoadLocation(strings.TrimPrefix(tzid, "/"))
+		if err != nil {
+			return time.Time{}, false, fmt.Errorf("unknown time zone %s", tzid)
+		}
+	}
+	t, err := time.ParseInLocation(dateTimeLayout, v, loc)
+	if err != nil {
+		return t, false, fmt.Errorf("invalid date-time in %s: %s", p.name, p.value)
+	}
+	return t, false, nil
+}
+
+// parseDuration parses a duration like P1W, P1DT2H or -PT15M. Days are
+// returned separately because they are nominal, a day across a DST change
+// isn't 24 hours.
+func parseDuration(s string) (int, time.Duration, error) {
+	invalid := fmt.Errorf("invalid duration %s", s)
+	sign := 1
+	v := s
+	switch {
+	case strings.HasPrefix(v, "-"):
+		sign = -1
+		v = v[1:]
+	case strings.HasPrefix(v, "+"):
+		v = v[1:]
+	}
+	if !strings.HasPrefix(v, "P") || len(v) < 3 {
+		return 0, 0, invalid
+	}
+	v = v[1:]
+	var days int
+	var d time.Duration
+	inTime := false
+	num := ""
+	for _, c := range v {
+		switch {
+		case c >= '0' && c <= '9':
+			num += string(c)
+			continue
+		case c == 'T' && num == "":
+			inTime = true
+			continue
+		}
+		n, err := strconv.Atoi(num)
+		if err != nil {
+			return 0, 0, invalid
+		}
+		num = ""
+		switch {
+		case c == 'W' && !inTime:
+			days += 7 * n
+		case c == 'D' && !inTime:
+			days += n
+		case c == 'H' && inTime:
+			d += time.Duration(n) * time.Hour
+		case c == 'M' && inTime:
+			d += time.Duration(n) * time.Minute
+		case c == 'S' && inTime:
+			d += time.Duration(n) * time.Second
+		default:
+			return 0, 0, invalid
+		}
+	}
+	if num != "" {
+		return 0, 0, invalid
+	}
+	return sign * days, time.Duration(sign) * d, nil
+}
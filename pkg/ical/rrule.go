@@ -0,0 +1,286 @@
+package ical
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxPeriods bounds the expansion of rules that never match, e.g. the 31st
+// of every second February
+const maxPeriods = 100000
+
+var weekdays = map[string]time.Weekday{
+	"SU": time.Sunday,
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+}
+
+// byDay is an element of BYDAY like MO, or 2MO and -1FR for the second
+// Monday and the last Friday of a month
+type byDay struct {
+	n   int
+	day time.Weekday
+}
+
+// rrule is a recurrence rule with FREQ DAILY, WEEKLY, MONTHLY or YEARLY and
+// the parts INTERVAL, COUNT, UNTIL, BYDAY, BYMONTHDAY and WKST
+type rrule struct {
+	freq       string
+	interval   int
+	count      int
+	until      time.Time
+	untilRaw   string
+	byDay      []byDay
+	byMonthDay []int
+	wkst       time.Weekday
+}
+
+func parseRRule(s string) (*rrule, error) {
+	r := &rrule{interval: 1, wkst: time.Monday}
+	for _, part := range strings.Split(s, ";") {
+		key, val, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid RRULE part %s", part)
+		}
+		var err error
+		switch strings.ToUpper(key) {
+		case "FREQ":
+			r.freq = strings.ToUpper(val)
+			switch r.freq {
+			case "DAILY", "WEEKLY", "MONTHLY", "YEARLY":
+			default:
+				return nil, fmt.Errorf("unsupported RRULE frequency %s", val)
+			}
+		case "INTERVAL":
+			r.interval, err = strconv.Atoi(val)
+			if err == nil && r.interval < 1 {
+				err = fmt.Errorf("invalid RRULE interval %s", val)
+			}
+		case "COUNT":
+			r.count, err = strconv.Atoi(val)
+			if err == nil && r.count < 1 {
+				err = fmt.Errorf("invalid RRULE count %s", val)
+			}
+		case "UNTIL":
+			r.untilRaw = val
+		case "BYDAY":
+			for _, v := range strings.Split(val, ",") {
+				v = strings.ToUpper(v)
+				if len(v) < 2 {
+					return nil, fmt.Errorf("invalid RRULE day %s", v)
+				}
+				day, ok := weekdays[v[len(v)-2:]]
+				if !ok {
+					return nil, fmt.Errorf("invalid RRULE day %s", v)
+				}
+				bd := byDay{day: day}
+				if len(v) > 2 {
+					bd.n, err = strconv.Atoi(v[:len(v)-2])
+					if err != nil || bd.n == 0 {
+						return nil, fmt.Errorf("invalid RRULE day %s", v)
+					}
+				}
+				r.byDay = append(r.byDay, bd)
+			}
+		case "BYMONTHDAY":
+			for _, v := range strings.Split(val, ",") {
+				d, err := strconv.Atoi(v)
+				if err != nil || d == 0 || d < -31 || d > 31 {
+					return nil, fmt.Errorf("invalid RRULE month day %s", v)
+				}
+				r.byMonthDay = append(r.byMonthDay, d)
+			}
+		case "WKST":
+			day, ok := weekdays[strings.ToUpper(val)]
+			if !ok {
+				return nil, fmt.Errorf("invalid RRULE week start %s", val)
+			}
+			r.wkst = day
+		default:
+			return nil, fmt.Errorf("unsupported RRULE part %s", key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid RRULE %s: %w", key, err)
+		}
+	}
+	if r.freq == "" {
+		return nil, fmt.Errorf("RRULE without FREQ")
+	}
+	if r.count > 0 && r.untilRaw != "" {
+		return nil, fmt.Errorf("RRULE has both COUNT and UNTIL")
+	}
+	for _, bd := range r.byDay {
+		if bd.n != 0 && r.freq != "MONTHLY" {
+			return nil, fmt.Errorf("numbered BYDAY is only supported for monthly rules")
+		}
+	}
+	if r.freq == "YEARLY" && (len(r.byDay) > 0 || len(r.byMonthDay) > 0) {
+		return nil, fmt.Errorf("BYDAY and BYMONTHDAY are not supported for yearly rules")
+	}
+	return r, nil
+}
+
+// resolveUntil parses UNTIL, which is in the zone of DTSTART unless it's
+// in UTC. A date includes the whole day.
+func (r *rrule) resolveUntil(loc *time.Location) error {
+	if r.untilRaw == "" {
+		return nil
+	}
+	t, allDay, err := parseDateTime(property{name: "UNTIL", value: r.untilRaw})
+	if err != nil {
+		return err
+	}
+	if allDay {
+		t = time.Date(t.Year(), t.Month(), t.Day(), 23, 59, 59, 0, loc)
+	} else if t.Location() != time.UTC {
+		t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, loc)
+	}
+	r.until = t
+	return nil
+}
+
+// occurrences calls fn with the start of each instance, beginning with
+// start, until fn returns false, the rule ends or an instance would start at
+// or after to
+func (r *rrule) occurrences(start, to time.Time, fn func(time.Time) bool) {
+	count := 0
+	for p := 0; p < maxPeriods; p++ {
+		candidates, periodStart := r.period(start, p)
+		if !periodStart.Before(to) {
+			return
+		}
+		for _, c := range candidates {
+			if c.Before(start) {
+				continue
+			}
+			if !r.until.IsZero() && c.After(r.until) {
+				return
+			}
+			if !c.Before(to) {
+				return
+			}
+			count++
+			if !fn(c) {
+				return
+			}
+			if r.count > 0 && count >= r.count {
+				return
+			}
+		}
+	}
+}
+
+// period returns the sorted instance candidates of the p-th period of the
+// rule and the beginning of that period
+func (r *rrule) period(start time.Time, p int) ([]time.Time, time.Time) {
+	loc := start.Location()
+	at := func(y int, m time.Month, d int) time.Time {
+		return time.Date(y, m, d, start.Hour(), start.Minute(), start.Second(), 0, loc)
+	}
+	y, m, d := start.Date()
+	var candidates []time.Time
+	var periodStart time.Time
+	switch r.freq {
+	case "DAILY":
+		periodStart = time.Date(y, m, d+p*r.interval, 0, 0, 0, 0, loc)
+		c := at(periodStart.Date())
+		if r.matchesDay(c) && r.matchesMonthDay(c) {
+			candidates = append(candidates, c)
+		}
+	case "WEEKLY":
+		offset := (int(start.Weekday()) - int(r.wkst) + 7) % 7
+		periodStart = time.Date(y, m, d-offset+7*p*r.interval, 0, 0, 0, 0, loc)
+		if len(r.byDay) == 0 {
+			candidates = append(candidates, at(y, m, d+7*p*r.interval))
+			break
+		}
+		py, pm, pd := periodStart.Date()
+		for i := 0; i < 7; i++ {
+			c := at(py, pm, pd+i)
+			if r.matchesDay(c) {
+				candidates = append(candidates, c)
+			}
+		}
+	case "MONTHLY":
+		periodStart = time.Date(y, m+time.Month(p*r.interval), 1, 0, 0, 0, 0, loc)
+		py, pm, _ := periodStart.Date()
+		last := daysIn(py, pm)
+		for day := 1; day <= last; day++ {
+			c := at(py, pm, day)
+			switch {
+			case len(r.byDay) == 0 && len(r.byMonthDay) == 0:
+				if day == d {
+					candidates = append(candidates, c)
+				}
+			case r.matchesMonthDay(c) && r.matchesMonthlyDay(c):
+				candidates = append(candidates, c)
+			}
+		}
+	case "YEARLY":
+		periodStart = time.Date(y+p*r.interval, 1, 1, 0, 0, 0, 0, loc)
+		// Feb 29 only recurs in leap years
+		if d <= daysIn(periodStart.Year(), m) {
+			candidates = append(candidates, at(periodStart.Year(), m, d))
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].Before(candidates[j]) })
+	return candidates, periodStart
+}
+
+func (r *rrule) matchesDay(t time.Time) bool {
+	if len(r.byDay) == 0 {
+		return true
+	}
+	for _, bd := range r.byDay {
+		if bd.day == t.Weekday() {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *rrule) matchesMonthDay(t time.Time) bool {
+	if len(r.byMonthDay) == 0 {
+		return true
+	}
+	last := daysIn(t.Year(), t.Month())
+	for _, md := range r.byMonthDay {
+		if md == t.Day() || (md < 0 && last+md+1 == t.Day()) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesMonthlyDay handles numbered BYDAY elements within a month
+func (r *rrule) matchesMonthlyDay(t time.Time) bool {
+	if len(r.byDay) == 0 {
+		return true
+	}
+	last := daysIn(t.Year(), t.Month())
+	for _, bd := range r.byDay {
+		if bd.day != t.Weekday() {
+			continue
+		}
+		switch {
+		case bd.n == 0:
+			return true
+		case bd.n > 0 && (t.Day()-1)/7+1 == bd.n:
+			return true
+		case bd.n < 0 && (last-t.Day())/7+1 == -bd.n:
+			return true
+		}
+	}
+	return false
+}
+
+func daysIn(year int, month time.Month) int {
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
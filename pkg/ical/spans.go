@@ -0,0 +1,142 @@
+package ical
+
+import (
+	"fmt"
+	"github.com/aldernero/timebox/pkg/util"
+	"strings"
+	"time"
+)
+
+// AllDayTag is added to spans imported from all-day events
+const AllDayTag = "all-day"
+
+// Options control how events are turned into spans
+type Options struct {
+	// Mapping maps event summaries to box names, ignoring case. The key "*"
+	// matches every event that isn't mapped otherwise.
+	Mapping map[string]string
+	// IncludeAllDay imports all-day events as spans tagged AllDayTag
+	// instead of skipping them
+	IncludeAllDay bool
+	// From and To limit the spans to those overlapping the range,
+	// recurring events are expanded within it. A zero To means now.
+	From time.Time
+	To   time.Time
+}
+
+// ParseMapping parses mappings like "Standup=Work/Meetings"
+func ParseMapping(raw []string) (map[string]string, error) {
+	mapping := make(map[string]string)
+	for _, r := range raw {
+		summary, box, ok := strings.Cut(r, "=")
+		if !ok || strings.TrimSpace(summary) == "" || strings.TrimSpace(box) == "" {
+			return nil, fmt.Errorf("invalid mapping %s, expected summary=box", r)
+		}
+		mapping[strings.TrimSpace(summary)] = strings.TrimSpace(box)
+	}
+	return mapping, nil
+}
+
+// ToExport turns events into spans for TimeBox.Import. The box of an event
+// is its mapped summary, else its first category, else its summary. Events
+// that can't be converted are returned as errors with their line.
+func ToExport(events []Event, opts Options) (util.Export, []util.ImportError) {
+	var e util.Export
+	var errs []util.ImportError
+	to := opts.To
+	if to.IsZero() {
+		to = time.Now()
+	}
+	// instances moved or changed with RECURRENCE-ID replace the generated ones
+	overridden := make(map[string]bool)
+	for _, ev := range events {
+		if ev.Err == nil && !ev.recurrenceID.IsZero() {
+			overridden[overrideKey(ev.UID, ev.recurrenceID)] = true
+		}
+	}
+	for _, ev := range events {
+		if ev.Err != nil {
+			errs = append(errs, util.ImportError{Kind: "event", Row: ev.Line, Err: ev.Err})
+			continue
+		}
+		if ev.AllDay && !opts.IncludeAllDay {
+			continue
+		}
+		box := opts.box(ev)
+		if box == "" {
+			errs = append(errs, util.ImportError{Kind: "event", Row: ev.Line, Err: fmt.Errorf("event has no summary to map to a box")})
+			continue
+		}
+		tags := util.ParseTags(ev.Tags)
+		if ev.AllDay {
+			tags = util.ParseTags(append(tags, AllDayTag))
+		}
+		add := func(start, end time.Time) {
+			if !end.After(opts.From) || !start.Before(to) {
+				return
+			}
+			e.Spans = append(e.Spans, util.ExportedSpan{
+				Box:   box,
+				Start: start,
+				End:   end,
+				Notes: ev.Description,
+				Tags:  tags,
+				Row:   ev.Line,
+			})
+		}
+		if ev.rrule == nil {
+			add(ev.Start, ev.End)
+			continue
+		}
+		length := ev.End.Sub(ev.Start)
+		days := int(length.Round(24*time.Hour) / (24 * time.Hour))
+		ev.rrule.occurrences(ev.Start, to, func(start time.Time) bool {
+			if overridden[overrideKey(ev.UID, start)] || ev.isExcluded(start) {
+				return true
+			}
+			end := start.Add(length)
+			if ev.AllDay {
+				end = start.AddDate(0, 0, days)
+			}
+			add(start, end)
+			return true
+		})
+	}
+	return e, errs
+}
+
+func (o Options) box(ev Event) string {
+	for summary, box := range o.Mapping {
+		if strings.EqualFold(summary, strings.TrimSpace(ev.Summary)) {
+			return box
+		}
+	}
+	if box, ok := o.Mapping["*"]; ok {
+		return box
+	}
+	if len(ev.Categories) > 0 && strings.TrimSpace(ev.Categories[0]) != "" {
+		return strings.TrimSpace(ev.Categories[0])
+	}
+	return strings.TrimSpace(ev.Summary)
+}
+
+func (e Event) isExcluded(start time.Time) bool {
+	for _, ex := range e.exdates {
+		if !ex.date && ex.t.Equal(start) {
+			return true
+		}
+		// an EXDATE given as a date excludes the instance on that day
+		if ex.date {
+			ey, em, ed := ex.t.Date()
+			sy, sm, sd := start.Date()
+			if ey == sy && em == sm && ed == sd {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func overrideKey(uid string, t time.Time) string {
+	return fmt.Sprintf("%s/%d", uid, t.Unix())
+}
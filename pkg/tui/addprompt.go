@@ -15,6 +15,7 @@ type promptType int
 const (
 	boxInput promptType = iota
 	spanInput
+	splitInput
 )
 
 type inputFields int
@@ -23,8 +24,6 @@ const (
 	nameField inputFields = iota
 	minField
 	maxField
-	cancelButton
-	submitButton
 )
 
 type AddPrompt struct {
@@ -32,6 +31,8 @@ type AddPrompt struct {
 	State        util2.PromptState
 	focusedField inputFields
 	editMode     bool
+	origName     string     // of the edited box
+	origSpan     util2.Span // of the edited or split span
 	inputs       []textinput.Model
 	status       string
 	Result       util2.InputResult
@@ -48,22 +49,23 @@ func AddBox() AddPrompt {
 		t.CharLimit = 30
 		switch i {
 		case 0:
-			t.Prompt = "Name  > "
+			t.Prompt = "Name    > "
 			t.Placeholder = "Box Name"
 			t.Focus()
 			t.PromptStyle = FocusedStyle
 			t.TextStyle = FocusedStyle
 		case 1:
-			t.Prompt = "Min   > "
+			t.Prompt = "Min     > "
 			t.Placeholder = "Weekly Min (e.g. 1h30m)"
 			t.CharLimit = 30
 		case 2:
-			t.Prompt = "Max   > "
+			t.Prompt = "Max     > "
 			t.Placeholder = "Weekly Max (e.g. 4h)"
 			t.CharLimit = 30
 		}
 		m.inputs[i] = t
 	}
+	m.inputs = append(m.inputs, targetInputs(util2.Box{})...)
 	m.State = util2.InUse
 	return m
 }
@@ -71,6 +73,7 @@ func AddBox() AddPrompt {
 func EditBox(box util2.Box) AddPrompt {
 	var m AddPrompt
 	m.editMode = true
+	m.origName = box.Name
 	m.inputs = make([]textinput.Model, 3)
 	var t textinput.Model
 	for i := range m.inputs {
@@ -80,31 +83,54 @@ func EditBox(box util2.Box) AddPrompt {
 		t.CharLimit = 30
 		switch i {
 		case 0:
-			t.Prompt = "Name > "
+			t.Prompt = "Name    > "
 			t.SetValue(box.Name)
 		case 1:
 			t.Focus()
 			t.PromptStyle = FocusedStyle
 			t.TextStyle = FocusedStyle
-			t.Prompt = "Min  > "
+			t.Prompt = "Min     > "
 			t.SetValue(box.MinTime.String())
 			t.CharLimit = 30
 		case 2:
-			t.Prompt = "Max  > "
+			t.Prompt = "Max     > "
 			t.SetValue(box.MaxTime.String())
 			t.CharLimit = 30
 		}
 		m.inputs[i] = t
 	}
+	m.inputs = append(m.inputs, targetInputs(box)...)
 	m.focusedField = minField
 	m.State = util2.InUse
 	return m
 }
 
+// targetPeriods are the periods with an explicit target input in the box
+// prompts, following the name, min and max inputs
+var targetPeriods = []util2.Period{util2.Month, util2.Quarter, util2.Year}
+
+func targetInputs(box util2.Box) []textinput.Model {
+	var inputs []textinput.Model
+	for _, p := range targetPeriods {
+		period := util2.TimePeriod{Period: p}
+		t := textinput.New()
+		t.PromptStyle = NoStyle
+		t.Cursor.Style = NoStyle
+		t.CharLimit = 30
+		t.Prompt = fmt.Sprintf("%-7s > ", period.String())
+		t.Placeholder = "Scaled, or min-max (e.g. 20h-30h)"
+		if target, ok := box.Targets[p]; ok {
+			t.SetValue(target.Min.String() + "-" + target.Max.String())
+		}
+		inputs = append(inputs, t)
+	}
+	return inputs
+}
+
 func AddSpan(boxName string) AddPrompt {
 	var m AddPrompt
 	m.mode = spanInput
-	m.inputs = make([]textinput.Model, 3)
+	m.inputs = make([]textinput.Model, 5)
 	var t textinput.Model
 	for i := range m.inputs {
 		t = textinput.New()
@@ -117,15 +143,23 @@ func AddSpan(boxName string) AddPrompt {
 			t.SetValue(boxName)
 		case 1:
 			t.Prompt = "Start > "
-			t.Placeholder = "Start (e.g. 2023-04-01 17:35:00)"
+			t.Placeholder = "Start (e.g. yesterday 14:30)"
 			t.CharLimit = 30
 			t.Focus()
 			t.PromptStyle = FocusedStyle
 			t.TextStyle = FocusedStyle
 		case 2:
 			t.Prompt = "End   > "
-			t.Placeholder = "End (e.g. 2023-04-01 19:02:30)"
+			t.Placeholder = "End (e.g. yesterday 16:00, now)"
 			t.CharLimit = 30
+		case 3:
+			t.Prompt = "Notes > "
+			t.Placeholder = "Notes (optional)"
+			t.CharLimit = 120
+		case 4:
+			t.Prompt = "Tags  > "
+			t.Placeholder = "Tags (e.g. scales, theory)"
+			t.CharLimit = 60
 		}
 		m.inputs[i] = t
 	}
@@ -134,6 +168,38 @@ func AddSpan(boxName string) AddPrompt {
 	return m
 }
 
+// EditSpan prompts for a span's box, times, notes and tags, with its times
+// shown in the zone of cal
+func EditSpan(span util2.Span, cal util2.Calendar) AddPrompt {
+	m := AddSpan(span.Box)
+	m.editMode = true
+	m.origSpan = span
+	m.inputs[1].SetValue(cal.In(span.Start).Format(inputTimeFormLong))
+	m.inputs[2].SetValue(cal.In(span.End).Format(inputTimeFormLong))
+	m.inputs[3].SetValue(span.Notes)
+	m.inputs[4].SetValue(strings.Join(span.Tags, ", "))
+	return m
+}
+
+// SplitSpan prompts for the time to split a span at, its middle by default
+func SplitSpan(span util2.Span, cal util2.Calendar) AddPrompt {
+	var m AddPrompt
+	m.mode = splitInput
+	m.origSpan = span
+	t := textinput.New()
+	t.PromptStyle = FocusedStyle
+	t.TextStyle = FocusedStyle
+	t.Cursor.Style = NoStyle
+	t.CharLimit = 30
+	t.Prompt = "At    > "
+	t.Placeholder = "Time within the span"
+	t.SetValue(cal.In(span.Start.Add(span.Duration() / 2)).Format(inputTimeFormLong))
+	t.Focus()
+	m.inputs = []textinput.Model{t}
+	m.State = util2.InUse
+	return m
+}
+
 func (m AddPrompt) Init() tea.Cmd {
 	return nil
 }
@@ -142,10 +208,6 @@ func (m AddPrompt) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	var cmds []tea.Cmd
 	var cmd tea.Cmd
 	var checkInput bool
-	var baseField int
-	if m.editMode {
-		baseField = 1
-	}
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
 		switch msg.String() {
@@ -153,20 +215,20 @@ func (m AddPrompt) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			return m, tea.Quit
 		case "tab":
 			m.focusedField++
-			if m.focusedField > submitButton {
-				m.focusedField = inputFields(baseField)
+			if m.focusedField > m.submitButton() {
+				m.focusedField = nameField
 			}
 		case "shift+tab":
 			m.focusedField--
-			if int(m.focusedField) < baseField {
-				m.focusedField = submitButton
+			if m.focusedField < nameField {
+				m.focusedField = m.submitButton()
 			}
 		case "enter":
 			switch m.focusedField {
-			case cancelButton:
+			case m.cancelButton():
 				m.State = util2.WasCancelled
 				return m, nil
-			case submitButton:
+			case m.submitButton():
 				checkInput = true
 			}
 		}
@@ -177,6 +239,7 @@ func (m AddPrompt) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			box, err := m.validateBoxInputs()
 			if err != nil {
 				m.status = err.Error()
+				return m, nil
 			}
 			m.Result = util2.NewInputResultBox(box)
 			m.State = util2.HasResult
@@ -185,6 +248,16 @@ func (m AddPrompt) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			span, err := m.validateSpanInputs()
 			if err != nil {
 				m.status = err.Error()
+				return m, nil
+			}
+			m.Result = util2.NewInputResultSpan(span)
+			m.State = util2.HasResult
+			return m, nil
+		case splitInput:
+			span, err := m.validateSplitInputs()
+			if err != nil {
+				m.status = err.Error()
+				return m, nil
 			}
 			m.Result = util2.NewInputResultSpan(span)
 			m.State = util2.HasResult
@@ -201,6 +274,16 @@ func (m AddPrompt) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return m, tea.Batch(cmds...)
 }
 
+// cancelButton and submitButton follow the inputs, whose number depends on
+// the prompt type
+func (m AddPrompt) cancelButton() inputFields {
+	return inputFields(len(m.inputs))
+}
+
+func (m AddPrompt) submitButton() inputFields {
+	return inputFields(len(m.inputs) + 1)
+}
+
 func (m AddPrompt) View() string {
 	return m.inputView()
 }
@@ -216,7 +299,13 @@ func (m AddPrompt) inputView() string {
 			title = "New Box"
 		}
 	case spanInput:
-		title = "New Timespan"
+		if m.editMode {
+			title = "Edit Timespan"
+		} else {
+			title = "New Timespan"
+		}
+	case splitInput:
+		title = "Split Timespan"
 	}
 	b.WriteString(InputTitleStyle.Render(title) + "\n")
 	for i := range m.inputs {
@@ -280,9 +369,23 @@ func (m AddPrompt) validateBoxInputs() (util2.Box, error) {
 	name := m.inputs[0].Value()
 	min := m.inputs[1].Value()
 	max := m.inputs[2].Value()
+	targets := make(map[util2.Period]string)
+	for i, p := range targetPeriods {
+		if value := strings.TrimSpace(m.inputs[3+i].Value()); value != "" {
+			targets[p] = value
+		}
+	}
+	// a box with explicit targets doesn't need weekly times
+	if len(targets) > 0 && min == "" && max == "" {
+		min, max = "0s", "0s"
+	}
 	if name == "" || min == "" || max == "" {
 		return box, fmt.Errorf("empty fields")
 	}
+	name, err := util2.NormalizeBoxPath(name)
+	if err != nil {
+		return box, err
+	}
 	minTime, err := time.ParseDuration(min)
 	if err != nil {
 		return box, fmt.Errorf("invalid duration: %v", err)
@@ -291,7 +394,22 @@ func (m AddPrompt) validateBoxInputs() (util2.Box, error) {
 	if err != nil {
 		return box, fmt.Errorf("invalid duration: %v", err)
 	}
+	if minTime > maxTime {
+		return box, fmt.Errorf("min time is greater than max time")
+	}
 	box = util2.Box{Name: name, MinTime: minTime, MaxTime: maxTime}
+	for _, p := range targetPeriods {
+		value, ok := targets[p]
+		if !ok {
+			continue
+		}
+		period := util2.TimePeriod{Period: p}
+		_, target, err := util2.ParseTarget(period.String() + "=" + value)
+		if err != nil {
+			return box, fmt.Errorf("invalid %s target: %v", strings.ToLower(period.String()), err)
+		}
+		box.SetTarget(p, target)
+	}
 	return box, nil
 }
 
@@ -305,16 +423,43 @@ func (m AddPrompt) validateSpanInputs() (util2.Span, error) {
 	}
 	minTime, err := util2.ParseTime(min)
 	if err != nil {
-		return span, fmt.Errorf("invalid duration: %v", err)
+		return span, fmt.Errorf("invalid start: %v", err)
 	}
 	maxTime, err := util2.ParseTime(max)
 	if err != nil {
-		return span, fmt.Errorf("invalid duration: %v", err)
+		return span, fmt.Errorf("invalid end: %v", err)
+	}
+	if m.editMode {
+		// an edited span keeps the zone it was recorded in
+		loc := m.origSpan.Start.Location()
+		minTime, maxTime = minTime.In(loc), maxTime.In(loc)
 	}
 	span = util2.Span{
+		ID:    m.origSpan.ID,
 		Start: minTime,
 		End:   maxTime,
 		Box:   name,
+		Notes: strings.TrimSpace(m.inputs[3].Value()),
+		Tags:  util2.ParseTags([]string{m.inputs[4].Value()}),
+	}
+	return span, nil
+}
+
+// validateSplitInputs returns the first part of the split span, which ends
+// at the split time
+func (m AddPrompt) validateSplitInputs() (util2.Span, error) {
+	span := m.origSpan
+	value := m.inputs[0].Value()
+	if value == "" {
+		return span, fmt.Errorf("empty fields")
+	}
+	at, err := util2.ParseTime(value)
+	if err != nil {
+		return span, fmt.Errorf("invalid time: %v", err)
+	}
+	if !at.After(span.Start) || !at.Before(span.End) {
+		return span, fmt.Errorf("the time must be within the span")
 	}
+	span.End = at.In(span.End.Location())
 	return span, nil
 }
@@ -0,0 +1,109 @@
+package tui
+
+import (
+	util2 "github.com/aldernero/timebox/pkg/util"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"strings"
+)
+
+// pickerPageSize is the number of boxes shown at once
+const pickerPageSize = 8
+
+// BoxPicker picks a box from a list that is filtered by typing
+type BoxPicker struct {
+	title  string
+	names  []string
+	filter textinput.Model
+	cursor int
+	State  util2.PromptState
+	Choice string
+}
+
+func NewBoxPicker(title string, names []string) BoxPicker {
+	t := textinput.New()
+	t.Prompt = "Filter > "
+	t.Placeholder = "Box name"
+	t.PromptStyle = FocusedStyle
+	t.TextStyle = FocusedStyle
+	t.CharLimit = 30
+	t.Focus()
+	return BoxPicker{title: title, names: names, filter: t, State: util2.InUse}
+}
+
+func (m BoxPicker) Init() tea.Cmd {
+	return nil
+}
+
+func (m BoxPicker) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if msg, ok := msg.(tea.KeyMsg); ok {
+		switch msg.String() {
+		case "ctrl+c":
+			return m, tea.Quit
+		case "esc":
+			m.State = util2.WasCancelled
+			return m, nil
+		case "up", "ctrl+p":
+			if m.cursor > 0 {
+				m.cursor--
+			}
+			return m, nil
+		case "down", "ctrl+n":
+			if m.cursor < len(m.matches())-1 {
+				m.cursor++
+			}
+			return m, nil
+		case "enter":
+			matches := m.matches()
+			if len(matches) == 0 {
+				return m, nil
+			}
+			m.Choice = matches[m.cursor]
+			m.State = util2.HasResult
+			return m, nil
+		}
+	}
+	var cmd tea.Cmd
+	m.filter, cmd = m.filter.Update(msg)
+	if n := len(m.matches()); m.cursor >= n && n > 0 {
+		m.cursor = n - 1
+	}
+	return m, cmd
+}
+
+// matches returns the names containing the filter, ignoring case
+func (m BoxPicker) matches() []string {
+	q := strings.ToLower(strings.TrimSpace(m.filter.Value()))
+	var result []string
+	for _, name := range m.names {
+		if strings.Contains(strings.ToLower(name), q) {
+			result = append(result, name)
+		}
+	}
+	return result
+}
+
+func (m BoxPicker) View() string {
+	var b strings.Builder
+	b.WriteString(InputTitleStyle.Render(m.title) + "\n")
+	b.WriteString(m.filter.View() + "\n\n")
+	matches := m.matches()
+	if len(matches) == 0 {
+		b.WriteString(ErrStyle("no matching boxes"))
+	}
+	// keep the cursor on the shown page
+	first := 0
+	if m.cursor >= pickerPageSize {
+		first = m.cursor - pickerPageSize + 1
+	}
+	for i := first; i < len(matches) && i < first+pickerPageSize; i++ {
+		if i == m.cursor {
+			b.WriteString(FocusedStyle.Render("> " + matches[i]))
+		} else {
+			b.WriteString(BlurredStyle.Render("  " + matches[i]))
+		}
+		b.WriteRune('\n')
+	}
+	b.WriteString("\n" + BlurredStyle.Render("↑/↓ select, Enter pick, Esc cancel"))
+	return PromptStyle.Render(InputStyle(b.String()))
+}
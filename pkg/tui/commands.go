@@ -1,6 +1,9 @@
 package tui
 
-import tea "github.com/charmbracelet/bubbletea"
+import (
+	tea "github.com/charmbracelet/bubbletea"
+	"time"
+)
 
 type cancelMsg struct {
 }
@@ -9,6 +12,15 @@ type reloadWithStatusMsg struct {
 	status string
 }
 
+type timerTickMsg struct {
+}
+
+func timerTickCmd() tea.Cmd {
+	return tea.Tick(time.Second, func(time.Time) tea.Msg {
+		return timerTickMsg{}
+	})
+}
+
 func reloadWithStatusCmd(status string) tea.Cmd {
 	return func() tea.Msg {
 		return reloadWithStatusMsg{status}
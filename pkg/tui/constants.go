@@ -7,6 +7,8 @@ const (
 	nav           // read
 	edit
 	del
+	split
+	move
 )
 
 type viewMode int
@@ -19,16 +21,26 @@ const (
 
 // Common shortcuts
 var (
-	addShortcut        = NewShortcut("a", "Add")
-	delShortcut        = NewShortcut("d", "Delete")
-	editShortcut       = NewShortcut("e", "Edit")
-	deleteShortcut     = NewShortcut("d", "Delete")
-	quitShortcut       = NewShortcut("q", "Quit")
-	periodShortcut     = NewShortcut("Tab", "Period")
-	enterShortcut      = NewShortcut("Enter", "SpansSets")
-	backShortcut       = NewShortcut("Esc", "Back")
-	boxSummaryShortcut = NewShortcut("b", "Boxes")
-	timelineShortcut   = NewShortcut("t", "Timeline")
+	addShortcut          = NewShortcut("a", "Add")
+	delShortcut          = NewShortcut("d", "Delete")
+	editShortcut         = NewShortcut("e", "Edit")
+	deleteShortcut       = NewShortcut("d", "Delete")
+	quitShortcut         = NewShortcut("q", "Quit")
+	periodShortcut       = NewShortcut("Tab", "Period")
+	historyShortcut      = NewShortcut("←/→", "Prev/Next")
+	prorateShortcut      = NewShortcut("p", "Pro-rate")
+	enterShortcut        = NewShortcut("Enter", "SpansSets")
+	expandShortcut       = NewShortcut("Space", "Expand")
+	backShortcut         = NewShortcut("Esc", "Back")
+	boxSummaryShortcut   = NewShortcut("b", "Boxes")
+	timelineShortcut     = NewShortcut("t", "Timeline")
+	timerShortcut        = NewShortcut("s", "Start/Stop")
+	archiveShortcut      = NewShortcut("x", "Archive")
+	showArchivedShortcut = NewShortcut("h", "Archived")
+	splitShortcut        = NewShortcut("c", "Split")
+	moveShortcut         = NewShortcut("m", "Move")
+	undoShortcut         = NewShortcut("u", "Undo")
+	redoShortcut         = NewShortcut("Ctrl+r", "Redo")
 )
 
 func printCrudState(s crudState) string {
@@ -41,6 +53,10 @@ func printCrudState(s crudState) string {
 		return "Edit"
 	case del:
 		return "Delete"
+	case split:
+		return "Split"
+	case move:
+		return "Move"
 	default:
 		return "Unknown"
 	}
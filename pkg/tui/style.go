@@ -27,6 +27,8 @@ var TableStyle = lipgloss.NewStyle().
 	Foreground(lipgloss.Color(ColorTableText)).
 	BorderForeground(lipgloss.Color(ColorTableBorder)).
 	Align(lipgloss.Right)
+var TreeColumnStyle = lipgloss.NewStyle().
+	Align(lipgloss.Left)
 var InputTitleStyle = lipgloss.NewStyle().
 	Width(defaultInputWidth).
 	Foreground(lipgloss.Color(ColorTextLightGray)).
@@ -50,6 +52,9 @@ var DeleteStyle = lipgloss.NewStyle().
 var PromptStyle = lipgloss.NewStyle().
 	Width(UIWidth).
 	Align(lipgloss.Center)
+var TimerStyle = lipgloss.NewStyle().
+	Foreground(lipgloss.Color(ColorLogo)).
+	Padding(0, 1)
 var ErrStyle = lipgloss.NewStyle().Foreground(lipgloss.Color(ColorError)).Render
 var NoStyle = lipgloss.NewStyle()
 var FocusedStyle = lipgloss.NewStyle().Foreground(lipgloss.Color(ColorPromptBorder))
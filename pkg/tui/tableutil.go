@@ -3,56 +3,86 @@ package tui
 import (
 	util2 "github.com/aldernero/timebox/pkg/util"
 	"github.com/evertras/bubble-table/table"
+	"strings"
 	"time"
 )
 
 const (
+	columnKeyID     = "id"
 	columnKeyBox    = "box"
+	columnKeyPath   = "path"
 	columnKeyMin    = "min"
 	columnKeyMax    = "max"
 	columnKeyUse    = "use"
+	columnKeyStatus = "status"
 	columnKeyStart  = "start"
 	columnKeyEnd    = "end"
 	columnKeyDur    = "dur"
+	columnKeyTags   = "tags"
+	columnKeyNotes  = "notes"
 	columnWidthBox  = 24
 	columnWidthTime = 20
 	columnWidthDur  = 12
 )
 
-func makeBoxSummaryRow(box string, min, max, use time.Duration) table.Row {
+func makeBoxSummaryRow(tb util2.TimeBox, usage util2.BoxUsage, expanded bool) table.Row {
+	box := usage.Box
+	marker := "  "
+	if tb.HasChildren(box.Name) {
+		if expanded {
+			marker = "▾ "
+		} else {
+			marker = "▸ "
+		}
+	}
+	label := strings.Repeat("  ", box.Depth()) + marker + box.Leaf()
+	if box.Archived() {
+		label += " (archived)"
+	}
 	return table.NewRow(table.RowData{
-		columnKeyBox: box,
-		columnKeyMin: min,
-		columnKeyMax: max,
-		columnKeyUse: util2.DurationParser(use),
+		columnKeyBox:    label,
+		columnKeyPath:   box.Name,
+		columnKeyMin:    usage.Min,
+		columnKeyMax:    usage.Max,
+		columnKeyUse:    util2.DurationParser(usage.Used),
+		columnKeyStatus: usage.Status.String(),
 	})
 }
 
-func makeTimelineRow(box string, start time.Time, end time.Time) table.Row {
+func makeTimelineRow(cal util2.Calendar, span util2.Span) table.Row {
 	return table.NewRow(table.RowData{
-		columnKeyBox:   box,
-		columnKeyStart: start.Format(time.DateTime),
-		columnKeyEnd:   end.Format(time.DateTime),
-		columnKeyDur:   util2.DurationParser(end.Sub(start)),
+		columnKeyID:    span.ID,
+		columnKeyBox:   span.Box,
+		columnKeyStart: cal.In(span.Start).Format(time.DateTime),
+		columnKeyEnd:   cal.In(span.End).Format(time.DateTime),
+		columnKeyDur:   util2.DurationParser(span.Duration()),
+		columnKeyTags:  span.TagString(),
+		columnKeyNotes: span.Notes,
 	})
 }
 
-func makeBoxSummaryTable(tb util2.TimeBox, p util2.Period) table.Model {
-	boxes := tb.Boxes
+// makeBoxSummaryTable lists the boxes as a tree, sub-boxes are only shown
+// when their parent is expanded. The used time of a box includes the time
+// used by its sub-boxes.
+func makeBoxSummaryTable(tb util2.TimeBox, p util2.Period, timespan util2.Span, prorate, showArchived bool, expanded map[string]bool) table.Model {
 	var rows []table.Row
-	timespan := util2.PeriodSoFar(p, time.January)
-	for _, val := range tb.Names {
-		box := boxes[val]
-		minTime, maxTime := box.ScaledTimes(p)
-		spans := tb.GetSpansForBox(val, timespan)
-		usedTime := spans.Duration()
-		rows = append(rows, makeBoxSummaryRow(val, minTime, maxTime, usedTime))
+	report := tb.Report(p, timespan, prorate)
+	if !showArchived {
+		report = report.WithoutArchived()
+	}
+	for _, usage := range report.Boxes {
+		name := usage.Box.Name
+		if !isBoxVisible(name, expanded) {
+			continue
+		}
+		rows = append(rows, makeBoxSummaryRow(tb, usage, expanded[name]))
 	}
 	return table.New([]table.Column{
-		table.NewFlexColumn(columnKeyBox, "Box", 2),
+		table.NewFlexColumn(columnKeyBox, "Box", 2).WithStyle(TreeColumnStyle),
 		table.NewFlexColumn(columnKeyMin, "Min", 1),
 		table.NewFlexColumn(columnKeyMax, "Max", 1),
 		table.NewFlexColumn(columnKeyUse, "Used", 1),
+		table.NewFlexColumn(columnKeyStatus, "Status", 1),
 	}).WithRows(rows).
 		BorderRounded().
 		WithBaseStyle(TableStyle).
@@ -61,18 +91,29 @@ func makeBoxSummaryTable(tb util2.TimeBox, p util2.Period) table.Model {
 		Focused(true)
 }
 
-func makeBoxViewTable(tb util2.TimeBox, boxName string, p util2.Period) table.Model {
+// isBoxVisible reports whether all ancestors of a box are expanded
+func isBoxVisible(name string, expanded map[string]bool) bool {
+	for parent := util2.ParentBoxName(name); parent != ""; parent = util2.ParentBoxName(parent) {
+		if !expanded[parent] {
+			return false
+		}
+	}
+	return true
+}
+
+func makeBoxViewTable(tb util2.TimeBox, boxName string, timespan util2.Span) table.Model {
 	var rows []table.Row
-	timespan := util2.PeriodSoFar(p, time.January)
-	spans := tb.GetSpansForBox(boxName, timespan)
+	spans := tb.GetSpansForBoxTree(boxName, timespan)
 	for _, val := range spans.Spans {
-		rows = append(rows, makeTimelineRow(boxName, val.Start, val.End))
+		rows = append(rows, makeTimelineRow(tb.Calendar, val))
 	}
 	return table.New([]table.Column{
 		table.NewFlexColumn(columnKeyBox, "Box", 2),
 		table.NewColumn(columnKeyStart, "Start", columnWidthTime),
 		table.NewColumn(columnKeyEnd, "End", columnWidthTime),
 		table.NewFlexColumn(columnKeyDur, "Duration", 1),
+		table.NewFlexColumn(columnKeyTags, "Tags", 1),
+		table.NewFlexColumn(columnKeyNotes, "Notes", 1),
 	}).WithRows(rows).
 		BorderRounded().
 		WithBaseStyle(TableStyle).
@@ -81,18 +122,19 @@ func makeBoxViewTable(tb util2.TimeBox, boxName string, p util2.Period) table.Mo
 		Focused(true)
 }
 
-func makeTimelineTable(tb util2.TimeBox, p util2.Period) table.Model {
+func makeTimelineTable(tb util2.TimeBox, timespan util2.Span) table.Model {
 	var rows []table.Row
-	timespan := util2.PeriodSoFar(p, time.January)
 	spans := tb.GetSpansForTimespan(timespan)
 	for _, val := range spans.Spans {
-		rows = append(rows, makeTimelineRow(val.Box, val.Start, val.End))
+		rows = append(rows, makeTimelineRow(tb.Calendar, val))
 	}
 	return table.New([]table.Column{
 		table.NewFlexColumn(columnKeyBox, "Box", columnWidthBox),
 		table.NewFlexColumn(columnKeyStart, "Start", columnWidthDur),
 		table.NewFlexColumn(columnKeyEnd, "End", columnWidthDur),
 		table.NewFlexColumn(columnKeyDur, "Duration", columnWidthDur),
+		table.NewFlexColumn(columnKeyTags, "Tags", columnWidthDur),
+		table.NewFlexColumn(columnKeyNotes, "Notes", columnWidthDur),
 	}).WithRows(rows).
 		BorderRounded().
 		WithBaseStyle(TableStyle).
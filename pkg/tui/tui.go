@@ -7,7 +7,6 @@ import (
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/evertras/bubble-table/table"
-	"log"
 	"os"
 	"time"
 )
@@ -24,16 +23,33 @@ type Model struct {
 	tbl       table.Model
 	addPrompt AddPrompt
 	delPrompt DeletePrompt
+	picker    BoxPicker
+	status    string
+	expanded  map[string]bool
+	// anchor is a time within the shown period, the zero time shows the
+	// current period up to now
+	anchor time.Time
+	// prorate scales the targets of the current period to the elapsed part
+	prorate bool
+	// showArchived also shows the boxes archived before the shown period
+	showArchived bool
+	// timer is the running timer as of the last reload, the timer tick
+	// only redraws it
+	timer        util2.Timer
+	timerRunning bool
 }
 
 func New(tb util2.TimeBox) Model {
-	return Model{
-		state:  nav,
-		view:   boxSummary,
-		period: util2.TimePeriod{Period: util2.Week},
-		tb:     tb,
-		tbl:    makeBoxSummaryTable(tb, util2.Week),
+	m := Model{
+		state:    nav,
+		view:     boxSummary,
+		period:   util2.TimePeriod{Period: util2.Week},
+		tb:       tb,
+		expanded: make(map[string]bool),
 	}
+	m.tbl = m.makeTable()
+	m.status = m.refreshTimer()
+	return m
 }
 
 func StartTea(tb util2.TimeBox) {
@@ -45,10 +61,13 @@ func StartTea(tb util2.TimeBox) {
 }
 
 func (m Model) Init() tea.Cmd {
-	return nil
+	return timerTickCmd()
 }
 
 func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if _, ok := msg.(timerTickMsg); ok {
+		return m, timerTickCmd()
+	}
 	// CRUD state machine
 	switch m.state {
 	case add: // create
@@ -59,6 +78,10 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		return m.updateEdit(msg)
 	case del: // delete
 		return m.updateDel(msg)
+	case split:
+		return m.updateSplit(msg)
+	case move:
+		return m.updateMove(msg)
 	default:
 		return m, nil
 	}
@@ -74,6 +97,10 @@ func (m Model) View() string {
 		return m.addPrompt.View()
 	case del: // delete
 		return m.delPrompt.View()
+	case split:
+		return m.addPrompt.View()
+	case move:
+		return m.picker.View()
 	default:
 		return "unknown"
 	}
@@ -96,23 +123,26 @@ func (m Model) updateAdd(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case util2.HasResult:
 		switch m.view {
 		case boxSummary:
-			res := m.addPrompt.Result
-			err := m.tb.AddBox(res.Box())
+			box := m.addPrompt.Result.Box()
+			m.state = nav
+			err := m.tb.AddBox(box)
 			if err != nil {
-				log.Fatal(err)
+				return m, reloadWithStatusCmd(fmt.Sprintf("Can't add box: %v", err))
+			}
+			m.tb = m.tb.Refresh()
+			if parent := box.Parent(); parent != "" {
+				m.expanded[parent] = true
 			}
-			m.tb = util2.TimeBoxFromDB(m.tb.Fname)
-			m.tbl = makeBoxSummaryTable(m.tb, util2.Week)
+			m.tbl = m.makeTable()
+		case boxView, timeline:
+			span := m.addPrompt.Result.Span()
 			m.state = nav
-		case boxView:
-			res := m.addPrompt.Result
-			err := m.tb.AddSpan(res.Span(), m.currScope)
+			err := m.tb.AddSpan(span, span.Box)
 			if err != nil {
-				log.Fatal(err)
+				return m, reloadWithStatusCmd(fmt.Sprintf("Can't add span: %v", err))
 			}
-			m.tb = util2.TimeBoxFromDB(m.tb.Fname)
-			m.tbl = makeBoxViewTable(m.tb, m.currScope, util2.Week)
-			m.state = nav
+			m.tb = m.tb.Refresh()
+			return m, reloadWithStatusCmd(fmt.Sprintf("Added span to %s", span.Box))
 		}
 	}
 	return m, cmd
@@ -122,14 +152,11 @@ func (m Model) updateNav(msg tea.Msg) (tea.Model, tea.Cmd) {
 	var cmd tea.Cmd
 	switch msg := msg.(type) {
 	case reloadWithStatusMsg:
-		switch m.view {
-		case boxSummary:
-			m.tbl = makeBoxSummaryTable(m.tb, m.period.Period)
-		case boxView:
-			m.tbl = makeBoxViewTable(m.tb, m.currScope, m.period.Period)
-		case timeline:
-			m.tbl = makeTimelineTable(m.tb, m.period.Period)
+		m.status = msg.status
+		if err := m.refreshTimer(); err != "" {
+			m.status = err
 		}
+		m.tbl = m.makeTable()
 		return m, nil
 	case tea.KeyMsg:
 		switch msg.String() {
@@ -142,12 +169,12 @@ func (m Model) updateNav(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.view = boxView
 				boxName := m.getSelectedBoxName()
 				m.currScope = boxName
-				m.tbl = makeBoxViewTable(m.tb, boxName, util2.Week)
+				m.tbl = m.makeTable()
 			}
 		case "esc":
 			if m.view == boxView || m.view == timeline {
 				m.view = boxSummary
-				m.tbl = makeBoxSummaryTable(m.tb, util2.Week)
+				m.tbl = m.makeTable()
 			}
 		case "tab":
 			m.period.Next()
@@ -157,6 +184,12 @@ func (m Model) updateNav(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.period.Previous()
 			cmd = reloadWithStatusCmd(fmt.Sprintf("Period: %s", m.period.String()))
 			return m, cmd
+		case "left":
+			m.shiftPeriod(-1)
+			return m, reloadWithStatusCmd("")
+		case "right":
+			m.shiftPeriod(1)
+			return m, reloadWithStatusCmd("")
 		case "a":
 			m.state = add
 			switch m.view {
@@ -173,20 +206,91 @@ func (m Model) updateNav(msg tea.Msg) (tea.Model, tea.Cmd) {
 			case boxSummary:
 				boxName := m.getSelectedBoxName()
 				m.delPrompt = NewDeletePrompt(fmt.Sprintf("Box: %s", boxName))
-			case boxView:
+			case boxView, timeline:
 				span := m.getSelectedSpan()
 				m.delPrompt = NewDeletePrompt(fmt.Sprintf("Span: %s", span.String()))
 			}
 		case "b":
 			m.view = boxSummary
-			m.tbl = makeBoxSummaryTable(m.tb, util2.Week)
+			m.tbl = m.makeTable()
 		case "t":
 			m.view = timeline
-			m.tbl = makeTimelineTable(m.tb, util2.Week)
+			m.tbl = m.makeTable()
+		case "s":
+			return m, m.toggleTimer()
+		case "u":
+			return m, m.undo()
+		case "ctrl+r":
+			return m, m.redo()
+		case "p":
+			if m.view == boxSummary {
+				m.prorate = !m.prorate
+				return m, reloadWithStatusCmd("")
+			}
+		case "x":
+			if m.view == boxSummary {
+				return m, m.toggleArchived()
+			}
+		case "h":
+			if m.view == boxSummary {
+				m.showArchived = !m.showArchived
+				if m.showArchived {
+					return m, reloadWithStatusCmd("Showing archived boxes")
+				}
+				return m, reloadWithStatusCmd("Hiding archived boxes")
+			}
+		case " ":
+			if m.view == boxSummary {
+				boxName := m.getSelectedBoxName()
+				if m.tb.HasChildren(boxName) {
+					m.expanded[boxName] = !m.expanded[boxName]
+					row := m.tbl.GetHighlightedRowIndex()
+					m.tbl = m.makeTable().WithHighlightedRow(row)
+				}
+				return m, nil
+			}
 		case "e":
+			if m.view == boxSummary {
+				m.state = edit
+				box := m.getSelectedBox()
+				m.addPrompt = EditBox(box)
+				return m, nil
+			}
+			span := m.getSelectedSpan()
+			if span.ID == 0 {
+				return m, reloadWithStatusCmd("Select a span to edit")
+			}
 			m.state = edit
-			box := m.getSelectedBox()
-			m.addPrompt = EditBox(box)
+			m.addPrompt = EditSpan(span, m.tb.Calendar)
+			return m, nil
+		case "c":
+			if m.view == boxSummary {
+				break
+			}
+			span := m.getSelectedSpan()
+			if span.ID == 0 {
+				return m, reloadWithStatusCmd("Select a span to split")
+			}
+			m.state = split
+			m.addPrompt = SplitSpan(span, m.tb.Calendar)
+			return m, nil
+		case "m":
+			if m.view == boxSummary {
+				break
+			}
+			span := m.getSelectedSpan()
+			if span.ID == 0 {
+				return m, reloadWithStatusCmd("Select a span to move")
+			}
+			var names []string
+			for _, name := range m.tb.ActiveNames() {
+				if name != span.Box {
+					names = append(names, name)
+				}
+			}
+			m.state = move
+			m.picker = NewBoxPicker("Move Timespan", names)
+			return m, nil
 		}
 	}
 	m.tbl, cmd = m.tbl.Update(msg)
@@ -209,14 +313,90 @@ func (m Model) updateEdit(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case util2.WasCancelled:
 		m.state = nav
 	case util2.HasResult:
-		res := m.addPrompt.Result
-		err := m.tb.UpdateBox(res.Box())
+		if m.addPrompt.mode == spanInput {
+			return m.updateSpan(cmd)
+		}
+		box := m.addPrompt.Result.Box()
+		m.state = nav
+		if oldName := m.addPrompt.origName; box.Name != oldName {
+			err := m.tb.RenameBox(oldName, box.Name)
+			if err != nil {
+				return m, reloadWithStatusCmd(fmt.Sprintf("Can't rename box: %v", err))
+			}
+			m.expanded[box.Name] = m.expanded[oldName]
+			m.tb = m.tb.Refresh()
+		}
+		err := m.tb.UpdateBox(box)
+		if err != nil {
+			// a rename before is kept
+			m.tb = m.tb.Refresh()
+			return m, reloadWithStatusCmd(fmt.Sprintf("Can't update box: %v", err))
+		}
+		m.tb = m.tb.Refresh()
+		m.tbl = m.makeTable()
+	}
+	return m, cmd
+}
+
+// updateSpan saves the result of the span edit prompt, which stays open
+// when the span can't be saved
+func (m Model) updateSpan(cmd tea.Cmd) (tea.Model, tea.Cmd) {
+	span := m.addPrompt.Result.Span()
+	err := m.tb.UpdateSpan(span)
+	if err != nil {
+		m.addPrompt.State = util2.InUse
+		m.addPrompt.status = fmt.Sprintf("Can't update span: %v", err)
+		return m, cmd
+	}
+	m.state = nav
+	m.tb = m.tb.Refresh()
+	return m, reloadWithStatusCmd(fmt.Sprintf("Updated span in %s", span.Box))
+}
+
+func (m Model) updateSplit(msg tea.Msg) (tea.Model, tea.Cmd) {
+	var cmd tea.Cmd
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "esc":
+			m.state = nav
+		}
+	}
+	mdl, cmd := m.addPrompt.Update(msg)
+	m.addPrompt = mdl.(AddPrompt)
+	switch m.addPrompt.State {
+	case util2.WasCancelled:
+		m.state = nav
+	case util2.HasResult:
+		at := m.addPrompt.Result.Span().End
+		_, err := m.tb.SplitSpan(m.addPrompt.origSpan, at)
 		if err != nil {
-			log.Fatal(err)
+			m.addPrompt.State = util2.InUse
+			m.addPrompt.status = fmt.Sprintf("Can't split span: %v", err)
+			return m, cmd
 		}
-		m.tb = util2.TimeBoxFromDB(m.tb.Fname)
-		m.tbl = makeBoxSummaryTable(m.tb, util2.Week)
 		m.state = nav
+		m.tb = m.tb.Refresh()
+		return m, reloadWithStatusCmd(fmt.Sprintf("Split span at %s", m.tb.Calendar.In(at).Format(time.DateTime)))
+	}
+	return m, cmd
+}
+
+func (m Model) updateMove(msg tea.Msg) (tea.Model, tea.Cmd) {
+	mdl, cmd := m.picker.Update(msg)
+	m.picker = mdl.(BoxPicker)
+	switch m.picker.State {
+	case util2.WasCancelled:
+		m.state = nav
+	case util2.HasResult:
+		m.state = nav
+		span := m.getSelectedSpan()
+		err := m.tb.MoveSpan(span, m.picker.Choice)
+		if err != nil {
+			return m, reloadWithStatusCmd(fmt.Sprintf("Can't move span: %v", err))
+		}
+		m.tb = m.tb.Refresh()
+		return m, reloadWithStatusCmd(fmt.Sprintf("Moved span to %s", m.picker.Choice))
 	}
 	return m, cmd
 }
@@ -239,26 +419,29 @@ func (m Model) updateDel(msg tea.Msg) (tea.Model, tea.Cmd) {
 				boxName := m.getSelectedBoxName()
 				err := m.tb.DeleteBox(boxName)
 				if err != nil {
-					log.Fatal(err)
+					m.state = nav
+					return m, reloadWithStatusCmd(fmt.Sprintf("Can't delete box: %v, archive it with x instead", err))
 				}
-				m.tb = util2.TimeBoxFromDB(m.tb.Fname)
-				m.tbl = makeBoxSummaryTable(m.tb, m.period.Period)
+				m.tb = m.tb.Refresh()
+				m.tbl = m.makeTable()
 			case boxView:
 				span := m.getSelectedSpan()
-				err := m.tb.DeleteSpan(span)
+				err := m.tb.DeleteSpanByID(span.ID)
 				if err != nil {
-					log.Fatal(err)
+					m.state = nav
+					return m, reloadWithStatusCmd(fmt.Sprintf("Can't delete span: %v", err))
 				}
-				m.tb = util2.TimeBoxFromDB(m.tb.Fname)
-				m.tbl = makeBoxSummaryTable(m.tb, m.period.Period)
+				m.tb = m.tb.Refresh()
+				m.tbl = m.makeTable()
 			case timeline:
 				span := m.getSelectedSpan()
-				err := m.tb.DeleteSpan(span)
+				err := m.tb.DeleteSpanByID(span.ID)
 				if err != nil {
-					log.Fatal(err)
+					m.state = nav
+					return m, reloadWithStatusCmd(fmt.Sprintf("Can't delete span: %v", err))
 				}
-				m.tb = util2.TimeBoxFromDB(m.tb.Fname)
-				m.tbl = makeTimelineTable(m.tb, m.period.Period)
+				m.tb = m.tb.Refresh()
+				m.tbl = m.makeTable()
 			}
 		}
 		m.state = nav
@@ -266,12 +449,138 @@ func (m Model) updateDel(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return m, cmd
 }
 
+// timespan returns the full bounds of the shown period
+func (m Model) timespan() util2.Span {
+	anchor := m.anchor
+	if anchor.IsZero() {
+		anchor = time.Now()
+	}
+	return util2.PeriodContaining(m.period.Period, anchor, m.tb.Calendar)
+}
+
+// shiftPeriod moves the shown period back or forth, but not past the current
+// one
+func (m *Model) shiftPeriod(offset int) {
+	start := util2.ShiftPeriod(m.period.Period, m.timespan().Start, offset)
+	if !start.Before(util2.PeriodStart(m.period.Period, time.Now(), m.tb.Calendar)) {
+		m.anchor = time.Time{}
+		return
+	}
+	m.anchor = start
+}
+
+func (m Model) periodLabel() string {
+	label := util2.PeriodLabel(m.period.Period, m.timespan().Start, m.tb.Calendar)
+	if m.prorate && m.view == boxSummary {
+		label += " (pro-rated)"
+	}
+	return label
+}
+
+// makeTable builds the table of the current view for the shown period
+func (m Model) makeTable() table.Model {
+	switch m.view {
+	case boxView:
+		return makeBoxViewTable(m.tb, m.currScope, m.timespan())
+	case timeline:
+		return makeTimelineTable(m.tb, m.timespan())
+	}
+	return makeBoxSummaryTable(m.tb, m.period.Period, m.timespan(), m.prorate, m.showArchived, m.expanded)
+}
+
+// toggleArchived archives the selected box, or makes it active again
+func (m *Model) toggleArchived() tea.Cmd {
+	box := m.getSelectedBox()
+	if box.Name == "" {
+		return nil
+	}
+	if box.Archived() {
+		if err := m.tb.UnarchiveBox(box.Name); err != nil {
+			return reloadWithStatusCmd(fmt.Sprintf("Can't unarchive box: %v", err))
+		}
+		m.tb = m.tb.Refresh()
+		return reloadWithStatusCmd(fmt.Sprintf("Unarchived %s", box.Name))
+	}
+	if err := m.tb.ArchiveBox(box.Name, time.Now()); err != nil {
+		return reloadWithStatusCmd(fmt.Sprintf("Can't archive box: %v", err))
+	}
+	m.tb = m.tb.Refresh()
+	return reloadWithStatusCmd(fmt.Sprintf("Archived %s", box.Name))
+}
+
+// undo undoes the last change of boxes or spans
+func (m *Model) undo() tea.Cmd {
+	desc, err := m.tb.Undo()
+	if err != nil {
+		return reloadWithStatusCmd(fmt.Sprintf("Can't undo: %v", err))
+	}
+	m.tb = m.tb.Refresh()
+	return reloadWithStatusCmd(fmt.Sprintf("Undid %s", desc))
+}
+
+// redo makes the last undone change again
+func (m *Model) redo() tea.Cmd {
+	desc, err := m.tb.Redo()
+	if err != nil {
+		return reloadWithStatusCmd(fmt.Sprintf("Can't redo: %v", err))
+	}
+	m.tb = m.tb.Refresh()
+	return reloadWithStatusCmd(fmt.Sprintf("Redid %s", desc))
+}
+
+// toggleTimer stops the running timer, or starts one for the selected box
+func (m *Model) toggleTimer() tea.Cmd {
+	if m.timerRunning {
+		timer := m.timer
+		span, err := m.tb.StopTimer(time.Now())
+		if err != nil {
+			return reloadWithStatusCmd(fmt.Sprintf("Can't stop timer: %v", err))
+		}
+		m.tb = m.tb.Refresh()
+		return reloadWithStatusCmd(fmt.Sprintf("Stopped %s after %s", timer.Box, util2.DurationParser(span.Duration())))
+	}
+	var boxName string
+	switch m.view {
+	case boxSummary:
+		boxName = m.getSelectedBoxName()
+	case boxView:
+		boxName = m.currScope
+	default:
+		return reloadWithStatusCmd("Select a box to start a timer")
+	}
+	err := m.tb.StartTimer(boxName, time.Now())
+	if err != nil {
+		return reloadWithStatusCmd(fmt.Sprintf("Can't start timer: %v", err))
+	}
+	return reloadWithStatusCmd(fmt.Sprintf("Started timer for %s", boxName))
+}
+
+// refreshTimer reads the running timer from the store, and returns a status
+// message if it can't
+func (m *Model) refreshTimer() string {
+	timer, running, err := m.tb.RunningTimer()
+	if err != nil {
+		return fmt.Sprintf("Can't read the timer: %v", err)
+	}
+	m.timer, m.timerRunning = timer, running
+	return ""
+}
+
+func (m Model) timerView() string {
+	if !m.timerRunning {
+		return ""
+	}
+	return TimerStyle.Render(fmt.Sprintf("%s running for %s", m.timer.Box, util2.DurationParser(m.timer.Elapsed())))
+}
+
 func (m Model) mainView() string {
 	return lipgloss.JoinVertical(
 		lipgloss.Top,
 		lipgloss.JoinHorizontal(lipgloss.Left, LogoStyle.Render(logo), m.helpString()),
 		m.tbl.View(),
-		m.period.View(),
+		lipgloss.JoinHorizontal(lipgloss.Left, m.period.View(), util2.PeriodStyle.Render(m.periodLabel())),
+		m.timerView(),
+		ErrStyle(m.status),
 		printCrudState(m.state),
 		printViewMode(m.view))
 }
@@ -281,23 +590,30 @@ func (m Model) helpString() string {
 	switch m.view {
 	case boxSummary:
 		row1 := ShortcutRow([]Shortcut{addShortcut, editShortcut, deleteShortcut, quitShortcut})
-		row2 := ShortcutRow([]Shortcut{enterShortcut, periodShortcut, timelineShortcut})
-		result = lipgloss.NewStyle().PaddingTop(1).Render(lipgloss.JoinHorizontal(lipgloss.Top, row1, row2))
+		row2 := ShortcutRow([]Shortcut{enterShortcut, expandShortcut, periodShortcut, historyShortcut, prorateShortcut, timelineShortcut, timerShortcut})
+		row3 := ShortcutRow([]Shortcut{archiveShortcut, showArchivedShortcut, undoShortcut, redoShortcut})
+		result = lipgloss.NewStyle().PaddingTop(1).Render(lipgloss.JoinHorizontal(lipgloss.Top, row1, row2, row3))
 	case boxView:
 		row1 := ShortcutRow([]Shortcut{addShortcut, editShortcut, deleteShortcut, quitShortcut})
-		row2 := ShortcutRow([]Shortcut{backShortcut, periodShortcut})
-		result = lipgloss.NewStyle().PaddingTop(1).Render(lipgloss.JoinHorizontal(lipgloss.Top, row1, row2))
+		row2 := ShortcutRow([]Shortcut{backShortcut, periodShortcut, historyShortcut, timerShortcut})
+		row3 := ShortcutRow([]Shortcut{splitShortcut, moveShortcut, undoShortcut, redoShortcut})
+		result = lipgloss.NewStyle().PaddingTop(1).Render(lipgloss.JoinHorizontal(lipgloss.Top, row1, row2, row3))
 	case timeline:
 		row1 := ShortcutRow([]Shortcut{editShortcut, deleteShortcut, quitShortcut})
-		row2 := ShortcutRow([]Shortcut{boxSummaryShortcut, periodShortcut, timelineShortcut})
-		result = lipgloss.NewStyle().PaddingTop(1).Render(lipgloss.JoinHorizontal(lipgloss.Top, row1, row2))
+		row2 := ShortcutRow([]Shortcut{boxSummaryShortcut, periodShortcut, historyShortcut, timelineShortcut})
+		row3 := ShortcutRow([]Shortcut{splitShortcut, moveShortcut, undoShortcut, redoShortcut})
+		result = lipgloss.NewStyle().PaddingTop(1).Render(lipgloss.JoinHorizontal(lipgloss.Top, row1, row2, row3))
 	}
 	return result
 }
 
 func (m Model) getSelectedBoxName() string {
 	row := m.tbl.HighlightedRow()
-	return row.Data[columnKeyBox].(string)
+	if name, ok := row.Data[columnKeyPath].(string); ok {
+		return name
+	}
+	name, _ := row.Data[columnKeyBox].(string)
+	return name
 }
 
 func (m Model) getSelectedBox() util2.Box {
@@ -305,22 +621,10 @@ func (m Model) getSelectedBox() util2.Box {
 	return m.tb.Boxes[boxName]
 }
 
+// getSelectedSpan returns the whole span of the highlighted row, which may
+// only show the part of it within the period
 func (m Model) getSelectedSpan() util2.Span {
 	row := m.tbl.HighlightedRow()
-	boxName := row.Data[columnKeyBox].(string)
-	startStr := row.Data[columnKeyStart].(string)
-	endStr := row.Data[columnKeyEnd].(string)
-	startTime, err := time.Parse(time.DateTime, startStr)
-	if err != nil {
-		panic(err)
-	}
-	endTime, err := time.Parse(time.DateTime, endStr)
-	if err != nil {
-		panic(err)
-	}
-	return util2.Span{
-		Start: startTime,
-		End:   endTime,
-		Box:   boxName,
-	}
+	id, _ := row.Data[columnKeyID].(int64)
+	return m.tb.Spans[id]
 }
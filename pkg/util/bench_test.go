@@ -0,0 +1,69 @@
+package util
+
+import (
+	"fmt"
+	"github.com/aldernero/timebox/pkg/db"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// benchTimeBox returns a TimeBox with n spans seeded over ten boxes, two of
+// them sub-boxes, and the start of the span in the middle
+func benchTimeBox(b *testing.B, n int) (TimeBox, time.Time) {
+	tbdb := openDB(b, filepath.Join(b.TempDir(), dbName))
+	boxes := []string{"Work", "Work/ClientA", "Work/ClientB", "Piano", "Run", "Read", "Chores", "Spanish", "Chess", "Email"}
+	first, err := tbdb.Seed(ctx, boxes, n)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return TimeBoxFromDB(ctx, tbdb), time.Unix(first, 0).Add(time.Duration(n/2) * db.SeedStep)
+}
+
+func BenchmarkTimeBox(b *testing.B) {
+	for _, n := range db.SeedSizes {
+		tb, middle := benchTimeBox(b, n)
+		week := PeriodContaining(Week, middle, tb.Calendar)
+		b.Run(fmt.Sprintf("Report/spans=%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				report := tb.Report(Week, week, false)
+				if report.Boxes[0].Used == 0 {
+					b.Fatal("no time used in", week)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("GetSpansForBoxTree/spans=%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if spans := tb.GetSpansForBoxTree("Work", week); spans.IsEmpty() {
+					b.Fatal("no spans in", week)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("GetSpansForTimespan/spans=%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if spans := tb.GetSpansForTimespan(week); spans.IsEmpty() {
+					b.Fatal("no spans in", week)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("Reload/spans=%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if tb := tb.Reload(); len(tb.Spans) != n {
+					b.Fatal(len(tb.Spans), "spans")
+				}
+			}
+		})
+		// what the TUI does after each change
+		b.Run(fmt.Sprintf("UpdateSpanAndRefresh/spans=%d", n), func(b *testing.B) {
+			set := tb.SpansSets["Work"]
+			span := set.Overlapping(Span{Start: middle, End: middle.Add(time.Minute)})[0]
+			for i := 0; i < b.N; i++ {
+				span.End = span.Start.Add(db.SeedLength - time.Duration(i%2)*time.Second)
+				if err := tb.UpdateSpan(span); err != nil {
+					b.Fatal(err)
+				}
+				tb = tb.Refresh()
+			}
+		})
+	}
+}
@@ -1,51 +1,269 @@
 package util
 
 import (
+	"context"
+	"fmt"
 	"github.com/aldernero/timebox/pkg/db"
+	"strings"
 	"time"
 )
 
+// BoxPathSeparator separates the levels of a hierarchical box name, the
+// usage of a sub-box like Work/ClientA counts towards Work as well
+const BoxPathSeparator = db.BoxPathSeparator
+
+// Box holds the weekly min and max time as the baseline for every period,
+// and optional explicit targets for a month, quarter or year
 type Box struct {
 	Name    string
 	MinTime time.Duration
 	MaxTime time.Duration
+	Targets map[Period]Target
+	// ArchiveTime is when the box was archived, the zero time for active
+	// boxes
+	ArchiveTime time.Time
+}
+
+// Target is the min and max time of a box for one period
+type Target struct {
+	Min time.Duration
+	Max time.Duration
+}
+
+func (t Target) String() string {
+	return DurationParser(t.Min) + "-" + DurationParser(t.Max)
+}
+
+// ParseTarget parses a target like "month=20h-30h" or "year=100h", a single
+// duration sets the min and the max time. An empty value like "month=" gives
+// a nil target, which removes the explicit target of the period.
+func ParseTarget(s string) (Period, *Target, error) {
+	name, value, ok := strings.Cut(s, "=")
+	if !ok {
+		return Week, nil, fmt.Errorf("invalid target \"%s\", expected period=min-max", s)
+	}
+	p, err := ParsePeriod(strings.TrimSpace(name))
+	if err != nil {
+		return Week, nil, err
+	}
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return p, nil, nil
+	}
+	minValue, maxValue, ok := strings.Cut(value, "-")
+	if !ok {
+		maxValue = minValue
+	}
+	var t Target
+	t.Min, err = time.ParseDuration(strings.TrimSpace(minValue))
+	if err != nil {
+		return p, nil, err
+	}
+	t.Max, err = time.ParseDuration(strings.TrimSpace(maxValue))
+	if err != nil {
+		return p, nil, err
+	}
+	if t.Min > t.Max {
+		return p, nil, fmt.Errorf("min time of target \"%s\" is greater than its max time", s)
+	}
+	return p, &t, nil
+}
+
+// SetTarget sets or, with a nil target, removes the explicit target of a
+// period. The week target is the baseline of the box.
+func (b *Box) SetTarget(p Period, t *Target) {
+	if p == Week {
+		b.MinTime, b.MaxTime = 0, 0
+		if t != nil {
+			b.MinTime, b.MaxTime = t.Min, t.Max
+		}
+		return
+	}
+	targets := make(map[Period]Target, len(b.Targets)+1)
+	for k, v := range b.Targets {
+		targets[k] = v
+	}
+	if t == nil {
+		delete(targets, p)
+	} else {
+		targets[p] = *t
+	}
+	b.Targets = targets
+}
+
+func (b Box) Archived() bool {
+	return !b.ArchiveTime.IsZero()
+}
+
+// ArchivedBy reports whether the box was archived by t
+func (b Box) ArchivedBy(t time.Time) bool {
+	return b.Archived() && !b.ArchiveTime.After(t)
+}
+
+// Parent returns the name of the parent box, or "" for a top level box
+func (b Box) Parent() string {
+	return ParentBoxName(b.Name)
+}
+
+// Leaf returns the last element of the box path
+func (b Box) Leaf() string {
+	return b.Name[strings.LastIndex(b.Name, BoxPathSeparator)+1:]
+}
+
+// Depth returns the number of ancestors of the box
+func (b Box) Depth() int {
+	return strings.Count(b.Name, BoxPathSeparator)
+}
+
+func ParentBoxName(name string) string {
+	i := strings.LastIndex(name, BoxPathSeparator)
+	if i < 0 {
+		return ""
+	}
+	return name[:i]
+}
+
+// IsBoxInTree reports whether name is root or one of its descendants
+func IsBoxInTree(name, root string) bool {
+	return name == root || strings.HasPrefix(name, root+BoxPathSeparator)
 }
 
-func AllBoxesFromDB(tbdb db.TBDB) ([]string, map[string]Box) {
+// NormalizeBoxPath trims the elements of a box path like " Work / ClientA"
+// and rejects empty elements
+func NormalizeBoxPath(path string) (string, error) {
+	parts := strings.Split(path, BoxPathSeparator)
+	for i, part := range parts {
+		parts[i] = strings.TrimSpace(part)
+		if parts[i] == "" {
+			return "", fmt.Errorf("invalid box path \"%s\"", path)
+		}
+	}
+	return strings.Join(parts, BoxPathSeparator), nil
+}
+
+func AllBoxesFromDB(ctx context.Context, store db.Store) ([]string, map[string]Box) {
 	result := make(map[string]Box)
-	brs, err := tbdb.GetAllBoxes()
+	brs, err := store.GetAllBoxes(ctx)
+	if err != nil {
+		panic(err)
+	}
+	trs, err := store.GetAllTargets(ctx)
 	if err != nil {
 		panic(err)
 	}
+	targets := make(map[string]map[Period]Target)
+	for _, tr := range trs {
+		if targets[tr.Box] == nil {
+			targets[tr.Box] = make(map[Period]Target)
+		}
+		addTarget(targets[tr.Box], tr)
+	}
 	names := make([]string, len(brs))
 	for i, br := range brs {
 		names[i] = br.Name
-		result[br.Name] = Box{
-			Name:    br.Name,
-			MinTime: time.Duration(br.MinTime) * time.Second,
-			MaxTime: time.Duration(br.MaxTime) * time.Second,
-		}
+		result[br.Name] = boxFromRow(br, targets[br.Name])
 	}
 	return names, result
 }
 
-func (b Box) ScaledTimes(p Period) (time.Duration, time.Duration) {
-	var factor float64
-	minSec := b.MinTime.Seconds()
-	maxSec := b.MaxTime.Seconds()
-	switch p {
-	case Week:
-		factor = 1.0
-	case Month:
-		factor = monthsPerWeek
-	case Quarter:
-		factor = quartersPerWeek
-	case Year:
-		factor = yearsPerWeek
-	default:
-		factor = 1.0
-	}
-	newMin := time.Duration(minSec/factor) * time.Second
-	newMax := time.Duration(maxSec/factor) * time.Second
-	return newMin, newMax
+func addTarget(targets map[Period]Target, tr db.TargetRow) {
+	p, err := ParsePeriod(tr.Period)
+	if err != nil {
+		panic(err)
+	}
+	targets[p] = Target{
+		Min: time.Duration(tr.MinTime) * time.Second,
+		Max: time.Duration(tr.MaxTime) * time.Second,
+	}
+}
+
+// boxFromImage returns the box of a journal image
+func boxFromImage(b db.BoxImage) Box {
+	var targets map[Period]Target
+	for _, tr := range b.Targets {
+		if targets == nil {
+			targets = make(map[Period]Target)
+		}
+		addTarget(targets, tr)
+	}
+	return boxFromRow(b.BoxRow, targets)
+}
+
+func boxFromRow(br db.BoxRow, targets map[Period]Target) Box {
+	box := Box{
+		Name:    br.Name,
+		MinTime: time.Duration(br.MinTime) * time.Second,
+		MaxTime: time.Duration(br.MaxTime) * time.Second,
+		Targets: targets,
+	}
+	if br.ArchiveTime != 0 {
+		box.ArchiveTime = time.Unix(br.ArchiveTime, 0)
+	}
+	return box
+}
+
+// targetRows returns the explicit targets of the box for the store
+func (b Box) targetRows() []db.TargetRow {
+	var rows []db.TargetRow
+	for _, p := range []Period{Month, Quarter, Year} {
+		if t, ok := b.Targets[p]; ok {
+			rows = append(rows, db.TargetRow{
+				Box:     b.Name,
+				Period:  periodKey(p),
+				MinTime: int64(t.Min.Seconds()),
+				MaxTime: int64(t.Max.Seconds()),
+			})
+		}
+	}
+	return rows
+}
+
+func periodKey(p Period) string {
+	t := TimePeriod{Period: p}
+	return strings.ToLower(t.String())
+}
+
+// ScaledTimes converts the weekly min and max time of the box to a period,
+// in proportion to the number of calendar days of the period. A 28 day
+// February gets exactly four weeks worth of time.
+func (b Box) ScaledTimes(period Span) (time.Duration, time.Duration) {
+	return scaleDuration(b.MinTime, DaysIn(period), 7), scaleDuration(b.MaxTime, DaysIn(period), 7)
+}
+
+// TargetTimes returns the min and max time of the box for period, the full
+// bounds of a period of type p. An explicit target for p is used as is,
+// otherwise the weekly times are scaled. A box without weekly times is scaled
+// from its explicit target for the shortest period instead, by the share of
+// calendar days of the period of that type containing the start of period.
+func (b Box) TargetTimes(p Period, period Span, cal Calendar) (time.Duration, time.Duration) {
+	if t, ok := b.Targets[p]; ok {
+		return t.Min, t.Max
+	}
+	if b.MinTime != 0 || b.MaxTime != 0 {
+		return b.ScaledTimes(period)
+	}
+	for _, base := range []Period{Month, Quarter, Year} {
+		if t, ok := b.Targets[base]; ok {
+			days := DaysIn(period)
+			baseDays := DaysIn(PeriodContaining(base, period.Start, cal))
+			return scaleDuration(t.Min, days, baseDays), scaleDuration(t.Max, days, baseDays)
+		}
+	}
+	return 0, 0
+}
+
+// ProratedTimes scales the min and max time of the box for period down to
+// the part of it that has elapsed at now
+func (b Box) ProratedTimes(p Period, period Span, cal Calendar, now time.Time) (time.Duration, time.Duration) {
+	minTime, maxTime := b.TargetTimes(p, period, cal)
+	elapsed, total := ElapsedIn(period, now)
+	return scaleDuration(minTime, elapsed, total), scaleDuration(maxTime, elapsed, total)
+}
+
+// scaleDuration returns d*num/den rounded to the second
+func scaleDuration(d time.Duration, num, den int64) time.Duration {
+	if den == 0 {
+		return 0
+	}
+	return (time.Duration(float64(d) * float64(num) / float64(den))).Round(time.Second)
 }
@@ -0,0 +1,110 @@
+package util
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func TestBox_ScaledTimes(t *testing.T) {
+	box := Box{Name: "Work", MinTime: 35 * time.Hour, MaxTime: 42 * time.Hour}
+	tests := map[string]struct {
+		period Period
+		anchor time.Time
+		min    time.Duration
+		max    time.Duration
+	}{
+		"week":               {Week, time.Date(2023, time.March, 15, 0, 0, 0, 0, time.Local), 35 * time.Hour, 42 * time.Hour},
+		"february":           {Month, time.Date(2023, time.February, 10, 0, 0, 0, 0, time.Local), 140 * time.Hour, 168 * time.Hour},
+		"leap february":      {Month, time.Date(2024, time.February, 10, 0, 0, 0, 0, time.Local), 145 * time.Hour, 174 * time.Hour},
+		"31 day month":       {Month, time.Date(2023, time.March, 10, 0, 0, 0, 0, time.Local), 155 * time.Hour, 186 * time.Hour},
+		"30 day month":       {Month, time.Date(2023, time.April, 10, 0, 0, 0, 0, time.Local), 150 * time.Hour, 180 * time.Hour},
+		"first quarter":      {Quarter, time.Date(2023, time.February, 10, 0, 0, 0, 0, time.Local), 450 * time.Hour, 540 * time.Hour},
+		"leap first quarter": {Quarter, time.Date(2024, time.February, 10, 0, 0, 0, 0, time.Local), 455 * time.Hour, 546 * time.Hour},
+		"year":               {Year, time.Date(2023, time.June, 1, 0, 0, 0, 0, time.Local), 1825 * time.Hour, 2190 * time.Hour},
+		"leap year":          {Year, time.Date(2024, time.June, 1, 0, 0, 0, 0, time.Local), 1830 * time.Hour, 2196 * time.Hour},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			minTime, maxTime := box.ScaledTimes(PeriodContaining(tc.period, tc.anchor, DefaultCalendar))
+			assert.Equal(t, tc.min, minTime)
+			assert.Equal(t, tc.max, maxTime)
+		})
+	}
+}
+
+func TestDaysIn_DST(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	assert.NoError(t, err)
+	// the week and the month of the switch to summer time
+	week := Span{Start: time.Date(2023, time.March, 26, 0, 0, 0, 0, berlin), End: time.Date(2023, time.April, 2, 0, 0, 0, 0, berlin)}
+	assert.Equal(t, int64(7), DaysIn(week))
+	march := Span{Start: time.Date(2023, time.March, 1, 0, 0, 0, 0, berlin), End: time.Date(2023, time.April, 1, 0, 0, 0, 0, berlin)}
+	assert.Equal(t, int64(31), DaysIn(march))
+}
+
+func TestBox_ProratedTimes(t *testing.T) {
+	box := Box{Name: "Work", MinTime: 28 * time.Hour, MaxTime: 56 * time.Hour}
+	february := Span{Start: time.Date(2023, time.February, 1, 0, 0, 0, 0, time.UTC), End: time.Date(2023, time.March, 1, 0, 0, 0, 0, time.UTC)}
+
+	minTime, maxTime := box.ProratedTimes(Month, february, DefaultCalendar, time.Date(2023, time.February, 8, 0, 0, 0, 0, time.UTC))
+	assert.Equal(t, 28*time.Hour, minTime)
+	assert.Equal(t, 56*time.Hour, maxTime)
+
+	minTime, _ = box.ProratedTimes(Month, february, DefaultCalendar, time.Date(2023, time.January, 8, 0, 0, 0, 0, time.UTC))
+	assert.Zero(t, minTime)
+	minTime, _ = box.ProratedTimes(Month, february, DefaultCalendar, time.Date(2023, time.May, 8, 0, 0, 0, 0, time.UTC))
+	assert.Equal(t, 112*time.Hour, minTime)
+}
+
+func TestBox_TargetTimes(t *testing.T) {
+	march := PeriodContaining(Month, time.Date(2023, time.March, 10, 0, 0, 0, 0, time.Local), DefaultCalendar)
+	week := PeriodContaining(Week, time.Date(2023, time.March, 10, 0, 0, 0, 0, time.Local), DefaultCalendar)
+	year := PeriodContaining(Year, time.Date(2023, time.March, 10, 0, 0, 0, 0, time.Local), DefaultCalendar)
+
+	box := Box{Name: "Training", MinTime: 5 * time.Hour, MaxTime: 7 * time.Hour}
+	box.SetTarget(Month, &Target{Min: 20 * time.Hour, Max: 30 * time.Hour})
+	minTime, maxTime := box.TargetTimes(Month, march, DefaultCalendar)
+	assert.Equal(t, 20*time.Hour, minTime)
+	assert.Equal(t, 30*time.Hour, maxTime)
+	// the other periods are scaled from the weekly times
+	minTime, _ = box.TargetTimes(Week, week, DefaultCalendar)
+	assert.Equal(t, 5*time.Hour, minTime)
+	_, maxTime = box.TargetTimes(Year, year, DefaultCalendar)
+	assert.Equal(t, 365*time.Hour, maxTime)
+
+	// without weekly times the explicit target is scaled instead
+	box = Box{Name: "Reading"}
+	box.SetTarget(Year, &Target{Min: 73 * time.Hour, Max: 146 * time.Hour})
+	minTime, maxTime = box.TargetTimes(Week, week, DefaultCalendar)
+	assert.Equal(t, 84*time.Minute, minTime)
+	assert.Equal(t, 168*time.Minute, maxTime)
+	minTime, _ = box.TargetTimes(Month, march, DefaultCalendar)
+	assert.Equal(t, 6*time.Hour+12*time.Minute, minTime)
+
+	box.SetTarget(Year, nil)
+	assert.Empty(t, box.Targets)
+	minTime, maxTime = box.TargetTimes(Year, year, DefaultCalendar)
+	assert.Zero(t, minTime)
+	assert.Zero(t, maxTime)
+}
+
+func TestParseTarget(t *testing.T) {
+	p, target, err := ParseTarget("month=20h-30h")
+	assert.NoError(t, err)
+	assert.Equal(t, Month, p)
+	assert.Equal(t, &Target{Min: 20 * time.Hour, Max: 30 * time.Hour}, target)
+	p, target, err = ParseTarget("Year = 100h")
+	assert.NoError(t, err)
+	assert.Equal(t, Year, p)
+	assert.Equal(t, &Target{Min: 100 * time.Hour, Max: 100 * time.Hour}, target)
+	p, target, err = ParseTarget("quarter=")
+	assert.NoError(t, err)
+	assert.Equal(t, Quarter, p)
+	assert.Nil(t, target)
+	for _, s := range []string{"month", "day=1h", "month=2h-1h", "month=x"} {
+		_, _, err = ParseTarget(s)
+		assert.Error(t, err, s)
+	}
+}
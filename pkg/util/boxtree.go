@@ -0,0 +1,65 @@
+package util
+
+import (
+	"time"
+)
+
+// Children returns the names of the direct sub-boxes of a box, in the order
+// of tb.Names. The top level boxes are the children of "".
+func (tb TimeBox) Children(name string) []string {
+	var result []string
+	for _, n := range tb.Names {
+		if ParentBoxName(n) == name {
+			result = append(result, n)
+		}
+	}
+	return result
+}
+
+// HasChildren reports whether a box has any sub-boxes
+func (tb TimeBox) HasChildren(name string) bool {
+	for _, n := range tb.Names {
+		if ParentBoxName(n) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// TreeNames returns all box names in depth-first order, each box followed by
+// its sub-boxes
+func (tb TimeBox) TreeNames() []string {
+	var result []string
+	var walk func(name string)
+	walk = func(name string) {
+		for _, child := range tb.Children(name) {
+			result = append(result, child)
+			walk(child)
+		}
+	}
+	walk("")
+	return result
+}
+
+// GetSpansForBoxTree returns the parts of the spans of a box and all of its
+// sub-boxes that fall within span
+func (tb TimeBox) GetSpansForBoxTree(box string, span Span) SpanSet {
+	spans := NewSpanSet()
+	for name := range tb.SpansSets {
+		if !IsBoxInTree(name, box) {
+			continue
+		}
+		for _, s := range tb.GetSpansForBox(name, span).Spans {
+			spans.Add(s)
+		}
+	}
+	spans.SortByStart()
+	return spans
+}
+
+// UsedTime returns the time spent within span on a box, including the time
+// spent on its sub-boxes
+func (tb TimeBox) UsedTime(box string, span Span) time.Duration {
+	spans := tb.GetSpansForBoxTree(box, span)
+	return spans.Duration()
+}
@@ -0,0 +1,93 @@
+package util
+
+import (
+	"github.com/aldernero/timebox/pkg/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestNormalizeBoxPath(t *testing.T) {
+	tests := map[string]struct {
+		path    string
+		want    string
+		wantErr bool
+	}{
+		"flat":          {path: "Work", want: "Work"},
+		"nested":        {path: "Work/ClientA/Meetings", want: "Work/ClientA/Meetings"},
+		"spaces":        {path: " Work / Client A ", want: "Work/Client A"},
+		"empty":         {path: "", wantErr: true},
+		"leading slash": {path: "/Work", wantErr: true},
+		"double slash":  {path: "Work//ClientA", wantErr: true},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := NormalizeBoxPath(tc.path)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestBox_Path(t *testing.T) {
+	box := Box{Name: "Work/ClientA/Meetings"}
+	assert.Equal(t, "Work/ClientA", box.Parent())
+	assert.Equal(t, "Meetings", box.Leaf())
+	assert.Equal(t, 2, box.Depth())
+	root := Box{Name: "Work"}
+	assert.Equal(t, "", root.Parent())
+	assert.Equal(t, "Work", root.Leaf())
+	assert.Equal(t, 0, root.Depth())
+	assert.True(t, IsBoxInTree("Work/ClientA", "Work"))
+	assert.True(t, IsBoxInTree("Work", "Work"))
+	assert.False(t, IsBoxInTree("Workshop", "Work"))
+}
+
+func TestTimeBox_Tree(t *testing.T) {
+	store := db.NewMemoryStore()
+	names := []string{"Work", "Work/ClientA", "Work/ClientA/Meetings", "Work/ClientB", "Piano"}
+	for _, name := range names {
+		require.NoError(t, store.AddBox(ctx, name, 0, 3600))
+	}
+	day := time.Date(2023, time.January, 2, 0, 0, 0, 0, time.Local)
+	spans := map[string]int{"Work": 1, "Work/ClientA": 2, "Work/ClientA/Meetings": 3, "Work/ClientB": 4, "Piano": 5}
+	for name, hour := range spans {
+		start := day.Add(time.Duration(hour) * time.Hour)
+		require.NoError(t, store.AddSpan(ctx, start.Unix(), start.Add(30*time.Minute).Unix(), name))
+	}
+	tb := TimeBoxFromDB(ctx, store)
+	assert.ElementsMatch(t, []string{"Work", "Piano"}, tb.Children(""))
+	assert.ElementsMatch(t, []string{"Work/ClientA", "Work/ClientB"}, tb.Children("Work"))
+	assert.True(t, tb.HasChildren("Work/ClientA"))
+	assert.False(t, tb.HasChildren("Work/ClientB"))
+	tree := tb.TreeNames()
+	require.Equal(t, len(names), len(tree))
+	for i, name := range tree {
+		if parent := ParentBoxName(name); parent != "" {
+			assert.Less(t, indexOf(tree, parent), i)
+		}
+	}
+	period := Span{Start: day, End: day.Add(24 * time.Hour)}
+	assert.Equal(t, 2*time.Hour, tb.UsedTime("Work", period))
+	assert.Equal(t, time.Hour, tb.UsedTime("Work/ClientA", period))
+	assert.Equal(t, 30*time.Minute, tb.UsedTime("Work/ClientB", period))
+	assert.Equal(t, 30*time.Minute, tb.UsedTime("Piano", period))
+	treeSpans := tb.GetSpansForBoxTree("Work/ClientA", period)
+	require.Equal(t, 2, treeSpans.Size())
+	assert.Equal(t, "Work/ClientA", treeSpans.Spans[0].Box)
+	assert.Equal(t, "Work/ClientA/Meetings", treeSpans.Spans[1].Box)
+}
+
+func indexOf(names []string, name string) int {
+	for i, n := range names {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,227 @@
+package util
+
+import (
+	"fmt"
+	"github.com/aldernero/timebox/pkg/db"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ProblemKind is a kind of inconsistency found by Diagnose
+type ProblemKind int
+
+const (
+	// ProblemOrphan is a span whose box doesn't exist
+	ProblemOrphan ProblemKind = iota
+	// ProblemOverlap is a span starting before an earlier span ends
+	ProblemOverlap
+	// ProblemEmpty is a span of zero length
+	ProblemEmpty
+	// ProblemNegative is a span ending before it starts
+	ProblemNegative
+	// ProblemFuture is a span ending after the current time
+	ProblemFuture
+)
+
+func (k ProblemKind) String() string {
+	switch k {
+	case ProblemOrphan:
+		return "orphaned"
+	case ProblemOverlap:
+		return "overlapping"
+	case ProblemEmpty:
+		return "zero length"
+	case ProblemNegative:
+		return "negative length"
+	case ProblemFuture:
+		return "in the future"
+	}
+	return fmt.Sprintf("ProblemKind(%d)", int(k))
+}
+
+// Problem is an inconsistent span. Other is the earlier span an overlapping
+// span overlaps with.
+type Problem struct {
+	Kind  ProblemKind
+	Span  Span
+	Other Span
+}
+
+// Key identifies a problem across calls to Diagnose
+func (p Problem) Key() string {
+	return fmt.Sprintf("%d:%d:%d", p.Kind, p.Span.ID, p.Other.ID)
+}
+
+func (p Problem) String() string {
+	switch p.Kind {
+	case ProblemOrphan:
+		return fmt.Sprintf("span %d belongs to box %s, which doesn't exist", p.Span.ID, p.Span.Box)
+	case ProblemOverlap:
+		return fmt.Sprintf("span %d (%s) overlaps span %d (%s) by %s", p.Span.ID, p.Span.Box, p.Other.ID, p.Other.Box, DurationParser(p.Span.GetOverlap(p.Other).Duration()))
+	case ProblemEmpty:
+		return fmt.Sprintf("span %d (%s) has zero length", p.Span.ID, p.Span.Box)
+	case ProblemNegative:
+		return fmt.Sprintf("span %d (%s) ends %s before it starts", p.Span.ID, p.Span.Box, DurationParser(-p.Span.Duration()))
+	case ProblemFuture:
+		return fmt.Sprintf("span %d (%s) ends in the future at %s", p.Span.ID, p.Span.Box, p.Span.End.Format(time.DateTime))
+	}
+	return p.Kind.String()
+}
+
+// Repair is a way to fix a problem
+type Repair int
+
+const (
+	// RepairRecreateBox creates the missing box of an orphaned span, and
+	// its missing parents
+	RepairRecreateBox Repair = iota
+	// RepairReassign moves an orphaned span to another box
+	RepairReassign
+	// RepairTrim moves the start of an overlapping span to the end of the
+	// earlier span
+	RepairTrim
+	// RepairSwap swaps the start and end of a span of negative length
+	RepairSwap
+	// RepairClamp ends a span in the future at the current time
+	RepairClamp
+	// RepairDelete deletes the span
+	RepairDelete
+)
+
+func (r Repair) String() string {
+	switch r {
+	case RepairRecreateBox:
+		return "recreate the box"
+	case RepairReassign:
+		return "move the span to another box"
+	case RepairTrim:
+		return "trim the span to start when the earlier one ends"
+	case RepairSwap:
+		return "swap the start and end"
+	case RepairClamp:
+		return "end the span now"
+	case RepairDelete:
+		return "delete the span"
+	}
+	return fmt.Sprintf("Repair(%d)", int(r))
+}
+
+// Repairs returns the repairs that apply to a problem at now, the first one
+// is the default which keeps as much as possible
+func (p Problem) Repairs(now time.Time) []Repair {
+	switch p.Kind {
+	case ProblemOrphan:
+		return []Repair{RepairRecreateBox, RepairReassign, RepairDelete}
+	case ProblemOverlap:
+		// a span within the other one can't be trimmed
+		if p.Span.End.After(p.Other.End) {
+			return []Repair{RepairTrim, RepairDelete}
+		}
+	case ProblemNegative:
+		return []Repair{RepairSwap, RepairDelete}
+	case ProblemFuture:
+		if p.Span.Start.Before(now) {
+			return []Repair{RepairClamp, RepairDelete}
+		}
+	}
+	return []Repair{RepairDelete}
+}
+
+// Diagnose finds spans without a box, overlapping spans, spans of zero or
+// negative length and spans ending after now. It reads the store directly,
+// spans without a box aren't part of the TimeBox.
+func (tb TimeBox) Diagnose(now time.Time) ([]Problem, error) {
+	rows, err := tb.store.GetSpansForTimeRange(tb.ctx, math.MinInt64, math.MaxInt64)
+	if err != nil {
+		return nil, err
+	}
+	boxes, err := tb.store.GetAllBoxes(tb.ctx)
+	if err != nil {
+		return nil, err
+	}
+	exists := make(map[string]bool)
+	for _, br := range boxes {
+		exists[br.Name] = true
+	}
+	spans := make([]Span, len(rows))
+	for i, sr := range rows {
+		spans[i] = spanFromRow(sr)
+	}
+	sort.SliceStable(spans, func(i, j int) bool {
+		return spans[i].Start.Before(spans[j].Start)
+	})
+	var problems []Problem
+	var last Span // the span ending last so far
+	for i, s := range spans {
+		if !exists[s.Box] {
+			problems = append(problems, Problem{Kind: ProblemOrphan, Span: s})
+		}
+		switch {
+		case s.End.Equal(s.Start):
+			problems = append(problems, Problem{Kind: ProblemEmpty, Span: s})
+		case s.End.Before(s.Start):
+			problems = append(problems, Problem{Kind: ProblemNegative, Span: s})
+			// it doesn't cover any time to overlap with
+			continue
+		}
+		if s.End.After(now) {
+			problems = append(problems, Problem{Kind: ProblemFuture, Span: s})
+		}
+		if i > 0 && s.Start.Before(last.End) && s.End.After(s.Start) {
+			problems = append(problems, Problem{Kind: ProblemOverlap, Span: s, Other: last})
+		}
+		if last.End.IsZero() || s.End.After(last.End) {
+			last = s
+		}
+	}
+	return problems, nil
+}
+
+// Fix applies a repair to a problem. box is the box to move an orphaned
+// span to with RepairReassign. Call Reload to see the changes.
+func (tb TimeBox) Fix(p Problem, r Repair, box string, now time.Time) error {
+	span := p.Span
+	description := fmt.Sprintf("fix span %d: %s", span.ID, r)
+	switch r {
+	case RepairRecreateBox:
+		return tb.journalBoxes(description, false, func(s db.Store) error {
+			parts := strings.Split(span.Box, BoxPathSeparator)
+			for i := range parts {
+				name := strings.Join(parts[:i+1], BoxPathSeparator)
+				if _, ok := tb.Boxes[name]; ok {
+					continue
+				}
+				err := s.AddBox(tb.ctx, name, 0, 0)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		}, span.Box)
+	case RepairReassign:
+		if _, ok := tb.Boxes[box]; !ok {
+			return fmt.Errorf("box %s doesn't exist", box)
+		}
+		span.Box = box
+	case RepairTrim:
+		if !span.End.After(p.Other.End) {
+			return fmt.Errorf("span %d is within span %d and can't be trimmed", span.ID, p.Other.ID)
+		}
+		span.Start = p.Other.End.In(span.Start.Location())
+	case RepairSwap:
+		span.Start, span.End = span.End, span.Start
+	case RepairClamp:
+		span.End = now.In(span.End.Location())
+	case RepairDelete:
+		return tb.journalSpans(description, func(s db.Store) error {
+			return s.DeleteSpanByID(tb.ctx, span.ID)
+		}, span.ID)
+	default:
+		return fmt.Errorf("unknown repair %d", int(r))
+	}
+	return tb.journalSpans(description, func(s db.Store) error {
+		return s.UpdateSpanRow(tb.ctx, span.row())
+	}, span.ID)
+}
@@ -0,0 +1,144 @@
+package util
+
+import (
+	"database/sql"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func doctorFixture(t *testing.T) (TimeBox, string, time.Time) {
+	fname := filepath.Join(t.TempDir(), dbName)
+	store := openDB(t, fname)
+	require.NoError(t, store.AddBox(ctx, "Work", 0, 0))
+	require.NoError(t, store.AddBox(ctx, "Piano", 0, 0))
+	start := time.Date(2023, time.March, 1, 9, 0, 0, 0, time.UTC)
+	for i, box := range []string{"Work", "Piano", "Work", "Piano"} {
+		s := start.Add(time.Duration(2*i) * time.Hour)
+		require.NoError(t, store.AddSpan(ctx, s.Unix(), s.Add(time.Hour).Unix(), box))
+	}
+	return TimeBoxFromDB(ctx, store), fname, start
+}
+
+// breakSpan changes a span's times in the database file, past the checks of
+// the store
+func breakSpan(t *testing.T, fname string, id int64, start, end time.Time) {
+	conn, err := sql.Open("sqlite", fname)
+	require.NoError(t, err)
+	defer conn.Close()
+	res, err := conn.Exec("UPDATE spans SET start = ?, end = ? WHERE id = ?", start.Unix(), end.Unix(), id)
+	require.NoError(t, err)
+	n, err := res.RowsAffected()
+	require.NoError(t, err)
+	require.Equal(t, int64(1), n, "no span %d", id)
+}
+
+func kinds(problems []Problem) []ProblemKind {
+	var result []ProblemKind
+	for _, p := range problems {
+		result = append(result, p.Kind)
+	}
+	return result
+}
+
+func TestTimeBox_Diagnose(t *testing.T) {
+	tb, fname, start := doctorFixture(t)
+	now := start.Add(24 * time.Hour)
+	problems, err := tb.Diagnose(now)
+	require.NoError(t, err)
+	assert.Empty(t, problems)
+
+	// span 2 overlaps span 1 by 30 minutes, span 3 is empty and span 4 is
+	// negative
+	breakSpan(t, fname, 2, start.Add(30*time.Minute), start.Add(3*time.Hour))
+	breakSpan(t, fname, 3, start.Add(4*time.Hour), start.Add(4*time.Hour))
+	breakSpan(t, fname, 4, start.Add(7*time.Hour), start.Add(6*time.Hour))
+	problems, err = tb.Diagnose(now)
+	require.NoError(t, err)
+	assert.Equal(t, []ProblemKind{ProblemOverlap, ProblemEmpty, ProblemNegative}, kinds(problems))
+	assert.Equal(t, int64(1), problems[0].Other.ID)
+	assert.Equal(t, "span 2 (Piano) overlaps span 1 (Work) by 30m0s", problems[0].String())
+
+	problems, err = tb.Diagnose(start.Add(2 * time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, []ProblemKind{ProblemFuture, ProblemOverlap, ProblemEmpty, ProblemFuture, ProblemNegative}, kinds(problems))
+}
+
+func TestTimeBox_DiagnoseContained(t *testing.T) {
+	tb, fname, start := doctorFixture(t)
+	// span 1 covers span 2, span 3 overlaps span 1 but not span 2
+	breakSpan(t, fname, 1, start, start.Add(4*time.Hour+30*time.Minute))
+	problems, err := tb.Diagnose(start.Add(24 * time.Hour))
+	require.NoError(t, err)
+	require.Len(t, problems, 2)
+	for _, p := range problems {
+		assert.Equal(t, int64(1), p.Other.ID)
+	}
+	assert.Equal(t, []Repair{RepairDelete}, problems[0].Repairs(time.Now()))
+	assert.Equal(t, []Repair{RepairTrim, RepairDelete}, problems[1].Repairs(time.Now()))
+}
+
+func TestTimeBox_Fix(t *testing.T) {
+	tb, fname, start := doctorFixture(t)
+	now := start.Add(5*time.Hour + 30*time.Minute)
+	breakSpan(t, fname, 2, start.Add(30*time.Minute), start.Add(3*time.Hour))
+	breakSpan(t, fname, 3, start.Add(4*time.Hour), start.Add(4*time.Hour))
+	breakSpan(t, fname, 4, start.Add(7*time.Hour), start.Add(5*time.Hour))
+	problems, err := tb.Diagnose(now)
+	require.NoError(t, err)
+	for _, p := range problems {
+		require.NoError(t, tb.Fix(p, p.Repairs(now)[0], "", now), p.String())
+	}
+	// swapping span 4 puts it in the future
+	problems, err = tb.Diagnose(now)
+	require.NoError(t, err)
+	assert.Equal(t, []ProblemKind{ProblemFuture}, kinds(problems))
+	require.NoError(t, tb.Fix(problems[0], problems[0].Repairs(now)[0], "", now))
+	problems, err = tb.Diagnose(now)
+	require.NoError(t, err)
+	assert.Empty(t, problems)
+
+	tb = tb.Reload()
+	piano := tb.SpansSets["Piano"].Spans
+	require.Len(t, piano, 2)
+	assert.True(t, piano[0].Start.Equal(start.Add(time.Hour)))
+	assert.True(t, piano[1].Start.Equal(start.Add(5*time.Hour)))
+	assert.True(t, piano[1].End.Equal(now))
+	assert.Len(t, tb.SpansSets["Work"].Spans, 1)
+}
+
+func TestTimeBox_FixOrphans(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), dbName)
+	tbdb := openDB(t, fname)
+	require.NoError(t, tbdb.AddBox(ctx, "Work", 0, 0))
+	start := time.Date(2023, time.March, 1, 9, 0, 0, 0, time.UTC)
+	// foreign keys are only enforced on connections that turn them on
+	conn, err := sql.Open("sqlite", fname)
+	require.NoError(t, err)
+	for i, box := range []string{"Music/Piano", "Gone"} {
+		s := start.Add(time.Duration(i) * time.Hour)
+		_, err = conn.Exec("INSERT INTO spans (start, end, box) VALUES (?, ?, ?)", s.Unix(), s.Add(time.Hour).Unix(), box)
+		require.NoError(t, err)
+	}
+	require.NoError(t, conn.Close())
+
+	tb := TimeBoxFromDB(ctx, tbdb)
+	problems, err := tb.Diagnose(time.Now())
+	require.NoError(t, err)
+	require.Equal(t, []ProblemKind{ProblemOrphan, ProblemOrphan}, kinds(problems))
+	assert.Equal(t, "span 1 belongs to box Music/Piano, which doesn't exist", problems[0].String())
+
+	require.NoError(t, tb.Fix(problems[0], RepairRecreateBox, "", time.Now()))
+	assert.Error(t, tb.Fix(problems[1], RepairReassign, "Nowhere", time.Now()))
+	require.NoError(t, tb.Fix(problems[1], RepairReassign, "Work", time.Now()))
+	tb = tb.Reload()
+	assert.Contains(t, tb.Boxes, "Music")
+	assert.Contains(t, tb.Boxes, "Music/Piano")
+	assert.Len(t, tb.SpansSets["Music/Piano"].Spans, 1)
+	assert.Len(t, tb.SpansSets["Work"].Spans, 1)
+	problems, err = tb.Diagnose(time.Now())
+	require.NoError(t, err)
+	assert.Empty(t, problems)
+}
@@ -0,0 +1,510 @@
+package util
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aldernero/timebox/pkg/db"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Export formats understood by Export.Write and ReadExport
+const (
+	FormatCSV  = "csv"
+	FormatJSON = "json"
+)
+
+var csvHeader = []string{"kind", "box", "min", "max", "start", "end", "notes", "tags", "targets", "archived", "tz"}
+
+// csvV1Fields is the number of fields of exports written before the targets,
+// archive time and zone were added, they are still read
+const csvV1Fields = 8
+
+// Export is the portable form of boxes and spans used by export and import.
+// Durations are written like "1h30m0s" and times in RFC 3339.
+type Export struct {
+	Boxes []ExportedBox  `json:"boxes"`
+	Spans []ExportedSpan `json:"spans"`
+}
+
+// ExportedBox is a box with its explicit targets written like
+// "month=20h0m0s-30h0m0s" and its archive time, empty for active boxes
+type ExportedBox struct {
+	Name     string   `json:"name"`
+	Min      string   `json:"min"`
+	Max      string   `json:"max"`
+	Targets  []string `json:"targets,omitempty"`
+	Archived string   `json:"archived,omitempty"`
+	Row      int      `json:"-"` // position in the input, for error reporting
+}
+
+// ExportedSpan is a span with the IANA name of the zone it was recorded in
+type ExportedSpan struct {
+	ID    int64     `json:"id,omitempty"`
+	Box   string    `json:"box"`
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	TZ    string    `json:"tz,omitempty"`
+	Notes string    `json:"notes,omitempty"`
+	Tags  []string  `json:"tags,omitempty"`
+	Row   int       `json:"-"` // position in the input, for error reporting
+}
+
+// Span returns the span with its times in its zone, if the zone is known
+func (es ExportedSpan) Span() Span {
+	start, end := es.Start, es.End
+	if loc := LoadZone(es.TZ); loc != nil {
+		start, end = start.In(loc), end.In(loc)
+	}
+	return Span{ID: es.ID, Start: start, End: end, Box: es.Box, Notes: es.Notes, Tags: es.Tags}
+}
+
+func exportBox(b Box) ExportedBox {
+	eb := ExportedBox{Name: b.Name, Min: b.MinTime.String(), Max: b.MaxTime.String()}
+	for _, p := range []Period{Month, Quarter, Year} {
+		if t, ok := b.Targets[p]; ok {
+			eb.Targets = append(eb.Targets, fmt.Sprintf("%s=%s-%s", periodKey(p), t.Min, t.Max))
+		}
+	}
+	if b.Archived() {
+		eb.Archived = b.ArchiveTime.Format(time.RFC3339)
+	}
+	return eb
+}
+
+// Export collects the spans within span, limited to the tree of box if box is
+// not empty. The boxes of the tree and their ancestors are included so that
+// the result can be imported into an empty database.
+func (tb TimeBox) Export(span Span, box string) Export {
+	var e Export
+	for _, name := range tb.TreeNames() {
+		if box != "" && !IsBoxInTree(name, box) && !IsBoxInTree(box, name) {
+			continue
+		}
+		e.Boxes = append(e.Boxes, exportBox(tb.Boxes[name]))
+	}
+	spans := tb.GetSpansForTimespan(span)
+	spans.SortByStart()
+	for _, s := range spans.Spans {
+		if box != "" && !IsBoxInTree(s.Box, box) {
+			continue
+		}
+		e.Spans = append(e.Spans, ExportedSpan{
+			ID:    s.ID,
+			Box:   s.Box,
+			Start: s.Start,
+			End:   s.End,
+			TZ:    ZoneName(s.Start.Location()),
+			Notes: s.Notes,
+			Tags:  s.Tags,
+		})
+	}
+	return e
+}
+
+func (e Export) Write(w io.Writer, format string) error {
+	switch format {
+	case FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(e)
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(csvHeader); err != nil {
+			return err
+		}
+		for _, b := range e.Boxes {
+			if err := cw.Write([]string{"box", b.Name, b.Min, b.Max, "", "", "", "", strings.Join(b.Targets, ","), b.Archived, ""}); err != nil {
+				return err
+			}
+		}
+		for _, s := range e.Spans {
+			start := s.Start.Format(time.RFC3339)
+			end := s.End.Format(time.RFC3339)
+			if err := cw.Write([]string{"span", s.Box, "", "", start, end, s.Notes, strings.Join(s.Tags, ","), "", "", s.TZ}); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+	return fmt.Errorf("unknown format %s", format)
+}
+
+// ReadExport parses data written by Export.Write. Boxes and spans keep their
+// position in the input (the line for csv, the list index for json) for
+// error reporting.
+func ReadExport(r io.Reader, format string) (Export, error) {
+	var e Export
+	switch format {
+	case FormatJSON:
+		if err := json.NewDecoder(r).Decode(&e); err != nil {
+			return e, err
+		}
+		for i := range e.Boxes {
+			e.Boxes[i].Row = i + 1
+		}
+		for i := range e.Spans {
+			e.Spans[i].Row = i + 1
+		}
+		return e, nil
+	case FormatCSV:
+		cr := csv.NewReader(r)
+		cr.FieldsPerRecord = -1
+		records, err := cr.ReadAll()
+		if err != nil {
+			return e, err
+		}
+		for i, rec := range records {
+			line := i + 1
+			if len(rec) != len(csvHeader) && len(rec) != csvV1Fields {
+				return e, fmt.Errorf("line %d: expected %d fields, got %d", line, len(csvHeader), len(rec))
+			}
+			rec = append(rec, make([]string, len(csvHeader)-len(rec))...)
+			switch rec[0] {
+			case "kind":
+				if i != 0 {
+					return e, fmt.Errorf("line %d: unexpected header", line)
+				}
+			case "box":
+				var targets []string
+				if rec[8] != "" {
+					targets = strings.Split(rec[8], ",")
+				}
+				e.Boxes = append(e.Boxes, ExportedBox{Name: rec[1], Min: rec[2], Max: rec[3], Targets: targets, Archived: rec[9], Row: line})
+			case "span":
+				start, err := time.Parse(time.RFC3339, rec[4])
+				if err != nil {
+					return e, fmt.Errorf("line %d: %w", line, err)
+				}
+				end, err := time.Parse(time.RFC3339, rec[5])
+				if err != nil {
+					return e, fmt.Errorf("line %d: %w", line, err)
+				}
+				e.Spans = append(e.Spans, ExportedSpan{
+					Box:   rec[1],
+					Start: start,
+					End:   end,
+					TZ:    rec[10],
+					Notes: rec[6],
+					Tags:  ParseTags([]string{rec[7]}),
+					Row:   line,
+				})
+			default:
+				return e, fmt.Errorf("line %d: unknown kind %s", line, rec[0])
+			}
+		}
+		return e, nil
+	}
+	return e, fmt.Errorf("unknown format %s", format)
+}
+
+// ConflictPolicy decides what an import does with a box that already exists
+// or a span that overlaps an existing span
+type ConflictPolicy int
+
+const (
+	ConflictSkip    ConflictPolicy = iota // keep the existing data
+	ConflictFail                          // stop the import
+	ConflictReplace                       // update the box, delete the overlapping spans
+)
+
+func ParseConflictPolicy(s string) (ConflictPolicy, error) {
+	switch s {
+	case "skip":
+		return ConflictSkip, nil
+	case "fail":
+		return ConflictFail, nil
+	case "replace":
+		return ConflictReplace, nil
+	}
+	return ConflictSkip, fmt.Errorf("unknown conflict policy %s, expected skip, fail or replace", s)
+}
+
+var errConflict = errors.New("conflicts with existing data")
+
+type ImportError struct {
+	Kind string // "box" or "span"
+	Row  int
+	Err  error
+}
+
+func (e ImportError) Error() string {
+	if e.Kind == "" {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%s %d: %s", e.Kind, e.Row, e.Err)
+}
+
+type ImportResult struct {
+	BoxesAdded    int
+	BoxesUpdated  int
+	BoxesSkipped  int
+	SpansAdded    int
+	SpansReplaced int
+	SpansSkipped  int
+	Errors        []ImportError
+	Aborted       bool // nothing was imported, because of a conflict under ConflictFail or an error of the store
+}
+
+// importBox is a box an import adds, or updates under ConflictReplace
+type importBox struct {
+	box    Box
+	row    int
+	update bool
+}
+
+// importSpan is a span an import adds, replacing the spans it overlaps
+// under ConflictReplace
+type importSpan struct {
+	span    Span
+	row     int
+	replace []Span
+}
+
+// Import adds the boxes and spans of e as one change, which undo reverts
+// as a whole. All rows are checked first: rows that fail the checks of
+// TimeBox.AddBox and TimeBox.AddSpan are reported in the result and
+// skipped, and under ConflictFail nothing is imported if a row conflicts.
+func (tb TimeBox) Import(e Export, policy ConflictPolicy) ImportResult {
+	tb.Source = db.SourceImport
+	res, boxes, spans := tb.planImport(e, policy)
+	if res.Aborted {
+		return ImportResult{Errors: res.Errors, Aborted: true}
+	}
+	if len(boxes) == 0 && len(spans) == 0 {
+		return res
+	}
+	err := tb.applyImport(boxes, spans)
+	if err != nil {
+		var ie ImportError
+		if !errors.As(err, &ie) {
+			ie = ImportError{Err: err}
+		}
+		return ImportResult{Errors: append(res.Errors, ie), Aborted: true}
+	}
+	return res
+}
+
+// planImport checks the rows of e against the TimeBox and returns the boxes
+// and spans to write, parents before their children
+func (tb TimeBox) planImport(e Export, policy ConflictPolicy) (ImportResult, []importBox, []importSpan) {
+	var res ImportResult
+	var boxes []importBox
+	var spans []importSpan
+	fail := func(kind string, row int, err error) {
+		res.Errors = append(res.Errors, ImportError{Kind: kind, Row: row, Err: err})
+	}
+	conflict := func(kind string, row int, err error) {
+		fail(kind, row, err)
+		res.Aborted = true
+	}
+	timer, running, err := tb.RunningTimer()
+	if err != nil {
+		return ImportResult{Errors: []ImportError{{Err: err}}, Aborted: true}, nil, nil
+	}
+
+	planned := make(map[string]bool)
+	known := func(name string) bool {
+		_, ok := tb.Boxes[name]
+		return ok || planned[name]
+	}
+	sorted := append([]ExportedBox(nil), e.Boxes...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return strings.Count(sorted[i].Name, BoxPathSeparator) < strings.Count(sorted[j].Name, BoxPathSeparator)
+	})
+	for _, eb := range sorted {
+		box, err := eb.box()
+		if err != nil {
+			fail("box", eb.Row, err)
+			continue
+		}
+		current, exists := tb.Boxes[box.Name]
+		if exists && policy == ConflictSkip {
+			res.BoxesSkipped++
+			continue
+		}
+		if exists && policy == ConflictFail {
+			conflict("box", eb.Row, fmt.Errorf("box %s %w", box.Name, errConflict))
+			return res, nil, nil
+		}
+		if i := strings.LastIndex(box.Name, BoxPathSeparator); !exists && i >= 0 && !known(box.Name[:i]) {
+			fail("box", eb.Row, fmt.Errorf("parent box %s doesn't exist", box.Name[:i]))
+			continue
+		}
+		if running && box.Archived() && !current.Archived() && IsBoxInTree(timer.Box, box.Name) {
+			fail("box", eb.Row, fmt.Errorf("a timer is running for %s", timer.Box))
+			continue
+		}
+		boxes = append(boxes, importBox{box: box, row: eb.Row, update: exists})
+		planned[box.Name] = true
+		if exists {
+			res.BoxesUpdated++
+		} else {
+			res.BoxesAdded++
+		}
+	}
+
+	// the spans the import adds, with made up IDs
+	added := NewSpanSet()
+	for _, es := range e.Spans {
+		span := es.Span()
+		span.ID, span.Tags = 0, ParseTags(es.Tags)
+		if err := db.ValidateSpanTimes(span.Start.Unix(), span.End.Unix()); err != nil {
+			fail("span", es.Row, err)
+			continue
+		}
+		if !known(span.Box) {
+			fail("span", es.Row, fmt.Errorf("box %s doesn't exist", span.Box))
+			continue
+		}
+		overlapping := tb.overlapping(span)
+		if len(overlapping) > 0 || len(added.Overlapping(span)) > 0 {
+			switch policy {
+			case ConflictSkip:
+				res.SpansSkipped++
+				continue
+			case ConflictFail:
+				conflict("span", es.Row, fmt.Errorf("span %s %w", span.Start.Format(time.RFC3339), errConflict))
+				return res, nil, nil
+			}
+			if len(added.Overlapping(span)) > 0 {
+				fail("span", es.Row, errors.New("span overlaps another span of the import"))
+				continue
+			}
+		}
+		spans = append(spans, importSpan{span: span, row: es.Row, replace: overlapping})
+		res.SpansAdded++
+		res.SpansReplaced += len(overlapping)
+		span.ID = -int64(len(spans))
+		added.Add(span)
+	}
+	return res, boxes, spans
+}
+
+// applyImport writes the checked boxes and spans of an import in one
+// change and updates the TimeBox to it, nothing is written if the store
+// refuses any of them
+func (tb TimeBox) applyImport(boxes []importBox, spans []importSpan) error {
+	var names []string
+	for _, ib := range boxes {
+		names = append(names, ib.box.Name)
+	}
+	description := fmt.Sprintf("import %d box(es) and %d span(s)", len(boxes), len(spans))
+	var keys db.ImageKeys
+	var after db.Image
+	err := tb.store.InTx(tb.ctx, func(s db.Store) error {
+		var err error
+		keys, err = tb.boxKeys(s, false, names)
+		if err != nil {
+			return err
+		}
+		for _, is := range spans {
+			for _, old := range is.replace {
+				keys.Spans = append(keys.Spans, old.ID)
+			}
+		}
+		keys, after, err = tb.record(s, description, keys, func(s db.Store) (db.ImageKeys, error) {
+			for _, ib := range boxes {
+				if err := tb.writeImportedBox(s, ib.box, ib.update); err != nil {
+					return db.ImageKeys{}, ImportError{Kind: "box", Row: ib.row, Err: err}
+				}
+			}
+			// the archive state is set once all boxes exist, since archiving
+			// a box reaches its sub-boxes and unarchiving it its parents.
+			// Sub-boxes go first, so that they keep their own archive time.
+			for i := len(boxes) - 1; i >= 0; i-- {
+				box := boxes[i].box
+				current, err := s.GetBox(tb.ctx, box.Name)
+				if err != nil {
+					return db.ImageKeys{}, err
+				}
+				switch archived := current.ArchiveTime != 0; {
+				case box.Archived() && !archived:
+					err = s.ArchiveBox(tb.ctx, box.Name, box.ArchiveTime.Unix())
+				case !box.Archived() && archived:
+					err = s.UnarchiveBox(tb.ctx, box.Name)
+				}
+				if err != nil {
+					return db.ImageKeys{}, ImportError{Kind: "box", Row: boxes[i].row, Err: err}
+				}
+			}
+			created, err := tb.boxKeys(s, false, names)
+			if err != nil {
+				return created, err
+			}
+			for _, is := range spans {
+				changes := db.SpanChanges{Add: []db.SpanRow{is.span.row()}}
+				for _, old := range is.replace {
+					changes.Delete = append(changes.Delete, old.ID)
+				}
+				ids, err := s.ApplySpanChanges(tb.ctx, changes)
+				if err != nil {
+					return created, ImportError{Kind: "span", Row: is.row, Err: err}
+				}
+				created.Spans = append(created.Spans, ids[0])
+			}
+			return created, nil
+		})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	tb.apply(keys, after)
+	return nil
+}
+
+// writeImportedBox adds a box or updates an existing one, along with its
+// targets
+func (tb TimeBox) writeImportedBox(s db.Store, box Box, update bool) error {
+	write := s.AddBox
+	if update {
+		write = s.UpdateBox
+	}
+	err := write(tb.ctx, box.Name, int64(box.MinTime.Seconds()), int64(box.MaxTime.Seconds()))
+	if err != nil {
+		return err
+	}
+	return s.SetBoxTargets(tb.ctx, box.Name, box.targetRows())
+}
+
+func (eb ExportedBox) box() (Box, error) {
+	name, err := NormalizeBoxPath(eb.Name)
+	if err != nil {
+		return Box{}, err
+	}
+	min, err := time.ParseDuration(eb.Min)
+	if err != nil {
+		return Box{}, fmt.Errorf("invalid min time: %w", err)
+	}
+	max, err := time.ParseDuration(eb.Max)
+	if err != nil {
+		return Box{}, fmt.Errorf("invalid max time: %w", err)
+	}
+	if min > max {
+		return Box{}, errors.New("min time is greater than max time")
+	}
+	box := Box{Name: name, MinTime: min, MaxTime: max}
+	for _, s := range eb.Targets {
+		p, t, err := ParseTarget(s)
+		if err != nil {
+			return Box{}, err
+		}
+		if p == Week {
+			return Box{}, fmt.Errorf("invalid target \"%s\", the week target is the min and max time", s)
+		}
+		box.SetTarget(p, t)
+	}
+	if eb.Archived != "" {
+		box.ArchiveTime, err = time.Parse(time.RFC3339, eb.Archived)
+		if err != nil {
+			return Box{}, fmt.Errorf("invalid archive time: %w", err)
+		}
+	}
+	return box, nil
+}
@@ -0,0 +1,208 @@
+package util
+
+import (
+	"bytes"
+	"github.com/aldernero/timebox/pkg/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func exchangeFixture(t *testing.T) (TimeBox, time.Time) {
+	tb := TimeBoxFromDB(ctx, db.NewMemoryStore())
+	require.NoError(t, tb.AddBox(Box{Name: "Work", MinTime: 30 * time.Hour, MaxTime: 40 * time.Hour}))
+	require.NoError(t, tb.AddBox(Box{Name: "Work/ClientA", MinTime: 0, MaxTime: 10 * time.Hour}))
+	require.NoError(t, tb.AddBox(Box{Name: "Piano", MinTime: time.Hour, MaxTime: 2 * time.Hour}))
+	start := time.Date(2023, time.March, 1, 9, 0, 0, 0, time.Local)
+	require.NoError(t, tb.AddSpan(Span{Start: start, End: start.Add(time.Hour), Notes: "standup, planning"}, "Work/ClientA"))
+	require.NoError(t, tb.AddSpan(Span{Start: start.Add(2 * time.Hour), End: start.Add(3 * time.Hour), Tags: []string{"a", "b"}}, "Piano"))
+	return tb.Reload(), start
+}
+
+func TestExport_RoundTrip(t *testing.T) {
+	for _, format := range []string{FormatCSV, FormatJSON} {
+		t.Run(format, func(t *testing.T) {
+			tb, start := exchangeFixture(t)
+			var buf bytes.Buffer
+			all := Span{End: time.Now()}
+			require.NoError(t, tb.Export(all, "").Write(&buf, format))
+
+			e, err := ReadExport(&buf, format)
+			require.NoError(t, err)
+			target := TimeBoxFromDB(ctx, db.NewMemoryStore())
+			res := target.Import(e, ConflictFail)
+			assert.Empty(t, res.Errors)
+			assert.Equal(t, 3, res.BoxesAdded)
+			assert.Equal(t, 2, res.SpansAdded)
+
+			target = target.Reload()
+			assert.Equal(t, tb.Boxes, target.Boxes)
+			spans := target.GetSpansForTimespan(all)
+			spans.SortByStart()
+			require.Equal(t, 2, spans.Size())
+			assert.Equal(t, "Work/ClientA", spans.Spans[0].Box)
+			assert.Equal(t, "standup, planning", spans.Spans[0].Notes)
+			assert.True(t, spans.Spans[0].Start.Equal(start))
+			assert.Equal(t, []string{"a", "b"}, spans.Spans[1].Tags)
+		})
+	}
+}
+
+func TestExport_TargetsArchiveAndZone(t *testing.T) {
+	ny := LoadZone("America/New_York")
+	require.NotNil(t, ny)
+	for _, format := range []string{FormatCSV, FormatJSON} {
+		t.Run(format, func(t *testing.T) {
+			tb := TimeBoxFromDB(ctx, db.NewMemoryStore())
+			require.NoError(t, tb.AddBox(Box{Name: "Work", MinTime: time.Hour, MaxTime: 2 * time.Hour, Targets: map[Period]Target{Month: {Min: 20 * time.Hour, Max: 30 * time.Hour}}}))
+			require.NoError(t, tb.AddBox(Box{Name: "Old", MaxTime: time.Hour}))
+			require.NoError(t, tb.AddBox(Box{Name: "Old/Sub", MaxTime: time.Hour}))
+			start := time.Date(2023, time.March, 1, 9, 0, 0, 0, ny)
+			require.NoError(t, tb.AddSpan(Span{Start: start, End: start.Add(time.Hour)}, "Old/Sub"))
+			archived := time.Date(2023, time.April, 1, 0, 0, 0, 0, time.UTC)
+			require.NoError(t, tb.ArchiveBox("Old/Sub", archived.Add(-time.Hour)))
+			require.NoError(t, tb.ArchiveBox("Old", archived))
+			tb = tb.Reload()
+
+			var buf bytes.Buffer
+			require.NoError(t, tb.Export(Span{End: time.Now()}, "").Write(&buf, format))
+			e, err := ReadExport(&buf, format)
+			require.NoError(t, err)
+			target := TimeBoxFromDB(ctx, db.NewMemoryStore())
+			res := target.Import(e, ConflictFail)
+			require.Empty(t, res.Errors)
+
+			target = target.Reload()
+			assert.Equal(t, tb.Boxes, target.Boxes)
+			require.Len(t, target.SpansSets["Old/Sub"].Spans, 1)
+			span := target.SpansSets["Old/Sub"].Spans[0]
+			assert.Equal(t, "America/New_York", span.Start.Location().String())
+			assert.Equal(t, 9, span.Start.Hour())
+		})
+	}
+}
+
+func TestImport_ReplaceIsAtomic(t *testing.T) {
+	tb, start := exchangeFixture(t)
+	e := Export{Spans: []ExportedSpan{{Box: "Nope", Start: start, End: start.Add(3 * time.Hour), Row: 1}}}
+	res := tb.Import(e, ConflictReplace)
+	require.Len(t, res.Errors, 1)
+	assert.Zero(t, res.SpansReplaced)
+	tb = tb.Reload()
+	assert.Len(t, tb.Spans, 2)
+
+	e.Spans[0].Box = "Piano"
+	res = tb.Import(e, ConflictReplace)
+	require.Empty(t, res.Errors)
+	assert.Equal(t, 2, res.SpansReplaced)
+	desc, err := tb.Undo()
+	require.NoError(t, err)
+	assert.Equal(t, "import 0 box(es) and 1 span(s)", desc)
+	tb = tb.Reload()
+	assert.Len(t, tb.Spans, 2)
+	assert.Len(t, tb.SpansSets["Work/ClientA"].Spans, 1)
+}
+
+func TestImport_IsOneChange(t *testing.T) {
+	tbdb := openDB(t, filepath.Join(t.TempDir(), dbName))
+	for _, store := range []db.Store{tbdb, db.NewMemoryStore()} {
+		tb := TimeBoxFromDB(ctx, store)
+		require.NoError(t, tb.AddBox(Box{Name: "Piano", MaxTime: time.Hour}))
+		start := time.Date(2023, time.March, 1, 9, 0, 0, 0, time.UTC)
+		require.NoError(t, tb.AddSpan(Span{Start: start, End: start.Add(time.Hour)}, "Piano"))
+		e := Export{
+			Boxes: []ExportedBox{{Name: "Music", Min: "0s", Max: "1h0m0s", Row: 1}},
+			Spans: []ExportedSpan{
+				{Box: "Music", Start: start.Add(2 * time.Hour), End: start.Add(3 * time.Hour), Row: 1},
+				{Box: "Music", Start: start.Add(30 * time.Minute), End: start.Add(90 * time.Minute), Row: 2},
+			},
+		}
+
+		// a conflict after the first rows leaves everything as it was
+		res := tb.Import(e, ConflictFail)
+		assert.True(t, res.Aborted)
+		require.Len(t, res.Errors, 1)
+		assert.Equal(t, 2, res.Errors[0].Row)
+		tb = tb.Reload()
+		assert.NotContains(t, tb.Boxes, "Music")
+		assert.Len(t, tb.Spans, 1)
+
+		res = tb.Import(e, ConflictSkip)
+		require.Empty(t, res.Errors)
+		assert.Equal(t, 1, res.SpansSkipped)
+		tb = tb.Reload()
+		assert.Len(t, tb.SpansSets["Music"].Spans, 1)
+		desc, err := tb.Undo()
+		require.NoError(t, err)
+		assert.Equal(t, "import 1 box(es) and 1 span(s)", desc)
+		tb = tb.Reload()
+		assert.NotContains(t, tb.Boxes, "Music")
+		assert.Len(t, tb.Spans, 1)
+	}
+}
+
+func TestExport_Filter(t *testing.T) {
+	tb, start := exchangeFixture(t)
+	e := tb.Export(Span{End: time.Now()}, "Work/ClientA")
+	require.Equal(t, 2, len(e.Boxes))
+	assert.Equal(t, "Work", e.Boxes[0].Name)
+	assert.Equal(t, "Work/ClientA", e.Boxes[1].Name)
+	assert.Equal(t, 1, len(e.Spans))
+
+	e = tb.Export(Span{Start: start.Add(90 * time.Minute), End: time.Now()}, "")
+	require.Equal(t, 1, len(e.Spans))
+	assert.Equal(t, "Piano", e.Spans[0].Box)
+}
+
+func TestImport_Conflicts(t *testing.T) {
+	tb, start := exchangeFixture(t)
+	data := `kind,box,min,max,start,end,notes,tags
+box,Piano,3h0m0s,4h0m0s,,,,
+span,Piano,,,` + start.Add(150*time.Minute).Format(time.RFC3339) + `,` + start.Add(4*time.Hour).Format(time.RFC3339) + `,,
+span,Nope,,,` + start.Add(5*time.Hour).Format(time.RFC3339) + `,` + start.Add(6*time.Hour).Format(time.RFC3339) + `,,
+span,Piano,,,` + time.Now().Add(time.Hour).Format(time.RFC3339) + `,` + time.Now().Add(2*time.Hour).Format(time.RFC3339) + `,,
+`
+	read := func() Export {
+		e, err := ReadExport(strings.NewReader(data), FormatCSV)
+		require.NoError(t, err)
+		return e
+	}
+
+	res := tb.Import(read(), ConflictSkip)
+	assert.Equal(t, 1, res.BoxesSkipped)
+	assert.Equal(t, 1, res.SpansSkipped)
+	require.Equal(t, 2, len(res.Errors))
+	assert.Equal(t, 4, res.Errors[0].Row)
+	assert.Equal(t, 5, res.Errors[1].Row)
+	assert.EqualError(t, res.Errors[1], "span 5: time span is in the future")
+
+	res = tb.Import(read(), ConflictFail)
+	assert.True(t, res.Aborted)
+	require.Equal(t, 1, len(res.Errors))
+	assert.Equal(t, 2, res.Errors[0].Row)
+
+	res = tb.Import(read(), ConflictReplace)
+	assert.False(t, res.Aborted)
+	assert.Equal(t, 1, res.BoxesUpdated)
+	assert.Equal(t, 1, res.SpansReplaced)
+	assert.Equal(t, 1, res.SpansAdded)
+	tb = tb.Reload()
+	assert.Equal(t, 3*time.Hour, tb.Boxes["Piano"].MinTime)
+	piano := tb.SpansSets["Piano"]
+	require.Equal(t, 1, piano.Size())
+	assert.True(t, piano.Spans[0].Start.Equal(start.Add(150*time.Minute)))
+}
+
+func TestReadExport_Errors(t *testing.T) {
+	_, err := ReadExport(strings.NewReader("kind,box,min,max,start,end,notes,tags\nspan,Piano,,,yesterday,,,\n"), FormatCSV)
+	assert.ErrorContains(t, err, "line 2")
+	_, err = ReadExport(strings.NewReader("kind,box,min,max,start,end,notes,tags\nbox,Piano,1h,2h,,,,,\n"), FormatCSV)
+	assert.ErrorContains(t, err, "line 2: expected 11 fields, got 9")
+	_, err = ReadExport(strings.NewReader("{}"), "xml")
+	assert.Error(t, err)
+	_, err = ParseConflictPolicy("merge")
+	assert.Error(t, err)
+}
@@ -0,0 +1,85 @@
+package util
+
+import (
+	"github.com/aldernero/timebox/pkg/db"
+	"time"
+)
+
+// Version is a box or a span as a change left it. BoxName is set for
+// versions of boxes and SpanID for versions of spans, Box or Span holds the
+// version and is nil for deleted ones.
+type Version struct {
+	Time        time.Time
+	Source      string
+	Operation   string
+	Description string
+	BoxName     string
+	SpanID      int64
+	Box         *Box
+	Span        *Span
+}
+
+func versionFromRow(vr db.VersionRow) (Version, error) {
+	v := Version{
+		Time:        time.Unix(vr.Time, 0),
+		Source:      vr.Source,
+		Operation:   vr.Operation,
+		Description: vr.Description,
+		BoxName:     vr.BoxName,
+		SpanID:      vr.SpanID,
+	}
+	if vr.Box != nil {
+		targets := make(map[Period]Target)
+		for _, tr := range vr.Box.Targets {
+			p, err := ParsePeriod(tr.Period)
+			if err != nil {
+				return v, err
+			}
+			targets[p] = Target{
+				Min: time.Duration(tr.MinTime) * time.Second,
+				Max: time.Duration(tr.MaxTime) * time.Second,
+			}
+		}
+		if len(targets) == 0 {
+			targets = nil
+		}
+		box := boxFromRow(vr.Box.BoxRow, targets)
+		v.Box = &box
+	}
+	if vr.Span != nil {
+		span := spanFromRow(*vr.Span)
+		v.Span = &span
+	}
+	return v, nil
+}
+
+func versionsFromRows(vrs []db.VersionRow, err error) ([]Version, error) {
+	if err != nil {
+		return nil, err
+	}
+	result := make([]Version, 0, len(vrs))
+	for _, vr := range vrs {
+		v, err := versionFromRow(vr)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, v)
+	}
+	return result, nil
+}
+
+// History returns the versions of boxes and spans made since a time, oldest
+// first
+func (tb TimeBox) History(since time.Time) ([]Version, error) {
+	return versionsFromRows(tb.store.GetHistory(tb.ctx, since.Unix()))
+}
+
+// SpanHistory returns the versions of a span, oldest first
+func (tb TimeBox) SpanHistory(id int64) ([]Version, error) {
+	return versionsFromRows(tb.store.GetSpanHistory(tb.ctx, id))
+}
+
+// BoxHistory returns the versions of a box, oldest first
+func (tb TimeBox) BoxHistory(name string) ([]Version, error) {
+	return versionsFromRows(tb.store.GetBoxHistory(tb.ctx, name))
+}
@@ -0,0 +1,182 @@
+package util
+
+import (
+	"github.com/aldernero/timebox/pkg/db"
+	"time"
+)
+
+// journal makes a change of the boxes and spans named by keys and records
+// it as an operation that can be undone, all in one transaction of the
+// store. change makes the change through the store it is given and returns
+// the keys of the boxes and spans it creates. The boxes and spans of the
+// TimeBox are updated to the change.
+func (tb TimeBox) journal(description string, keys db.ImageKeys, change func(s db.Store) (db.ImageKeys, error)) error {
+	var after db.Image
+	err := tb.store.InTx(tb.ctx, func(s db.Store) error {
+		var err error
+		keys, after, err = tb.record(s, description, keys, change)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	tb.apply(keys, after)
+	return nil
+}
+
+// record makes a change through s and journals it, s runs in the
+// transaction of the change. It returns the keys of the change with the
+// ones it created, and the image after it.
+func (tb TimeBox) record(s db.Store, description string, keys db.ImageKeys, change func(s db.Store) (db.ImageKeys, error)) (db.ImageKeys, db.Image, error) {
+	before, err := s.GetImage(tb.ctx, keys)
+	if err != nil {
+		return keys, db.Image{}, err
+	}
+	created, err := change(s)
+	if err != nil {
+		return keys, db.Image{}, err
+	}
+	keys.Boxes = append(keys.Boxes, created.Boxes...)
+	keys.Spans = append(keys.Spans, created.Spans...)
+	after, err := s.GetImage(tb.ctx, keys)
+	if err != nil {
+		return keys, after, err
+	}
+	_, err = s.AddOperation(tb.ctx, db.OperationRow{
+		Time:        time.Now().Unix(),
+		Description: description,
+		Source:      tb.source(),
+		Keys:        keys,
+		Before:      before,
+		After:       after,
+	})
+	return keys, after, err
+}
+
+// journalBoxes journals a change of the boxes in the trees of the given
+// boxes and of their parents, which renaming, archiving and deleting reach.
+// With spans, the spans of the trees are journaled too.
+func (tb TimeBox) journalBoxes(description string, spans bool, change func(s db.Store) error, trees ...string) error {
+	var keys db.ImageKeys
+	var after db.Image
+	err := tb.store.InTx(tb.ctx, func(s db.Store) error {
+		var err error
+		keys, err = tb.boxKeys(s, spans, trees)
+		if err != nil {
+			return err
+		}
+		keys, after, err = tb.record(s, description, keys, func(s db.Store) (db.ImageKeys, error) {
+			err := change(s)
+			if err != nil {
+				return db.ImageKeys{}, err
+			}
+			// boxes created under the trees or as their parents
+			return tb.boxKeys(s, false, trees)
+		})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	tb.apply(keys, after)
+	return nil
+}
+
+// apply makes the boxes and spans of the TimeBox named by keys match image,
+// which holds the ones that exist. The Names of the TimeBox only change with
+// Refresh or Reload.
+func (tb TimeBox) apply(keys db.ImageKeys, image db.Image) {
+	for _, id := range keys.Spans {
+		if old, ok := tb.Spans[id]; ok {
+			tb.removeSpan(old)
+		}
+	}
+	boxes := make(map[string]db.BoxImage, len(image.Boxes))
+	for _, b := range image.Boxes {
+		boxes[b.Name] = b
+	}
+	for _, name := range keys.Boxes {
+		b, ok := boxes[name]
+		if !ok {
+			delete(tb.Boxes, name)
+			delete(tb.SpansSets, name)
+			continue
+		}
+		tb.Boxes[name] = boxFromImage(b)
+		if _, ok := tb.SpansSets[name]; !ok {
+			tb.SpansSets[name] = NewSpanSet()
+		}
+	}
+	for _, sr := range image.Spans {
+		if _, ok := tb.Spans[sr.ID]; !ok {
+			tb.addSpan(spanFromRow(sr))
+		}
+	}
+}
+
+// boxKeys returns the keys of the boxes in the trees and their parents, and
+// with spans the keys of the spans of the trees
+func (tb TimeBox) boxKeys(s db.Store, spans bool, trees []string) (db.ImageKeys, error) {
+	var keys db.ImageKeys
+	boxes, err := s.GetAllBoxes(tb.ctx)
+	if err != nil {
+		return keys, err
+	}
+	for _, b := range boxes {
+		related, inTree := false, false
+		for _, tree := range trees {
+			inTree = inTree || IsBoxInTree(b.Name, tree)
+			related = related || inTree || IsBoxInTree(tree, b.Name)
+		}
+		if !related {
+			continue
+		}
+		keys.Boxes = append(keys.Boxes, b.Name)
+		if !spans || !inTree {
+			continue
+		}
+		rows, err := s.GetSpansForBox(tb.ctx, b.Name)
+		if err != nil {
+			return keys, err
+		}
+		for _, sr := range rows {
+			keys.Spans = append(keys.Spans, sr.ID)
+		}
+	}
+	return keys, nil
+}
+
+func (tb TimeBox) source() string {
+	if tb.Source == "" {
+		return db.SourceAPI
+	}
+	return tb.Source
+}
+
+// journalSpans journals a change of the spans with the given IDs
+func (tb TimeBox) journalSpans(description string, change func(s db.Store) error, ids ...int64) error {
+	return tb.journal(description, db.ImageKeys{Spans: ids}, func(s db.Store) (db.ImageKeys, error) {
+		return db.ImageKeys{}, change(s)
+	})
+}
+
+// Undo reverts the last change that wasn't undone and returns its
+// description
+func (tb TimeBox) Undo() (string, error) {
+	op, err := tb.store.UndoOperation(tb.ctx, tb.source())
+	if err != nil {
+		return "", err
+	}
+	tb.apply(op.Keys, op.Before)
+	return op.Description, nil
+}
+
+// Redo makes the first undone change again and returns its description
+func (tb TimeBox) Redo() (string, error) {
+	op, err := tb.store.RedoOperation(tb.ctx, tb.source())
+	if err != nil {
+		return "", err
+	}
+	tb.apply(op.Keys, op.After)
+	return op.Description, nil
+}
@@ -0,0 +1,165 @@
+package util
+
+import (
+	"github.com/aldernero/timebox/pkg/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTimeBox_UndoRedo(t *testing.T) {
+	tbdb := openDB(t, filepath.Join(t.TempDir(), dbName))
+	for _, store := range []db.Store{tbdb, db.NewMemoryStore()} {
+		tb := TimeBoxFromDB(ctx, store)
+		_, err := tb.Undo()
+		assert.ErrorIs(t, err, db.ErrNothingToUndo)
+
+		require.NoError(t, tb.AddBox(Box{Name: "Work", MaxTime: time.Hour}))
+		start := time.Date(2023, time.March, 1, 9, 0, 0, 0, time.UTC)
+		for i := 0; i < 2; i++ {
+			s := start.Add(time.Duration(i) * time.Hour)
+			require.NoError(t, tb.AddSpan(Span{Start: s, End: s.Add(time.Hour)}, "Work"))
+		}
+		tb = tb.Reload()
+		merge, err := MergeSpans(tb.SpansSets["Work"].Spans)
+		require.NoError(t, err)
+		require.NoError(t, tb.ApplyEdits([]SpanEdit{merge}))
+		require.NoError(t, tb.RenameBox("Work", "Job"))
+
+		desc, err := tb.Undo()
+		require.NoError(t, err)
+		assert.Equal(t, "rename box Work to Job", desc)
+		desc, err = tb.Undo()
+		require.NoError(t, err)
+		assert.Equal(t, "merge 2 spans of Work", desc)
+		tb = tb.Reload()
+		assert.Len(t, tb.SpansSets["Work"].Spans, 2)
+		assert.NotContains(t, tb.Boxes, "Job")
+
+		desc, err = tb.Redo()
+		require.NoError(t, err)
+		assert.Equal(t, "merge 2 spans of Work", desc)
+		tb = tb.Reload()
+		assert.Len(t, tb.SpansSets["Work"].Spans, 1)
+
+		// a new change drops what's left to redo
+		require.NoError(t, tb.DeleteBoxAndSpans("Work"))
+		_, err = tb.Redo()
+		assert.ErrorIs(t, err, db.ErrNothingToRedo)
+		_, err = tb.Undo()
+		require.NoError(t, err)
+		tb = tb.Reload()
+		assert.Equal(t, time.Hour, tb.Boxes["Work"].MaxTime)
+		assert.Len(t, tb.SpansSets["Work"].Spans, 1)
+	}
+}
+
+func TestTimeBox_History(t *testing.T) {
+	tbdb := openDB(t, filepath.Join(t.TempDir(), dbName))
+	for _, store := range []db.Store{tbdb, db.NewMemoryStore()} {
+		tb := TimeBoxFromDB(ctx, store)
+		start := time.Now()
+		require.NoError(t, tb.AddBox(Box{Name: "Work", Targets: map[Period]Target{Month: {Min: time.Hour, Max: 2 * time.Hour}}}))
+		s := time.Date(2023, time.March, 1, 9, 0, 0, 0, time.UTC)
+		require.NoError(t, tb.AddSpan(Span{Start: s, End: s.Add(time.Hour)}, "Work"))
+		tb = tb.Reload()
+		span := tb.SpansSets["Work"].Spans[0]
+		span.End = s.Add(2 * time.Hour)
+		tb.Source = db.SourceTUI
+		require.NoError(t, tb.UpdateSpan(span))
+
+		export := Export{Spans: []ExportedSpan{{Box: "Work", Start: s.Add(3 * time.Hour), End: s.Add(4 * time.Hour)}}}
+		res := tb.Import(export, ConflictSkip)
+		require.Empty(t, res.Errors)
+
+		versions, err := tb.SpanHistory(span.ID)
+		require.NoError(t, err)
+		require.Len(t, versions, 2)
+		assert.Equal(t, db.VersionAdded, versions[0].Operation)
+		assert.Equal(t, db.SourceAPI, versions[0].Source)
+		assert.Equal(t, "update span 1", versions[1].Description)
+		assert.Equal(t, db.SourceTUI, versions[1].Source)
+		assert.Equal(t, 2*time.Hour, versions[1].Span.Duration())
+
+		versions, err = tb.BoxHistory("Work")
+		require.NoError(t, err)
+		require.Len(t, versions, 1)
+		assert.Equal(t, time.Hour, versions[0].Box.Targets[Month].Min)
+
+		versions, err = tb.History(start)
+		require.NoError(t, err)
+		require.Len(t, versions, 4)
+		assert.Equal(t, db.SourceImport, versions[3].Source)
+	}
+}
+
+func TestTimeBox_UndoStopTimer(t *testing.T) {
+	tbdb := openDB(t, filepath.Join(t.TempDir(), dbName))
+	for _, store := range []db.Store{tbdb, db.NewMemoryStore()} {
+		tb := TimeBoxFromDB(ctx, store)
+		require.NoError(t, tb.AddBox(Box{Name: "Work", MaxTime: time.Hour}))
+		start := time.Now().Add(-time.Hour).Truncate(time.Second)
+		require.NoError(t, tb.StartTimer("Work", start))
+		_, err := tb.StopTimer(time.Now())
+		require.NoError(t, err)
+
+		desc, err := tb.Undo()
+		require.NoError(t, err)
+		assert.Equal(t, "stop timer", desc)
+		timer, running, err := tb.RunningTimer()
+		require.NoError(t, err)
+		require.True(t, running)
+		assert.True(t, timer.Start.Equal(start))
+		assert.Empty(t, tb.Reload().SpansSets["Work"].Spans)
+	}
+}
+
+func TestTimeBox_JournalBoxKeys(t *testing.T) {
+	store := db.NewMemoryStore()
+	tb := TimeBoxFromDB(ctx, store)
+	for _, name := range []string{"Work", "Work/A", "Work/A/X", "Work/B", "Piano"} {
+		require.NoError(t, tb.AddBox(Box{Name: name, MaxTime: time.Hour}))
+	}
+	require.NoError(t, tb.UpdateBox(Box{Name: "Work/A", MaxTime: 2 * time.Hour}))
+	require.NoError(t, tb.RenameBox("Work/A", "Work/C"))
+	ops, err := store.GetOperations(ctx)
+	require.NoError(t, err)
+	require.Len(t, ops, 7)
+	// the box, its sub-boxes and its parents
+	assert.Equal(t, []string{"Work", "Work/A", "Work/A/X"}, ops[5].Keys.Boxes)
+	assert.Equal(t, []string{"Work", "Work/A", "Work/A/X", "Work/C", "Work/C/X"}, ops[6].Keys.Boxes)
+}
+
+func TestTimeBox_JournalFailedChange(t *testing.T) {
+	tbdb := openDB(t, filepath.Join(t.TempDir(), dbName))
+	for _, store := range []db.Store{tbdb, db.NewMemoryStore()} {
+		tb := TimeBoxFromDB(ctx, store)
+		start := time.Now()
+		err := tb.journal("add box Work", db.ImageKeys{Boxes: []string{"Work"}}, func(s db.Store) (db.ImageKeys, error) {
+			err := s.AddBox(ctx, "Work", 0, int64(time.Hour))
+			require.NoError(t, err)
+			return db.ImageKeys{}, assert.AnError
+		})
+		assert.ErrorIs(t, err, assert.AnError)
+		// neither the change nor its history are left behind
+		assert.NotContains(t, tb.Reload().Boxes, "Work")
+		versions, err := tb.History(start)
+		require.NoError(t, err)
+		assert.Empty(t, versions)
+		_, err = tb.Undo()
+		assert.ErrorIs(t, err, db.ErrNothingToUndo)
+	}
+}
+
+func TestTimeBox_DeleteMissingSpan(t *testing.T) {
+	tbdb := openDB(t, filepath.Join(t.TempDir(), dbName))
+	for _, store := range []db.Store{tbdb, db.NewMemoryStore()} {
+		tb := TimeBoxFromDB(ctx, store)
+		assert.EqualError(t, tb.DeleteSpanByID(42), "span 42 doesn't exist")
+		// nothing is journaled
+		_, err := tb.Undo()
+		assert.ErrorIs(t, err, db.ErrNothingToUndo)
+	}
+}
@@ -0,0 +1,256 @@
+package util
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ParseLogEntry parses a one-line entry like "45m piano yesterday evening
+// #scales" or "Work 9:00-12:30" into a span. An entry has a duration or a
+// range of times of day, a box matched against names with MatchBox, an
+// optional time expression and #tags, in any order.
+//
+// A duration ends now, or on the given day at the current time of day, or
+// starts at the given time of day. A range is on the given day, today by
+// default, and ends the next day when its end is before its start.
+func ParseLogEntry(entry string, names []string, parser TimeParser) (Span, error) {
+	var (
+		span     Span
+		tags     []string
+		duration time.Duration
+		from, to string
+		rest     []string
+	)
+	for _, tok := range strings.Fields(entry) {
+		switch {
+		case strings.HasPrefix(tok, "#"):
+			tags = append(tags, tok)
+			continue
+		case tok[0] != '+' && tok[0] != '-':
+			if d, err := time.ParseDuration(tok); err == nil {
+				if duration != 0 {
+					return span, fmt.Errorf("more than one duration in \"%s\"", entry)
+				}
+				duration = d
+				continue
+			}
+			if a, b, ok := parseClockRange(tok); ok {
+				if from != "" {
+					return span, fmt.Errorf("more than one range in \"%s\"", entry)
+				}
+				from, to = a, b
+				continue
+			}
+		}
+		rest = append(rest, tok)
+	}
+	if duration == 0 && from == "" {
+		return span, fmt.Errorf("no duration like 45m or range like 9:00-12:30 in \"%s\"", entry)
+	}
+	if duration != 0 && from != "" {
+		return span, fmt.Errorf("both a duration and a range in \"%s\"", entry)
+	}
+	box, when, err := splitLogWords(rest, names)
+	if err != nil {
+		return span, err
+	}
+	var hasClock bool
+	for _, tok := range when {
+		if _, isClock := timeWord(tok); isClock {
+			hasClock = true
+		}
+	}
+	expr := strings.Join(when, " ")
+	switch {
+	case from != "":
+		if hasClock {
+			return span, fmt.Errorf("a range can't have another time of day in \"%s\"", entry)
+		}
+		if span.Start, err = parser.Parse(strings.TrimSpace(expr + " " + from)); err != nil {
+			return span, err
+		}
+		if span.End, err = parser.Parse(strings.TrimSpace(expr + " " + to)); err != nil {
+			return span, err
+		}
+		if !span.End.After(span.Start) {
+			span.End = span.End.AddDate(0, 0, 1)
+		}
+	case hasClock:
+		if span.Start, err = parser.Parse(expr); err != nil {
+			return span, err
+		}
+		span.End = span.Start.Add(duration)
+	default:
+		// on the given day at the current time of day
+		span.End = parser.Now()
+		if expr != "" {
+			if span.End, err = parser.Parse(expr + " " + span.End.Format(time.TimeOnly)); err != nil {
+				return span, err
+			}
+		}
+		span.Start = span.End.Add(-duration)
+	}
+	span.Box = box
+	span.Tags = ParseTags(tags)
+	return span, nil
+}
+
+// parseClockRange parses a range of times of day like 9:00-12:30 or 9am-11am
+func parseClockRange(s string) (string, string, bool) {
+	a, b, ok := strings.Cut(s, "-")
+	if !ok {
+		return "", "", false
+	}
+	_, okA := parseClock(a)
+	_, okB := parseClock(b)
+	return a, b, okA && okB
+}
+
+// splitLogWords splits the words of a log entry into the box and the time
+// expression. Box names made of time words like "Morning Run" are matched
+// first, the other words are matched with MatchBox.
+func splitLogWords(words []string, names []string) (string, []string, error) {
+	lower := make([]string, len(words))
+	for i, w := range words {
+		lower[i] = strings.ToLower(w)
+	}
+	var (
+		found      []string
+		at, length int
+	)
+	for _, name := range names {
+		box := Box{Name: name}
+		for _, phrase := range [][]string{strings.Fields(strings.ToLower(name)), strings.Fields(strings.ToLower(box.Leaf()))} {
+			i := indexWords(lower, phrase)
+			if i < 0 || len(phrase) < length {
+				continue
+			}
+			if len(phrase) > length {
+				found, at, length = nil, i, len(phrase)
+			}
+			if !containsName(found, name) {
+				found = append(found, name)
+			}
+		}
+	}
+	if len(found) == 1 {
+		when := append(append([]string{}, words[:at]...), words[at+length:]...)
+		return found[0], when, nil
+	}
+	if len(found) > 1 {
+		return "", nil, ambiguousBox(strings.Join(words[at:at+length], " "), found)
+	}
+	var query, when []string
+	for _, w := range words {
+		if isTime, _ := timeWord(w); isTime {
+			when = append(when, w)
+		} else {
+			query = append(query, w)
+		}
+	}
+	if len(query) == 0 {
+		return "", nil, fmt.Errorf("no box given")
+	}
+	box, err := MatchBox(strings.Join(query, " "), names)
+	return box, when, err
+}
+
+// MatchBox finds the box a query like "pia" or "mus/pno" refers to. Exact
+// names win over exact leaf names, then prefixes of leaf names and of full
+// names, substrings and finally the letters of the query in order. More
+// than one best match is an error.
+func MatchBox(query string, names []string) (string, error) {
+	q := strings.ToLower(strings.TrimSpace(query))
+	if q == "" {
+		return "", fmt.Errorf("no box given")
+	}
+	best := -1
+	var matches []string
+	for _, name := range names {
+		rank := matchRank(q, name)
+		switch {
+		case rank < 0:
+			continue
+		case best < 0 || rank < best:
+			best, matches = rank, []string{name}
+		case rank == best:
+			matches = append(matches, name)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("no box matches \"%s\"", query)
+	case 1:
+		return matches[0], nil
+	}
+	return "", ambiguousBox(query, matches)
+}
+
+// matchRank returns how well q matches a box name, lower is better and -1
+// is no match
+func matchRank(q, name string) int {
+	n := strings.ToLower(name)
+	leaf := strings.ToLower(Box{Name: name}.Leaf())
+	switch {
+	case n == q:
+		return 0
+	case leaf == q:
+		return 1
+	case strings.HasPrefix(leaf, q):
+		return 2
+	case strings.HasPrefix(n, q):
+		return 3
+	case strings.Contains(n, q):
+		return 4
+	case isSubsequence(strings.ReplaceAll(q, " ", ""), n):
+		return 5
+	}
+	return -1
+}
+
+func isSubsequence(q, s string) bool {
+	for _, c := range s {
+		if len(q) == 0 {
+			break
+		}
+		if strings.HasPrefix(q, string(c)) {
+			q = q[len(string(c)):]
+		}
+	}
+	return len(q) == 0
+}
+
+func indexWords(words, phrase []string) int {
+	if len(phrase) == 0 {
+		return -1
+	}
+	for i := 0; i+len(phrase) <= len(words); i++ {
+		match := true
+		for j, p := range phrase {
+			if words[i+j] != p {
+				match = false
+				break
+			}
+		}
+		if match {
+			return i
+		}
+	}
+	return -1
+}
+
+func containsName(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func ambiguousBox(query string, matches []string) error {
+	sort.Strings(matches)
+	return fmt.Errorf("box \"%s\" is ambiguous, it matches %s", query, strings.Join(matches, ", "))
+}
@@ -0,0 +1,69 @@
+package util
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestMatchBox(t *testing.T) {
+	names := []string{"Music", "Music/Piano", "Music/Guitar", "Work", "Morning Run"}
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"music", "Music"},
+		{"piano", "Music/Piano"},
+		{"mus", "Music"},
+		{"music/g", "Music/Guitar"},
+		{"gui", "Music/Guitar"},
+		{"wrk", "Work"},
+		{"mpno", "Music/Piano"},
+	}
+	for _, tt := range tests {
+		got, err := MatchBox(tt.query, names)
+		require.NoError(t, err, tt.query)
+		assert.Equal(t, tt.want, got, tt.query)
+	}
+	_, err := MatchBox("m", names)
+	assert.ErrorContains(t, err, "ambiguous")
+	_, err = MatchBox("xyz", names)
+	assert.ErrorContains(t, err, "no box matches")
+}
+
+func TestParseLogEntry(t *testing.T) {
+	names := []string{"Music", "Music/Piano", "Work", "Morning Run"}
+	// a Wednesday
+	now := time.Date(2023, time.March, 15, 10, 0, 0, 0, time.Local)
+	p := NewTimeParser(func() time.Time { return now })
+	day := func(d, h, m int) time.Time {
+		return time.Date(2023, time.March, d, h, m, 0, 0, time.Local)
+	}
+	tests := []struct {
+		entry      string
+		box        string
+		start, end time.Time
+		tags       []string
+	}{
+		{"45m piano yesterday evening #scales", "Music/Piano", day(14, 19, 0), day(14, 19, 45), []string{"scales"}},
+		{"Work 9:00-12:30", "Work", day(15, 9, 0), day(15, 12, 30), nil},
+		{"30m wrk", "Work", day(15, 9, 30), day(15, 10, 0), nil},
+		{"1h morning run mon", "Morning Run", day(13, 9, 0), day(13, 10, 0), nil},
+		{"#late piano last fri 10pm-1am", "Music/Piano", day(10, 22, 0), day(11, 1, 0), []string{"late"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.entry, func(t *testing.T) {
+			span, err := ParseLogEntry(tt.entry, names, p)
+			require.NoError(t, err)
+			assert.Equal(t, tt.box, span.Box)
+			assert.True(t, tt.start.Equal(span.Start), "start %s, want %s", span.Start, tt.start)
+			assert.True(t, tt.end.Equal(span.End), "end %s, want %s", span.End, tt.end)
+			assert.Equal(t, tt.tags, span.Tags)
+		})
+	}
+	for _, entry := range []string{"piano yesterday", "45m", "45m 9:00-10:00 piano", "45m 1h piano", "9:00-10:00 piano noon", "45m guitar"} {
+		_, err := ParseLogEntry(entry, names, p)
+		assert.Error(t, err, entry)
+	}
+}
@@ -0,0 +1,98 @@
+package util
+
+import (
+	"fmt"
+	"time"
+)
+
+// Status compares the time used on a box with its min and max time
+type Status int
+
+const (
+	StatusUnder Status = iota
+	StatusWithin
+	StatusOver
+)
+
+func (s Status) String() string {
+	switch s {
+	case StatusUnder:
+		return "under"
+	case StatusWithin:
+		return "within"
+	case StatusOver:
+		return "over"
+	}
+	return fmt.Sprintf("Status(%d)", int(s))
+}
+
+func (s Status) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// BoxUsage is the time used on a box and its sub-boxes within a period,
+// together with the targets of the box for the period
+type BoxUsage struct {
+	Box    Box
+	Min    time.Duration
+	Max    time.Duration
+	Used   time.Duration
+	Status Status
+	// Archived is set for boxes archived before the end of the period
+	Archived bool
+}
+
+type Report struct {
+	Period   Period
+	Span     Span // the full period
+	Prorated bool
+	Boxes    []BoxUsage // in TreeNames order
+}
+
+// Report computes the usage of every box within span, the full bounds of a
+// period of type p like the ones returned by PeriodSpan. With prorate the
+// targets of a period that isn't over yet are scaled to the elapsed part.
+func (tb TimeBox) Report(p Period, span Span, prorate bool) Report {
+	report := Report{Period: p, Span: span, Prorated: prorate}
+	now := time.Now()
+	for _, name := range tb.TreeNames() {
+		box := tb.Boxes[name]
+		minTime, maxTime := box.TargetTimes(p, span, tb.Calendar)
+		if prorate {
+			minTime, maxTime = box.ProratedTimes(p, span, tb.Calendar, now)
+		}
+		used := tb.UsedTime(name, span)
+		report.Boxes = append(report.Boxes, BoxUsage{
+			Box:      box,
+			Min:      minTime,
+			Max:      maxTime,
+			Used:     used,
+			Status:   UsageStatus(used, minTime, maxTime),
+			Archived: box.ArchivedBy(span.End),
+		})
+	}
+	return report
+}
+
+// WithoutArchived leaves out the archived boxes that weren't used in the
+// period, boxes archived after it are kept
+func (r Report) WithoutArchived() Report {
+	result := r
+	result.Boxes = nil
+	for _, u := range r.Boxes {
+		if !u.Archived || u.Used > 0 {
+			result.Boxes = append(result.Boxes, u)
+		}
+	}
+	return result
+}
+
+func UsageStatus(used, min, max time.Duration) Status {
+	switch {
+	case used < min:
+		return StatusUnder
+	case used > max:
+		return StatusOver
+	}
+	return StatusWithin
+}
@@ -0,0 +1,74 @@
+package util
+
+import (
+	"github.com/aldernero/timebox/pkg/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestUsageStatus(t *testing.T) {
+	assert.Equal(t, StatusUnder, UsageStatus(time.Hour, 2*time.Hour, 3*time.Hour))
+	assert.Equal(t, StatusWithin, UsageStatus(2*time.Hour, 2*time.Hour, 3*time.Hour))
+	assert.Equal(t, StatusWithin, UsageStatus(3*time.Hour, 2*time.Hour, 3*time.Hour))
+	assert.Equal(t, StatusOver, UsageStatus(4*time.Hour, 2*time.Hour, 3*time.Hour))
+	text, err := StatusOver.MarshalText()
+	require.NoError(t, err)
+	assert.Equal(t, "over", string(text))
+}
+
+func TestTimeBox_Report(t *testing.T) {
+	tb := TimeBoxFromDB(ctx, db.NewMemoryStore())
+	require.NoError(t, tb.AddBox(Box{Name: "Work", MinTime: 2 * time.Hour, MaxTime: 4 * time.Hour}))
+	require.NoError(t, tb.AddBox(Box{Name: "Work/ClientA", MinTime: 0, MaxTime: time.Hour}))
+	require.NoError(t, tb.AddBox(Box{Name: "Piano", MinTime: time.Hour, MaxTime: 2 * time.Hour}))
+	start := time.Date(2023, time.March, 1, 9, 0, 0, 0, time.Local)
+	require.NoError(t, tb.AddSpan(Span{Start: start, End: start.Add(90 * time.Minute)}, "Work/ClientA"))
+	require.NoError(t, tb.AddSpan(Span{Start: start.Add(2 * time.Hour), End: start.Add(3 * time.Hour)}, "Work"))
+	tb = tb.Reload()
+
+	week := Span{Start: start.AddDate(0, 0, -1), End: start.AddDate(0, 0, 6)}
+	report := tb.Report(Week, week, false)
+	require.Equal(t, 3, len(report.Boxes))
+	byName := make(map[string]BoxUsage)
+	for _, u := range report.Boxes {
+		byName[u.Box.Name] = u
+	}
+	assert.Equal(t, 150*time.Minute, byName["Work"].Used)
+	assert.Equal(t, StatusWithin, byName["Work"].Status)
+	assert.Equal(t, StatusOver, byName["Work/ClientA"].Status)
+	assert.Equal(t, StatusUnder, byName["Piano"].Status)
+	assert.Equal(t, "Work/ClientA", report.Boxes[indexOf(tb.TreeNames(), "Work/ClientA")].Box.Name)
+}
+
+func TestReport_WithoutArchived(t *testing.T) {
+	tb := TimeBoxFromDB(ctx, db.NewMemoryStore())
+	for _, name := range []string{"Spanish", "Piano", "Work"} {
+		require.NoError(t, tb.AddBox(Box{Name: name, MinTime: time.Hour, MaxTime: 2 * time.Hour}))
+	}
+	start := time.Date(2023, time.March, 1, 9, 0, 0, 0, time.Local)
+	require.NoError(t, tb.AddSpan(Span{Start: start, End: start.Add(time.Hour)}, "Piano"))
+	require.NoError(t, tb.ArchiveBox("Spanish", start))
+	require.NoError(t, tb.ArchiveBox("Piano", start.AddDate(0, 0, 1)))
+	tb = tb.Reload()
+	assert.Equal(t, []string{"Work"}, tb.ActiveNames())
+
+	names := func(r Report) []string {
+		var result []string
+		for _, u := range r.Boxes {
+			result = append(result, u.Box.Name)
+		}
+		return result
+	}
+	// before the boxes were archived
+	feb := Span{Start: time.Date(2023, time.February, 1, 0, 0, 0, 0, time.Local), End: time.Date(2023, time.March, 1, 0, 0, 0, 0, time.Local)}
+	assert.ElementsMatch(t, []string{"Spanish", "Piano", "Work"}, names(tb.Report(Month, feb, false).WithoutArchived()))
+	// archived during the period, only the used ones are left
+	march := Span{Start: feb.End, End: time.Date(2023, time.April, 1, 0, 0, 0, 0, time.Local)}
+	report := tb.Report(Month, march, false)
+	assert.Equal(t, 3, len(report.Boxes))
+	assert.ElementsMatch(t, []string{"Piano", "Work"}, names(report.WithoutArchived()))
+	april := Span{Start: march.End, End: time.Date(2023, time.May, 1, 0, 0, 0, 0, time.Local)}
+	assert.ElementsMatch(t, []string{"Work"}, names(tb.Report(Month, april, false).WithoutArchived()))
+}
@@ -1,8 +1,11 @@
 package util
 
 import (
+	"context"
 	"fmt"
 	"github.com/aldernero/timebox/pkg/db"
+	"sort"
+	"strings"
 	"time"
 )
 
@@ -11,6 +14,71 @@ type Span struct {
 	Start time.Time
 	End   time.Time
 	Box   string
+	Notes string
+	Tags  []string
+}
+
+// spanFromRow returns the span with its times in the zone it was recorded in
+func spanFromRow(sr db.SpanRow) Span {
+	loc := zoneLocation(sr.TZ)
+	return Span{
+		ID:    sr.ID,
+		Start: time.Unix(sr.Start, 0).In(loc),
+		End:   time.Unix(sr.End, 0).In(loc),
+		Box:   sr.Box,
+		Notes: sr.Notes,
+		Tags:  sr.Tags,
+	}
+}
+
+func (s Span) row() db.SpanRow {
+	return db.SpanRow{
+		ID:    s.ID,
+		Start: s.Start.Unix(),
+		End:   s.End.Unix(),
+		Box:   s.Box,
+		Notes: s.Notes,
+		Tags:  s.Tags,
+		TZ:    ZoneName(s.Start.Location()),
+	}
+}
+
+// HasTag reports whether the span is tagged with tag
+func (s Span) HasTag(tag string) bool {
+	for _, t := range s.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// TagString joins the span's tags for display, e.g. "#piano #scales"
+func (s Span) TagString() string {
+	tags := make([]string, len(s.Tags))
+	for i, t := range s.Tags {
+		tags[i] = "#" + t
+	}
+	return strings.Join(tags, " ")
+}
+
+// ParseTags normalizes user supplied tags: surrounding whitespace and a
+// leading '#' are dropped, tags are lowercased, deduplicated and sorted
+func ParseTags(raw []string) []string {
+	seen := make(map[string]bool)
+	var tags []string
+	for _, r := range raw {
+		for _, field := range strings.FieldsFunc(r, func(c rune) bool { return c == ',' || c == ' ' }) {
+			tag := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(field), "#"))
+			if tag == "" || seen[tag] {
+				continue
+			}
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	sort.Strings(tags)
+	return tags
 }
 
 func (s Span) Duration() time.Duration {
@@ -30,9 +98,12 @@ func (s Span) Overlaps(span Span) bool {
 	return !disjoint
 }
 
+// GetOverlap returns the part of s that lies within span, keeping the ID,
+// box, notes and tags of s. The zero Span is returned if they don't overlap.
 func (s Span) GetOverlap(span Span) Span {
 	var result Span
 	if s.Overlaps(span) {
+		result = s
 		result.Start = Later(s.Start, span.Start)
 		result.End = Earlier(s.End, span.End)
 	}
@@ -44,8 +115,11 @@ func (s Span) String() string {
 }
 
 type SpanSet struct {
-	Spans  []Span         // list for table loads
+	Spans  []Span         // list for table loads, ordered by start
 	lookup map[int64]Span // map for fast lookups
+	// longest bounds the length of the spans, spans overlapping a time
+	// start at most this long before it
+	longest time.Duration
 }
 
 func NewSpanSet() SpanSet {
@@ -66,13 +140,68 @@ func (s *SpanSet) Size() int {
 func (s *SpanSet) Add(span Span) {
 	if _, ok := s.lookup[span.ID]; !ok {
 		s.lookup[span.ID] = span
-		s.Spans = append(s.Spans, span)
+		// after the spans starting at the same time, spans loaded in order
+		// are appended
+		i := sort.Search(len(s.Spans), func(i int) bool {
+			return s.Spans[i].Start.After(span.Start)
+		})
+		s.Spans = append(s.Spans, Span{})
+		copy(s.Spans[i+1:], s.Spans[i:])
+		s.Spans[i] = span
+		if d := span.Duration(); d > s.longest {
+			s.longest = d
+		}
 		return
 	} else {
 		panic(fmt.Sprintf("Span already exists: %s", span.String()))
 	}
 }
 
+// Overlapping returns the spans overlapping span in the order of their start,
+// found by binary search
+func (s *SpanSet) Overlapping(span Span) []Span {
+	from := sort.Search(len(s.Spans), func(i int) bool {
+		return s.Spans[i].Start.After(span.Start.Add(-s.longest))
+	})
+	to := sort.Search(len(s.Spans), func(i int) bool {
+		return !s.Spans[i].Start.Before(span.End)
+	})
+	var result []Span
+	for i := from; i < to; i++ {
+		if s.Spans[i].Overlaps(span) {
+			result = append(result, s.Spans[i])
+		}
+	}
+	return result
+}
+
+// Runs returns the runs of at least two spans of the same box in a row,
+// each starting at most gap after the previous one ends. A span of another
+// box in between ends a run.
+func (s *SpanSet) Runs(gap time.Duration) [][]Span {
+	var runs [][]Span
+	var run []Span
+	var end time.Time // of the run so far
+	for _, span := range s.Spans {
+		if len(run) > 0 && span.Box == run[0].Box && !span.Start.After(end.Add(gap)) {
+			run = append(run, span)
+		} else {
+			if len(run) > 1 {
+				runs = append(runs, run)
+			}
+			run = []Span{span}
+			end = span.End
+		}
+		if span.End.After(end) {
+			end = span.End
+		}
+	}
+	if len(run) > 1 {
+		runs = append(runs, run)
+	}
+	return runs
+}
+
 func (s *SpanSet) HasSpan(span Span) bool {
 	_, ok := s.lookup[span.ID]
 	return ok
@@ -97,32 +226,41 @@ func (s *SpanSet) Remove(span Span) {
 		for i, val := range s.Spans {
 			if val.ID == span.ID {
 				s.Spans = append(s.Spans[:i], s.Spans[i+1:]...)
+				if val.Duration() >= s.longest {
+					s.longest = 0
+					for _, other := range s.Spans {
+						if d := other.Duration(); d > s.longest {
+							s.longest = d
+						}
+					}
+				}
 				return
 			}
 		}
 	}
 }
 
-func AllSpansFromDB(tbdb db.TBDB) (map[string]SpanSet, map[int64]Span) {
+func (s *SpanSet) SortByStart() {
+	sort.SliceStable(s.Spans, func(i, j int) bool {
+		return s.Spans[i].Start.Before(s.Spans[j].Start)
+	})
+}
+
+func AllSpansFromDB(ctx context.Context, store db.Store) (map[string]SpanSet, map[int64]Span) {
 	spanSetMap := make(map[string]SpanSet)
 	spanMap := make(map[int64]Span)
-	brs, err := tbdb.GetAllBoxes()
+	brs, err := store.GetAllBoxes(ctx)
 	if err != nil {
 		panic(err)
 	}
 	for _, br := range brs {
 		spanset := NewSpanSet()
-		srs, err := tbdb.GetSpansForBox(br.Name)
+		srs, err := store.GetSpansForBox(ctx, br.Name)
 		if err != nil {
 			panic(err)
 		}
 		for _, sr := range srs {
-			span := Span{
-				ID:    sr.ID,
-				Start: time.Unix(sr.Start, 0),
-				End:   time.Unix(sr.End, 0),
-				Box:   sr.Box,
-			}
+			span := spanFromRow(sr)
 			spanset.Add(span)
 			spanMap[sr.ID] = span
 		}
@@ -131,18 +269,14 @@ func AllSpansFromDB(tbdb db.TBDB) (map[string]SpanSet, map[int64]Span) {
 	return spanSetMap, spanMap
 }
 
-func AllSpansFromDBForTimeRange(tbdb db.TBDB, start, end time.Time) SpanSet {
+func AllSpansFromDBForTimeRange(ctx context.Context, store db.Store, start, end time.Time, tags ...string) SpanSet {
 	result := NewSpanSet()
-	srs, err := tbdb.GetSpansForTimeRange(start.Unix(), end.Unix())
+	srs, err := store.GetSpansForTimeRange(ctx, start.Unix(), end.Unix(), tags...)
 	if err != nil {
 		panic(err)
 	}
 	for _, sr := range srs {
-		result.Add(Span{
-			ID:    sr.ID,
-			Start: time.Unix(sr.Start, 0),
-			End:   time.Unix(sr.End, 0),
-		})
+		result.Add(spanFromRow(sr))
 	}
 	return result
 }
@@ -1,6 +1,7 @@
 package util
 
 import (
+	"context"
 	"fmt"
 	"github.com/aldernero/timebox/pkg/db"
 	"github.com/stretchr/testify/assert"
@@ -13,14 +14,24 @@ import (
 
 const dbName = "test.db"
 
-func setup(t *testing.T) db.TBDB {
+var ctx = context.Background()
+
+func setup(t *testing.T) *db.TBDB {
 	tempDir, err := os.MkdirTemp(os.TempDir(), "timebox")
 	require.NoError(t, err)
 	testdb := filepath.Join(tempDir, filepath.FromSlash(dbName))
 	err = os.Remove(testdb)
 	require.NoFileExists(t, testdb)
-	tbdb := db.NewDBWithName(testdb)
-	require.NoError(t, tbdb.CreateDB())
+	return openDB(t, testdb)
+}
+
+func openDB(t testing.TB, name string) *db.TBDB {
+	tbdb, err := db.Open(ctx, name)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, tbdb.Close())
+	})
+	require.NoError(t, tbdb.CreateDB(ctx))
 	return tbdb
 }
 
@@ -323,20 +334,91 @@ func TestSpanSet_Duration(t *testing.T) {
 	}
 }
 
+func TestSpanSet_Overlapping(t *testing.T) {
+	start := time.Date(2023, time.January, 1, 0, 0, 0, 0, time.UTC)
+	at := func(h int) time.Time {
+		return start.Add(time.Duration(h) * time.Hour)
+	}
+	spans := NewSpanSet()
+	// added out of order, the long span starts well before the others
+	spans.Add(Span{ID: 1, Start: at(10), End: at(11)})
+	spans.Add(Span{ID: 2, Start: at(0), End: at(9)})
+	spans.Add(Span{ID: 3, Start: at(12), End: at(13)})
+	spans.Add(Span{ID: 4, Start: at(9), End: at(10)})
+	var ids []int64
+	for _, s := range spans.Spans {
+		ids = append(ids, s.ID)
+	}
+	assert.Equal(t, []int64{2, 4, 1, 3}, ids)
+
+	tests := map[string]struct {
+		span Span
+		want []int64
+	}{
+		"within the long span": {Span{Start: at(5), End: at(6)}, []int64{2}},
+		"across spans":         {Span{Start: at(8), End: at(11)}, []int64{2, 4, 1}},
+		"gap":                  {Span{Start: at(11), End: at(12)}, nil},
+		"after all":            {Span{Start: at(13), End: at(20)}, nil},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var got []int64
+			for _, s := range spans.Overlapping(tc.span) {
+				got = append(got, s.ID)
+			}
+			assert.Equal(t, tc.want, got)
+		})
+	}
+
+	// removing the long span narrows the search again
+	spans.Remove(Span{ID: 2})
+	assert.Equal(t, time.Hour, spans.longest)
+	assert.Empty(t, spans.Overlapping(Span{Start: at(5), End: at(6)}))
+}
+
+func TestSpanSet_Runs(t *testing.T) {
+	start := time.Date(2023, time.January, 1, 9, 0, 0, 0, time.UTC)
+	at := func(m int) time.Time {
+		return start.Add(time.Duration(m) * time.Minute)
+	}
+	spans := NewSpanSet()
+	spans.Add(Span{ID: 1, Start: at(0), End: at(30), Box: "Work"})
+	spans.Add(Span{ID: 2, Start: at(30), End: at(60), Box: "Work"})
+	spans.Add(Span{ID: 3, Start: at(61), End: at(90), Box: "Work"})
+	spans.Add(Span{ID: 4, Start: at(90), End: at(100), Box: "Piano"})
+	spans.Add(Span{ID: 5, Start: at(100), End: at(110), Box: "Work"})
+	spans.Add(Span{ID: 6, Start: at(110), End: at(120), Box: "Work"})
+	ids := func(runs [][]Span) [][]int64 {
+		var result [][]int64
+		for _, run := range runs {
+			var r []int64
+			for _, s := range run {
+				r = append(r, s.ID)
+			}
+			result = append(result, r)
+		}
+		return result
+	}
+	assert.Equal(t, [][]int64{{1, 2}, {5, 6}}, ids(spans.Runs(0)))
+	assert.Equal(t, [][]int64{{1, 2, 3}, {5, 6}}, ids(spans.Runs(time.Minute)))
+	empty := NewSpanSet()
+	assert.Empty(t, ids(empty.Runs(time.Hour)))
+}
+
 func TestAllSpansFromDB(t *testing.T) {
 	tbdb := setup(t)
 	for i := 0; i < 4; i++ {
 		boxName := fmt.Sprintf("box%d", i)
-		err := tbdb.AddBox(boxName, 100, 1000)
+		err := tbdb.AddBox(ctx, boxName, 100, 1000)
 		require.NoError(t, err)
 		for j := 0; j < 4; j++ {
 			start := time.Date(2023, time.January, 1, i, j, 0, 0, time.Local)
 			end := time.Date(2023, time.January, 1, i, j+1, 0, 0, time.Local)
-			err := tbdb.AddSpan(start.Unix(), end.Unix(), boxName)
+			err := tbdb.AddSpan(ctx, start.Unix(), end.Unix(), boxName)
 			require.NoError(t, err)
 		}
 	}
-	spans := AllSpansFromDB(tbdb)
+	spans, _ := AllSpansFromDB(ctx, tbdb)
 	assert.Equal(t, 4, len(spans))
 	for i := 0; i < 4; i++ {
 		boxName := fmt.Sprintf("box%d", i)
@@ -365,20 +447,39 @@ func TestAllSpansFromDBForTimeRange(t *testing.T) {
 	}
 	for i := 0; i < 4; i++ {
 		boxName := fmt.Sprintf("box%d", i)
-		err := tbdb.AddBox(boxName, 100, 1000)
+		err := tbdb.AddBox(ctx, boxName, 100, 1000)
 		require.NoError(t, err)
 	}
-	err := tbdb.AddSpan(span1.Start.Unix(), span1.End.Unix(), "box1")
+	err := tbdb.AddSpan(ctx, span1.Start.Unix(), span1.End.Unix(), "box1")
 	require.NoError(t, err)
-	err = tbdb.AddSpan(span2.Start.Unix(), span2.End.Unix(), "box1")
+	err = tbdb.AddSpan(ctx, span2.Start.Unix(), span2.End.Unix(), "box1")
 	require.NoError(t, err)
-	err = tbdb.AddSpan(span3.Start.Unix(), span3.End.Unix(), "box2")
+	err = tbdb.AddSpan(ctx, span3.Start.Unix(), span3.End.Unix(), "box2")
 	require.NoError(t, err)
-	err = tbdb.AddSpan(span4.Start.Unix(), span4.End.Unix(), "box3")
+	err = tbdb.AddSpan(ctx, span4.Start.Unix(), span4.End.Unix(), "box3")
 	require.NoError(t, err)
-	spanRow, err := tbdb.GetSpansForTimeRange(span1.Start.Unix(), span4.End.Unix())
+	spanRow, err := tbdb.GetSpansForTimeRange(ctx, span1.Start.Unix(), span4.End.Unix())
 	require.NoError(t, err)
 	assert.Equal(t, 4, len(spanRow))
-	spans := AllSpansFromDBForTimeRange(tbdb, span1.Start, span4.End)
+	spans := AllSpansFromDBForTimeRange(ctx, tbdb, span1.Start, span4.End)
 	assert.Equal(t, 4, spans.Size())
 }
+
+func TestParseTags(t *testing.T) {
+	tests := map[string]struct {
+		raw  []string
+		want []string
+	}{
+		"empty":      {raw: nil, want: nil},
+		"single":     {raw: []string{"piano"}, want: []string{"piano"}},
+		"hash":       {raw: []string{"#Scales"}, want: []string{"scales"}},
+		"comma":      {raw: []string{"theory, #scales"}, want: []string{"scales", "theory"}},
+		"duplicates": {raw: []string{"a", "A", "#a"}, want: []string{"a"}},
+		"blank":      {raw: []string{" ", "#"}, want: nil},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.want, ParseTags(tc.raw))
+		})
+	}
+}
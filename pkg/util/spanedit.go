@@ -0,0 +1,186 @@
+package util
+
+import (
+	"fmt"
+	"github.com/aldernero/timebox/pkg/db"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SpanEdit replaces the spans Old with the spans New, like a merge of
+// several spans into one or a split of one span into several. The first new
+// span keeps the ID of the first old one.
+type SpanEdit struct {
+	Old []Span
+	New []Span
+}
+
+// MergeSpans merges spans of one box into a span from the earliest start to
+// the latest end, covering the gaps between them. Notes are joined and tags
+// combined.
+func MergeSpans(spans []Span) (SpanEdit, error) {
+	if len(spans) < 2 {
+		return SpanEdit{}, fmt.Errorf("at least two spans are needed to merge")
+	}
+	old := append([]Span(nil), spans...)
+	sort.SliceStable(old, func(i, j int) bool {
+		return old[i].Start.Before(old[j].Start)
+	})
+	merged := old[0]
+	merged.Tags = nil
+	var notes, tags []string
+	for _, s := range old {
+		if s.Box != merged.Box {
+			return SpanEdit{}, fmt.Errorf("can't merge spans of %s and %s", merged.Box, s.Box)
+		}
+		if s.End.After(merged.End) {
+			merged.End = s.End.In(merged.End.Location())
+		}
+		if s.Notes != "" && !containsName(notes, s.Notes) {
+			notes = append(notes, s.Notes)
+		}
+		tags = append(tags, s.Tags...)
+	}
+	merged.Notes = strings.Join(notes, "; ")
+	merged.Tags = ParseTags(tags)
+	return SpanEdit{Old: old, New: []Span{merged}}, nil
+}
+
+// SplitSpanAt splits a span at times within it into spans with the same
+// box, notes and tags
+func SplitSpanAt(span Span, times ...time.Time) (SpanEdit, error) {
+	if len(times) == 0 {
+		return SpanEdit{}, fmt.Errorf("no time to split at")
+	}
+	times = append([]time.Time(nil), times...)
+	sort.Slice(times, func(i, j int) bool {
+		return times[i].Before(times[j])
+	})
+	edit := SpanEdit{Old: []Span{span}}
+	rest := span
+	for _, at := range times {
+		if !at.After(rest.Start) || !at.Before(rest.End) {
+			return SpanEdit{}, fmt.Errorf("split time %s isn't within the span or is given twice", at.Format(time.DateTime))
+		}
+		piece := rest
+		piece.End = at.In(span.End.Location())
+		edit.New = append(edit.New, piece)
+		rest.ID = 0
+		rest.Start = at.In(span.Start.Location())
+		rest.Tags = append([]string(nil), span.Tags...)
+	}
+	edit.New = append(edit.New, rest)
+	return edit, nil
+}
+
+// SplitSpanEvery splits a span into pieces of length every, the last piece
+// can be shorter
+func SplitSpanEvery(span Span, every time.Duration) (SpanEdit, error) {
+	if every <= 0 {
+		return SpanEdit{}, fmt.Errorf("the length of the pieces must be positive")
+	}
+	var times []time.Time
+	for at := span.Start.Add(every); at.Before(span.End); at = at.Add(every) {
+		times = append(times, at)
+	}
+	if len(times) == 0 {
+		return SpanEdit{}, fmt.Errorf("span %d isn't longer than %s", span.ID, DurationParser(every))
+	}
+	return SplitSpanAt(span, times...)
+}
+
+// CheckEdits checks that the new spans of edits are in existing boxes, end
+// after they start and don't overlap each other or any span that isn't
+// replaced
+func (tb TimeBox) CheckEdits(edits []SpanEdit) error {
+	replaced := make(map[int64]bool)
+	var added []Span
+	for _, e := range edits {
+		for _, s := range e.Old {
+			replaced[s.ID] = true
+		}
+	}
+	for _, e := range edits {
+		for _, s := range e.New {
+			if !s.End.After(s.Start) {
+				return fmt.Errorf("span must end after it starts")
+			}
+			if _, ok := tb.Boxes[s.Box]; !ok {
+				return fmt.Errorf("box %s doesn't exist", s.Box)
+			}
+			for _, other := range added {
+				if other.Overlaps(s) {
+					return fmt.Errorf("new spans overlap each other")
+				}
+			}
+			added = append(added, s)
+			for _, spanset := range tb.SpansSets {
+				for _, other := range spanset.Overlapping(s) {
+					if !replaced[other.ID] {
+						return fmt.Errorf("span overlaps span %d (%s)", other.ID, other.Box)
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// ApplyEdits checks edits with CheckEdits and applies all of them in one
+// transaction of the store. The new spans of edits get their IDs.
+func (tb TimeBox) ApplyEdits(edits []SpanEdit) error {
+	err := tb.CheckEdits(edits)
+	if err != nil {
+		return err
+	}
+	var changes db.SpanChanges
+	for i, e := range edits {
+		if len(e.Old) == 0 || len(e.New) == 0 {
+			return fmt.Errorf("edit %d doesn't replace spans with spans", i)
+		}
+		e.New[0].ID = e.Old[0].ID
+		for _, s := range e.Old[1:] {
+			changes.Delete = append(changes.Delete, s.ID)
+		}
+		changes.Update = append(changes.Update, e.New[0].row())
+		for _, s := range e.New[1:] {
+			changes.Add = append(changes.Add, s.row())
+		}
+	}
+	var keys db.ImageKeys
+	for _, e := range edits {
+		for _, s := range e.Old {
+			keys.Spans = append(keys.Spans, s.ID)
+		}
+	}
+	var ids []int64
+	err = tb.journal(editsDescription(edits), keys, func(s db.Store) (db.ImageKeys, error) {
+		ids, err = s.ApplySpanChanges(tb.ctx, changes)
+		return db.ImageKeys{Spans: ids}, err
+	})
+	if err != nil {
+		return err
+	}
+	// the IDs of the added spans, SplitSpan returns them
+	for _, e := range edits {
+		for j := range e.New[1:] {
+			e.New[j+1].ID, ids = ids[0], ids[1:]
+		}
+	}
+	return nil
+}
+
+// editsDescription describes edits as a merge or a split when they are one
+func editsDescription(edits []SpanEdit) string {
+	if len(edits) == 1 {
+		e := edits[0]
+		if len(e.New) == 1 {
+			return fmt.Sprintf("merge %d spans of %s", len(e.Old), e.New[0].Box)
+		}
+		if len(e.Old) == 1 {
+			return fmt.Sprintf("split span %d into %d", e.Old[0].ID, len(e.New))
+		}
+	}
+	return fmt.Sprintf("edit spans in %d places", len(edits))
+}
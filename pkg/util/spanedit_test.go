@@ -0,0 +1,98 @@
+package util
+
+import (
+	"github.com/aldernero/timebox/pkg/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMergeSpans(t *testing.T) {
+	start := time.Date(2023, time.March, 1, 9, 0, 0, 0, time.UTC)
+	spans := []Span{
+		{ID: 2, Start: start.Add(time.Hour), End: start.Add(2 * time.Hour), Box: "Work", Notes: "review", Tags: []string{"deep"}},
+		{ID: 1, Start: start, End: start.Add(time.Hour), Box: "Work", Notes: "email", Tags: []string{"admin"}},
+		{ID: 3, Start: start.Add(2*time.Hour + time.Minute), End: start.Add(3 * time.Hour), Box: "Work", Notes: "review"},
+	}
+	edit, err := MergeSpans(spans)
+	require.NoError(t, err)
+	require.Len(t, edit.New, 1)
+	merged := edit.New[0]
+	assert.Equal(t, int64(1), merged.ID)
+	assert.Equal(t, int64(1), edit.Old[0].ID)
+	assert.True(t, merged.Start.Equal(start))
+	assert.True(t, merged.End.Equal(start.Add(3*time.Hour)))
+	assert.Equal(t, "email; review", merged.Notes)
+	assert.Equal(t, []string{"admin", "deep"}, merged.Tags)
+
+	_, err = MergeSpans(spans[:1])
+	assert.Error(t, err)
+	spans[2].Box = "Piano"
+	_, err = MergeSpans(spans)
+	assert.EqualError(t, err, "can't merge spans of Work and Piano")
+}
+
+func TestSplitSpan(t *testing.T) {
+	start := time.Date(2023, time.March, 1, 9, 0, 0, 0, time.UTC)
+	span := Span{ID: 1, Start: start, End: start.Add(150 * time.Minute), Box: "Work", Tags: []string{"deep"}}
+	edit, err := SplitSpanEvery(span, time.Hour)
+	require.NoError(t, err)
+	require.Len(t, edit.New, 3)
+	for i, s := range edit.New {
+		assert.True(t, s.Start.Equal(start.Add(time.Duration(i)*time.Hour)))
+		assert.Equal(t, []string{"deep"}, s.Tags)
+	}
+	assert.Equal(t, []int64{1, 0, 0}, []int64{edit.New[0].ID, edit.New[1].ID, edit.New[2].ID})
+	assert.Equal(t, 30*time.Minute, edit.New[2].Duration())
+
+	edit, err = SplitSpanAt(span, start.Add(2*time.Hour), start.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Len(t, edit.New, 3)
+	_, err = SplitSpanAt(span, start.Add(time.Hour), start.Add(time.Hour))
+	assert.Error(t, err)
+	_, err = SplitSpanAt(span, start.Add(3*time.Hour))
+	assert.Error(t, err)
+	_, err = SplitSpanEvery(span, 3*time.Hour)
+	assert.Error(t, err)
+}
+
+func TestTimeBox_ApplyEdits(t *testing.T) {
+	tbdb := openDB(t, filepath.Join(t.TempDir(), dbName))
+	for _, store := range []db.Store{tbdb, db.NewMemoryStore()} {
+		tb := TimeBoxFromDB(ctx, store)
+		require.NoError(t, tb.AddBox(Box{Name: "Work"}))
+		require.NoError(t, tb.AddBox(Box{Name: "Piano"}))
+		start := time.Date(2023, time.March, 1, 9, 0, 0, 0, time.UTC)
+		for i, box := range []string{"Work", "Work", "Piano", "Work"} {
+			s := start.Add(time.Duration(i) * time.Hour)
+			require.NoError(t, tb.AddSpan(Span{Start: s, End: s.Add(time.Hour)}, box))
+		}
+		tb = tb.Reload()
+		period := Span{Start: start, End: start.Add(4 * time.Hour)}
+		set := tb.GetSpansForTimespan(period)
+		runs := set.Runs(0)
+		require.Len(t, runs, 1)
+		merge, err := MergeSpans(runs[0])
+		require.NoError(t, err)
+
+		// merging across the piano span fails and changes nothing
+		across, err := MergeSpans(tb.SpansSets["Work"].Spans)
+		require.NoError(t, err)
+		assert.EqualError(t, tb.ApplyEdits([]SpanEdit{across}), "span overlaps span 3 (Piano)")
+
+		split, err := SplitSpanEvery(tb.SpansSets["Piano"].Spans[0], 20*time.Minute)
+		require.NoError(t, err)
+		edits := []SpanEdit{merge, split}
+		require.NoError(t, tb.ApplyEdits(edits))
+		assert.NotZero(t, edits[1].New[2].ID)
+		assert.Len(t, tb.SpansSets["Work"].Spans, 2)
+		assert.Len(t, tb.SpansSets["Piano"].Spans, 3)
+		tb = tb.Reload()
+		assert.Len(t, tb.SpansSets["Work"].Spans, 2)
+		assert.Len(t, tb.SpansSets["Piano"].Spans, 3)
+		assert.Equal(t, 3*time.Hour, tb.UsedTime("Work", period))
+		assert.Equal(t, time.Hour, tb.UsedTime("Piano", period))
+	}
+}
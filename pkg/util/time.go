@@ -3,6 +3,8 @@ package util
 import (
 	"fmt"
 	"github.com/charmbracelet/lipgloss"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -21,9 +23,6 @@ const (
 	secondsPerDay         = 86400
 	secondsPerHour        = 3600
 	secondsPerMinute      = 60
-	monthsPerWeek         = 0.2301 // estimate for faster calculation
-	quartersPerWeek       = 0.0767 // estimate for faster calculation
-	yearsPerWeek          = 0.0192 // estimate for faster calculation
 	ColorDurationHours    = "#FF0087"
 	ColorDurationMinutes  = "#00D7FF"
 	ColorDurationSeconds  = "#FFFF5F"
@@ -76,6 +75,31 @@ func (t *TimePeriod) String() string {
 	return t.Names()[t.Current()]
 }
 
+// Set parses a period name like "week", so a TimePeriod can be used as a
+// command line flag
+func (t *TimePeriod) Set(s string) error {
+	p, err := ParsePeriod(s)
+	if err != nil {
+		return err
+	}
+	t.Period = p
+	return nil
+}
+
+func (t *TimePeriod) Type() string {
+	return "period"
+}
+
+func ParsePeriod(s string) (Period, error) {
+	var t TimePeriod
+	for i, name := range t.Names() {
+		if strings.EqualFold(s, name) {
+			return Period(i), nil
+		}
+	}
+	return Week, fmt.Errorf("unknown period %s, expected week, month, quarter or year", s)
+}
+
 func (t *TimePeriod) View() string {
 	var result string
 	for i, name := range t.Names() {
@@ -88,23 +112,94 @@ func (t *TimePeriod) View() string {
 	return result
 }
 
-// WeekStart calculates the time at the beginning of the week for a given time
+// Calendar holds the settings that define the bounds of a period, the day
+// weeks start on, the month fiscal quarters and years start in and the zone
+// whose midnights separate the days
+type Calendar struct {
+	WeekStart       time.Weekday
+	FiscalYearStart time.Month
+	// Location defaults to time.Local if nil
+	Location *time.Location
+}
+
+// DefaultCalendar has Sunday based weeks and calendar years in time.Local
+var DefaultCalendar = Calendar{WeekStart: time.Sunday, FiscalYearStart: time.January}
+
+// In returns t in the zone of the calendar, for display
+func (c Calendar) In(t time.Time) time.Time {
+	if c.Location == nil {
+		return t.In(time.Local)
+	}
+	return t.In(c.Location)
+}
+
+// ParseWeekday parses a day name like "monday" or "Mon"
+func ParseWeekday(s string) (time.Weekday, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		name := strings.ToLower(d.String())
+		if s == name || (len(s) >= 3 && strings.HasPrefix(name, s)) {
+			return d, nil
+		}
+	}
+	return time.Sunday, fmt.Errorf("unknown week day %s", s)
+}
+
+// ParseMonth parses a month name like "april" or "Apr", or a number from 1
+// to 12
+func ParseMonth(s string) (time.Month, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	for m := time.January; m <= time.December; m++ {
+		name := strings.ToLower(m.String())
+		if s == name || (len(s) >= 3 && strings.HasPrefix(name, s)) || s == strconv.Itoa(int(m)) {
+			return m, nil
+		}
+	}
+	return time.January, fmt.Errorf("unknown month %s", s)
+}
+
+// ParseCalendar parses the week start day, the fiscal year start month and
+// the IANA time zone of a calendar, empty values keep those of
+// DefaultCalendar
+func ParseCalendar(weekStartDay, fiscalYearStart, timeZone string) (Calendar, error) {
+	cal := DefaultCalendar
+	var err error
+	if weekStartDay != "" {
+		cal.WeekStart, err = ParseWeekday(weekStartDay)
+		if err != nil {
+			return cal, err
+		}
+	}
+	if fiscalYearStart != "" {
+		cal.FiscalYearStart, err = ParseMonth(fiscalYearStart)
+		if err != nil {
+			return cal, err
+		}
+	}
+	if timeZone != "" {
+		cal.Location, err = time.LoadLocation(timeZone)
+	}
+	return cal, err
+}
+
+// WeekStart calculates the time at the beginning of the week starting on
+// wsd for a given time, at midnight in the location of t even if a DST
+// change happened since
 //
 //goland:noinspection SpellCheckingInspection
-func WeekStart(t time.Time) time.Time {
-	wday := int(t.Weekday())
-	seconds := wday*86400 + t.Hour()*3600 + t.Minute()*60 + t.Second()
-	return t.Add(time.Duration(-seconds) * time.Second)
+func WeekStart(t time.Time, wsd time.Weekday) time.Time {
+	wday := (int(t.Weekday()) - int(wsd) + 7) % 7
+	return time.Date(t.Year(), t.Month(), t.Day()-wday, 0, 0, 0, 0, t.Location())
 }
 
 // ThisWeekStart calculates the time at the beginning of the current week
-func ThisWeekStart() time.Time {
-	return WeekStart(time.Now())
+func ThisWeekStart(wsd time.Weekday) time.Time {
+	return WeekStart(time.Now(), wsd)
 }
 
 // MonthStart calculates the time at the beginning of the month for a given time
 func MonthStart(t time.Time) time.Time {
-	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.Local)
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
 }
 
 // ThisMonthStart calculates the time at the beginning of the current month
@@ -115,17 +210,14 @@ func ThisMonthStart() time.Time {
 func QuarterStart(t time.Time, fys time.Month) time.Time {
 	y := t.Year()
 	m := int(t.Month())
-	diff := m - int(fys)
-	if diff < 0 {
-		diff *= -1
-	}
-	monthOffset := (3 - diff%3) % 3
+	// months since the start of the fiscal quarter
+	monthOffset := ((m-int(fys))%3 + 3) % 3
 	m -= monthOffset
 	if m < 1 {
 		y--
 		m += 12
 	}
-	return time.Date(y, time.Month(m), 1, 0, 0, 0, 0, time.Local)
+	return time.Date(y, time.Month(m), 1, 0, 0, 0, 0, t.Location())
 }
 
 func ThisQuarterStart(fys time.Month) time.Time {
@@ -134,7 +226,7 @@ func ThisQuarterStart(fys time.Month) time.Time {
 
 // YearStart calculates the time at the beginning of the month for a given time
 func YearStart(t time.Time) time.Time {
-	return time.Date(t.Year(), time.January, 1, 0, 0, 0, 0, time.Local)
+	return time.Date(t.Year(), time.January, 1, 0, 0, 0, 0, t.Location())
 }
 
 // ThisYearStart calculates the time at the beginning of the current year
@@ -142,6 +234,16 @@ func ThisYearStart() time.Time {
 	return YearStart(time.Now())
 }
 
+// FiscalYearStart calculates the time at the beginning of the fiscal year
+// starting in month fys for a given time
+func FiscalYearStart(t time.Time, fys time.Month) time.Time {
+	y := t.Year()
+	if t.Month() < fys {
+		y--
+	}
+	return time.Date(y, fys, 1, 0, 0, 0, 0, t.Location())
+}
+
 //goland:noinspection SpellCheckingInspection
 func DurationParser(d time.Duration) string {
 	dsec := int(d.Seconds())
@@ -178,8 +280,8 @@ func Later(t1, t2 time.Time) time.Time {
 	return t2
 }
 
-func WeekSoFar() Span {
-	return Span{Start: ThisWeekStart(), End: time.Now()}
+func WeekSoFar(wsd time.Weekday) Span {
+	return Span{Start: ThisWeekStart(wsd), End: time.Now()}
 }
 
 func MonthSoFar() Span {
@@ -190,23 +292,102 @@ func QuarterSoFar(fys time.Month) Span {
 	return Span{Start: ThisQuarterStart(fys), End: time.Now()}
 }
 
-func YearSoFar() Span {
-	return Span{Start: ThisYearStart(), End: time.Now()}
+func YearSoFar(fys time.Month) Span {
+	return Span{Start: FiscalYearStart(time.Now(), fys), End: time.Now()}
+}
+
+func PeriodSoFar(p Period, cal Calendar) Span {
+	now := time.Now()
+	return Span{Start: PeriodStart(p, now, cal), End: now}
 }
 
-func PeriodSoFar(p Period, fys time.Month) Span {
-	var result Span
+// PeriodStart returns the beginning of the period of type p containing t,
+// in the zone of the calendar
+func PeriodStart(p Period, t time.Time, cal Calendar) time.Time {
+	t = cal.In(t)
 	switch p {
-	case Week:
-		result = WeekSoFar()
 	case Month:
-		result = MonthSoFar()
+		return MonthStart(t)
 	case Quarter:
-		result = QuarterSoFar(fys)
+		return QuarterStart(t, cal.FiscalYearStart)
 	case Year:
-		result = YearSoFar()
+		return FiscalYearStart(t, cal.FiscalYearStart)
 	}
-	return result
+	return WeekStart(t, cal.WeekStart)
+}
+
+// ShiftPeriod moves the start of a period by offset periods of type p
+func ShiftPeriod(p Period, start time.Time, offset int) time.Time {
+	switch p {
+	case Month:
+		return start.AddDate(0, offset, 0)
+	case Quarter:
+		return start.AddDate(0, 3*offset, 0)
+	case Year:
+		return start.AddDate(offset, 0, 0)
+	}
+	return start.AddDate(0, 0, 7*offset)
+}
+
+// PeriodContaining returns the full period of type p containing t, from its
+// start up to the start of the next period
+func PeriodContaining(p Period, t time.Time, cal Calendar) Span {
+	start := PeriodStart(p, t, cal)
+	return Span{Start: start, End: ShiftPeriod(p, start, 1)}
+}
+
+// PeriodSpan returns the full period offset periods away from the current
+// one, e.g. -1 for last week
+func PeriodSpan(p Period, cal Calendar, offset int) Span {
+	start := ShiftPeriod(p, PeriodStart(p, time.Now(), cal), offset)
+	return PeriodContaining(p, start, cal)
+}
+
+// DaysIn returns the number of calendar days from the start to the end of
+// span, which are both expected to be at midnight. Days with a DST change
+// count as one day.
+func DaysIn(span Span) int64 {
+	start := time.Date(span.Start.Year(), span.Start.Month(), span.Start.Day(), 0, 0, 0, 0, time.UTC)
+	end := time.Date(span.End.Year(), span.End.Month(), span.End.Day(), 0, 0, 0, 0, time.UTC)
+	return int64(end.Sub(start) / (24 * time.Hour))
+}
+
+// ElapsedIn returns the seconds of span that have passed at now, and the
+// length of span in seconds
+func ElapsedIn(span Span, now time.Time) (int64, int64) {
+	total := span.End.Unix() - span.Start.Unix()
+	elapsed := now.Unix() - span.Start.Unix()
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	if elapsed > total {
+		elapsed = total
+	}
+	return elapsed, total
+}
+
+// PeriodLabel names the period of type p starting at start, e.g.
+// "Week of 2023-03-05", "March 2023", "Q2 2023" or "2023". Fiscal years not
+// starting in January are named after both years, like "Q1 2023/24".
+func PeriodLabel(p Period, start time.Time, cal Calendar) string {
+	fys := cal.FiscalYearStart
+	switch p {
+	case Month:
+		return start.Format("January 2006")
+	case Quarter:
+		return fmt.Sprintf("Q%d %s", FiscalQuarter(fys, start.Month()), fiscalYearLabel(start, fys))
+	case Year:
+		return fiscalYearLabel(start, fys)
+	}
+	return "Week of " + start.Format(time.DateOnly)
+}
+
+func fiscalYearLabel(t time.Time, fys time.Month) string {
+	y := FiscalYearStart(t, fys).Year()
+	if fys == time.January {
+		return strconv.Itoa(y)
+	}
+	return fmt.Sprintf("%d/%02d", y, (y+1)%100)
 }
 
 func FiscalQuarter(fiscalYearStart, calendarMonth time.Month) int {
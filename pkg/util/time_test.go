@@ -2,6 +2,7 @@ package util
 
 import (
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"testing"
 	"time"
 )
@@ -26,7 +27,7 @@ func TestWeekStart(t *testing.T) {
 	for name, tc := range tests {
 		t.Run(name, func(t *testing.T) {
 			t1 := time.Date(tc.year, time.Month(tc.month), tc.day, tc.hour, tc.min, tc.sec, 0, time.Local)
-			t2 := WeekStart(t1)
+			t2 := WeekStart(t1, time.Sunday)
 			assert.True(t, t2.After(t1.Add(-168*time.Hour))) // within the last week
 			assert.Zero(t, t2.Hour())
 			assert.Zero(t, t2.Minute())
@@ -126,7 +127,7 @@ func TestYearStart(t *testing.T) {
 
 func TestThisWeekStart(t *testing.T) {
 	now := time.Now()
-	tws := ThisWeekStart()
+	tws := ThisWeekStart(time.Sunday)
 	dur := int(now.Sub(tws).Seconds())
 	assert.Equal(t, time.Sunday, tws.Weekday())
 	assert.Zero(t, tws.Hour())
@@ -200,3 +201,133 @@ func TestFiscalQuarter(t *testing.T) {
 		})
 	}
 }
+
+func TestPeriodSpan(t *testing.T) {
+	now := time.Now()
+	current := PeriodSpan(Month, DefaultCalendar, 0)
+	assert.True(t, MonthStart(now).Equal(current.Start))
+	assert.True(t, MonthStart(now).AddDate(0, 1, 0).Equal(current.End))
+
+	for _, p := range []Period{Week, Month, Quarter, Year} {
+		last := PeriodSpan(p, DefaultCalendar, -1)
+		assert.True(t, PeriodSoFar(p, DefaultCalendar).Start.Equal(last.End), p)
+		before := PeriodSpan(p, DefaultCalendar, -2)
+		assert.True(t, last.Start.Equal(before.End), p)
+	}
+	last := PeriodSpan(Month, DefaultCalendar, -1)
+	assert.Equal(t, 1, last.Start.Day())
+	assert.Equal(t, now.AddDate(0, 0, -now.Day()+1).AddDate(0, -1, 0).Month(), last.Start.Month())
+}
+
+func TestParsePeriod(t *testing.T) {
+	p, err := ParsePeriod("Quarter")
+	assert.NoError(t, err)
+	assert.Equal(t, Quarter, p)
+	var tp TimePeriod
+	assert.NoError(t, tp.Set("year"))
+	assert.Equal(t, Year, tp.Period)
+	assert.Error(t, tp.Set("decade"))
+}
+
+func TestPeriodContaining(t *testing.T) {
+	anchor := time.Date(2023, time.March, 15, 13, 45, 9, 0, time.Local)
+	monday := Calendar{WeekStart: time.Monday, FiscalYearStart: time.January}
+	april := Calendar{WeekStart: time.Sunday, FiscalYearStart: time.April}
+	tests := map[string]struct {
+		period Period
+		cal    Calendar
+		start  time.Time
+		end    time.Time
+		label  string
+	}{
+		"week":           {Week, DefaultCalendar, time.Date(2023, time.March, 12, 0, 0, 0, 0, time.Local), time.Date(2023, time.March, 19, 0, 0, 0, 0, time.Local), "Week of 2023-03-12"},
+		"monday week":    {Week, monday, time.Date(2023, time.March, 13, 0, 0, 0, 0, time.Local), time.Date(2023, time.March, 20, 0, 0, 0, 0, time.Local), "Week of 2023-03-13"},
+		"month":          {Month, DefaultCalendar, time.Date(2023, time.March, 1, 0, 0, 0, 0, time.Local), time.Date(2023, time.April, 1, 0, 0, 0, 0, time.Local), "March 2023"},
+		"quarter":        {Quarter, DefaultCalendar, time.Date(2023, time.January, 1, 0, 0, 0, 0, time.Local), time.Date(2023, time.April, 1, 0, 0, 0, 0, time.Local), "Q1 2023"},
+		"fiscal quarter": {Quarter, Calendar{FiscalYearStart: time.February}, time.Date(2023, time.February, 1, 0, 0, 0, 0, time.Local), time.Date(2023, time.May, 1, 0, 0, 0, 0, time.Local), "Q1 2023/24"},
+		"april quarter":  {Quarter, april, time.Date(2023, time.January, 1, 0, 0, 0, 0, time.Local), time.Date(2023, time.April, 1, 0, 0, 0, 0, time.Local), "Q4 2022/23"},
+		"year":           {Year, DefaultCalendar, time.Date(2023, time.January, 1, 0, 0, 0, 0, time.Local), time.Date(2024, time.January, 1, 0, 0, 0, 0, time.Local), "2023"},
+		"fiscal year":    {Year, april, time.Date(2022, time.April, 1, 0, 0, 0, 0, time.Local), time.Date(2023, time.April, 1, 0, 0, 0, 0, time.Local), "2022/23"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			span := PeriodContaining(tc.period, anchor, tc.cal)
+			assert.True(t, tc.start.Equal(span.Start), span.Start)
+			assert.True(t, tc.end.Equal(span.End), span.End)
+			assert.Equal(t, tc.label, PeriodLabel(tc.period, span.Start, tc.cal))
+			prev := PeriodContaining(tc.period, ShiftPeriod(tc.period, span.Start, -1), tc.cal)
+			assert.True(t, prev.End.Equal(span.Start))
+		})
+	}
+}
+
+func TestWeekStart_WeekStartDay(t *testing.T) {
+	sunday := time.Date(2023, time.March, 12, 10, 0, 0, 0, time.Local)
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		start := WeekStart(sunday, d)
+		assert.Equal(t, d, start.Weekday())
+		assert.False(t, start.After(sunday))
+		assert.True(t, sunday.Sub(start) < 7*24*time.Hour)
+	}
+}
+
+func TestParseWeekdayAndMonth(t *testing.T) {
+	d, err := ParseWeekday("Monday")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Monday, d)
+	d, err = ParseWeekday("sat")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Saturday, d)
+	_, err = ParseWeekday("s")
+	assert.Error(t, err)
+	m, err := ParseMonth("april")
+	assert.NoError(t, err)
+	assert.Equal(t, time.April, m)
+	m, err = ParseMonth("10")
+	assert.NoError(t, err)
+	assert.Equal(t, time.October, m)
+	_, err = ParseMonth("13")
+	assert.Error(t, err)
+
+	cal, err := ParseCalendar("monday", "", "")
+	assert.NoError(t, err)
+	assert.Equal(t, Calendar{WeekStart: time.Monday, FiscalYearStart: time.January}, cal)
+	_, err = ParseCalendar("", "sometime", "")
+	assert.Error(t, err)
+	cal, err = ParseCalendar("", "", "Asia/Tokyo")
+	assert.NoError(t, err)
+	assert.Equal(t, "Asia/Tokyo", cal.Location.String())
+	_, err = ParseCalendar("", "", "Mars/Olympus")
+	assert.Error(t, err)
+}
+
+func TestPeriodStart_DST(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	require.NoError(t, err)
+	cal := Calendar{WeekStart: time.Sunday, FiscalYearStart: time.January, Location: berlin}
+	tests := map[string]struct {
+		period Period
+		t      time.Time
+		cal    Calendar
+		start  time.Time
+		end    time.Time
+	}{
+		"week after spring forward": {Week, time.Date(2023, time.March, 29, 10, 0, 0, 0, berlin), cal, time.Date(2023, time.March, 26, 0, 0, 0, 0, berlin), time.Date(2023, time.April, 2, 0, 0, 0, 0, berlin)},
+		"week after fall back":      {Week, time.Date(2023, time.October, 31, 0, 30, 0, 0, berlin), cal, time.Date(2023, time.October, 29, 0, 0, 0, 0, berlin), time.Date(2023, time.November, 5, 0, 0, 0, 0, berlin)},
+		"monday week across switch": {Week, time.Date(2023, time.March, 26, 23, 0, 0, 0, berlin), Calendar{WeekStart: time.Monday, Location: berlin}, time.Date(2023, time.March, 20, 0, 0, 0, 0, berlin), time.Date(2023, time.March, 27, 0, 0, 0, 0, berlin)},
+		"month in utc input":        {Month, time.Date(2023, time.March, 31, 23, 30, 0, 0, time.UTC), cal, time.Date(2023, time.April, 1, 0, 0, 0, 0, berlin), time.Date(2023, time.May, 1, 0, 0, 0, 0, berlin)},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			span := PeriodContaining(tc.period, tc.t, tc.cal)
+			assert.True(t, tc.start.Equal(span.Start), span.Start)
+			assert.True(t, tc.end.Equal(span.End), span.End)
+			assert.Zero(t, span.Start.Hour())
+			assert.Equal(t, berlin, span.Start.Location())
+		})
+	}
+	// the week of the switch to summer time is an hour short, but 7 days
+	week := PeriodContaining(Week, time.Date(2023, time.March, 29, 10, 0, 0, 0, berlin), cal)
+	assert.Equal(t, 167*time.Hour, week.End.Sub(week.Start))
+	assert.Equal(t, int64(7), DaysIn(week))
+}
@@ -1,165 +1,309 @@
 package util
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"github.com/aldernero/timebox/pkg/db"
 	"time"
 )
 
 type TimeBox struct {
-	tbdb      db.TBDB
-	Fname     string
+	ctx       context.Context
+	store     db.Store
 	Names     []string
 	Boxes     map[string]Box
 	SpansSets map[string]SpanSet
 	Spans     map[int64]Span
+	// Calendar defines the bounds of weeks, quarters and years
+	Calendar Calendar
+	// Source is recorded in the history of the changes made through the
+	// TimeBox, db.SourceAPI if empty
+	Source string
+	// loaded is when the spans were read from the store, Refresh reads the
+	// history since
+	loaded int64
 }
 
-func TimeBoxFromDB(dbname string) TimeBox {
+// refreshMargin is how long before it was stamped Refresh looks for a
+// change, a change is stamped when it starts and may commit a bit later
+const refreshMargin = 60
+
+// TimeBoxFromDB loads all boxes and spans from a store, e.g. a SQLite file
+// opened with db.Open or a db.MemoryStore. The TimeBox and the ones reloaded
+// from it call the store with ctx.
+func TimeBoxFromDB(ctx context.Context, store db.Store) TimeBox {
 	var tb TimeBox
-	tb.Fname = dbname
-	tb.tbdb = db.NewDBWithName(dbname)
-	tb.tbdb.Init()
-	tb.Names, tb.Boxes = AllBoxesFromDB(tb.tbdb)
-	tb.SpansSets, tb.Spans = AllSpansFromDB(tb.tbdb)
+	tb.ctx = ctx
+	tb.store = store
+	tb.Calendar = DefaultCalendar
+	tb.store.Init(tb.ctx)
+	return tb.Reload()
+}
+
+// Reload returns a fresh TimeBox from the same store and with the same
+// context, calendar and source. It reads all spans, Refresh is cheaper.
+func (tb TimeBox) Reload() TimeBox {
+	tb.loaded = time.Now().Unix()
+	tb.Names, tb.Boxes = AllBoxesFromDB(tb.ctx, tb.store)
+	tb.SpansSets, tb.Spans = AllSpansFromDB(tb.ctx, tb.store)
 	return tb
 }
 
-func (tb TimeBox) SyncFromDB() {
-	tb.tbdb = db.NewDBWithName(tb.Fname)
-	tb.tbdb.Init()
-	tb.Names, tb.Boxes = AllBoxesFromDB(tb.tbdb)
-	tb.SpansSets, tb.Spans = AllSpansFromDB(tb.tbdb)
+// Refresh returns the TimeBox with its boxes read again and the changes of
+// spans made since it was loaded or refreshed, through it or by other
+// programs. Unlike Reload it only reads the history of the spans since then.
+func (tb TimeBox) Refresh() TimeBox {
+	now := time.Now().Unix()
+	versions, err := tb.store.GetHistory(tb.ctx, tb.loaded-refreshMargin)
+	if err != nil {
+		panic(err)
+	}
+	tb.loaded = now
+	tb.Names, tb.Boxes = AllBoxesFromDB(tb.ctx, tb.store)
+	// versions are applied in order, so the ones applied before again
+	// change nothing
+	for _, v := range versions {
+		if v.BoxName != "" {
+			continue
+		}
+		if old, ok := tb.Spans[v.SpanID]; ok {
+			tb.removeSpan(old)
+		}
+		if v.Span != nil {
+			tb.addSpan(spanFromRow(*v.Span))
+		}
+	}
+	for name := range tb.SpansSets {
+		if _, ok := tb.Boxes[name]; !ok {
+			delete(tb.SpansSets, name)
+		}
+	}
+	for name := range tb.Boxes {
+		if _, ok := tb.SpansSets[name]; !ok {
+			tb.SpansSets[name] = NewSpanSet()
+		}
+	}
+	return tb
 }
 
-func (tb TimeBox) GetSpansForBox(box string, span Span) SpanSet {
-	spans := NewSpanSet()
-	boxSpans := tb.SpansSets[box]
+func (tb TimeBox) Store() db.Store {
+	return tb.store
+}
 
-	for _, s := range boxSpans.Spans {
-		overlap := s.GetOverlap(span)
-		if !overlap.IsZero() {
-			spans.Add(overlap)
-		}
+// addSpan adds a span that is in the store to the spans of the TimeBox
+func (tb TimeBox) addSpan(span Span) {
+	spanset, ok := tb.SpansSets[span.Box]
+	if !ok {
+		spanset = NewSpanSet()
 	}
-	return spans
+	spanset.Add(span)
+	tb.SpansSets[span.Box] = spanset
+	tb.Spans[span.ID] = span
 }
 
-func (tb TimeBox) GetSpansForTimespan(span Span) SpanSet {
-	spans := NewSpanSet()
-	start := span.Start.Unix()
-	end := span.End.Unix()
-	spanRow, err := tb.tbdb.GetSpansForTimeRange(start, end)
-	if err != nil {
-		panic(err)
+// removeSpan removes a span from the spans of the TimeBox
+func (tb TimeBox) removeSpan(span Span) {
+	if spanset, ok := tb.SpansSets[span.Box]; ok {
+		spanset.Remove(span)
+		tb.SpansSets[span.Box] = spanset
 	}
-	for _, sr := range spanRow {
-		spans.Add(Span{ID: sr.ID, Start: time.Unix(sr.Start, 0), End: time.Unix(sr.End, 0), Box: sr.Box})
+	delete(tb.Spans, span.ID)
+}
+
+// overlapping returns the spans of all boxes that overlap span
+func (tb TimeBox) overlapping(span Span) []Span {
+	var result []Span
+	for _, spanset := range tb.SpansSets {
+		result = append(result, spanset.Overlapping(span)...)
+	}
+	return result
+}
+
+func (tb TimeBox) GetSpansForBox(box string, span Span) SpanSet {
+	spans := NewSpanSet()
+	boxSpans := tb.SpansSets[box]
+	for _, s := range boxSpans.Overlapping(span) {
+		spans.Add(s.GetOverlap(span))
 	}
 	return spans
 }
 
+// GetSpansForTimespan returns the spans within span, optionally only those
+// having all the given tags
+func (tb TimeBox) GetSpansForTimespan(span Span, tags ...string) SpanSet {
+	return AllSpansFromDBForTimeRange(tb.ctx, tb.store, span.Start, span.End, tags...)
+}
+
 func (tb TimeBox) GetSpans(span Span) map[string]SpanSet {
 	spans := make(map[string]SpanSet)
 	for box, spanset := range tb.SpansSets {
-		spans[box] = SpanSet{}
-		for _, s := range spanset.Spans {
-			overlap := s.GetOverlap(span)
-			if !overlap.IsZero() {
-				spanset := spans[box]
-				spanset.Add(overlap)
-				spans[box] = spanset
-			}
+		result := NewSpanSet()
+		for _, s := range spanset.Overlapping(span) {
+			result.Add(s.GetOverlap(span))
 		}
+		spans[box] = result
 	}
 	return spans
 }
 
 func (tb TimeBox) AddBox(box Box) error {
-	err := tb.tbdb.AddBox(box.Name, int64(box.MinTime.Seconds()), int64(box.MaxTime.Seconds()))
-	if err != nil {
-		return err
-	}
-	tb.Boxes[box.Name] = box
-	return nil
+	return tb.journalBoxes("add box "+box.Name, false, func(s db.Store) error {
+		err := s.AddBox(tb.ctx, box.Name, int64(box.MinTime.Seconds()), int64(box.MaxTime.Seconds()))
+		if err != nil {
+			return err
+		}
+		return s.SetBoxTargets(tb.ctx, box.Name, box.targetRows())
+	}, box.Name)
 }
 
 func (tb TimeBox) UpdateBox(box Box) error {
-	err := tb.tbdb.UpdateBox(box.Name, int64(box.MinTime.Seconds()), int64(box.MaxTime.Seconds()))
+	return tb.journalBoxes("update box "+box.Name, false, func(s db.Store) error {
+		err := s.UpdateBox(tb.ctx, box.Name, int64(box.MinTime.Seconds()), int64(box.MaxTime.Seconds()))
+		if err != nil {
+			return err
+		}
+		return s.SetBoxTargets(tb.ctx, box.Name, box.targetRows())
+	}, box.Name)
+}
+
+// RenameBox renames a box and its sub-boxes along with their spans, call
+// Refresh to see the new names
+func (tb TimeBox) RenameBox(oldName, newName string) error {
+	path, err := NormalizeBoxPath(newName)
 	if err != nil {
 		return err
 	}
-	tb.Boxes[box.Name] = box
-	return nil
+	return tb.journalBoxes(fmt.Sprintf("rename box %s to %s", oldName, path), true, func(s db.Store) error {
+		return s.RenameBox(tb.ctx, oldName, path)
+	}, oldName, path)
 }
 
-func (tb TimeBox) DeleteBox(box string) error {
-	err := tb.tbdb.DeleteBox(box)
+// ArchiveBox archives a box and its sub-boxes, they keep their spans and
+// still show up in reports of the periods they were used in
+func (tb TimeBox) ArchiveBox(box string, at time.Time) error {
+	timer, running, err := tb.RunningTimer()
 	if err != nil {
 		return err
 	}
-	delete(tb.Boxes, box)
-	return nil
+	if running && IsBoxInTree(timer.Box, box) {
+		return fmt.Errorf("a timer is running for %s", timer.Box)
+	}
+	return tb.journalBoxes("archive box "+box, false, func(s db.Store) error {
+		return s.ArchiveBox(tb.ctx, box, at.Unix())
+	}, box)
 }
 
-func (tb TimeBox) DeleteBoxAndSpans(box string) error {
-	err := tb.tbdb.DeleteBoxAndSpans(box)
-	if err != nil {
-		return err
+// UnarchiveBox makes an archived box active again, along with its sub-boxes
+// and parents
+func (tb TimeBox) UnarchiveBox(box string) error {
+	return tb.journalBoxes("unarchive box "+box, false, func(s db.Store) error {
+		return s.UnarchiveBox(tb.ctx, box)
+	}, box)
+}
+
+// ActiveNames returns the names of the boxes that aren't archived
+func (tb TimeBox) ActiveNames() []string {
+	var result []string
+	for _, name := range tb.Names {
+		if !tb.Boxes[name].Archived() {
+			result = append(result, name)
+		}
 	}
-	tb.SyncFromDB()
-	return nil
+	return result
+}
+
+func (tb TimeBox) DeleteBox(box string) error {
+	return tb.journalBoxes("delete box "+box, false, func(s db.Store) error {
+		return s.DeleteBox(tb.ctx, box)
+	}, box)
+}
+
+func (tb TimeBox) DeleteBoxAndSpans(box string) error {
+	return tb.journalBoxes("delete box "+box+" and its spans", true, func(s db.Store) error {
+		return s.DeleteBoxAndSpans(tb.ctx, box)
+	}, box)
+}
+
+func (tb TimeBox) DeleteBoxTree(box string) error {
+	return tb.journalBoxes("delete box "+box+" and its sub-boxes", true, func(s db.Store) error {
+		return s.DeleteBoxTree(tb.ctx, box)
+	}, box)
 }
 
 func (tb TimeBox) AddSpan(span Span, box string) error {
-	err := tb.tbdb.AddSpan(span.Start.Unix(), span.End.Unix(), box)
-	if err != nil {
-		return err
-	}
-	if _, ok := tb.SpansSets[box]; !ok {
-		tb.SpansSets[box] = NewSpanSet()
-	}
-	spanset := tb.SpansSets[box]
-	spanset.Add(span)
-	tb.SpansSets[box] = spanset
-	return nil
+	span.Box = box
+	return tb.journal("add span to "+box, db.ImageKeys{}, func(s db.Store) (db.ImageKeys, error) {
+		id, err := s.AddSpanRow(tb.ctx, span.row())
+		span.ID = id
+		return db.ImageKeys{Spans: []int64{id}}, err
+	})
 }
 
 func (tb TimeBox) DeleteSpan(span Span) error {
 	box := span.Box
-	err := tb.tbdb.DeleteSpan(span.Start.Unix(), span.End.Unix(), box)
-	if err != nil {
-		return err
+	var ids []int64
+	if spanset, ok := tb.SpansSets[box]; ok {
+		for _, s := range spanset.Spans {
+			if s.Start.Equal(span.Start) && s.End.Equal(span.End) {
+				ids = append(ids, s.ID)
+			}
+		}
 	}
-	spanset := tb.SpansSets[box]
-	spanset.Remove(span)
-	tb.SpansSets[box] = spanset
-	return nil
+	return tb.journalSpans("delete span of "+box, func(s db.Store) error {
+		return s.DeleteSpan(tb.ctx, span.Start.Unix(), span.End.Unix(), box)
+	}, ids...)
 }
 
 func (tb TimeBox) DeleteSpanByID(id int64) error {
-	err := tb.tbdb.DeleteSpanByID(id)
-	if err != nil {
-		return err
-	}
-	tb.SyncFromDB()
-	return nil
+	return tb.journalSpans(fmt.Sprintf("delete span %d", id), func(s db.Store) error {
+		return s.DeleteSpanByID(tb.ctx, id)
+	}, id)
 }
 
+// UpdateSpan changes a span's times, box, notes and tags. The span must end
+// after it starts and not overlap any other span.
 func (tb TimeBox) UpdateSpan(span Span) error {
+	return tb.updateSpan(span, fmt.Sprintf("update span %d", span.ID))
+}
+
+func (tb TimeBox) updateSpan(span Span, description string) error {
+	if !span.End.After(span.Start) {
+		return errors.New("span must end after it starts")
+	}
+	if _, ok := tb.Boxes[span.Box]; !ok {
+		return fmt.Errorf("box %s doesn't exist", span.Box)
+	}
 	// check if span overlaps with any other spans
-	for k, v := range tb.Spans {
-		if k == span.ID {
-			continue
-		}
-		if v.Overlaps(span) {
+	for _, s := range tb.overlapping(span) {
+		if s.ID != span.ID {
 			return errors.New("updated span overlaps with an existing span")
 		}
 	}
-	err := tb.tbdb.UpdateSpan(span.ID, span.Start.Unix(), span.End.Unix(), span.Box)
+	return tb.journalSpans(description, func(s db.Store) error {
+		return s.UpdateSpanRow(tb.ctx, span.row())
+	}, span.ID)
+}
+
+// MoveSpan moves a span to another box
+func (tb TimeBox) MoveSpan(span Span, box string) error {
+	if span.Box == box {
+		return fmt.Errorf("span is already in %s", box)
+	}
+	span.Box = box
+	return tb.updateSpan(span, fmt.Sprintf("move span %d to %s", span.ID, box))
+}
+
+// SplitSpan splits a span at a time within it into two spans with the same
+// box, notes and tags, and returns the second one
+func (tb TimeBox) SplitSpan(span Span, at time.Time) (Span, error) {
+	edit, err := SplitSpanAt(span, at)
 	if err != nil {
-		return err
+		return Span{}, err
+	}
+	err = tb.ApplyEdits([]SpanEdit{edit})
+	if err != nil {
+		return Span{}, err
 	}
-	tb.SyncFromDB()
-	return nil
+	return edit.New[1], nil
 }
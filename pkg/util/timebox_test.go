@@ -0,0 +1,158 @@
+package util
+
+import (
+	"github.com/aldernero/timebox/pkg/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTimeBox_MemoryStore(t *testing.T) {
+	tb := TimeBoxFromDB(ctx, db.NewMemoryStore())
+	require.NoError(t, tb.AddBox(Box{Name: "Piano", MinTime: time.Hour, MaxTime: 2 * time.Hour}))
+	start := time.Date(2023, time.March, 1, 18, 0, 0, 0, time.Local)
+	span := Span{Start: start, End: start.Add(45 * time.Minute), Notes: "scales", Tags: []string{"practice"}}
+	require.NoError(t, tb.AddSpan(span, "Piano"))
+	assert.Error(t, tb.AddSpan(span, "Piano"))
+
+	tb = tb.Reload()
+	assert.Equal(t, []string{"Piano"}, tb.Names)
+	require.Equal(t, 1, len(tb.Spans))
+	period := Span{Start: start.Add(-time.Hour), End: start.Add(time.Hour)}
+	spans := tb.GetSpansForTimespan(period, "practice")
+	require.Equal(t, 1, spans.Size())
+	got := spans.Spans[0]
+	assert.Equal(t, "Piano", got.Box)
+	assert.Equal(t, "scales", got.Notes)
+
+	got.End = got.End.Add(15 * time.Minute)
+	require.NoError(t, tb.UpdateSpan(got))
+	assert.Equal(t, time.Hour, tb.UsedTime("Piano", period))
+	tb = tb.Reload()
+	assert.Equal(t, time.Hour, tb.UsedTime("Piano", period))
+
+	require.NoError(t, tb.DeleteBoxAndSpans("Piano"))
+	assert.Empty(t, tb.Spans)
+	assert.Zero(t, tb.UsedTime("Piano", period))
+}
+
+func TestTimeBox_Targets(t *testing.T) {
+	tb := TimeBoxFromDB(ctx, db.NewMemoryStore())
+	box := Box{Name: "Training", MinTime: time.Hour, MaxTime: 2 * time.Hour}
+	box.SetTarget(Month, &Target{Min: 20 * time.Hour, Max: 30 * time.Hour})
+	require.NoError(t, tb.AddBox(box))
+	tb = tb.Reload()
+	assert.Equal(t, map[Period]Target{Month: {Min: 20 * time.Hour, Max: 30 * time.Hour}}, tb.Boxes["Training"].Targets)
+
+	box = tb.Boxes["Training"]
+	box.SetTarget(Month, nil)
+	box.SetTarget(Year, &Target{Min: 100 * time.Hour, Max: 200 * time.Hour})
+	require.NoError(t, tb.UpdateBox(box))
+	tb = tb.Reload()
+	assert.Equal(t, map[Period]Target{Year: {Min: 100 * time.Hour, Max: 200 * time.Hour}}, tb.Boxes["Training"].Targets)
+}
+
+func TestTimeBox_SplitAndMoveSpan(t *testing.T) {
+	tb := TimeBoxFromDB(ctx, db.NewMemoryStore())
+	require.NoError(t, tb.AddBox(Box{Name: "Piano"}))
+	require.NoError(t, tb.AddBox(Box{Name: "Theory"}))
+	start := time.Date(2023, time.March, 1, 18, 0, 0, 0, time.Local)
+	period := Span{Start: start, End: start.Add(3 * time.Hour)}
+	require.NoError(t, tb.AddSpan(Span{Start: start, End: start.Add(time.Hour), Notes: "scales", Tags: []string{"practice"}}, "Piano"))
+	require.NoError(t, tb.AddSpan(Span{Start: start.Add(2 * time.Hour), End: start.Add(3 * time.Hour)}, "Theory"))
+	tb = tb.Reload()
+	first := tb.SpansSets["Piano"].Spans[0]
+
+	_, err := tb.SplitSpan(first, start)
+	assert.Error(t, err)
+	_, err = tb.SplitSpan(first, start.Add(time.Hour))
+	assert.Error(t, err)
+	second, err := tb.SplitSpan(first, start.Add(20*time.Minute))
+	require.NoError(t, err)
+	assert.True(t, second.Start.Equal(start.Add(20*time.Minute)))
+	assert.Equal(t, "scales", second.Notes)
+	assert.Equal(t, time.Hour, tb.UsedTime("Piano", period))
+	tb = tb.Reload()
+	require.Len(t, tb.SpansSets["Piano"].Spans, 2)
+	assert.True(t, tb.Spans[first.ID].End.Equal(start.Add(20*time.Minute)))
+	assert.Equal(t, []string{"practice"}, tb.Spans[second.ID].Tags)
+
+	require.NoError(t, tb.MoveSpan(tb.Spans[second.ID], "Theory"))
+	assert.Equal(t, 20*time.Minute, tb.UsedTime("Piano", period))
+	assert.Equal(t, time.Hour+40*time.Minute, tb.UsedTime("Theory", period))
+	assert.Error(t, tb.MoveSpan(tb.Spans[first.ID], "Piano"))
+	assert.Error(t, tb.MoveSpan(tb.Spans[first.ID], "Nowhere"))
+
+	// the edited span can't overlap another one or end before it starts
+	moved := tb.Spans[second.ID]
+	moved.End = start.Add(2*time.Hour + time.Minute)
+	assert.Error(t, tb.UpdateSpan(moved))
+	moved.End = moved.Start
+	assert.Error(t, tb.UpdateSpan(moved))
+	tb = tb.Reload()
+	assert.Equal(t, 20*time.Minute, tb.UsedTime("Piano", period))
+	assert.Equal(t, time.Hour+40*time.Minute, tb.UsedTime("Theory", period))
+}
+
+func TestTimeBox_ChangesKeepSpansUpToDate(t *testing.T) {
+	tbdb := openDB(t, filepath.Join(t.TempDir(), dbName))
+	for _, store := range []db.Store{tbdb, db.NewMemoryStore()} {
+		tb := TimeBoxFromDB(ctx, store)
+		// the spans of tb match the store without reading it again
+		inSync := func() {
+			fresh := tb.Reload()
+			assert.Equal(t, fresh.Boxes, tb.Boxes)
+			assert.Equal(t, fresh.Spans, tb.Spans)
+			for name, spanset := range fresh.SpansSets {
+				assert.Equal(t, spanset.Spans, tb.SpansSets[name].Spans, name)
+			}
+		}
+		require.NoError(t, tb.AddBox(Box{Name: "Work", MaxTime: time.Hour}))
+		require.NoError(t, tb.AddBox(Box{Name: "Work/A", MaxTime: time.Hour}))
+		start := time.Date(2023, time.March, 1, 9, 0, 0, 0, time.UTC)
+		for i := 0; i < 3; i++ {
+			s := start.Add(time.Duration(i) * time.Hour)
+			require.NoError(t, tb.AddSpan(Span{Start: s, End: s.Add(30 * time.Minute)}, "Work/A"))
+		}
+		inSync()
+		require.NoError(t, tb.RenameBox("Work", "Job"))
+		inSync()
+		require.NoError(t, tb.ArchiveBox("Job", start.Add(24*time.Hour)))
+		inSync()
+		require.NoError(t, tb.DeleteBoxTree("Job"))
+		inSync()
+		for i := 0; i < 3; i++ {
+			_, err := tb.Undo()
+			require.NoError(t, err)
+			inSync()
+		}
+		_, err := tb.Redo()
+		require.NoError(t, err)
+		inSync()
+		tb = tb.Refresh()
+		assert.Equal(t, tb.Reload().Names, tb.Names)
+	}
+}
+
+func TestTimeBox_Refresh(t *testing.T) {
+	name := filepath.Join(t.TempDir(), dbName)
+	tb := TimeBoxFromDB(ctx, openDB(t, name))
+	// another program changing the same database
+	other := TimeBoxFromDB(ctx, openDB(t, name))
+	require.NoError(t, other.AddBox(Box{Name: "Piano", MaxTime: time.Hour}))
+	start := time.Date(2023, time.March, 1, 9, 0, 0, 0, time.UTC)
+	require.NoError(t, other.AddSpan(Span{Start: start, End: start.Add(time.Hour)}, "Piano"))
+
+	tb = tb.Refresh()
+	assert.Equal(t, []string{"Piano"}, tb.Names)
+	require.Len(t, tb.SpansSets["Piano"].Spans, 1)
+	span := tb.SpansSets["Piano"].Spans[0]
+
+	other = other.Reload()
+	require.NoError(t, other.DeleteSpanByID(span.ID))
+	tb = tb.Refresh()
+	assert.Empty(t, tb.Spans)
+	assert.Empty(t, tb.SpansSets["Piano"].Spans)
+}
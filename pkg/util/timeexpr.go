@@ -0,0 +1,222 @@
+package util
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TimeExprForms lists the accepted time expressions for error messages
+const TimeExprForms = `a day (today, yesterday, mon, last fri, 2023-03-01), a time of day ` +
+	`(14:30, 14:30:05, 9am, 9:30pm, noon, midnight, morning, afternoon, evening), ` +
+	`both like "yesterday 14:30", optional offsets like "+1h" or "-30m", ` +
+	`"now", or a duration like "90m" or "2h ago" for that long ago`
+
+// named times of day
+var dayTimes = map[string]time.Duration{
+	"midnight":  0,
+	"morning":   9 * time.Hour,
+	"noon":      12 * time.Hour,
+	"afternoon": 15 * time.Hour,
+	"evening":   19 * time.Hour,
+}
+
+var clockPattern = regexp.MustCompile(`^(\d{1,2})(?::(\d{2}))?(?::(\d{2}))?(am|pm)?$`)
+
+// TimeParser parses time expressions like "yesterday 14:30", "mon 9am",
+// "last friday", "today -2h" or "noon" relative to the time returned by Now,
+// in the location of that time
+type TimeParser struct {
+	Now func() time.Time
+}
+
+func NewTimeParser(now func() time.Time) TimeParser {
+	return TimeParser{Now: now}
+}
+
+// Parse parses a time expression. A day without a time of day is its
+// midnight, a time of day without a day is today, offsets are added at the
+// end. "today" followed only by offsets is relative to now.
+func (p TimeParser) Parse(s string) (time.Time, error) {
+	now := p.Now()
+	expr := strings.ToLower(strings.TrimSpace(s))
+	if expr == "" {
+		return time.Time{}, p.error(s, "empty time")
+	}
+	if t, err := time.Parse(time.RFC3339, strings.TrimSpace(s)); err == nil {
+		return t, nil
+	}
+	tokens := strings.Fields(expr)
+	// a bare duration, or a duration followed by "ago"
+	if d, err := time.ParseDuration(tokens[0]); err == nil && !strings.ContainsAny(tokens[0][:1], "+-") &&
+		(len(tokens) == 1 || (len(tokens) == 2 && tokens[1] == "ago")) {
+		return now.Add(-d), nil
+	}
+	var (
+		day       time.Time
+		hasDay    bool
+		isNow     bool
+		today     bool
+		clock     time.Duration
+		hasClock  bool
+		offset    time.Duration
+		hasOffset bool
+	)
+	setDay := func(tok string, d time.Time) error {
+		if hasDay {
+			return p.error(s, fmt.Sprintf("more than one day in \"%s\"", tok))
+		}
+		day, hasDay = d, true
+		return nil
+	}
+	setClock := func(tok string, d time.Duration) error {
+		if hasClock {
+			return p.error(s, fmt.Sprintf("more than one time of day in \"%s\"", tok))
+		}
+		clock, hasClock = d, true
+		return nil
+	}
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	for i := 0; i < len(tokens); i++ {
+		tok := tokens[i]
+		var err error
+		switch {
+		case tok == "at":
+			continue
+		case tok == "now":
+			if err = setDay(tok, midnight); err == nil {
+				err = setClock(tok, 0)
+			}
+			isNow = true
+		case tok == "today":
+			err = setDay(tok, midnight)
+			today = true
+		case tok == "yesterday":
+			err = setDay(tok, midnight.AddDate(0, 0, -1))
+		case tok == "tomorrow":
+			err = setDay(tok, midnight.AddDate(0, 0, 1))
+		case tok == "last":
+			if i+1 == len(tokens) {
+				return time.Time{}, p.error(s, "\"last\" must be followed by a week day")
+			}
+			i++
+			wd, werr := ParseWeekday(tokens[i])
+			if werr != nil {
+				return time.Time{}, p.error(s, fmt.Sprintf("\"last\" must be followed by a week day, not \"%s\"", tokens[i]))
+			}
+			// the last one before today
+			back := (int(midnight.Weekday())-int(wd)+6)%7 + 1
+			err = setDay(tok, midnight.AddDate(0, 0, -back))
+		case tok[0] == '+' || tok[0] == '-':
+			d, derr := time.ParseDuration(tok)
+			if derr != nil {
+				return time.Time{}, p.error(s, fmt.Sprintf("invalid offset \"%s\"", tok))
+			}
+			offset += d
+			hasOffset = true
+		default:
+			if d, ok := dayTimes[tok]; ok {
+				err = setClock(tok, d)
+				break
+			}
+			if wd, werr := ParseWeekday(tok); werr == nil {
+				// the last one up to today
+				back := (int(midnight.Weekday()) - int(wd) + 7) % 7
+				err = setDay(tok, midnight.AddDate(0, 0, -back))
+				break
+			}
+			if d, derr := time.ParseInLocation(time.DateOnly, tok, now.Location()); derr == nil {
+				err = setDay(tok, d)
+				break
+			}
+			d, ok := parseClock(tok)
+			if !ok {
+				return time.Time{}, p.error(s, fmt.Sprintf("unknown word \"%s\"", tok))
+			}
+			err = setClock(tok, d)
+		}
+		if err != nil {
+			return time.Time{}, err
+		}
+	}
+	var result time.Time
+	switch {
+	case isNow || (!hasDay && !hasClock):
+		result = now
+	case !hasDay:
+		day = midnight
+		fallthrough
+	case hasClock:
+		// calendar arithmetic keeps the wall clock time on days with a DST change
+		h, m, sec := int(clock/time.Hour), int(clock/time.Minute%60), int(clock/time.Second%60)
+		result = time.Date(day.Year(), day.Month(), day.Day(), h, m, sec, 0, day.Location())
+	case today && hasOffset:
+		result = now
+	default:
+		result = day
+	}
+	return result.Add(offset), nil
+}
+
+// parseClock parses a time of day like 14:30, 14:30:05, 9am or 9:30pm
+func parseClock(s string) (time.Duration, bool) {
+	m := clockPattern.FindStringSubmatch(s)
+	// a bare number isn't a time of day
+	if m == nil || (m[2] == "" && m[4] == "") {
+		return 0, false
+	}
+	h, _ := strconv.Atoi(m[1])
+	minutes, _ := strconv.Atoi("0" + m[2])
+	seconds, _ := strconv.Atoi("0" + m[3])
+	if minutes > 59 || seconds > 59 {
+		return 0, false
+	}
+	switch m[4] {
+	case "":
+		if h > 23 {
+			return 0, false
+		}
+	case "am", "pm":
+		if h < 1 || h > 12 {
+			return 0, false
+		}
+		h %= 12
+		if m[4] == "pm" {
+			h += 12
+		}
+	}
+	return time.Duration(h)*time.Hour + time.Duration(minutes)*time.Minute + time.Duration(seconds)*time.Second, true
+}
+
+func (p TimeParser) error(s, reason string) error {
+	return fmt.Errorf("can't parse time \"%s\": %s, expected %s", s, reason, TimeExprForms)
+}
+
+// timeWord reports whether tok is a word of a time expression, and whether it
+// is a time of day
+func timeWord(tok string) (isTime, isClock bool) {
+	tok = strings.ToLower(tok)
+	switch tok {
+	case "at", "today", "yesterday", "tomorrow", "last":
+		return true, false
+	case "now":
+		return true, true
+	}
+	if _, ok := dayTimes[tok]; ok {
+		return true, true
+	}
+	if _, ok := parseClock(tok); ok {
+		return true, true
+	}
+	if tok[0] == '+' || tok[0] == '-' {
+		_, err := time.ParseDuration(tok)
+		return err == nil, false
+	}
+	if _, err := ParseWeekday(tok); err == nil {
+		return true, false
+	}
+	_, err := time.Parse(time.DateOnly, tok)
+	return err == nil, false
+}
@@ -0,0 +1,72 @@
+package util
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestTimeParser_Parse(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	require.NoError(t, err)
+	// a Wednesday
+	now := time.Date(2023, time.March, 15, 10, 0, 0, 0, berlin)
+	p := NewTimeParser(func() time.Time { return now })
+	day := func(d, h, m int) time.Time {
+		return time.Date(2023, time.March, d, h, m, 0, 0, berlin)
+	}
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"now", now},
+		{"today", day(15, 0, 0)},
+		{"yesterday 14:30", day(14, 14, 30)},
+		{"tomorrow at 9am", day(16, 9, 0)},
+		{"Mon 9am", day(13, 9, 0)},
+		{"wednesday", day(15, 0, 0)},
+		{"last wed", day(8, 0, 0)},
+		{"last friday noon", day(10, 12, 0)},
+		{"today -2h", now.Add(-2 * time.Hour)},
+		{"now -10m", now.Add(-10 * time.Minute)},
+		{"-30m", now.Add(-30 * time.Minute)},
+		{"noon", day(15, 12, 0)},
+		{"9:30pm", day(15, 21, 30)},
+		{"12am", day(15, 0, 0)},
+		{"evening +30m", day(15, 19, 30)},
+		{"90m", now.Add(-90 * time.Minute)},
+		{"2h ago", now.Add(-2 * time.Hour)},
+		{"2023-03-01", day(1, 0, 0)},
+		{"2023-03-01 15:04:05", time.Date(2023, time.March, 1, 15, 4, 5, 0, berlin)},
+		{"2023-03-01T15:04:05Z", time.Date(2023, time.March, 1, 15, 4, 5, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			got, err := p.Parse(tt.expr)
+			require.NoError(t, err)
+			assert.True(t, tt.want.Equal(got), "got %s, want %s", got, tt.want)
+		})
+	}
+}
+
+func TestTimeParser_ParseDST(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	require.NoError(t, err)
+	// the day after the clocks moved forward
+	now := time.Date(2023, time.March, 27, 10, 0, 0, 0, berlin)
+	p := NewTimeParser(func() time.Time { return now })
+	got, err := p.Parse("yesterday 14:30")
+	require.NoError(t, err)
+	assert.Equal(t, "2023-03-26 14:30", got.Format("2006-01-02 15:04"))
+}
+
+func TestTimeParser_ParseErrors(t *testing.T) {
+	p := NewTimeParser(time.Now)
+	for _, expr := range []string{"", "soon", "9", "25:00", "13pm", "last", "last week", "today yesterday", "noon 14:00", "+1x"} {
+		_, err := p.Parse(expr)
+		if assert.Error(t, err, expr) {
+			assert.Contains(t, err.Error(), TimeExprForms)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package util
+
+import (
+	"fmt"
+	"github.com/aldernero/timebox/pkg/db"
+	"time"
+)
+
+// Timer is a span that has been started but not yet stopped
+type Timer struct {
+	Start time.Time
+	Box   string
+}
+
+func (t Timer) Elapsed() time.Duration {
+	return time.Since(t.Start)
+}
+
+// RunningTimer returns the running timer, if there is one
+func (tb TimeBox) RunningTimer() (Timer, bool, error) {
+	tr, running, err := tb.store.GetTimer(tb.ctx)
+	if err != nil || !running {
+		return Timer{}, false, err
+	}
+	return Timer{Start: time.Unix(tr.Start, 0).In(zoneLocation(tr.TZ)), Box: tr.Box}, true, nil
+}
+
+// StartTimer starts a timer, the span it turns into is recorded in the zone
+// of start
+func (tb TimeBox) StartTimer(box string, start time.Time) error {
+	if tb.Boxes[box].Archived() {
+		return fmt.Errorf("box %s is archived", box)
+	}
+	return tb.store.StartTimer(tb.ctx, db.TimerRow{Start: start.Unix(), Box: box, TZ: ZoneName(start.Location())})
+}
+
+func (tb TimeBox) StopTimer(end time.Time) (Span, error) {
+	var sr db.SpanRow
+	err := tb.journal("stop timer", db.ImageKeys{Timer: true}, func(s db.Store) (db.ImageKeys, error) {
+		var err error
+		sr, err = s.StopTimer(tb.ctx, end.Unix())
+		return db.ImageKeys{Spans: []int64{sr.ID}}, err
+	})
+	if err != nil {
+		return Span{}, err
+	}
+	return spanFromRow(sr), nil
+}
+
+func (tb TimeBox) CancelTimer() error {
+	return tb.store.DeleteTimer(tb.ctx)
+}
@@ -5,37 +5,15 @@ import (
 	"time"
 )
 
-const (
-	timeFormatShort = "15:04:05"
-	timeFormatLong  = "2006-01-02 15:04:05"
-)
-
+// ParseDurationOrTime parses a time expression like "yesterday 14:30" or a
+// duration like "90m" for that long ago, relative to the current time
 func ParseDurationOrTime(s string) (time.Time, error) {
-	now := time.Now()
-	d, err := time.ParseDuration(s)
-	if err == nil {
-		return now.Add(-d), nil
-	}
-	return ParseTime(s)
+	return NewTimeParser(time.Now).Parse(s)
 }
 
+// ParseTime is the same as ParseDurationOrTime
 func ParseTime(s string) (time.Time, error) {
-	var ts time.Time
-	timeFormat := timeFormatLong
-	if len(s) < len(timeFormatLong) {
-		now := time.Now()
-		ts, err := time.ParseInLocation(timeFormatShort, s, time.Local)
-		if err != nil {
-			return ts, err
-		}
-		ts = time.Date(now.Year(), now.Month(), now.Day(), ts.Hour(), ts.Minute(), ts.Second(), 0, time.Local)
-		return ts, nil
-	}
-	ts, err := time.ParseInLocation(timeFormat, s, time.Local)
-	if err != nil {
-		return ts, err
-	}
-	return ts, nil
+	return ParseDurationOrTime(s)
 }
 
 type InputResult struct {
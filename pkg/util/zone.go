@@ -0,0 +1,94 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	zonesMu sync.Mutex
+	// zones caches the locations loaded by name, nil for unknown names
+	zones = make(map[string]*time.Location)
+
+	localZoneOnce sync.Once
+	localZone     string
+)
+
+// LoadZone returns the location with the given IANA name, or nil for an
+// empty or unknown name
+func LoadZone(name string) *time.Location {
+	if name == "" {
+		return nil
+	}
+	zonesMu.Lock()
+	defer zonesMu.Unlock()
+	if loc, ok := zones[name]; ok {
+		return loc
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil || name == "Local" {
+		loc = nil
+	}
+	zones[name] = loc
+	return loc
+}
+
+// ZoneName returns the IANA name of a location, resolving time.Local to the
+// zone of the system. It returns "" for locations without a name, like the
+// fixed offsets of parsed RFC 3339 times.
+func ZoneName(loc *time.Location) string {
+	if loc == nil {
+		return ""
+	}
+	if name := loc.String(); LoadZone(name) != nil {
+		return name
+	}
+	if loc == time.Local {
+		return localZoneName()
+	}
+	return ""
+}
+
+// zoneLocation returns the location of a stored zone name, spans without a
+// zone or with the zone of the system are shown in time.Local
+func zoneLocation(name string) *time.Location {
+	if name == "" || name == ZoneName(time.Local) {
+		return time.Local
+	}
+	if loc := LoadZone(name); loc != nil {
+		return loc
+	}
+	return time.Local
+}
+
+// localZoneName finds the IANA name of the system zone from $TZ or the
+// /etc/localtime link, which is what time.Local is loaded from
+func localZoneName() string {
+	localZoneOnce.Do(func() {
+		if tz, ok := os.LookupEnv("TZ"); ok {
+			tz = strings.TrimPrefix(tz, ":")
+			if tz == "" {
+				tz = "UTC"
+			}
+			if LoadZone(tz) != nil {
+				localZone = tz
+			}
+			return
+		}
+		path, err := filepath.EvalSymlinks("/etc/localtime")
+		if err != nil {
+			return
+		}
+		const dir = "zoneinfo" + string(filepath.Separator)
+		if i := strings.LastIndex(path, dir); i >= 0 {
+			name := filepath.ToSlash(path[i+len(dir):])
+			if LoadZone(name) != nil {
+				localZone = name
+			}
+		}
+	})
+	return localZone
+}
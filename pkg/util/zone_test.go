@@ -0,0 +1,49 @@
+package util
+
+import (
+	"github.com/aldernero/timebox/pkg/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestZoneName(t *testing.T) {
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	require.NoError(t, err)
+	assert.Equal(t, "Asia/Tokyo", ZoneName(tokyo))
+	assert.Equal(t, "UTC", ZoneName(time.UTC))
+	assert.Equal(t, "", ZoneName(time.FixedZone("", 3600)))
+	assert.Nil(t, LoadZone("Mars/Olympus"))
+	assert.Nil(t, LoadZone(""))
+}
+
+func TestTimeBox_SpanZones(t *testing.T) {
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	require.NoError(t, err)
+	store := db.NewMemoryStore()
+	tb := TimeBoxFromDB(ctx, store)
+	require.NoError(t, tb.AddBox(Box{Name: "Work", MinTime: time.Hour, MaxTime: 2 * time.Hour}))
+	start := time.Date(2023, time.March, 1, 9, 0, 0, 0, tokyo)
+	require.NoError(t, tb.AddSpan(Span{Start: start, End: start.Add(time.Hour)}, "Work"))
+	// a span from before zones were stored
+	_, err = store.AddSpanRow(ctx, db.SpanRow{Start: start.Add(2 * time.Hour).Unix(), End: start.Add(3 * time.Hour).Unix(), Box: "Work"})
+	require.NoError(t, err)
+
+	tb = tb.Reload()
+	spans := tb.SpansSets["Work"].Spans
+	require.Equal(t, 2, len(spans))
+	// the wall clock time where the span was recorded is kept
+	assert.Equal(t, tokyo, spans[0].Start.Location())
+	assert.Equal(t, "09:00", spans[0].Start.Format("15:04"))
+	assert.Equal(t, time.Local, spans[1].Start.Location())
+	// and shown in the zone of the calendar
+	tb.Calendar.Location = time.UTC
+	assert.Equal(t, "00:00", tb.Calendar.In(spans[0].Start).Format("15:04"))
+
+	require.NoError(t, tb.StartTimer("Work", time.Now().In(tokyo)))
+	timer, running, err := tb.RunningTimer()
+	require.NoError(t, err)
+	require.True(t, running)
+	assert.Equal(t, tokyo, timer.Start.Location())
+}